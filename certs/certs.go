@@ -0,0 +1,269 @@
+// Package certs obtains and renews TLS certificates through ACME DNS-01 challenges.
+package certs
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
+
+	"github.com/fmotalleb/go-tools/log"
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+const (
+	checkInterval = time.Hour
+
+	accountKeyFile = "account.pem"
+	certFile       = "cert.pem"
+	keyFile        = "key.pem"
+	// The cache holds private keys, it is only readable by the owner.
+	cacheDirPerm  = 0o700
+	cacheFilePerm = 0o600
+)
+
+var errNoCertificate = errors.New("certificate not issued yet")
+
+// ChallengeSolver publishes the TXT records of DNS-01 challenges.
+type ChallengeSolver interface {
+	PresentTXT(name, value string)
+	CleanUpTXT(name, value string)
+}
+
+// Manager keeps a certificate for the configured names issued and renewed.
+type Manager struct {
+	cfg    config.ACMEConfig
+	solver ChallengeSolver
+	cert   atomic.Pointer[tls.Certificate]
+	// ready is closed once a certificate is loaded or issued.
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+// New returns a manager answering challenges through solver.
+func New(cfg config.ACMEConfig, solver ChallengeSolver) *Manager {
+	return &Manager{cfg: cfg, solver: solver, ready: make(chan struct{})}
+}
+
+// Ready returns a channel closed once the manager has a certificate to serve.
+func (m *Manager) Ready() <-chan struct{} {
+	return m.ready
+}
+
+// GetCertificate implements [tls.Config.GetCertificate].
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := m.cert.Load()
+	if cert == nil {
+		return nil, errNoCertificate
+	}
+	return cert, nil
+}
+
+// Run loads the cached certificate and renews it when it gets close to expiry until ctx is done.
+func (m *Manager) Run(ctx context.Context) error {
+	logger := log.Of(ctx).Named("acme").With(zap.Strings("domains", m.cfg.Domains))
+	if err := m.load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logger.Warn("failed to load cached certificate", zap.Error(err))
+	}
+	for {
+		if m.needsRenewal(time.Now()) {
+			logger.Info("requesting certificate")
+			if err := m.obtain(ctx); err != nil {
+				logger.Error("failed to obtain certificate", zap.Error(err))
+			} else {
+				logger.Info("certificate issued", zap.Time("not_after", m.cert.Load().Leaf.NotAfter))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(checkInterval):
+		}
+	}
+}
+
+func (m *Manager) needsRenewal(now time.Time) bool {
+	cert := m.cert.Load()
+	return cert == nil || now.Add(m.cfg.RenewBefore).After(cert.Leaf.NotAfter)
+}
+
+func (m *Manager) load() error {
+	cert, err := tls.LoadX509KeyPair(m.path(certFile), m.path(keyFile))
+	if err != nil {
+		return err
+	}
+	m.setCertificate(&cert)
+	return nil
+}
+
+func (m *Manager) setCertificate(cert *tls.Certificate) {
+	m.cert.Store(cert)
+	m.readyOnce.Do(func() { close(m.ready) })
+}
+
+func (m *Manager) obtain(ctx context.Context) error {
+	accountKey, err := m.accountKey()
+	if err != nil {
+		return err
+	}
+	client := &acme.Client{Key: accountKey, DirectoryURL: m.cfg.Directory}
+	account := &acme.Account{}
+	if m.cfg.Email != "" {
+		account.Contact = []string{"mailto:" + m.cfg.Email}
+	}
+	if _, err = client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return fmt.Errorf("register account: %w", err)
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(m.cfg.Domains...))
+	if err != nil {
+		return fmt.Errorf("create order: %w", err)
+	}
+	for _, authzURL := range order.AuthzURLs {
+		if err = m.authorize(ctx, client, authzURL); err != nil {
+			return err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return fmt.Errorf("wait order: %w", err)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: m.cfg.Domains}, certKey)
+	if err != nil {
+		return err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("finalize order: %w", err)
+	}
+	return m.store(chain, certKey)
+}
+
+// authorize answers the DNS-01 challenge of a single authorization.
+func (m *Manager) authorize(ctx context.Context, client *acme.Client, authzURL string) error {
+	authz, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("get authorization: %w", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("no dns-01 challenge offered for %s", authz.Identifier.Value)
+	}
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return err
+	}
+	name := challengeName(authz.Identifier.Value)
+	m.solver.PresentTXT(name, value)
+	defer m.solver.CleanUpTXT(name, value)
+
+	if _, err := client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("accept challenge of %s: %w", authz.Identifier.Value, err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("authorize %s: %w", authz.Identifier.Value, err)
+	}
+	return nil
+}
+
+// challengeName returns the name of the TXT record answering the DNS-01 challenge of domain. The
+// challenge of a wildcard name is answered on its base domain.
+func challengeName(domain string) string {
+	return "_acme-challenge." + dns.Fqdn(strings.TrimPrefix(domain, "*."))
+}
+
+// accountKey loads the ACME account key, creating it on first use.
+func (m *Manager) accountKey() (crypto.Signer, error) {
+	if data, err := os.ReadFile(m.path(accountKeyFile)); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("invalid account key in %s", m.path(accountKeyFile))
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.writeFile(accountKeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (m *Manager) store(chain [][]byte, key *ecdsa.PrivateKey) error {
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if err := m.writeFile(keyFile, keyPEM); err != nil {
+		return err
+	}
+	if err := m.writeFile(certFile, certPEM); err != nil {
+		return err
+	}
+	m.setCertificate(&cert)
+	return nil
+}
+
+func (m *Manager) path(name string) string {
+	return filepath.Join(m.cfg.CacheDir, name)
+}
+
+// writeFile atomically replaces name in the cache directory.
+func (m *Manager) writeFile(name string, data []byte) error {
+	if err := os.MkdirAll(m.cfg.CacheDir, cacheDirPerm); err != nil {
+		return err
+	}
+	tmp := m.path(name + ".tmp")
+	if err := os.WriteFile(tmp, data, cacheFilePerm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path(name))
+}
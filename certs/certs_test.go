@@ -0,0 +1,325 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// testSolver records the TXT records published for challenges.
+type testSolver struct {
+	mu        sync.Mutex
+	txt       map[string][]string
+	presented []string
+}
+
+func (s *testSolver) PresentTXT(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.txt[name] = append(s.txt[name], value)
+	s.presented = append(s.presented, name)
+}
+
+func (s *testSolver) CleanUpTXT(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.txt[name] = slices.DeleteFunc(s.txt[name], func(v string) bool { return v == value })
+	if len(s.txt[name]) == 0 {
+		delete(s.txt, name)
+	}
+}
+
+func (s *testSolver) published(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.txt[name]) > 0
+}
+
+// fakeCA is a minimal ACME server validating challenges against the TXT records of solver. It
+// trusts every request signature and names authorizations after the identifiers as ordered.
+type fakeCA struct {
+	t      *testing.T
+	solver *testSolver
+	url    string
+	key    *ecdsa.PrivateKey
+	cert   *x509.Certificate
+
+	mu          sync.Mutex
+	identifiers []string
+	valid       map[int]bool
+	chain       []byte
+}
+
+func newFakeCA(t *testing.T, solver *testSolver) *fakeCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &fakeCA{t: t, solver: solver, key: key, cert: cert, valid: make(map[int]bool)}
+	server := httptest.NewServer(ca)
+	t.Cleanup(server.Close)
+	ca.url = server.URL
+	return ca
+}
+
+func (ca *fakeCA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	var payload []byte
+	if r.Method == http.MethodPost {
+		var jws struct {
+			Payload string `json:"payload"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		payload, _ = base64.RawURLEncoding.DecodeString(jws.Payload)
+	}
+
+	path := r.URL.Path
+	authzIndex, isAuthz := pathIndex(path, "/authz/")
+	challengeIndex, isChallenge := pathIndex(path, "/challenge/")
+	switch {
+	case path == "/directory":
+		ca.json(w, http.StatusOK, map[string]string{
+			"newNonce":   ca.url + "/nonce",
+			"newAccount": ca.url + "/account",
+			"newOrder":   ca.url + "/order",
+		})
+	case path == "/nonce":
+		w.WriteHeader(http.StatusOK)
+	case path == "/account":
+		w.Header().Set("Location", ca.url+"/account/1")
+		ca.json(w, http.StatusCreated, map[string]string{"status": "valid"})
+	case path == "/order":
+		var req struct {
+			Identifiers []struct {
+				Value string `json:"value"`
+			} `json:"identifiers"`
+		}
+		_ = json.Unmarshal(payload, &req)
+		for _, id := range req.Identifiers {
+			ca.identifiers = append(ca.identifiers, id.Value)
+		}
+		w.Header().Set("Location", ca.url+"/order/1")
+		ca.json(w, http.StatusCreated, ca.order())
+	case path == "/order/1":
+		w.Header().Set("Location", ca.url+"/order/1")
+		ca.json(w, http.StatusOK, ca.order())
+	case isAuthz:
+		ca.json(w, http.StatusOK, ca.authz(authzIndex))
+	case isChallenge:
+		// Only the wildcard-free name of an identifier is a valid DNS name for the challenge.
+		id := ca.identifiers[challengeIndex]
+		name := "_acme-challenge." + strings.TrimPrefix(id, "*.") + "."
+		if !ca.solver.published(name) {
+			ca.t.Errorf("challenge of %s accepted without a TXT record at %s", id, name)
+		}
+		ca.valid[challengeIndex] = true
+		ca.json(w, http.StatusOK, map[string]string{"type": "dns-01", "url": r.URL.String(), "status": "valid"})
+	case path == "/finalize":
+		ca.finalize(w, payload)
+	case path == "/cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = w.Write(ca.chain)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// pathIndex parses the index following prefix in path.
+func pathIndex(path, prefix string) (int, bool) {
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok {
+		return 0, false
+	}
+	index, err := strconv.Atoi(rest)
+	return index, err == nil
+}
+
+func (ca *fakeCA) json(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (ca *fakeCA) order() map[string]any {
+	authzs := make([]string, len(ca.identifiers))
+	for i := range ca.identifiers {
+		authzs[i] = fmt.Sprintf("%s/authz/%d", ca.url, i)
+	}
+	status := "pending"
+	if len(ca.valid) == len(ca.identifiers) {
+		status = "ready"
+	}
+	order := map[string]any{"status": status, "authorizations": authzs, "finalize": ca.url + "/finalize"}
+	if ca.chain != nil {
+		order["status"], order["certificate"] = "valid", ca.url+"/cert"
+	}
+	return order
+}
+
+func (ca *fakeCA) authz(index int) map[string]any {
+	status := "pending"
+	if ca.valid[index] {
+		status = "valid"
+	}
+	return map[string]any{
+		"status":     status,
+		"identifier": map[string]string{"type": "dns", "value": ca.identifiers[index]},
+		"challenges": []map[string]string{
+			{"type": "http-01", "url": fmt.Sprintf("%s/unused/%d", ca.url, index), "token": "unused", "status": "pending"},
+			{"type": "dns-01", "url": fmt.Sprintf("%s/challenge/%d", ca.url, index), "token": fmt.Sprintf("token-%d", index), "status": "pending"},
+		},
+	}
+}
+
+func (ca *fakeCA) finalize(w http.ResponseWriter, payload []byte) {
+	var req struct {
+		CSR string `json:"csr"`
+	}
+	_ = json.Unmarshal(payload, &req)
+	der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ca.chain = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+	w.Header().Set("Location", ca.url+"/order/1")
+	ca.json(w, http.StatusOK, ca.order())
+}
+
+func TestManagerObtainsCertificate(t *testing.T) {
+	t.Parallel()
+
+	solver := &testSolver{txt: make(map[string][]string)}
+	ca := newFakeCA(t, solver)
+	cfg := config.ACMEConfig{
+		Directory:   ca.url + "/directory",
+		Domains:     []string{"example.com", "*.example.com"},
+		CacheDir:    t.TempDir(),
+		RenewBefore: 30 * 24 * time.Hour,
+	}
+	m := New(cfg, solver)
+	if _, err := m.GetCertificate(nil); err == nil {
+		t.Fatal("GetCertificate() before issuance expected error, got nil")
+	}
+	if isReady(m) {
+		t.Fatal("Ready() closed before issuance")
+	}
+
+	if err := m.obtain(context.Background()); err != nil {
+		t.Fatalf("obtain() returned error: %v", err)
+	}
+	if !isReady(m) {
+		t.Fatal("Ready() not closed after issuance")
+	}
+	cert, err := m.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate() returned error: %v", err)
+	}
+	if !slices.Equal(cert.Leaf.DNSNames, cfg.Domains) || len(cert.Certificate) != 2 {
+		t.Fatalf("certificate names %v with %d certificates, want %v with the chain", cert.Leaf.DNSNames, len(cert.Certificate), cfg.Domains)
+	}
+	if want := []string{"_acme-challenge.example.com.", "_acme-challenge.example.com."}; !slices.Equal(solver.presented, want) {
+		t.Fatalf("challenges presented at %v, want %v", solver.presented, want)
+	}
+	if len(solver.txt) != 0 {
+		t.Fatalf("txt records = %v, want every challenge cleaned up", solver.txt)
+	}
+	if m.needsRenewal(time.Now()) || !m.needsRenewal(time.Now().Add(61*24*time.Hour)) {
+		t.Fatal("needsRenewal() does not follow renew_before")
+	}
+
+	// The issued certificate is cached for the next start.
+	assertCached(t, New(cfg, solver), cert.Leaf)
+}
+
+func assertCached(t *testing.T, m *Manager, leaf *x509.Certificate) {
+	t.Helper()
+	if err := m.load(); err != nil {
+		t.Fatalf("load() returned error: %v", err)
+	}
+	if got, _ := m.GetCertificate(nil); got == nil || !got.Leaf.Equal(leaf) {
+		t.Fatal("cached certificate differs from the issued one")
+	}
+	if !isReady(m) {
+		t.Fatal("Ready() not closed after loading the cached certificate")
+	}
+}
+
+func isReady(m *Manager) bool {
+	select {
+	case <-m.Ready():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestChallengeName(t *testing.T) {
+	t.Parallel()
+
+	for domain, want := range map[string]string{
+		"example.com":       "_acme-challenge.example.com.",
+		"example.com.":      "_acme-challenge.example.com.",
+		"*.example.com":     "_acme-challenge.example.com.",
+		"*.sub.example.com": "_acme-challenge.sub.example.com.",
+	} {
+		if got := challengeName(domain); got != want {
+			t.Errorf("challengeName(%q) = %q, want %q", domain, got, want)
+		}
+	}
+}
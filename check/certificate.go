@@ -0,0 +1,87 @@
+package check
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+
+	utls "github.com/refraction-networking/utls"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// certificatePins holds the expectations on the leaf certificate presented by candidates. A nil
+// certificatePins accepts every certificate.
+type certificatePins struct {
+	spki    map[string]struct{}
+	issuer  *regexp.Regexp
+	subject *regexp.Regexp
+	// err is the error of a pattern failing to compile, configs are validated before use.
+	err error
+}
+
+// newCertificatePins returns the pins of cc, nil when it pins nothing.
+func newCertificatePins(cc config.CertificateCheck) *certificatePins {
+	if !cc.Enabled() {
+		return nil
+	}
+	pins := &certificatePins{spki: make(map[string]struct{}, len(cc.SPKI))}
+	for _, digest := range cc.SPKI {
+		pins.spki[digest] = struct{}{}
+	}
+	compile := func(pattern string) *regexp.Regexp {
+		if pattern == "" {
+			return nil
+		}
+		re, err := regexp.Compile(pattern)
+		pins.err = errors.Join(pins.err, err)
+		return re
+	}
+	pins.issuer = compile(cc.Issuer)
+	pins.subject = compile(cc.Subject)
+	return pins
+}
+
+// verify checks the leaf certificate presented on conn.
+func (p *certificatePins) verify(conn net.Conn) error {
+	if p == nil {
+		return nil
+	}
+	if p.err != nil {
+		return p.err
+	}
+	certs := peerCertificates(conn)
+	if len(certs) == 0 {
+		return errors.New("no certificate presented")
+	}
+	leaf := certs[0]
+	if len(p.spki) > 0 {
+		digest := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+		if _, ok := p.spki[base64.StdEncoding.EncodeToString(digest[:])]; !ok {
+			return fmt.Errorf("certificate of %q is not pinned", leaf.Subject)
+		}
+	}
+	if p.issuer != nil && !p.issuer.MatchString(leaf.Issuer.String()) {
+		return fmt.Errorf("certificate issuer %q does not match %q", leaf.Issuer, p.issuer)
+	}
+	if p.subject != nil && !p.subject.MatchString(leaf.Subject.String()) {
+		return fmt.Errorf("certificate subject %q does not match %q", leaf.Subject, p.subject)
+	}
+	return nil
+}
+
+// peerCertificates returns the certificates presented on a connection returned by dialTLS.
+func peerCertificates(conn net.Conn) []*x509.Certificate {
+	switch conn := conn.(type) {
+	case *tls.Conn:
+		return conn.ConnectionState().PeerCertificates
+	case *utls.UConn:
+		return conn.ConnectionState().PeerCertificates
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package check
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestTLSCheckCertificatePins(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(srv.Close)
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	digest := sha256.Sum256(srv.Certificate().RawSubjectPublicKeyInfo)
+	pinned := base64.StdEncoding.EncodeToString(digest[:])
+	other := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	tests := []struct {
+		name    string
+		pins    config.CertificateCheck
+		wantErr string
+	}{
+		{name: "spki", pins: config.CertificateCheck{SPKI: []string{other, pinned}}},
+		{name: "issuer", pins: config.CertificateCheck{Issuer: "O=Acme Co$"}},
+		{name: "unpinned spki", pins: config.CertificateCheck{SPKI: []string{other}}, wantErr: "not pinned"},
+		{name: "other subject", pins: config.CertificateCheck{Subject: "CN=edge"}, wantErr: "subject"},
+		{
+			name:    "issuer of another origin",
+			pins:    config.CertificateCheck{SPKI: []string{pinned}, Issuer: "Let's Encrypt"},
+			wantErr: "issuer",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			check := newTLSCheck(&config.ScanConfig{
+				SNI:         "example.com",
+				Port:        addr.Port,
+				Timeout:     int(time.Second),
+				Certificate: tt.pins,
+			})
+			check.rootCAs = roots
+			err := check.Check(context.Background(), addr.IP)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Check() returned error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Check() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuildAddsTLSCheckForCertificatePins(t *testing.T) {
+	t.Parallel()
+
+	checks := Build(&config.ScanConfig{Certificate: config.CertificateCheck{Issuer: "Acme"}})
+	if len(checks) != 1 || checks[0].String() != "tls" {
+		t.Fatalf("Build() = %v, want the tls check", checks)
+	}
+	if checks := Build(&config.ScanConfig{}); len(checks) != 0 {
+		t.Fatalf("Build() = %v, want no checks without pins", checks)
+	}
+}
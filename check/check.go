@@ -0,0 +1,109 @@
+// Package check runs native health checks that complement mithra programs.
+package check
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/go-tools/log"
+
+	"github.com/fmotalleb/mithra/vm"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// Checker validates a single candidate IP.
+type Checker interface {
+	Check(ctx context.Context, ip net.IP) error
+	String() string
+}
+
+// Runner executes the domain program, if any, followed by the native checks.
+type Runner struct {
+	vm     *vm.VM
+	checks []Checker
+}
+
+// NewRunner builds the program and native checks configured for a domain.
+func NewRunner(sc *config.ScanConfig) (*Runner, error) {
+	vmRuntime, err := sc.BuildVM()
+	if err != nil {
+		return nil, err
+	}
+	return &Runner{
+		vm:     vmRuntime,
+		checks: Build(sc),
+	}, nil
+}
+
+// ErrHTTP3Unsupported is returned for the HTTP/3 check by builds without HTTP/3 support.
+var ErrHTTP3Unsupported = errors.New("http3 check is not built in, rebuild without the no_http3 and minimal tags")
+
+// NewChecksRunner runs checks without a program, so tests can replace the probes of a domain.
+func NewChecksRunner(checks ...Checker) *Runner {
+	return &Runner{checks: checks}
+}
+
+// Build returns the native checks enabled for a domain.
+func Build(sc *config.ScanConfig) []Checker {
+	checks := make([]Checker, 0)
+	if sc.Resolve.Enabled {
+		checks = append(checks, newResolveCheck(sc))
+	}
+	if sc.NativeTLS() {
+		checks = append(checks, newTLSCheck(sc))
+	}
+	if sc.NativeHTTP() {
+		checks = append(checks, newHTTPCheck(sc))
+	}
+	if sc.HTTP3.Enabled {
+		checks = append(checks, newHTTP3Check(sc))
+	}
+	if sc.Speed.Enabled {
+		checks = append(checks, newSpeedCheck(sc))
+	}
+	return checks
+}
+
+// Run probes ip with the program, if any, and, if it succeeds, with every native check.
+// Each step is logged at debug level through the logger carried by ctx.
+func (r *Runner) Run(ctx context.Context, ip net.IP) vm.Result {
+	logger := log.Of(ctx)
+	res := vm.Result{Success: true}
+	if r.vm != nil {
+		res = r.vm.ExecuteIP(ctx, ip)
+		if !res.Success {
+			logger.Debug("program failed",
+				zap.String("step", Step(res)),
+				zap.Duration("duration", res.Duration),
+				zap.Error(res.Error),
+			)
+			return res
+		}
+		logger.Debug("program passed", zap.Duration("duration", res.Duration))
+	}
+	start := time.Now()
+	for _, c := range r.checks {
+		checkStart := time.Now()
+		if err := c.Check(ctx, ip); err != nil {
+			res.Success = false
+			res.Error = &Error{Check: c.String(), Err: err}
+			logger.Debug("check failed",
+				zap.String("check", c.String()),
+				zap.Duration("duration", time.Since(checkStart)),
+				zap.Error(err),
+			)
+			break
+		}
+		logger.Debug("check passed",
+			zap.String("check", c.String()),
+			zap.Duration("duration", time.Since(checkStart)),
+		)
+	}
+	res.Duration += time.Since(start)
+	return res
+}
@@ -0,0 +1,125 @@
+package check
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+var fingerprints = map[string]utls.ClientHelloID{
+	config.FingerprintChrome:  utls.HelloChrome_Auto,
+	config.FingerprintFirefox: utls.HelloFirefox_Auto,
+	config.FingerprintSafari:  utls.HelloSafari_Auto,
+	config.FingerprintEdge:    utls.HelloEdge_Auto,
+	config.FingerprintIOS:     utls.HelloIOS_Auto,
+	config.FingerprintAndroid: utls.HelloAndroid_11_OkHttp,
+}
+
+// cipherSuiteIDs maps cipher suite names to their IDs, unknown names are skipped
+// as they are rejected during config validation.
+func cipherSuiteIDs(names []string) []uint16 {
+	known := make(map[string]uint16)
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, s := range suites {
+			known[s.Name] = s.ID
+		}
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		if id, ok := known[name]; ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// stdTLSConfig returns a crypto/tls config honoring the ALPN list and cipher suites of profile.
+// crypto/tls picks its own cipher order, only a fingerprint keeps the configured one.
+func stdTLSConfig(profile config.ClientProfile, serverName string) *tls.Config {
+	return &tls.Config{
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   profile.ALPN,
+		CipherSuites: cipherSuiteIDs(profile.CipherSuites),
+	}
+}
+
+// dialTLS connects to addr and completes a TLS handshake shaped by profile. The certificate is
+// verified with rootCAs, the system roots when nil.
+func dialTLS(
+	ctx context.Context,
+	dialer *net.Dialer,
+	addr string,
+	profile config.ClientProfile,
+	serverName string,
+	rootCAs *x509.CertPool,
+) (net.Conn, error) {
+	conn, err := dial(ctx, dialer, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	if profile.Fingerprint == "" {
+		tlsConfig := stdTLSConfig(profile, serverName)
+		tlsConfig.RootCAs = rootCAs
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		timingOf(ctx).handshaken(start)
+		return tlsConn, nil
+	}
+	spec, err := fingerprintSpec(profile)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	uconn := utls.UClient(conn, &utls.Config{ServerName: serverName, RootCAs: rootCAs}, utls.HelloCustom)
+	if err := uconn.ApplyPreset(&spec); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if err := uconn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	timingOf(ctx).handshaken(start)
+	return uconn, nil
+}
+
+// fingerprintSpec returns the ClientHello of the profile fingerprint with the ALPN list
+// and cipher order of profile applied on top.
+func fingerprintSpec(profile config.ClientProfile) (utls.ClientHelloSpec, error) {
+	id, ok := fingerprints[profile.Fingerprint]
+	if !ok {
+		return utls.ClientHelloSpec{}, fmt.Errorf("unknown fingerprint %q", profile.Fingerprint)
+	}
+	spec, err := utls.UTLSIdToSpec(id)
+	if err != nil {
+		return spec, err
+	}
+	if len(profile.CipherSuites) > 0 {
+		suites := cipherSuiteIDs(profile.CipherSuites)
+		// Keep the GREASE value of fingerprints that send one.
+		if len(spec.CipherSuites) > 0 && spec.CipherSuites[0] == utls.GREASE_PLACEHOLDER {
+			suites = append([]uint16{utls.GREASE_PLACEHOLDER}, suites...)
+		}
+		spec.CipherSuites = suites
+	}
+	if len(profile.ALPN) > 0 {
+		for _, ext := range spec.Extensions {
+			if alpn, ok := ext.(*utls.ALPNExtension); ok {
+				alpn.AlpnProtocols = profile.ALPN
+			}
+		}
+	}
+	return spec, nil
+}
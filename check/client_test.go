@@ -0,0 +1,33 @@
+package check
+
+import (
+	"crypto/tls"
+	"slices"
+	"testing"
+
+	utls "github.com/refraction-networking/utls"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestFingerprintSpecAppliesOverrides(t *testing.T) {
+	t.Parallel()
+
+	spec, err := fingerprintSpec(config.ClientProfile{
+		Fingerprint:  config.FingerprintChrome,
+		ALPN:         []string{"http/1.1"},
+		CipherSuites: []string{"TLS_CHACHA20_POLY1305_SHA256", "TLS_AES_128_GCM_SHA256"},
+	})
+	if err != nil {
+		t.Fatalf("fingerprintSpec() error = %v", err)
+	}
+	wantSuites := []uint16{utls.GREASE_PLACEHOLDER, tls.TLS_CHACHA20_POLY1305_SHA256, tls.TLS_AES_128_GCM_SHA256}
+	if !slices.Equal(spec.CipherSuites, wantSuites) {
+		t.Errorf("cipher suites = %x, want %x", spec.CipherSuites, wantSuites)
+	}
+	for _, ext := range spec.Extensions {
+		if alpn, ok := ext.(*utls.ALPNExtension); ok && !slices.Equal(alpn.AlpnProtocols, []string{"http/1.1"}) {
+			t.Errorf("alpn = %v, want [http/1.1]", alpn.AlpnProtocols)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package check
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/fmotalleb/mithra/vm"
+)
+
+// Error is the failure of a native check.
+type Error struct {
+	Check string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return e.Check + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Step names the probe step that failed in res, "program[i]" for program instructions
+// and the check name for native checks. It returns "" for successful probes.
+func Step(res vm.Result) string {
+	var checkErr *Error
+	if errors.As(res.Error, &checkErr) {
+		return checkErr.Check
+	}
+	var probeErr *vm.ProbeError
+	if errors.As(res.Error, &probeErr) {
+		return "program[" + strconv.Itoa(probeErr.Index) + "]"
+	}
+	return ""
+}
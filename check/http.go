@@ -0,0 +1,233 @@
+package check
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+var errTooManyRedirects = errors.New("too many redirects")
+
+const (
+	schemeHTTP  = "http"
+	schemeHTTPS = "https"
+)
+
+type httpCheck struct {
+	cfg        config.HTTPCheck
+	profile    config.ClientProfile
+	scheme     string
+	host       string
+	port       string
+	path       string
+	statusCode int
+	timeout    time.Duration
+	bodyRegex  *regexp.Regexp
+	headers    []headerExpectation
+	// err is the error of a pattern failing to compile, configs are validated before use.
+	err error
+}
+
+// headerExpectation requires a response header with a value matching pattern, any value when
+// pattern is nil.
+type headerExpectation struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+func newHTTPCheck(sc *config.ScanConfig) *httpCheck {
+	scheme := schemeHTTPS
+	if sc.HTTPOnly {
+		scheme = schemeHTTP
+	}
+	var err error
+	compile := func(pattern string) *regexp.Regexp {
+		if pattern == "" {
+			return nil
+		}
+		re, compileErr := regexp.Compile(pattern)
+		err = errors.Join(err, compileErr)
+		return re
+	}
+	bodyRegex := compile(sc.HTTP.Expect.BodyRegex)
+	headers := make([]headerExpectation, 0, len(sc.HTTP.Expect.Headers))
+	for _, name := range slices.Sorted(maps.Keys(sc.HTTP.Expect.Headers)) {
+		headers = append(headers, headerExpectation{name: name, pattern: compile(sc.HTTP.Expect.Headers[name])})
+	}
+	return &httpCheck{
+		cfg:        sc.HTTP,
+		profile:    sc.Client,
+		scheme:     scheme,
+		host:       sc.SNI,
+		port:       strconv.Itoa(sc.Port),
+		path:       sc.Path,
+		statusCode: sc.StatusCode,
+		timeout:    time.Duration(sc.Timeout),
+		bodyRegex:  bodyRegex,
+		headers:    headers,
+		err:        err,
+	}
+}
+
+func (h *httpCheck) String() string { return "http" }
+
+// Check requests the configured path from ip and applies the redirect policy and expectations.
+func (h *httpCheck) Check(ctx context.Context, ip net.IP) error {
+	if h.err != nil {
+		return h.err
+	}
+	origin := net.JoinHostPort(h.host, h.port)
+	transport := pinnedTransport(h.profile, h.host, h.port, ip, h.timeout)
+	defer transport.CloseIdleConnections()
+
+	var location string
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   h.timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.Response != nil {
+				location = req.Response.Header.Get("Location")
+			}
+			return h.checkRedirect(req, via)
+		},
+	}
+
+	reqURL := url.URL{Scheme: h.scheme, Host: origin, Path: h.path}
+	req, err := http.NewRequestWithContext(traceHandshake(ctx), http.MethodGet, reqURL.String(), http.NoBody)
+	if err != nil {
+		return err
+	}
+	if h.profile.UserAgent != "" {
+		req.Header.Set("User-Agent", h.profile.UserAgent)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := h.readBody(resp.Body)
+	if err != nil {
+		return err
+	}
+	if loc := resp.Header.Get("Location"); loc != "" {
+		location = loc
+	}
+	return h.checkResponse(req.URL, resp, location, body)
+}
+
+// checkRedirect applies the redirect policy to req, redirected from via.
+func (h *httpCheck) checkRedirect(req *http.Request, via []*http.Request) error {
+	if !h.cfg.FollowRedirects {
+		return http.ErrUseLastResponse
+	}
+	if len(via) > h.cfg.RedirectLimit() {
+		return errTooManyRedirects
+	}
+	if h.cfg.SameHostRedirects && req.URL.Host != via[0].URL.Host {
+		return fmt.Errorf("redirect to foreign host %q", req.URL.Host)
+	}
+	return nil
+}
+
+// readBody returns the body when an expectation matches it, up to the drain limit, and drains it
+// otherwise.
+func (h *httpCheck) readBody(r io.Reader) ([]byte, error) {
+	if h.cfg.Expect.BodyContains == "" && h.bodyRegex == nil {
+		_, _ = io.Copy(io.Discard, io.LimitReader(r, maxDrainBytes))
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r, maxDrainBytes))
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	return body, nil
+}
+
+// checkResponse applies the expectations to the final response of a request of reqURL, location
+// being the last Location header seen.
+func (h *httpCheck) checkResponse(reqURL *url.URL, resp *http.Response, location string, body []byte) error {
+	if h.statusCode > 0 && resp.StatusCode != h.statusCode {
+		return fmt.Errorf("status mismatch: expected %d got %d", h.statusCode, resp.StatusCode)
+	}
+	if expected := h.cfg.Expect.RedirectLocation; expected != "" && !sameLocation(reqURL, location, expected) {
+		return fmt.Errorf("redirect location mismatch: expected %q got %q", expected, location)
+	}
+	if err := h.checkHeaders(resp.Header); err != nil {
+		return err
+	}
+	if expected := h.cfg.Expect.BodyContains; expected != "" && !bytes.Contains(body, []byte(expected)) {
+		return fmt.Errorf("body does not contain %q", expected)
+	}
+	if h.bodyRegex != nil && !h.bodyRegex.Match(body) {
+		return fmt.Errorf("body does not match %q", h.bodyRegex)
+	}
+	return nil
+}
+
+// checkHeaders applies the header expectations to the headers of the final response.
+func (h *httpCheck) checkHeaders(header http.Header) error {
+	for _, expected := range h.headers {
+		values := header.Values(expected.name)
+		if len(values) == 0 {
+			return fmt.Errorf("header %s missing", http.CanonicalHeaderKey(expected.name))
+		}
+		if expected.pattern != nil && !slices.ContainsFunc(values, expected.pattern.MatchString) {
+			return fmt.Errorf("header %s %q does not match %q", http.CanonicalHeaderKey(expected.name), values, expected.pattern)
+		}
+	}
+	for _, name := range h.cfg.Expect.AbsentHeaders {
+		if values := header.Values(name); len(values) > 0 {
+			return fmt.Errorf("header %s present: %q", http.CanonicalHeaderKey(name), values)
+		}
+	}
+	return nil
+}
+
+const maxDrainBytes = 64 << 10
+
+// pinnedTransport returns a transport for requests to host:port, connecting them to ip.
+func pinnedTransport(profile config.ClientProfile, host, port string, ip net.IP, timeout time.Duration) *http.Transport {
+	origin := net.JoinHostPort(host, port)
+	target := net.JoinHostPort(ip.String(), port)
+	dialer := &net.Dialer{Timeout: timeout}
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			// Requests to the probed origin are pinned to the candidate IP.
+			if addr == origin {
+				addr = target
+			}
+			return dial(ctx, dialer, network, addr)
+		},
+		TLSClientConfig:   stdTLSConfig(profile, host),
+		ForceAttemptHTTP2: slices.Contains(profile.ALPN, "h2"),
+		DisableKeepAlives: true,
+	}
+}
+
+// sameLocation compares a Location header with the expected value, accepting
+// both the raw header and its form resolved against the request URL.
+func sameLocation(base *url.URL, location string, expected string) bool {
+	if location == "" {
+		return false
+	}
+	if location == expected {
+		return true
+	}
+	resolved, err := base.Parse(location)
+	if err != nil {
+		return false
+	}
+	return resolved.String() == expected
+}
@@ -0,0 +1,149 @@
+//go:build !no_http3 && !minimal
+
+package check
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/quic"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// HTTP3Supported tells whether the HTTP/3 check is built in, the no_http3 and minimal build tags
+// leave it out along with its QUIC dependency.
+const HTTP3Supported = true
+
+const (
+	http3ALPN = "h3"
+	// quicCloseTimeout bounds the wait for the peer to acknowledge the close of the connection.
+	quicCloseTimeout = 100 * time.Millisecond
+)
+
+// http3Check requests the configured path from the candidate over HTTP/3, after a QUIC handshake
+// with the SNI. The QUIC stack shapes its own handshake, client.fingerprint does not apply, and
+// its traffic is not counted against max_bytes_per_cycle.
+type http3Check struct {
+	profile    config.ClientProfile
+	host       string
+	port       string
+	path       string
+	statusCode int
+	timeout    time.Duration
+	// rootCAs verifies the certificate of the edge, the system roots when nil.
+	rootCAs *x509.CertPool
+}
+
+func newHTTP3Check(sc *config.ScanConfig) *http3Check {
+	return &http3Check{
+		profile:    sc.Client,
+		host:       sc.SNI,
+		port:       strconv.Itoa(cmp.Or(sc.HTTP3.Port, sc.Port)),
+		path:       sc.Path,
+		statusCode: sc.StatusCode,
+		timeout:    time.Duration(sc.Timeout),
+	}
+}
+
+func (h *http3Check) String() string { return "http3" }
+
+// Check completes a QUIC handshake with ip negotiating h3, requests the configured path and
+// checks the status of the response. The handshake is timed as the handshake of the probe.
+func (h *http3Check) Check(ctx context.Context, ip net.IP) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	endpoint, err := quic.Listen("udp", ":0", nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), quicCloseTimeout)
+		defer cancel()
+		_ = endpoint.Close(closeCtx)
+	}()
+
+	tlsConfig := stdTLSConfig(h.profile, h.host)
+	tlsConfig.MinVersion = tls.VersionTLS13
+	tlsConfig.NextProtos = []string{http3ALPN}
+	tlsConfig.RootCAs = h.rootCAs
+	start := time.Now()
+	conn, err := endpoint.Dial(ctx, "udp", net.JoinHostPort(ip.String(), h.port), &quic.Config{
+		TLSConfig:        tlsConfig,
+		HandshakeTimeout: h.timeout,
+	})
+	if err != nil {
+		return err
+	}
+	timingOf(ctx).handshaken(start)
+	if proto := conn.ConnectionState().NegotiatedProtocol; proto != http3ALPN {
+		return fmt.Errorf("negotiated ALPN %q instead of %q", proto, http3ALPN)
+	}
+
+	status, err := h.roundTrip(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if h.statusCode > 0 && status != h.statusCode {
+		return fmt.Errorf("status mismatch: expected %d got %d", h.statusCode, status)
+	}
+	return nil
+}
+
+// roundTrip opens the control stream of the client and sends the request, returning the status
+// of the final response. The body of the response is not read.
+func (h *http3Check) roundTrip(ctx context.Context, conn *quic.Conn) (int, error) {
+	control, err := conn.NewSendOnlyStream(ctx)
+	if err != nil {
+		return 0, err
+	}
+	control.SetWriteContext(ctx)
+	// Default settings, which leave the peer without a dynamic table to encode the response with.
+	if _, err = control.Write(appendH3Frame([]byte{h3StreamControl}, h3FrameSettings, nil)); err != nil {
+		return 0, err
+	}
+	if err = control.Flush(); err != nil {
+		return 0, err
+	}
+
+	stream, err := conn.NewStream(ctx)
+	if err != nil {
+		return 0, err
+	}
+	stream.SetReadContext(ctx)
+	stream.SetWriteContext(ctx)
+	authority := h.host
+	if h.port != "443" {
+		authority = net.JoinHostPort(h.host, h.port)
+	}
+	headers := qpackRequest(authority, h.path, h.profile.UserAgent)
+	if _, err := stream.Write(appendH3Frame(nil, h3FrameHeaders, headers)); err != nil {
+		return 0, err
+	}
+	stream.CloseWrite()
+
+	for {
+		typ, payload, err := readH3Frame(stream)
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		switch typ {
+		case h3FrameHeaders:
+			status, err := qpackStatus(payload)
+			// Informational responses precede the final one.
+			if err != nil || status >= 200 {
+				return status, err
+			}
+		case h3FrameData:
+			return 0, errors.New("response data before its headers")
+		}
+		// Frames of other types, reserved ones among them, are ignored.
+	}
+}
@@ -0,0 +1,27 @@
+//go:build no_http3 || minimal
+
+package check
+
+import (
+	"context"
+	"net"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// HTTP3Supported tells whether the HTTP/3 check is built in, the no_http3 and minimal build tags
+// leave it out along with its QUIC dependency.
+const HTTP3Supported = false
+
+// http3Check fails every probe, NewHandler rejects configs enabling it in these builds.
+type http3Check struct{}
+
+func newHTTP3Check(*config.ScanConfig) *http3Check {
+	return &http3Check{}
+}
+
+func (h *http3Check) String() string { return "http3" }
+
+func (h *http3Check) Check(context.Context, net.IP) error {
+	return ErrHTTP3Unsupported
+}
@@ -0,0 +1,167 @@
+//go:build !no_http3 && !minimal
+
+package check
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+	"golang.org/x/net/quic"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// startHTTP3Server serves HTTP/3 on a local UDP port, answering every request with status, and
+// returns its port and the pool trusting its certificate for host.
+func startHTTP3Server(t *testing.T, host string, status int) (int, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	endpoint, err := quic.Listen("udp", "127.0.0.1:0", &quic.Config{TLSConfig: &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{http3ALPN},
+		MinVersion:   tls.VersionTLS13,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = endpoint.Close(context.Background()) })
+
+	// The status is a literal with the name of a static :status entry and a Huffman coded value.
+	value := strconv.Itoa(status)
+	response := appendQPACKInt([]byte{0, 0}, 0x50, 4, 25)
+	response = appendQPACKInt(response, 0x80, 7, hpack.HuffmanEncodeLength(value))
+	response = hpack.AppendHuffmanString(response, value)
+	go func() {
+		for {
+			conn, err := endpoint.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					stream, err := conn.AcceptStream(context.Background())
+					if err != nil {
+						return
+					}
+					if stream.IsReadOnly() {
+						continue
+					}
+					if typ, _, err := readH3Frame(stream); err != nil || typ != h3FrameHeaders {
+						stream.Reset(0x0101)
+						continue
+					}
+					_, _ = stream.Write(appendH3Frame(nil, h3FrameHeaders, response))
+					_ = stream.Close()
+				}
+			}()
+		}
+	}()
+	return int(endpoint.LocalAddr().Port()), roots
+}
+
+func TestHTTP3Check(t *testing.T) {
+	t.Parallel()
+
+	port, roots := startHTTP3Server(t, "edge.example.com", 204)
+	newCheck := func(statusCode int) *http3Check {
+		check := newHTTP3Check(&config.ScanConfig{
+			SNI:        "edge.example.com",
+			Port:       443,
+			Path:       "/health",
+			StatusCode: statusCode,
+			Timeout:    int(2 * time.Second),
+			HTTP3:      config.HTTP3Check{Enabled: true, Port: port},
+		})
+		check.rootCAs = roots
+		return check
+	}
+
+	var timing Timing
+	if err := newCheck(204).Check(WithTiming(context.Background(), &timing), net.IPv4(127, 0, 0, 1)); err != nil {
+		t.Fatalf("Check() returned error: %v", err)
+	}
+	if timing.Handshake() <= 0 {
+		t.Fatal("Check() did not time the QUIC handshake")
+	}
+	if err := newCheck(200).Check(context.Background(), net.IPv4(127, 0, 0, 1)); err == nil {
+		t.Fatal("Check() accepted a mismatching status")
+	}
+	if err := newHTTP3Check(&config.ScanConfig{
+		SNI:     "edge.example.com",
+		Port:    port,
+		Path:    "/",
+		Timeout: int(2 * time.Second),
+	}).Check(context.Background(), net.IPv4(127, 0, 0, 1)); err == nil {
+		t.Fatal("Check() accepted a certificate the system roots do not trust")
+	}
+}
+
+func TestQPACKStatus(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		section []byte
+		want    int
+		wantErr bool
+	}{
+		{name: "indexed", section: appendQPACKInt([]byte{0, 0}, 0xc0, 6, 64), want: 204},
+		{name: "name reference", section: appendQPACKField([]byte{0, 0}, 25, "302"), want: 302},
+		{
+			name:    "literal name",
+			section: appendQPACKString(appendQPACKString([]byte{0, 0}, 0x20, 3, ":status"), 0, 7, "418"),
+			want:    418,
+		},
+		{
+			name:    "after other fields",
+			section: appendQPACKInt(appendQPACKField([]byte{0, 0}, qpackUserAgent, "edge"), 0xc0, 6, 27),
+			want:    404,
+		},
+		{name: "dynamic table", section: []byte{1, 0, 0x80}, wantErr: true},
+		{name: "dynamic reference", section: []byte{0, 0, 0x80}, wantErr: true},
+		{name: "missing", section: appendQPACKInt([]byte{0, 0}, 0xc0, 6, qpackMethodGet), wantErr: true},
+		{name: "truncated", section: []byte{0, 0, 0x5f}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := qpackStatus(tt.section)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("qpackStatus() = %d, %v, want %d (error %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package check
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestHTTPCheckBodyExpectations(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "<html><title>Access denied</title></html>")
+	}))
+	t.Cleanup(srv.Close)
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+
+	tests := []struct {
+		name    string
+		expect  config.HTTPExpect
+		wantErr string
+	}{
+		{name: "contains", expect: config.HTTPExpect{BodyContains: "<title>Access"}},
+		{name: "regex", expect: config.HTTPExpect{BodyRegex: `(?i)access\s+denied`}},
+		{name: "block page", expect: config.HTTPExpect{BodyContains: "Welcome"}, wantErr: "body does not contain"},
+		{name: "regex mismatch", expect: config.HTTPExpect{BodyRegex: `^\{`}, wantErr: "body does not match"},
+		{
+			name:    "both must match",
+			expect:  config.HTTPExpect{BodyContains: "denied", BodyRegex: `Welcome`},
+			wantErr: "body does not match",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			check := newHTTPCheck(&config.ScanConfig{
+				SNI:        "edge.example.com",
+				Port:       addr.Port,
+				Path:       "/",
+				StatusCode: http.StatusOK,
+				Timeout:    int(time.Second),
+				HTTPOnly:   true,
+				HTTP:       config.HTTPCheck{Expect: tt.expect},
+			})
+			err := check.Check(context.Background(), addr.IP)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Check() returned error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Check() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPCheckHeaderExpectations(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Server", "cloudflare")
+		w.Header().Set("CF-RAY", "8f0c1a2b3c4d5e6f-FRA")
+		w.Header().Add("Via", "1.1 proxy")
+		w.Header().Add("Via", "1.1 filter")
+	}))
+	t.Cleanup(srv.Close)
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+
+	tests := []struct {
+		name    string
+		expect  config.HTTPExpect
+		wantErr string
+	}{
+		{name: "present", expect: config.HTTPExpect{Headers: map[string]string{"cf-ray": ""}}},
+		{name: "value", expect: config.HTTPExpect{Headers: map[string]string{"server": "^cloudflare$", "cf-ray": `-[A-Z]{3}$`}}},
+		{name: "any value", expect: config.HTTPExpect{Headers: map[string]string{"via": "filter"}}},
+		{name: "missing", expect: config.HTTPExpect{Headers: map[string]string{"x-served-by": ""}}, wantErr: "header X-Served-By missing"},
+		{name: "mismatch", expect: config.HTTPExpect{Headers: map[string]string{"server": "^nginx"}}, wantErr: "header Server"},
+		{name: "absent", expect: config.HTTPExpect{AbsentHeaders: []string{"x-filtered"}}},
+		{name: "filtering header", expect: config.HTTPExpect{AbsentHeaders: []string{"via"}}, wantErr: "header Via present"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			check := newHTTPCheck(&config.ScanConfig{
+				SNI:      "edge.example.com",
+				Port:     addr.Port,
+				Path:     "/",
+				Timeout:  int(time.Second),
+				HTTPOnly: true,
+				HTTP:     config.HTTPCheck{Expect: tt.expect},
+			})
+			err := check.Check(context.Background(), addr.IP)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Check() returned error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Check() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPCheckRedirectPolicy(t *testing.T) {
+	t.Parallel()
+
+	// /hop/N redirects N times before answering, /foreign redirects to another host.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/foreign" {
+			http.Redirect(w, r, "http://other.example.com/hop/0", http.StatusFound)
+			return
+		}
+		hops, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hop/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if hops > 0 {
+			http.Redirect(w, r, "/hop/"+strconv.Itoa(hops-1), http.StatusFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+	limit := func(n int) *int { return &n }
+
+	tests := []struct {
+		name       string
+		path       string
+		statusCode int
+		http       config.HTTPCheck
+		wantErr    string
+	}{
+		{name: "not followed", path: "/hop/1", statusCode: http.StatusFound},
+		{
+			name:       "location",
+			path:       "/hop/2",
+			statusCode: http.StatusFound,
+			http:       config.HTTPCheck{Expect: config.HTTPExpect{RedirectLocation: "/hop/1"}},
+		},
+		{
+			name:    "location mismatch",
+			path:    "/hop/2",
+			http:    config.HTTPCheck{Expect: config.HTTPExpect{RedirectLocation: "/hop/0"}},
+			wantErr: "redirect location mismatch",
+		},
+		{name: "default limit", path: "/hop/5", statusCode: http.StatusOK, http: config.HTTPCheck{FollowRedirects: true}},
+		{
+			name:    "above default limit",
+			path:    "/hop/6",
+			http:    config.HTTPCheck{FollowRedirects: true},
+			wantErr: errTooManyRedirects.Error(),
+		},
+		{
+			name:    "above limit",
+			path:    "/hop/3",
+			http:    config.HTTPCheck{FollowRedirects: true, MaxRedirects: limit(2)},
+			wantErr: errTooManyRedirects.Error(),
+		},
+		{
+			name:    "no redirect allowed",
+			path:    "/hop/1",
+			http:    config.HTTPCheck{FollowRedirects: true, MaxRedirects: limit(0)},
+			wantErr: errTooManyRedirects.Error(),
+		},
+		{
+			name:       "no redirect needed",
+			path:       "/hop/0",
+			statusCode: http.StatusOK,
+			http:       config.HTTPCheck{FollowRedirects: true, MaxRedirects: limit(0)},
+		},
+		{
+			name:    "same host only",
+			path:    "/foreign",
+			http:    config.HTTPCheck{FollowRedirects: true, SameHostRedirects: true},
+			wantErr: "redirect to foreign host",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			check := newHTTPCheck(&config.ScanConfig{
+				SNI:        "edge.example.com",
+				Port:       addr.Port,
+				Path:       tt.path,
+				StatusCode: tt.statusCode,
+				Timeout:    int(time.Second),
+				HTTPOnly:   true,
+				HTTP:       tt.http,
+			})
+			err := check.Check(context.Background(), addr.IP)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Check() returned error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Check() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,325 @@
+//go:build !no_http3 && !minimal
+
+package check
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// HTTP/3 frames (RFC 9114) and the subset of QPACK (RFC 9204) the HTTP/3 check needs. The check
+// announces no dynamic table, so field sections only reference the static table.
+
+const (
+	h3FrameData     = 0x00
+	h3FrameHeaders  = 0x01
+	h3FrameSettings = 0x04
+
+	h3StreamControl = 0x00
+
+	// maxH3FrameSize bounds the frames read from a response.
+	maxH3FrameSize = 64 << 10
+)
+
+// QUIC variable-length integers (RFC 9000, section 16) encode their length in the two most
+// significant bits of the first byte.
+const (
+	varintLen2      = 0x4000
+	varintLen4      = 0x80000000
+	varintLen8      = 0xc000000000000000
+	varintMask      = 0x3f
+	varintLenShift  = 6
+	varintByteShift = 8
+)
+
+// Patterns and prefix lengths of the QPACK integers and field line representations (RFC 9204,
+// sections 4.1.1 and 4.5).
+const (
+	qpackContinuation = 0x80
+	qpackIntMask      = 0x7f
+	qpackIntShift     = 7
+
+	qpackInsertCountBits = 8
+	qpackBaseBits        = 7
+	qpackValueBits       = 7
+
+	qpackIndexedBit      = 0x80
+	qpackIndexedStatic   = 0x40
+	qpackIndexedBits     = 6
+	qpackNameRefBit      = 0x40
+	qpackNameRefStatic   = 0x10
+	qpackNameRefBits     = 4
+	qpackLiteralNameBit  = 0x20
+	qpackLiteralNameBits = 3
+
+	qpackIndexed = qpackIndexedBit | qpackIndexedStatic
+	qpackNameRef = qpackNameRefBit | qpackNameRefStatic
+)
+
+// Indexes of the QPACK static table.
+const (
+	qpackAuthority   = 0
+	qpackPath        = 1
+	qpackMethodGet   = 17
+	qpackSchemeHTTPS = 23
+	qpackUserAgent   = 95
+)
+
+// qpackStatuses holds the :status entries of the QPACK static table.
+var qpackStatuses = map[uint64]string{
+	24: "103", 25: "200", 26: "304", 27: "404", 28: "503",
+	63: "100", 64: "204", 65: "206", 66: "302", 67: "400", 68: "403", 69: "421", 70: "425", 71: "500",
+}
+
+var (
+	errQPACKDynamic   = errors.New("qpack: field section references the dynamic table")
+	errQPACKTruncated = errors.New("qpack: truncated field section")
+	errNoStatus       = errors.New("response without :status")
+)
+
+const statusField = ":status"
+
+// appendVarint appends v as a QUIC variable-length integer.
+func appendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return binary.BigEndian.AppendUint16(b, varintLen2|uint16(v))
+	case v < 1<<30:
+		return binary.BigEndian.AppendUint32(b, varintLen4|uint32(v))
+	default:
+		return binary.BigEndian.AppendUint64(b, varintLen8|v)
+	}
+}
+
+// readVarint reads a QUIC variable-length integer.
+func readVarint(r io.ByteReader) (uint64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	v := uint64(first & varintMask)
+	for range 1<<(first>>varintLenShift) - 1 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		v = v<<varintByteShift | uint64(b)
+	}
+	return v, nil
+}
+
+// appendH3Frame appends a frame of type typ holding payload.
+func appendH3Frame(b []byte, typ uint64, payload []byte) []byte {
+	b = appendVarint(b, typ)
+	b = appendVarint(b, uint64(len(payload)))
+	return append(b, payload...)
+}
+
+// h3Reader is a stream frames are read from.
+type h3Reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// readH3Frame reads the next frame of r, io.EOF when the stream ended between frames.
+func readH3Frame(r h3Reader) (typ uint64, payload []byte, err error) {
+	typ, err = readVarint(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	size, err := readVarint(r)
+	if err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	if size > maxH3FrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes exceeds %d", size, maxH3FrameSize)
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	return typ, payload, nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// appendQPACKInt appends v as an integer with an n-bit prefix, the bits of the first byte above
+// the prefix set to flags.
+func appendQPACKInt(b []byte, flags byte, n uint, v uint64) []byte {
+	limit := uint64(1)<<n - 1
+	if v < limit {
+		return append(b, flags|byte(v))
+	}
+	b = append(b, flags|byte(limit))
+	for v -= limit; v >= qpackContinuation; v >>= qpackIntShift {
+		b = append(b, qpackContinuation|byte(v))
+	}
+	return append(b, byte(v))
+}
+
+// appendQPACKString appends s as a string literal without Huffman coding, its length having an
+// n-bit prefix.
+func appendQPACKString(b []byte, flags byte, n uint, s string) []byte {
+	return append(appendQPACKInt(b, flags, n, uint64(len(s))), s...)
+}
+
+// appendQPACKField appends a field line with the name of the static table entry index.
+func appendQPACKField(b []byte, index uint64, value string) []byte {
+	return appendQPACKString(appendQPACKInt(b, qpackNameRef, qpackNameRefBits, index), 0, qpackValueBits, value)
+}
+
+// qpackRequest returns the field section of a GET of path from authority.
+func qpackRequest(authority, path, userAgent string) []byte {
+	// Neither the required insert count nor the base reference a dynamic table.
+	b := []byte{0, 0}
+	b = appendQPACKInt(b, qpackIndexed, qpackIndexedBits, qpackMethodGet)
+	b = appendQPACKInt(b, qpackIndexed, qpackIndexedBits, qpackSchemeHTTPS)
+	b = appendQPACKField(b, qpackAuthority, authority)
+	if path == "/" {
+		b = appendQPACKInt(b, qpackIndexed, qpackIndexedBits, qpackPath)
+	} else {
+		b = appendQPACKField(b, qpackPath, path)
+	}
+	if userAgent != "" {
+		b = appendQPACKField(b, qpackUserAgent, userAgent)
+	}
+	return b
+}
+
+type qpackReader struct {
+	b []byte
+}
+
+// int reads an integer with an n-bit prefix and returns the first byte holding the prefix.
+func (r *qpackReader) int(n uint) (first byte, v uint64, err error) {
+	if len(r.b) == 0 {
+		return 0, 0, errQPACKTruncated
+	}
+	first, r.b = r.b[0], r.b[1:]
+	limit := uint64(1)<<n - 1
+	if v = uint64(first) & limit; v < limit {
+		return first, v, nil
+	}
+	for shift := uint(0); shift < 63; shift += qpackIntShift {
+		if len(r.b) == 0 {
+			return 0, 0, errQPACKTruncated
+		}
+		c := r.b[0]
+		r.b = r.b[1:]
+		v += uint64(c&qpackIntMask) << shift
+		if c&qpackContinuation == 0 {
+			return first, v, nil
+		}
+	}
+	return 0, 0, errors.New("qpack: integer overflow")
+}
+
+// string reads a string literal whose length has an n-bit prefix, Huffman coded when the bit
+// above the prefix is set.
+func (r *qpackReader) string(n uint) (string, error) {
+	first, size, err := r.int(n)
+	if err != nil {
+		return "", err
+	}
+	if size > uint64(len(r.b)) {
+		return "", errQPACKTruncated
+	}
+	raw := r.b[:size]
+	r.b = r.b[size:]
+	if first&(1<<n) != 0 {
+		return hpack.HuffmanDecodeToString(raw)
+	}
+	return string(raw), nil
+}
+
+// indexedField reads an indexed field line, whose first byte is first.
+func (r *qpackReader) indexedField(first byte) (name, value string, err error) {
+	_, index, err := r.int(qpackIndexedBits)
+	if err != nil {
+		return "", "", err
+	}
+	if first&qpackIndexedStatic == 0 {
+		return "", "", errQPACKDynamic
+	}
+	if status, ok := qpackStatuses[index]; ok {
+		return statusField, status, nil
+	}
+	return "", "", nil
+}
+
+// nameRefField reads a literal field line with a name reference, whose first byte is first.
+func (r *qpackReader) nameRefField(first byte) (name, value string, err error) {
+	_, index, err := r.int(qpackNameRefBits)
+	if err != nil {
+		return "", "", err
+	}
+	if first&qpackNameRefStatic == 0 {
+		return "", "", errQPACKDynamic
+	}
+	if value, err = r.string(qpackValueBits); err != nil {
+		return "", "", err
+	}
+	if _, ok := qpackStatuses[index]; ok {
+		name = statusField
+	}
+	return name, value, nil
+}
+
+// field reads the next field line, its name is empty when it references a static table entry
+// other than :status.
+func (r *qpackReader) field() (name, value string, err error) {
+	switch first := r.b[0]; {
+	case first&qpackIndexedBit != 0:
+		return r.indexedField(first)
+	case first&qpackNameRefBit != 0:
+		return r.nameRefField(first)
+	case first&qpackLiteralNameBit != 0:
+		if name, err = r.string(qpackLiteralNameBits); err != nil {
+			return "", "", err
+		}
+		value, err = r.string(qpackValueBits)
+		return name, value, err
+	default:
+		// Field lines referencing entries after the base, which are always dynamic.
+		return "", "", errQPACKDynamic
+	}
+}
+
+// qpackStatus returns the :status of the response field section.
+func qpackStatus(section []byte) (int, error) {
+	r := &qpackReader{b: section}
+	if _, insertCount, err := r.int(qpackInsertCountBits); err != nil {
+		return 0, err
+	} else if insertCount != 0 {
+		return 0, errQPACKDynamic
+	}
+	if _, _, err := r.int(qpackBaseBits); err != nil {
+		return 0, err
+	}
+	for len(r.b) > 0 {
+		name, value, err := r.field()
+		if err != nil {
+			return 0, err
+		}
+		if name == statusField {
+			status, err := strconv.Atoi(value)
+			if err != nil {
+				return 0, fmt.Errorf("invalid :status %q", value)
+			}
+			return status, nil
+		}
+	}
+	return 0, errNoStatus
+}
@@ -0,0 +1,179 @@
+package check
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/go-tools/log"
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+const (
+	ipv4Bits = 8 * net.IPv4len
+	ipv6Bits = 8 * net.IPv6len
+
+	resolveCacheTTL = time.Minute
+	// resolveFailureTTL caches failed lookups, so probes do not each wait for a failing resolver.
+	resolveFailureTTL = 10 * time.Second
+)
+
+var relationCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "helios_dns_scan_relation_total",
+		Help: "Candidate IPs by relation to the official answer set of the SNI.",
+	},
+	[]string{"domain", "relation"},
+)
+
+func init() {
+	prometheus.MustRegister(relationCounter)
+}
+
+// resolveCheck compares candidates with the official answers of the SNI hostname.
+type resolveCheck struct {
+	domain string
+	host   string
+	cfg    config.ResolveCheck
+	lookup func(ctx context.Context, resolver string, host string) ([]net.IP, error)
+
+	mu        sync.Mutex
+	official  []net.IP
+	err       error
+	expiresAt time.Time
+	// refreshing is closed once the lookup in flight completes, nil while none is.
+	refreshing chan struct{}
+}
+
+func newResolveCheck(sc *config.ScanConfig) *resolveCheck {
+	return &resolveCheck{
+		domain: sc.Domain,
+		host:   sc.SNI,
+		cfg:    sc.Resolve,
+		lookup: lookup,
+	}
+}
+
+func (r *resolveCheck) String() string { return "resolve" }
+
+// Check classifies ip and rejects it when the relation is not accepted.
+func (r *resolveCheck) Check(ctx context.Context, ip net.IP) error {
+	official, err := r.officialIPs(ctx)
+	if err != nil {
+		return err
+	}
+	relation := classify(ip, official, r.cfg.NearPrefix)
+	relationCounter.WithLabelValues(r.domain, relation).Inc()
+	log.Of(ctx).Debug("candidate classified",
+		zap.String("host", r.host),
+		zap.String("relation", relation),
+	)
+	if len(r.cfg.Accept) > 0 && !slices.Contains(r.cfg.Accept, relation) {
+		return fmt.Errorf("relation %q to %s is not accepted", relation, r.host)
+	}
+	return nil
+}
+
+// officialIPs returns the cached official answers of the SNI, or the error of the last lookup.
+// Once the cache expired a single probe looks them up again while the others wait for it.
+func (r *resolveCheck) officialIPs(ctx context.Context) ([]net.IP, error) {
+	for {
+		r.mu.Lock()
+		if time.Now().Before(r.expiresAt) {
+			official, err := r.official, r.err
+			r.mu.Unlock()
+			return official, err
+		}
+		done := r.refreshing
+		if done == nil {
+			done = make(chan struct{})
+			r.refreshing = done
+			r.mu.Unlock()
+			return r.refresh(ctx, done)
+		}
+		r.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// refresh looks the official answers up outside the lock and caches the result, then releases
+// the probes waiting on done. A lookup failing because its probe was canceled is not cached.
+func (r *resolveCheck) refresh(ctx context.Context, done chan struct{}) ([]net.IP, error) {
+	ips, err := r.lookup(ctx, r.cfg.Resolver, r.host)
+	if err != nil {
+		ips, err = nil, fmt.Errorf("resolve %s: %w", r.host, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refreshing = nil
+	close(done)
+	if err != nil && ctx.Err() != nil {
+		return nil, err
+	}
+	ttl := resolveCacheTTL
+	if err != nil {
+		ttl = resolveFailureTTL
+	}
+	r.official, r.err, r.expiresAt = ips, err, time.Now().Add(ttl)
+	return ips, err
+}
+
+// lookup resolves the A and AAAA records of host, using resolver when set
+// and the system resolver otherwise.
+func lookup(ctx context.Context, resolver string, host string) ([]net.IP, error) {
+	if resolver == "" {
+		return net.DefaultResolver.LookupIP(ctx, "ip", host)
+	}
+	client := new(dns.Client)
+	result := make([]net.IP, 0)
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg := new(dns.Msg)
+		msg.SetQuestion(dns.Fqdn(host), qtype)
+		resp, _, err := client.ExchangeContext(ctx, msg, resolver)
+		if err != nil {
+			return nil, err
+		}
+		for _, rr := range resp.Answer {
+			switch v := rr.(type) {
+			case *dns.A:
+				result = append(result, v.A)
+			case *dns.AAAA:
+				result = append(result, v.AAAA)
+			}
+		}
+	}
+	return result, nil
+}
+
+// classify reports whether ip is in the official set, within the same
+// prefix as one of its members, or unrelated to it.
+func classify(ip net.IP, official []net.IP, nearPrefix int) string {
+	for _, o := range official {
+		if o.Equal(ip) {
+			return config.RelationIn
+		}
+	}
+	for _, o := range official {
+		bits := ipv4Bits
+		if o.To4() == nil {
+			bits = ipv6Bits
+		}
+		mask := net.CIDRMask(min(nearPrefix, bits), bits)
+		if mask != nil && o.Mask(mask).Equal(ip.Mask(mask)) {
+			return config.RelationNear
+		}
+	}
+	return config.RelationUnrelated
+}
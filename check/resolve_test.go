@@ -0,0 +1,88 @@
+package check
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestClassify(t *testing.T) {
+	t.Parallel()
+
+	official := []net.IP{net.ParseIP("104.16.1.10"), net.ParseIP("2606:4700::6810:10a")}
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{ip: "104.16.1.10", want: config.RelationIn},
+		{ip: "104.16.1.99", want: config.RelationNear},
+		{ip: "104.16.2.10", want: config.RelationUnrelated},
+		{ip: "2606:4700::6810:10a", want: config.RelationIn},
+		{ip: "2606:4700::1", want: config.RelationNear},
+	}
+	for _, tt := range tests {
+		if got := classify(net.ParseIP(tt.ip), official, 24); got != tt.want {
+			t.Errorf("classify(%s) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestResolveCheckCachesFailures(t *testing.T) {
+	t.Parallel()
+
+	var lookups atomic.Int32
+	r := newResolveCheck(&config.ScanConfig{Domain: "edge.example.com.", SNI: "edge.example.com"})
+	r.lookup = func(context.Context, string, string) ([]net.IP, error) {
+		lookups.Add(1)
+		return nil, errors.New("no route to resolver")
+	}
+	for range 3 {
+		if err := r.Check(context.Background(), net.ParseIP("192.0.2.1")); err == nil {
+			t.Fatal("Check() returned no error while the lookup fails")
+		}
+	}
+	if got := lookups.Load(); got != 1 {
+		t.Fatalf("lookups = %d, want the failure cached", got)
+	}
+}
+
+func TestResolveCheckLooksUpOutsideTheLock(t *testing.T) {
+	t.Parallel()
+
+	var lookups atomic.Int32
+	release := make(chan struct{})
+	r := newResolveCheck(&config.ScanConfig{Domain: "edge.example.com.", SNI: "edge.example.com"})
+	r.lookup = func(context.Context, string, string) ([]net.IP, error) {
+		lookups.Add(1)
+		<-release
+		return []net.IP{net.ParseIP("192.0.2.1")}, nil
+	}
+	results := make(chan error, 2)
+	for range 2 {
+		go func() { results <- r.Check(context.Background(), net.ParseIP("192.0.2.1")) }()
+	}
+
+	for lookups.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// A probe giving up is not held by the lookup in flight.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := r.officialIPs(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("officialIPs() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(release)
+	for range 2 {
+		if err := <-results; err != nil {
+			t.Fatalf("Check() returned error: %v", err)
+		}
+	}
+	if got := lookups.Load(); got != 1 {
+		t.Fatalf("lookups = %d, want the probes to share one", got)
+	}
+}
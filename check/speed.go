@@ -0,0 +1,99 @@
+package check
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// kilobyte is the unit of the size and rate of speed checks.
+const kilobyte = 1 << 10
+
+// speedCheck downloads a file from the candidate and requires a minimum transfer rate.
+type speedCheck struct {
+	profile config.ClientProfile
+	scheme  string
+	host    string
+	port    string
+	path    string
+	size    int64
+	minRate float64
+	timeout time.Duration
+}
+
+func newSpeedCheck(sc *config.ScanConfig) *speedCheck {
+	scheme := schemeHTTPS
+	if sc.HTTPOnly {
+		scheme = schemeHTTP
+	}
+	return &speedCheck{
+		profile: sc.Client,
+		scheme:  scheme,
+		host:    sc.SNI,
+		port:    strconv.Itoa(sc.Port),
+		path:    cmp.Or(sc.Speed.Path, sc.Path),
+		size:    int64(sc.Speed.SizeKB) * kilobyte,
+		minRate: sc.Speed.MinKBps,
+		timeout: sc.Speed.Timeout,
+	}
+}
+
+func (s *speedCheck) String() string { return "speed" }
+
+// Check downloads the configured size from ip and compares the rate of the transfer, from the
+// response headers to the last byte, with the minimum one.
+func (s *speedCheck) Check(ctx context.Context, ip net.IP) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	transport := pinnedTransport(s.profile, s.host, s.port, ip, s.timeout)
+	defer transport.CloseIdleConnections()
+
+	// The path may carry a query, such as the size of the file to serve.
+	reqURL := s.scheme + "://" + net.JoinHostPort(s.host, s.port) + s.path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, http.NoBody)
+	if err != nil {
+		return err
+	}
+	if s.profile.UserAgent != "" {
+		req.Header.Set("User-Agent", s.profile.UserAgent)
+	}
+	client := &http.Client{
+		Transport: transport,
+		// A redirect would measure another server than the candidate.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("download status %d", resp.StatusCode)
+	}
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, s.size))
+	elapsed := time.Since(start)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("downloaded %d of %d KB within %s", n/kilobyte, s.size/kilobyte, s.timeout)
+		}
+		return err
+	}
+	if n < s.size {
+		return fmt.Errorf("body ended after %d of %d KB", n/kilobyte, s.size/kilobyte)
+	}
+	rate := float64(n) / kilobyte / max(elapsed.Seconds(), time.Millisecond.Seconds())
+	if rate < s.minRate {
+		return fmt.Errorf("downloaded at %.0f KB/s, below %.0f KB/s", rate, s.minRate)
+	}
+	return nil
+}
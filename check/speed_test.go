@@ -0,0 +1,75 @@
+package check
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestSpeedCheck(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "http://mirror.example.net/down?bytes=65536", http.StatusFound)
+			return
+		}
+		size, _ := strconv.Atoi(r.URL.Query().Get("bytes"))
+		delay, _ := time.ParseDuration(r.URL.Query().Get("delay"))
+		for sent := 0; sent < size; sent += 16 << 10 {
+			time.Sleep(delay)
+			_, _ = w.Write(bytes.Repeat([]byte{'x'}, min(16<<10, size-sent)))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+
+	tests := []struct {
+		name    string
+		path    string
+		minKBps float64
+		timeout time.Duration
+		wantErr string
+	}{
+		{name: "fast", path: "/down?bytes=65536", minKBps: 100, timeout: time.Second},
+		{name: "slow", path: "/down?bytes=65536&delay=20ms", minKBps: 10000, timeout: time.Second, wantErr: "below"},
+		{name: "short", path: "/down?bytes=1024", minKBps: 1, timeout: time.Second, wantErr: "body ended"},
+		{name: "redirect", path: "/moved", minKBps: 1, timeout: time.Second, wantErr: "download status 302"},
+		{
+			name:    "timeout",
+			path:    "/down?bytes=65536&delay=100ms",
+			minKBps: 1,
+			timeout: 150 * time.Millisecond,
+			wantErr: "within",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			check := newSpeedCheck(&config.ScanConfig{
+				SNI:      "edge.example.com",
+				Port:     addr.Port,
+				Path:     "/",
+				HTTPOnly: true,
+				Speed:    config.SpeedCheck{Enabled: true, Path: tt.path, SizeKB: 64, MinKBps: tt.minKBps, Timeout: tt.timeout},
+			})
+			err := check.Check(context.Background(), addr.IP)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Check() returned error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Check() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
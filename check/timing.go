@@ -0,0 +1,72 @@
+package check
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// Timing records how long the first connection of the native checks of a probe took to connect
+// and to complete its TLS handshake. Connections opened by the program are made by the VM itself
+// and are not timed.
+type Timing struct {
+	connect   atomic.Int64
+	handshake atomic.Int64
+}
+
+// Connect returns the duration of the TCP connect, zero when nothing connected.
+func (t *Timing) Connect() time.Duration { return time.Duration(t.connect.Load()) }
+
+// Handshake returns the duration of the TLS handshake, zero without one.
+func (t *Timing) Handshake() time.Duration { return time.Duration(t.handshake.Load()) }
+
+// Latency returns the connect and handshake durations, zero when nothing connected.
+func (t *Timing) Latency() time.Duration { return t.Connect() + t.Handshake() }
+
+type timingKey struct{}
+
+// WithTiming returns a context timing the first connection of the native checks run with it into t.
+func WithTiming(ctx context.Context, t *Timing) context.Context {
+	return context.WithValue(ctx, timingKey{}, t)
+}
+
+// timingOf returns the timing of ctx, nil when it has none.
+func timingOf(ctx context.Context) *Timing {
+	t, _ := ctx.Value(timingKey{}).(*Timing)
+	return t
+}
+
+// connected records a connect started at start, unless an earlier connection was recorded.
+// A nil timing records nothing.
+func (t *Timing) connected(start time.Time) {
+	if t != nil {
+		t.connect.CompareAndSwap(0, max(int64(time.Since(start)), 1))
+	}
+}
+
+// handshaken records a TLS handshake started at start, unless an earlier one was recorded.
+// A nil timing records nothing.
+func (t *Timing) handshaken(start time.Time) {
+	if t != nil {
+		t.handshake.CompareAndSwap(0, max(int64(time.Since(start)), 1))
+	}
+}
+
+// traceHandshake returns ctx timing the TLS handshakes of the HTTP requests made with it.
+func traceHandshake(ctx context.Context) context.Context {
+	t := timingOf(ctx)
+	if t == nil {
+		return ctx
+	}
+	var start time.Time
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		TLSHandshakeStart: func() { start = time.Now() },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				t.handshaken(start)
+			}
+		},
+	})
+}
@@ -0,0 +1,39 @@
+package check
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestHTTPCheckTimesConnect(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// The response is slow, the latency of the IP only covers the connect.
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+	check := newHTTPCheck(&config.ScanConfig{
+		SNI:      "edge.example.com",
+		Port:     addr.Port,
+		Path:     "/",
+		Timeout:  int(time.Second),
+		HTTPOnly: true,
+	})
+
+	var timing Timing
+	if err := check.Check(WithTiming(context.Background(), &timing), addr.IP); err != nil {
+		t.Fatalf("Check() returned error: %v", err)
+	}
+	if timing.Connect() <= 0 || timing.Handshake() != 0 || timing.Latency() >= 50*time.Millisecond {
+		t.Fatalf("timing = %v connect, %v handshake, want the plain connect only", timing.Connect(), timing.Handshake())
+	}
+}
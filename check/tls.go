@@ -0,0 +1,73 @@
+package check
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// tlsCheck completes a TLS handshake with the client profile of the domain and verifies the
+// pins of the presented certificate.
+type tlsCheck struct {
+	profile config.ClientProfile
+	pins    *certificatePins
+	sni     string
+	port    string
+	timeout time.Duration
+	// rootCAs verifies the certificate of the edge, the system roots when nil.
+	rootCAs *x509.CertPool
+}
+
+func newTLSCheck(sc *config.ScanConfig) *tlsCheck {
+	profile := sc.Client
+	if profile.RequiresTLSALPN() && len(profile.ALPN) == 0 && profile.Fingerprint == "" {
+		// Without an ALPN list only the required protocol is offered.
+		profile.ALPN = []string{profile.RequireALPN}
+	}
+	return &tlsCheck{
+		profile: profile,
+		pins:    newCertificatePins(sc.Certificate),
+		sni:     sc.SNI,
+		port:    strconv.Itoa(sc.Port),
+		timeout: time.Duration(sc.Timeout),
+	}
+}
+
+func (t *tlsCheck) String() string { return "tls" }
+
+// Check performs a handshake with ip, verifies the certificate and closes the connection.
+func (t *tlsCheck) Check(ctx context.Context, ip net.IP) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	dialer := &net.Dialer{Timeout: t.timeout}
+	conn, err := dialTLS(ctx, dialer, net.JoinHostPort(ip.String(), t.port), t.profile, t.sni, t.rootCAs)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if t.profile.RequiresTLSALPN() {
+		if proto := negotiatedProtocol(conn); proto != t.profile.RequireALPN {
+			return fmt.Errorf("negotiated ALPN %q instead of %q", proto, t.profile.RequireALPN)
+		}
+	}
+	return t.pins.verify(conn)
+}
+
+// negotiatedProtocol returns the ALPN protocol negotiated on a connection returned by dialTLS.
+func negotiatedProtocol(conn net.Conn) string {
+	switch conn := conn.(type) {
+	case *tls.Conn:
+		return conn.ConnectionState().NegotiatedProtocol
+	case *utls.UConn:
+		return conn.ConnectionState().NegotiatedProtocol
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package check
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestTLSCheckRequiresALPN(t *testing.T) {
+	t.Parallel()
+
+	start := func(http2 bool) (*net.TCPAddr, *x509.CertPool) {
+		srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+		srv.EnableHTTP2 = http2
+		srv.StartTLS()
+		t.Cleanup(srv.Close)
+		roots := x509.NewCertPool()
+		roots.AddCert(srv.Certificate())
+		return srv.Listener.Addr().(*net.TCPAddr), roots
+	}
+
+	tests := []struct {
+		name    string
+		http2   bool
+		profile config.ClientProfile
+		wantErr string
+	}{
+		{name: "h2", http2: true, profile: config.ClientProfile{RequireALPN: "h2"}},
+		{name: "h2 offered with http/1.1", http2: true, profile: config.ClientProfile{ALPN: []string{"http/1.1", "h2"}, RequireALPN: "h2"}},
+		{name: "http/1.1 only", profile: config.ClientProfile{RequireALPN: "h2"}, wantErr: "no application protocol"},
+		{
+			name:    "h2 preferred",
+			http2:   true,
+			profile: config.ClientProfile{ALPN: []string{"h2", "http/1.1"}, RequireALPN: "http/1.1"},
+			wantErr: `negotiated ALPN "h2"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			addr, roots := start(tt.http2)
+			check := newTLSCheck(&config.ScanConfig{
+				SNI:     "example.com",
+				Port:    addr.Port,
+				Timeout: int(time.Second),
+				Client:  tt.profile,
+			})
+			check.rootCAs = roots
+			err := check.Check(context.Background(), addr.IP)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Check() returned error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Check() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
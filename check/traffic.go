@@ -0,0 +1,67 @@
+package check
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Traffic counts the bytes the native checks of probes send and receive. Connections opened by
+// the program are made by the VM itself and are not counted, nor is the QUIC traffic of the
+// HTTP/3 check; config.ScanConfig.CountsTraffic tells whether a domain is counted at all.
+type Traffic struct {
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+// Sent returns the bytes written so far.
+func (t *Traffic) Sent() int64 { return t.sent.Load() }
+
+// Received returns the bytes read so far.
+func (t *Traffic) Received() int64 { return t.received.Load() }
+
+// Add adds the bytes counted by other to t.
+func (t *Traffic) Add(other *Traffic) {
+	t.sent.Add(other.Sent())
+	t.received.Add(other.Received())
+}
+
+type trafficKey struct{}
+
+// WithTraffic returns a context counting the bytes of the native checks run with it into t.
+func WithTraffic(ctx context.Context, t *Traffic) context.Context {
+	return context.WithValue(ctx, trafficKey{}, t)
+}
+
+// dial connects to addr with dialer, counting the bytes of the connection into the traffic of ctx
+// and timing the connect into its timing.
+func dial(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	timingOf(ctx).connected(start)
+	if t, ok := ctx.Value(trafficKey{}).(*Traffic); ok {
+		return &countingConn{Conn: conn, traffic: t}, nil
+	}
+	return conn, nil
+}
+
+type countingConn struct {
+	net.Conn
+	traffic *Traffic
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.traffic.received.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.traffic.sent.Add(int64(n))
+	return n, err
+}
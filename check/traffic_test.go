@@ -0,0 +1,37 @@
+package check
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestHTTPCheckCountsTraffic(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(make([]byte, 1024))
+	}))
+	t.Cleanup(srv.Close)
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+	check := newHTTPCheck(&config.ScanConfig{
+		SNI:      "edge.example.com",
+		Port:     addr.Port,
+		Path:     "/",
+		Timeout:  int(time.Second),
+		HTTPOnly: true,
+	})
+
+	var traffic Traffic
+	if err := check.Check(WithTraffic(context.Background(), &traffic), addr.IP); err != nil {
+		t.Fatalf("Check() returned error: %v", err)
+	}
+	if traffic.Sent() == 0 || traffic.Received() < 1024 {
+		t.Fatalf("traffic = %d sent, %d received, want the request and the 1024 byte body", traffic.Sent(), traffic.Received())
+	}
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/fmotalleb/go-tools/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fmotalleb/helios-dns/devserver"
+)
+
+var devserverOpts devserver.Options
+
+var devserverCmd = &cobra.Command{
+	Use:   "devserver",
+	Short: "Run a local origin to test configs and scan programs against",
+	Long: `devserver serves TLS and plain HTTP on local addresses, presenting a
+self-signed certificate for whatever SNI is requested and answering every
+request with the configured status. Latency and failure rate simulate slow
+and flaky edges, so configs can be tested end-to-end without probing real
+provider ranges.`,
+	Args: cobra.NoArgs,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		if devserverOpts.FailureRate < 0 || devserverOpts.FailureRate > 1 {
+			return fmt.Errorf("invalid failure rate %v, expected a value between 0 and 1", devserverOpts.FailureRate)
+		}
+		if http.StatusText(devserverOpts.Status) == "" {
+			return fmt.Errorf("invalid status %d", devserverOpts.Status)
+		}
+		ctx, cancel := signal.NotifyContext(
+			context.Background(),
+			os.Kill, os.Interrupt,
+		)
+		defer cancel()
+		ctx, err := log.WithNewEnvLogger(ctx)
+		if err != nil {
+			return err
+		}
+		return devserver.New(devserverOpts).Serve(ctx)
+	},
+	SilenceUsage: true,
+}
+
+func init() {
+	rootCmd.AddCommand(devserverCmd)
+	devserverCmd.Flags().StringVarP(&devserverOpts.Listen, "listen", "l", "127.0.0.1:8443", "TLS listen address (disabled if empty)")
+	devserverCmd.Flags().StringVar(&devserverOpts.HTTPListen, "http-listen", "127.0.0.1:8080", "plain HTTP listen address (disabled if empty)")
+	devserverCmd.Flags().IntVar(&devserverOpts.Status, "status", http.StatusOK, "status code of every response")
+	devserverCmd.Flags().DurationVar(&devserverOpts.Latency, "latency", 0, "delay before the TLS handshake or request of each connection")
+	devserverCmd.Flags().Float64Var(&devserverOpts.FailureRate, "failure-rate", 0, "fraction of connections closed without an answer (0 to 1)")
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"strconv"
+
+	"github.com/fmotalleb/helios-dns/check"
+	"github.com/fmotalleb/helios-dns/export"
+	"github.com/fmotalleb/helios-dns/report"
+)
+
+var showFeatures = false
+
+// feature is an optional subsystem that build tags can leave out of the binary.
+type feature struct {
+	Name    string `json:"name" yaml:"name"`
+	Enabled bool   `json:"enabled" yaml:"enabled"`
+	// Tag is the build tag leaving the feature out, the minimal tag leaves out every feature.
+	Tag string `json:"tag" yaml:"tag"`
+}
+
+type featureList []feature
+
+// builtFeatures lists the optional subsystems and whether this binary includes them.
+func builtFeatures() featureList {
+	return featureList{
+		{Name: "error_reporting", Enabled: report.SentrySupported, Tag: "no_sentry"},
+		{Name: "export_parquet", Enabled: export.ParquetSupported, Tag: "no_parquet"},
+		{Name: "http3_check", Enabled: check.HTTP3Supported, Tag: "no_http3"},
+	}
+}
+
+// Header implements the table output of the CLI.
+func (l featureList) Header() []string {
+	return []string{"FEATURE", "ENABLED", "TAG"}
+}
+
+// Rows implements the table output of the CLI.
+func (l featureList) Rows() [][]string {
+	rows := make([][]string, len(l))
+	for i, f := range l {
+		rows[i] = []string{f.Name, strconv.FormatBool(f.Enabled), f.Tag}
+	}
+	return rows
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fmotalleb/helios-dns/server"
+)
+
+const defaultHealthcheckTimeout = 2 * time.Second
+
+var (
+	healthcheckOpts server.HealthcheckOptions
+	errUnhealthy    = errors.New("unhealthy")
+)
+
+var healthcheckCmd = &cobra.Command{
+	Use:   "healthcheck",
+	Short: "Check a running instance and exit 1 if it is unhealthy",
+	Long: `healthcheck queries the DNS listener and, when --http is set, the
+/healthz endpoint of a running instance, and exits with 1 if any check fails.
+It needs nothing else in the image, so it can be used as a Docker HEALTHCHECK
+or a Kubernetes exec probe.`,
+	Args:        cobra.NoArgs,
+	Annotations: map[string]string{resultAnnotation: ""},
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		if healthcheckOpts.DNS == "" && healthcheckOpts.HTTP == "" {
+			return errors.New("nothing to check, set --dns or --http")
+		}
+		report := server.Healthcheck(context.Background(), healthcheckOpts)
+		if err := printResult(cmd.OutOrStdout(), report); err != nil {
+			return err
+		}
+		if !report.Healthy {
+			return errUnhealthy
+		}
+		return nil
+	},
+	SilenceUsage: true,
+}
+
+func init() {
+	rootCmd.AddCommand(healthcheckCmd)
+	healthcheckCmd.Flags().StringVar(&healthcheckOpts.DNS, "dns", "127.0.0.1:5353", "DNS listen address of the instance (not checked if empty)")
+	healthcheckCmd.Flags().StringVar(&healthcheckOpts.Name, "name", "", "name whose A records must be answered (default: only check that the listener answers)")
+	healthcheckCmd.Flags().StringVar(&healthcheckOpts.HTTP, "http", "", "HTTP listen address or base URL of the instance (not checked if empty)")
+	healthcheckCmd.Flags().BoolVar(&healthcheckOpts.Ready, "ready", false, "check /readyz instead of /healthz")
+	healthcheckCmd.Flags().DurationVarP(&healthcheckOpts.Timeout, "timeout", "t", defaultHealthcheckTimeout, "timeout of each check")
+}
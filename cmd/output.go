@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v3"
+)
+
+// Output formats accepted by the global --output flag.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+var outputFormat = outputTable
+
+// resultAnnotation marks the commands printing their result with printResult.
+const resultAnnotation = "prints-result"
+
+// tabular is implemented by results that can be rendered as a table.
+type tabular interface {
+	Header() []string
+	Rows() [][]string
+}
+
+func validateOutputFormat() error {
+	switch outputFormat {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	default:
+		return fmt.Errorf("invalid output format %q, expected one of json, yaml or table", outputFormat)
+	}
+}
+
+// checkOutputHonored rejects --output for a command printing no result, rather than silently
+// ignoring it. The root command prints a result with --features and --self-test only.
+func checkOutputHonored(cmd *cobra.Command) error {
+	if !cmd.Flags().Changed("output") {
+		return nil
+	}
+	if _, ok := cmd.Annotations[resultAnnotation]; ok || (!cmd.HasParent() && (showFeatures || selfTest)) {
+		return nil
+	}
+	return fmt.Errorf("%s prints no result, --output is not supported", cmd.CommandPath())
+}
+
+// printResult writes result to w using the format selected by --output.
+// Results that do not implement tabular fall back to YAML in table mode.
+func printResult(w io.Writer, result any) error {
+	switch outputFormat {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	case outputTable:
+		if t, ok := result.(tabular); ok {
+			return writeTable(w, t)
+		}
+	}
+	enc := yaml.NewEncoder(w)
+	defer enc.Close()
+	return enc.Encode(result)
+}
+
+func writeTable(w io.Writer, t tabular) error {
+	const padding = 2
+	tw := tabwriter.NewWriter(w, 0, 0, padding, ' ', 0)
+	if _, err := fmt.Fprintln(tw, strings.Join(t.Header(), "\t")); err != nil {
+		return err
+	}
+	for _, row := range t.Rows() {
+		if _, err := fmt.Fprintln(tw, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
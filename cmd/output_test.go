@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestPrintResult(t *testing.T) {
+	previous := outputFormat
+	t.Cleanup(func() { outputFormat = previous })
+
+	features := featureList{{Name: "export_parquet", Enabled: true, Tag: "no_parquet"}}
+	render := func(format string, result any) string {
+		t.Helper()
+		outputFormat = format
+		if err := validateOutputFormat(); err != nil {
+			t.Fatalf("validateOutputFormat() returned error: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := printResult(&buf, result); err != nil {
+			t.Fatalf("printResult() returned error: %v", err)
+		}
+		return buf.String()
+	}
+
+	if got := strings.Fields(render(outputTable, features)); strings.Join(got, " ") != "FEATURE ENABLED TAG export_parquet true no_parquet" {
+		t.Fatalf("table output = %q, want the header and a row per feature", got)
+	}
+	var decoded []feature
+	if err := json.Unmarshal([]byte(render(outputJSON, features)), &decoded); err != nil || !slices.Equal(decoded, features) {
+		t.Fatalf("json output decoded to %v, %v, want %v", decoded, err, features)
+	}
+	if got := render(outputYAML, features); !strings.Contains(got, "export_parquet") || strings.Contains(got, "FEATURE") {
+		t.Fatalf("yaml output = %q, want the features without the table header", got)
+	}
+	// Results without a table layout fall back to YAML.
+	if got := render(outputTable, map[string]int{"records": 2}); got != "records: 2\n" {
+		t.Fatalf("table output of a non-tabular result = %q, want YAML", got)
+	}
+
+	outputFormat = "xml"
+	if err := validateOutputFormat(); err == nil {
+		t.Fatal("validateOutputFormat() accepted xml")
+	}
+}
+
+func TestCheckOutputHonored(t *testing.T) {
+	root := &cobra.Command{Use: "helios-dns"}
+	root.PersistentFlags().String("output", outputTable, "")
+	printer := &cobra.Command{Use: "scan", Annotations: map[string]string{resultAnnotation: ""}}
+	silent := &cobra.Command{Use: "devserver"}
+	root.AddCommand(printer, silent)
+
+	for _, tt := range []struct {
+		cmd     *cobra.Command
+		args    []string
+		wantErr bool
+	}{
+		{cmd: silent, args: nil},
+		{cmd: silent, args: []string{"--output", outputJSON}, wantErr: true},
+		{cmd: printer, args: []string{"--output", outputJSON}},
+		{cmd: root, args: []string{"--output", outputJSON}, wantErr: true},
+	} {
+		if err := tt.cmd.ParseFlags(tt.args); err != nil {
+			t.Fatalf("ParseFlags(%v) returned error: %v", tt.args, err)
+		}
+		if err := checkOutputHonored(tt.cmd); (err != nil) != tt.wantErr {
+			t.Errorf("checkOutputHonored(%s %v) = %v, want error %v", tt.cmd.Name(), tt.args, err, tt.wantErr)
+		}
+	}
+}
@@ -24,6 +24,7 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"os"
 	"os/signal"
 	"time"
@@ -32,13 +33,19 @@ import (
 	"github.com/fmotalleb/go-tools/log"
 	"github.com/fmotalleb/go-tools/reloader"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
 	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/loglevel"
 	"github.com/fmotalleb/helios-dns/server"
 )
 
 var (
 	debug = false
+	// cfIPList is the list of Cloudflare IPv4 ranges scanned by default.
+	cfIPList = "https://www.cloudflare.com/ips-v4"
+	// cfIps are scanned in place of cfIPList until it was downloaded, so scans work offline too.
 	cfIps = []string{
 		"173.245.48.0/20",
 		"103.21.244.0/22",
@@ -58,6 +65,11 @@ var (
 	}
 )
 
+var (
+	selfTest          = false
+	errSelfTestFailed = errors.New("self-test failed")
+)
+
 const (
 	reloadDebounce        = 15 * time.Second
 	defaultInterval       = 10 * time.Minute
@@ -79,12 +91,19 @@ A records for configured domain names.
 Use a config file for domain-specific rules, and optionally override defaults
 with command-line flags.`,
 	Version: git.String(),
-	PersistentPreRun: func(_ *cobra.Command, _ []string) {
+	PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
 		if debug {
 			log.SetDebugDefaults()
 		}
+		if err := validateOutputFormat(); err != nil {
+			return err
+		}
+		return checkOutputHonored(cmd)
 	},
 	RunE: func(cmd *cobra.Command, _ []string) error {
+		if showFeatures {
+			return printResult(cmd.OutOrStdout(), builtFeatures())
+		}
 		var configFile string
 		var err error
 		if configFile, err = cmd.Flags().GetString("config"); err != nil {
@@ -105,6 +124,30 @@ with command-line flags.`,
 		if err != nil {
 			return err
 		}
+		if selfTest {
+			if !debug {
+				// Keep the report readable, logs of the steps are only shown with --verbose.
+				ctx = log.WithLogger(ctx, zap.NewNop())
+			}
+			report := server.SelfTest(ctx, args)
+			if err = printResult(cmd.OutOrStdout(), report); err != nil {
+				return err
+			}
+			if !report.Passed {
+				return errSelfTestFailed
+			}
+			return nil
+		}
+		// The logger is rebuilt at debug level and filtered by levels, so SIGUSR2 and the HTTP API
+		// can switch between info and debug at runtime.
+		levels := loglevel.New(zapcore.LevelOf(log.Of(ctx).Core()))
+		if ctx, err = log.WithNewEnvLogger(ctx, func(b *log.Builder) *log.Builder {
+			return b.LevelValue(zapcore.DebugLevel)
+		}); err != nil {
+			return err
+		}
+		ctx = loglevel.WithSwitch(log.WithLogger(ctx, levels.Wrap(log.Of(ctx))), levels)
+		levels.ToggleOnSignal(ctx, log.Of(ctx))
 		err = reloader.WithOsSignal(ctx, func(ctx context.Context) error {
 			var cfg config.Config
 			if err = config.Parse(ctx, &cfg, configFile, args); err != nil {
@@ -131,11 +174,15 @@ func Execute() {
 // init initializes command-line flags for the root command, including configuration file path, format, debug mode, and dry-run options.
 func init() {
 	rootCmd.PersistentFlags().BoolVarP(&debug, "verbose", "v", false, "enable debug logging")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", outputTable, "output format of subcommand results (json, yaml or table)")
+	rootCmd.Flags().BoolVar(&selfTest, "self-test", false, "scan a built-in local server, query it and exit with a pass/fail report")
+	rootCmd.Flags().BoolVar(&showFeatures, "features", false, "list the optional features built into this binary and exit")
 	rootCmd.Flags().StringP("config", "c", "", "config file, if config has a value set, argument for that value will be ignored")
-	rootCmd.Flags().StringP("listen", "l", "127.0.0.1:5353", "listen address of dns server")
+	rootCmd.Flags().StringP("listen", "l", "127.0.0.1:5353", "listen address of dns server, comma separated to listen on several")
 	rootCmd.Flags().String("http-listen", "", "listen address of http server (disabled if empty)")
 	rootCmd.Flags().Duration("interval", defaultInterval, "update interval for records")
-	rootCmd.Flags().StringArray("cidr", cfIps, "CIDRs to test against")
+	config.SeedCIDRList(cfIPList, cfIps)
+	rootCmd.Flags().StringArray("cidr", []string{cfIPList}, "CIDRs or URLs of CIDR lists to test against")
 	rootCmd.Flags().String("path", "/", "path of http(s) test")
 	rootCmd.Flags().DurationP("timeout", "t", defaultTimeout, "timeout of execution for each IP")
 	rootCmd.Flags().String("sni", "", "sni address to check response against")
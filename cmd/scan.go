@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+
+	"github.com/fmotalleb/go-tools/log"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/server"
+)
+
+var scanCompare = false
+
+// compareArgs are the old and the new config compared by scan --compare.
+const compareArgs = 2
+
+var scanCmd = &cobra.Command{
+	Use:   "scan --compare OLD NEW",
+	Short: "Compare the checks of two config revisions over the same candidates",
+	Long: `scan --compare samples the CIDRs of every domain of both configs once,
+probes each candidate with the checks of both configs back to back, and reports
+how the accepted sets and latencies differ, so program and threshold changes can
+be evaluated before rollout. Defaults of the root command flags apply to both
+configs.`,
+	Args:        cobra.ExactArgs(compareArgs),
+	Annotations: map[string]string{resultAnnotation: ""},
+	RunE: func(cmd *cobra.Command, paths []string) error {
+		if !scanCompare {
+			return errors.New("nothing to do, set --compare")
+		}
+		ctx, cancel := signal.NotifyContext(
+			context.Background(),
+			os.Kill, os.Interrupt,
+		)
+		defer cancel()
+		ctx, err := log.WithNewEnvLogger(ctx)
+		if err != nil {
+			return err
+		}
+		if !debug {
+			// Keep the report readable, logs of the probes are only shown with --verbose.
+			ctx = log.WithLogger(ctx, zap.NewNop())
+		}
+		args, err := buildArgsMap(rootCmd)
+		if err != nil {
+			return err
+		}
+		var oldCfg, newCfg config.Config
+		if err = config.Parse(ctx, &oldCfg, paths[0], args); err != nil {
+			return err
+		}
+		if err = config.Parse(ctx, &newCfg, paths[1], args); err != nil {
+			return err
+		}
+		report, err := server.CompareScans(ctx, oldCfg, newCfg)
+		if err != nil {
+			return err
+		}
+		return printResult(cmd.OutOrStdout(), report)
+	},
+	SilenceUsage: true,
+}
+
+func init() {
+	rootCmd.AddCommand(scanCmd)
+	scanCmd.Flags().BoolVar(&scanCompare, "compare", false, "compare the checks of the OLD and NEW config files")
+}
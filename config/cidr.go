@@ -0,0 +1,152 @@
+package config
+
+import (
+	"iter"
+	"math/big"
+	"math/rand/v2"
+	"net"
+	"slices"
+
+	"github.com/fmotalleb/mithra/cidr"
+)
+
+const (
+	// ipv6SequentialBits is the largest host part of an IPv6 CIDR that is walked
+	// address by address, larger ranges are sampled at random.
+	ipv6SequentialBits = 16
+	// ipv6DefaultSamples caps random sampling of large IPv6 ranges when sample_max is 0.
+	ipv6DefaultSamples = 256
+	// ipv6SampleAttempts bounds the addresses of large IPv6 ranges tried per sampled address, as
+	// excluded addresses are skipped.
+	ipv6SampleAttempts = 4
+)
+
+// ipFilter holds the networks excluded from sampling, and the addresses to keep when keep is set.
+type ipFilter struct {
+	networks []*net.IPNet
+	keep     func(net.IP) bool
+}
+
+// excludes reports whether ip is within one of the networks of f or not kept by it.
+func (f ipFilter) excludes(ip net.IP) bool {
+	if f.keep != nil && !f.keep(ip) {
+		return true
+	}
+	return slices.ContainsFunc(f.networks, func(network *net.IPNet) bool { return network.Contains(ip) })
+}
+
+// sampleIPv4 walks the range of it in order, like cidr.Iterator.SeqSampled, yielding the first
+// minCount addresses and then every address with probability, until limit addresses were yielded.
+// Addresses exclude holds are skipped without being counted. Every address is a copy, as the
+// iterator reuses its buffer and the scan workers receive them after the walk moved on.
+func sampleIPv4(it *cidr.Iterator, probability float64, limit int, minCount int, exclude ipFilter) iter.Seq[net.IP] {
+	if limit > 0 && minCount > limit {
+		minCount = limit
+	}
+	return func(yield func(net.IP) bool) {
+		count := 0
+		for ip := range it.Seq() {
+			if limit > 0 && count >= limit {
+				return
+			}
+			if exclude.excludes(ip) || (count >= minCount && rand.Float64() >= probability) { //nolint:gosec // sampling needs no cryptographic randomness
+				continue
+			}
+			if !yield(slices.Clone(ip)) {
+				return
+			}
+			count++
+		}
+	}
+}
+
+// sampleIPv6 yields the first minCount addresses of ipNet, then samples the rest of it.
+// Small ranges are walked in order with probability, large ones get random addresses.
+// Sampling stops after limit addresses, or ipv6DefaultSamples for large ranges when limit is 0.
+// Addresses exclude holds are skipped without being counted, the walk over the first addresses
+// and random sampling give up after ipv6SampleAttempts addresses per yielded one.
+func sampleIPv6(ipNet *net.IPNet, probability float64, limit int, minCount int, exclude ipFilter) iter.Seq[net.IP] {
+	ones, bits := ipNet.Mask.Size()
+	hostBits := bits - ones
+	base := new(big.Int).SetBytes(ipNet.IP.To16())
+	size := new(big.Int).Lsh(big.NewInt(1), uint(hostBits)) //nolint:gosec // a prefix length is at most bits
+	if limit > 0 && minCount > limit {
+		minCount = limit
+	}
+	return func(yield func(net.IP) bool) {
+		s := &ipv6Sampler{base: base, size: size, exclude: exclude, yield: yield}
+		offset := new(big.Int)
+		first := big.NewInt(int64(minCount) * ipv6SampleAttempts)
+		for ; s.count < minCount && offset.Cmp(size) < 0 && offset.Cmp(first) < 0; offset.Add(offset, big.NewInt(1)) {
+			if !s.emit(ipAt(base, offset)) {
+				return
+			}
+		}
+		if hostBits <= ipv6SequentialBits {
+			s.walk(offset, probability, limit)
+			return
+		}
+		if limit <= 0 {
+			limit = ipv6DefaultSamples
+		}
+		s.random(limit)
+	}
+}
+
+// ipv6Sampler yields the sampled addresses of the range of size addresses starting at base.
+type ipv6Sampler struct {
+	base, size *big.Int
+	exclude    ipFilter
+	yield      func(net.IP) bool
+	count      int
+}
+
+// emit yields ip unless it is excluded, it reports false once the consumer stopped.
+func (s *ipv6Sampler) emit(ip net.IP) bool {
+	if s.exclude.excludes(ip) {
+		return true
+	}
+	if !s.yield(ip) {
+		return false
+	}
+	s.count++
+	return true
+}
+
+// walk samples the addresses from offset on in order with probability, up to limit.
+func (s *ipv6Sampler) walk(offset *big.Int, probability float64, limit int) {
+	for ; offset.Cmp(s.size) < 0; offset.Add(offset, big.NewInt(1)) {
+		if limit > 0 && s.count >= limit {
+			return
+		}
+		if rand.Float64() >= probability { //nolint:gosec // sampling needs no cryptographic randomness
+			continue
+		}
+		if !s.emit(ipAt(s.base, offset)) {
+			return
+		}
+	}
+}
+
+// random samples random addresses until limit were yielded or the attempts ran out.
+func (s *ipv6Sampler) random(limit int) {
+	for attempts := 0; s.count < limit && attempts < limit*ipv6SampleAttempts; attempts++ {
+		if !s.emit(ipAt(s.base, randomOffset(s.size))) {
+			return
+		}
+	}
+}
+
+func ipAt(base *big.Int, offset *big.Int) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	new(big.Int).Add(base, offset).FillBytes(ip)
+	return ip
+}
+
+func randomOffset(size *big.Int) *big.Int {
+	buf := make([]byte, len(size.Bytes()))
+	for i := range buf {
+		buf[i] = byte(rand.Uint32()) //nolint:gosec // sampling needs no cryptographic randomness
+	}
+	return new(big.Int).Mod(new(big.Int).SetBytes(buf), size)
+}
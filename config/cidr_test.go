@@ -0,0 +1,94 @@
+package config
+
+import (
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/fmotalleb/mithra/cidr"
+)
+
+func TestSampleIPv6(t *testing.T) {
+	t.Parallel()
+
+	_, small, _ := net.ParseCIDR("2001:db8::/126")
+	var got []string
+	for ip := range sampleIPv6(small, 1, 0, 0, ipFilter{}) {
+		got = append(got, ip.String())
+	}
+	if len(got) != 4 || got[0] != "2001:db8::" || got[3] != "2001:db8::3" {
+		t.Fatalf("sampleIPv6(/126) = %v, want every address in order", got)
+	}
+
+	_, large, _ := net.ParseCIDR("2606:4700::/32")
+	count := 0
+	for ip := range sampleIPv6(large, 0.05, 8, 2, ipFilter{}) {
+		if !large.Contains(ip) {
+			t.Fatalf("sampleIPv6(/32) yielded %s outside the range", ip)
+		}
+		if count == 0 && ip.String() != "2606:4700::" {
+			t.Fatalf("first sample = %s, want the first address of the range", ip)
+		}
+		count++
+	}
+	if count != 8 {
+		t.Fatalf("sampleIPv6(/32) yielded %d addresses, want 8", count)
+	}
+}
+
+func TestSampleSkipsExcludedCIDRs(t *testing.T) {
+	t.Parallel()
+
+	_, blocked, _ := net.ParseCIDR("192.0.2.0/25")
+	_, blocked6, _ := net.ParseCIDR("2001:db8::/127")
+	exclude := ipFilter{networks: []*net.IPNet{blocked, blocked6}}
+
+	it, err := cidr.NewIPv4CIDR("192.0.2.0/24")
+	if err != nil {
+		t.Fatalf("NewIPv4CIDR() returned error: %v", err)
+	}
+	var got []string
+	for ip := range sampleIPv4(it, 0, 4, 4, exclude) {
+		got = append(got, ip.String())
+	}
+	if !slices.Equal(got, []string{"192.0.2.128", "192.0.2.129", "192.0.2.130", "192.0.2.131"}) {
+		t.Fatalf("sampleIPv4() = %v, want the first addresses after the excluded /25", got)
+	}
+
+	_, small, _ := net.ParseCIDR("2001:db8::/126")
+	got = nil
+	for ip := range sampleIPv6(small, 1, 0, 0, exclude) {
+		got = append(got, ip.String())
+	}
+	if !slices.Equal(got, []string{"2001:db8::2", "2001:db8::3"}) {
+		t.Fatalf("sampleIPv6(/126) = %v, want the addresses outside the excluded /127", got)
+	}
+
+	_, large, _ := net.ParseCIDR("2606:4700::/32")
+	_, half, _ := net.ParseCIDR("2606:4700::/33")
+	for ip := range sampleIPv6(large, 0.05, 8, 2, ipFilter{networks: []*net.IPNet{half}}) {
+		if half.Contains(ip) {
+			t.Fatalf("sampleIPv6(/32) yielded excluded %s", ip)
+		}
+	}
+}
+
+func TestSampleIPv4CopiesAddresses(t *testing.T) {
+	t.Parallel()
+
+	it, err := cidr.NewIPv4CIDR("192.0.2.0/30")
+	if err != nil {
+		t.Fatalf("NewIPv4CIDR() returned error: %v", err)
+	}
+	var got []net.IP
+	for ip := range sampleIPv4(it, 1, 0, 0, ipFilter{}) {
+		got = append(got, ip)
+	}
+	addrs := make([]string, len(got))
+	for i, ip := range got {
+		addrs[i] = ip.String()
+	}
+	if !slices.Equal(addrs, []string{"192.0.2.0", "192.0.2.1", "192.0.2.2", "192.0.2.3"}) {
+		t.Fatalf("sampleIPv4() = %v, want every address kept after the walk", addrs)
+	}
+}
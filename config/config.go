@@ -3,95 +3,955 @@ package config
 
 import (
 	"cmp"
+	"context"
+	"crypto/sha256"
+	"fmt"
 	"iter"
 	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/go-tools/log"
 	"github.com/fmotalleb/go-tools/template"
 	"github.com/fmotalleb/mithra/cidr"
 	"github.com/fmotalleb/mithra/vm"
+	"github.com/miekg/dns"
 )
 
 // Config represents application-level settings.
 type Config struct {
-	Listen         string        `mapstructure:"listen" default:"{{ .args.listen }}" validate:"required,hostport"`
-	UpdateInterval time.Duration `mapstructure:"interval" default:"{{ .args.interval }}" validate:"gt=0"`
-	MaxWorkers     int           `mapstructure:"max_workers" default:"{{ .args.max_workers }}" validate:"gt=0"`
-	HTTPListen     string        `mapstructure:"http_listen" default:"{{ .args.http_listen }}" validate:"omitempty,hostport"`
-	Domains        []*ScanConfig `mapstructure:"domains" validate:"required,min=1"`
+	Listen           []string           `mapstructure:"listen" default:"{{ .args.listen }}" validate:"required,min=1,unique,dive,hostport"`
+	ListenTCP        *bool              `mapstructure:"listen_tcp"`
+	UpdateInterval   time.Duration      `mapstructure:"interval" default:"{{ .args.interval }}" validate:"gt=0"`
+	MaxWorkers       int                `mapstructure:"max_workers" default:"{{ .args.max_workers }}" validate:"gt=0"`
+	MaxProbes        int                `mapstructure:"max_probes_per_interval" validate:"gte=0"`
+	MaxBytes         int64              `mapstructure:"max_bytes_per_cycle" validate:"gte=0"`
+	Shard            string             `mapstructure:"shard" validate:"omitempty,shard"`
+	ScanMode         string             `mapstructure:"scan_mode" default:"fast" validate:"oneof=fast paced"`
+	ScanJitter       time.Duration      `mapstructure:"scan_jitter" validate:"gte=0"`
+	HTTPListen       string             `mapstructure:"http_listen" default:"{{ .args.http_listen }}" validate:"omitempty,hostport"`
+	APIToken         string             `mapstructure:"api_token"`
+	Upstream         string             `mapstructure:"upstream" validate:"omitempty,upstream"`
+	Upstreams        []string           `mapstructure:"upstreams" validate:"dive,upstream"`
+	UpstreamCheck    time.Duration      `mapstructure:"upstream_check_interval" default:"30s" validate:"gte=0"`
+	UpstreamTimeout  time.Duration      `mapstructure:"upstream_timeout" default:"2s" validate:"gt=0"`
+	UpstreamFailures int                `mapstructure:"upstream_failure_threshold" default:"3" validate:"gt=0"`
+	ForwardUnknown   bool               `mapstructure:"forward_unknown"`
+	Mode             string             `mapstructure:"mode" default:"authoritative" validate:"oneof=authoritative proxy"`
+	BindRetry        time.Duration      `mapstructure:"bind_retry" validate:"gte=0"`
+	WriteTimeout     time.Duration      `mapstructure:"write_timeout" default:"2s" validate:"gte=0"`
+	DrainTimeout     time.Duration      `mapstructure:"drain_timeout" default:"2s" validate:"gte=0"`
+	Domains          []*ScanConfig      `mapstructure:"domains" validate:"required,min=1"`
+	ClientGroups     []ClientGroup      `mapstructure:"client_groups" validate:"dive"`
+	AllowClients     []string           `mapstructure:"allow_clients" validate:"dive,cidr"`
+	DenyClients      []string           `mapstructure:"deny_clients" validate:"dive,cidr"`
+	ExcludeCIDRs     []string           `mapstructure:"exclude_cidr" validate:"dive,cidr"`
+	IPLists          IPListsConfig      `mapstructure:"ip_lists"`
+	Serial           SerialConfig       `mapstructure:"serial"`
+	ACME             ACMEConfig         `mapstructure:"acme"`
+	Chaos            bool               `mapstructure:"chaos"`
+	PublishMaxHold   time.Duration      `mapstructure:"publish_group_max_hold" default:"1h" validate:"gte=0"`
+	Export           ExportConfig       `mapstructure:"export"`
+	ProbeWebhook     WebhookConfig      `mapstructure:"probe_webhook"`
+	Watchdog         WatchdogConfig     `mapstructure:"watchdog"`
+	RescanOnMiss     RescanConfig       `mapstructure:"rescan_on_miss"`
+	Revalidate       RevalidateConfig   `mapstructure:"revalidate"`
+	Dnstap           DnstapConfig       `mapstructure:"dnstap"`
+	QueryLog         QueryLogConfig     `mapstructure:"query_log"`
+	Privacy          PrivacyConfig      `mapstructure:"privacy"`
+	UI               UIConfig           `mapstructure:"ui"`
+	ErrorReporting   ReportingConfig    `mapstructure:"error_reporting"`
+	StaticRecords    []StaticRecord     `mapstructure:"static_records"`
+	Zones            []ZoneConfig       `mapstructure:"zones" validate:"dive"`
+	EDNS             EDNSConfig         `mapstructure:"edns"`
+	Rcodes           RcodeConfig        `mapstructure:"rcodes"`
+	OutsideLimit     OutsideLimitConfig `mapstructure:"outside_zones_limit"`
+	Compress         bool               `mapstructure:"compress"`
+	MaxQuestions     int                `mapstructure:"max_questions" default:"1" validate:"gte=1"`
+	// CrashOnPanic exits on a panic of a query or a probe instead of recovering from it.
+	CrashOnPanic bool `mapstructure:"crash_on_panic"`
+}
+
+// Modes of Config. In proxy mode every query is forwarded to the upstreams, except for the
+// configured domains with servable IPs whose answers are overridden.
+const (
+	ModeAuthoritative = "authoritative"
+	ModeProxy         = "proxy"
+)
+
+// Proxy tells whether the instance runs in proxy mode.
+func (cfg *Config) Proxy() bool {
+	return cfg.Mode == ModeProxy
+}
+
+// RcodeConfig selects the response codes of queries for names without records. Names below a
+// zone, a domain or a static record are served names, anything else is outside the served zones.
+type RcodeConfig struct {
+	UnknownName  string `mapstructure:"unknown_name" default:"nxdomain" validate:"oneof=nxdomain noerror"`
+	OutsideZones string `mapstructure:"outside_zones" default:"refused" validate:"oneof=refused nxdomain noerror"`
+}
+
+// OutsideLimitConfig throttles the clients querying names outside the served zones, as open resolver
+// scanners and reflection attacks do. Once a client subnet sent more than queries of them within
+// window, its next ones are dropped without an answer until the window ends. Zero queries
+// disables it.
+type OutsideLimitConfig struct {
+	Queries int           `mapstructure:"queries" validate:"gte=0"`
+	Window  time.Duration `mapstructure:"window" default:"1m" validate:"gt=0"`
+}
+
+// Response codes of RcodeConfig.
+const (
+	RcodeNoError  = "noerror"
+	RcodeNXDomain = "nxdomain"
+	RcodeRefused  = "refused"
+)
+
+// EDNSConfig controls EDNS0 handling. With ClientSubnet the address of an EDNS Client Subnet
+// option stands in for the client address when picking client groups and answers. With Padding
+// the answers to queries carrying a padding option are padded to a multiple of that many bytes.
+type EDNSConfig struct {
+	UDPSize      uint16 `mapstructure:"udp_size" default:"1232" validate:"gte=512"`
+	ClientSubnet bool   `mapstructure:"client_subnet"`
+	Padding      int    `mapstructure:"padding" validate:"gte=0,lte=4096"`
+}
+
+// Scan modes, fast probes as quickly as workers allow, paced spreads probes over the interval.
+const (
+	ScanModeFast  = "fast"
+	ScanModePaced = "paced"
+)
+
+// TCPEnabled reports whether DNS is also served over TCP, which is the default.
+func (cfg *Config) TCPEnabled() bool {
+	return cfg.ListenTCP == nil || *cfg.ListenTCP
+}
+
+// ShardOf returns the index and count of the shard of the candidate space scanned by this
+// instance, 0 and 1 when shard is unset.
+func (cfg *Config) ShardOf() (int, int) {
+	index, count, err := parseShard(cfg.Shard)
+	if err != nil {
+		return 0, 1
+	}
+	return index, count
+}
+
+// parseShard parses "i/N" where 0 <= i < N.
+func parseShard(s string) (int, int, error) {
+	indexStr, countStr, found := strings.Cut(s, "/")
+	if !found {
+		return 0, 0, fmt.Errorf("shard %q is not in the i/N form", s)
+	}
+	index, err := strconv.Atoi(indexStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	if count < 1 || index < 0 || index >= count {
+		return 0, 0, fmt.Errorf("shard %q is out of range", s)
+	}
+	return index, count, nil
+}
+
+// UpstreamList returns every configured upstream resolver in failover order.
+func (cfg *Config) UpstreamList() []string {
+	result := make([]string, 0, len(cfg.Upstreams)+1)
+	if cfg.Upstream != "" {
+		result = append(result, cfg.Upstream)
+	}
+	return append(result, cfg.Upstreams...)
+}
+
+// Zone serial strategies.
+const (
+	SerialUnixTime = "unixtime"
+	SerialDate     = "date"
+	SerialCounter  = "counter"
+)
+
+// SerialConfig controls how zone serials are generated and persisted.
+type SerialConfig struct {
+	Strategy  string `mapstructure:"strategy" default:"unixtime" validate:"oneof=unixtime date counter"`
+	StateFile string `mapstructure:"state_file"`
+}
+
+// ZoneConfig declares a zone this server is authoritative for, answers within it carry the AA bit
+// and negative answers carry its SOA. Hostmaster defaults to hostmaster.<name>.
+type ZoneConfig struct {
+	Name        string         `mapstructure:"name" validate:"required,fqdn"`
+	NameServers []string       `mapstructure:"ns" validate:"required,min=1,dive,fqdn"`
+	Hostmaster  string         `mapstructure:"hostmaster" validate:"omitempty,fqdn"`
+	TTL         time.Duration  `mapstructure:"ttl" default:"1h" validate:"gt=0"`
+	Refresh     time.Duration  `mapstructure:"refresh" default:"1h" validate:"gt=0"`
+	Retry       time.Duration  `mapstructure:"retry" default:"15m" validate:"gt=0"`
+	Expire      time.Duration  `mapstructure:"expire" default:"168h" validate:"gt=0"`
+	NegativeTTL time.Duration  `mapstructure:"negative_ttl" default:"5m" validate:"gt=0"`
+	DNSSEC      DNSSECConfig   `mapstructure:"dnssec"`
+	Transfer    TransferConfig `mapstructure:"transfer"`
+}
+
+// TransferConfig lets secondaries pull a zone over AXFR/IXFR and notifies them when it changes.
+type TransferConfig struct {
+	Allow  []string `mapstructure:"allow" validate:"dive,cidr"`
+	Notify []string `mapstructure:"notify" validate:"dive,hostport"`
+}
+
+// AllowedNetworks returns the parsed networks allowed to transfer the zone.
+func (t TransferConfig) AllowedNetworks() ([]*net.IPNet, error) {
+	return parseNetworks(t.Allow)
+}
+
+// DNSSECConfig signs the answers of a zone on the fly. Keys are generated on first start and kept
+// in KeyDir, without KeyDir they are regenerated on every start and the parent DS record goes stale.
+type DNSSECConfig struct {
+	Enabled           bool          `mapstructure:"enabled"`
+	KeyDir            string        `mapstructure:"key_dir"`
+	Algorithm         string        `mapstructure:"algorithm" default:"ECDSAP256SHA256" validate:"oneof=ECDSAP256SHA256 ED25519"`
+	SignatureValidity time.Duration `mapstructure:"signature_validity" default:"168h" validate:"gte=1h"`
+}
+
+// ACMEConfig controls certificates issued through ACME DNS-01 challenges answered by this server.
+type ACMEConfig struct {
+	Enabled     bool          `mapstructure:"enabled"`
+	Email       string        `mapstructure:"email" validate:"omitempty,email"`
+	Directory   string        `mapstructure:"directory" default:"https://acme-v02.api.letsencrypt.org/directory" validate:"url"`
+	Domains     []string      `mapstructure:"domains" validate:"dive,required"`
+	CacheDir    string        `mapstructure:"cache_dir"`
+	RenewBefore time.Duration `mapstructure:"renew_before" default:"720h" validate:"gt=0"`
+}
+
+// Export file formats.
+const (
+	ExportCSV     = "csv"
+	ExportParquet = "parquet"
+)
+
+// ExportConfig controls the export of probe outcomes to files, it is disabled when dir is empty.
+type ExportConfig struct {
+	Dir         string        `mapstructure:"dir"`
+	Format      string        `mapstructure:"format" default:"csv" validate:"oneof=csv parquet"`
+	RotateEvery time.Duration `mapstructure:"rotate_every" default:"24h" validate:"gt=0"`
+	MaxFiles    int           `mapstructure:"max_files" validate:"gte=0"`
+}
+
+// WebhookConfig posts probe outcomes in batches of NDJSON as they happen, it is disabled when url is
+// empty. A batch is sent once it holds batch_size outcomes or flush_interval after its first one.
+type WebhookConfig struct {
+	URL           string            `mapstructure:"url" validate:"omitempty,url"`
+	Headers       map[string]string `mapstructure:"headers"`
+	BatchSize     int               `mapstructure:"batch_size" default:"100" validate:"gt=0"`
+	FlushInterval time.Duration     `mapstructure:"flush_interval" default:"5s" validate:"gt=0"`
+	Timeout       time.Duration     `mapstructure:"timeout" default:"10s" validate:"gt=0"`
+	Buffer        int               `mapstructure:"buffer" default:"10000" validate:"gt=0"`
+}
+
+// WatchdogConfig controls the periodic self-test of the DNS listener, it is disabled when interval is zero.
+type WatchdogConfig struct {
+	Interval time.Duration `mapstructure:"interval" validate:"gte=0"`
+	Timeout  time.Duration `mapstructure:"timeout" default:"2s" validate:"gt=0"`
+	Webhook  string        `mapstructure:"webhook" validate:"omitempty,url"`
+}
+
+// IPListsConfig loads the IPs allowed and denied as candidates and records from plain-text files,
+// one IP or CIDR per line, polled every reload_interval and reloaded when they change.
+type IPListsConfig struct {
+	Allow          []string      `mapstructure:"allow" validate:"dive,required"`
+	Deny           []string      `mapstructure:"deny" validate:"dive,required"`
+	ReloadInterval time.Duration `mapstructure:"reload_interval" default:"10s" validate:"gt=0"`
+}
+
+// RescanConfig scans a domain right away when it is queried while it has no records to serve,
+// at most once per min_interval per domain.
+type RescanConfig struct {
+	Enabled     bool          `mapstructure:"enabled"`
+	MinInterval time.Duration `mapstructure:"min_interval" default:"30s" validate:"gt=0"`
+}
+
+// RevalidateConfig re-probes the served IPs of every domain each interval between update cycles,
+// evicting the ones failing failures re-validations in a row and serving standby IPs in their
+// place. Scans accept up to standby IPs beyond result_limit for that. It is disabled when interval
+// is zero.
+type RevalidateConfig struct {
+	Interval time.Duration `mapstructure:"interval" validate:"gte=0"`
+	Standby  int           `mapstructure:"standby" validate:"gte=0"`
+	Failures int           `mapstructure:"failures" default:"2" validate:"gte=1"`
+}
+
+// DnstapConfig streams dnstap query and response events to a unix socket or to a file, it is
+// disabled when both are empty. Identity defaults to the hostname.
+type DnstapConfig struct {
+	Socket   string `mapstructure:"socket" validate:"excluded_with=File"`
+	File     string `mapstructure:"file"`
+	Identity string `mapstructure:"identity"`
+	Buffer   int    `mapstructure:"buffer" default:"1024" validate:"gt=0"`
+}
+
+// Enabled reports whether dnstap events are written anywhere.
+func (c DnstapConfig) Enabled() bool {
+	return c.Socket != "" || c.File != ""
+}
+
+// QueryLogConfig writes a JSON line per answered query to file, it is disabled when file is empty.
+// The file is rotated once it grows past max_size_mb or, when rotate_every is set, gets older than
+// rotate_every. Only the newest max_files rotated files are kept, zero keeps all.
+type QueryLogConfig struct {
+	File        string        `mapstructure:"file"`
+	MaxSizeMB   int           `mapstructure:"max_size_mb" default:"100" validate:"gt=0"`
+	RotateEvery time.Duration `mapstructure:"rotate_every" validate:"gte=0"`
+	MaxFiles    int           `mapstructure:"max_files" validate:"gte=0"`
+}
+
+// PrivacyConfig anonymizes the client addresses written to the query log, dnstap, the
+// application log and /api/clients, it is disabled when mode is empty. Mode truncate keeps the
+// first ipv4_prefix or ipv6_prefix bits of an address, hash replaces it with a keyed HMAC-SHA256
+// of it, so a client can be followed across entries without being identified.
+type PrivacyConfig struct {
+	Mode       string `mapstructure:"mode" validate:"omitempty,oneof=truncate hash"`
+	IPv4Prefix int    `mapstructure:"ipv4_prefix" default:"24" validate:"gt=0,lte=32"`
+	IPv6Prefix int    `mapstructure:"ipv6_prefix" default:"48" validate:"gt=0,lte=128"`
+	Key        string `mapstructure:"key" validate:"required_if=Mode hash"`
+}
+
+// StaticRecord is a fixed record served alongside the scanned domains, ttl defaults to the answer TTL.
+type StaticRecord struct {
+	Name  string        `mapstructure:"name" validate:"required,fqdn"`
+	Type  string        `mapstructure:"type" validate:"oneof=A AAAA CNAME TXT MX"`
+	Value string        `mapstructure:"value" validate:"required"`
+	TTL   time.Duration `mapstructure:"ttl" validate:"gte=0"`
+}
+
+// ParseMX parses the value of a static MX record, a preference followed by the FQDN of the mail
+// exchange, such as "10 mail.example.com.".
+func ParseMX(value string) (uint16, string, error) {
+	prefStr, host, found := strings.Cut(strings.TrimSpace(value), " ")
+	if !found {
+		return 0, "", fmt.Errorf("MX value %q is not in the \"preference host\" form", value)
+	}
+	pref, err := strconv.ParseUint(prefStr, 10, 16)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid MX preference %q", prefStr)
+	}
+	host = strings.TrimSpace(host)
+	if _, ok := dns.IsDomainName(host); !ok || !dns.IsFqdn(host) {
+		return 0, "", fmt.Errorf("MX host must be a valid FQDN (got %q)", host)
+	}
+	return uint16(pref), dns.CanonicalName(host), nil
+}
+
+// ReportingConfig sends errors and panics to a Sentry compatible DSN, it is disabled when dsn is empty.
+type ReportingConfig struct {
+	DSN          string        `mapstructure:"dsn" validate:"omitempty,url"`
+	Environment  string        `mapstructure:"environment"`
+	SampleRate   float64       `mapstructure:"sample_rate" default:"1" validate:"gt=0,lte=1"`
+	DedupeWindow time.Duration `mapstructure:"dedupe_window" default:"10m" validate:"gt=0"`
+	MaxEvents    int           `mapstructure:"max_events" default:"20" validate:"gte=0"`
+}
+
+// UIConfig brands the status dashboard, which is served from dir instead of the embedded assets when set.
+type UIConfig struct {
+	Dir    string   `mapstructure:"dir"`
+	Title  string   `mapstructure:"title" default:"Helios DNS Status"`
+	Logo   string   `mapstructure:"logo"`
+	Colors UIColors `mapstructure:"colors"`
+}
+
+// UIColors override the CSS colors of the dashboard, empty values keep the built-in theme.
+type UIColors struct {
+	Background string `mapstructure:"background" validate:"omitempty,iscolor"`
+	Text       string `mapstructure:"text" validate:"omitempty,iscolor"`
+	Accent     string `mapstructure:"accent" validate:"omitempty,iscolor"`
+	Card       string `mapstructure:"card" validate:"omitempty,iscolor"`
+	Border     string `mapstructure:"border" validate:"omitempty,iscolor"`
+}
+
+// ClientGroup overrides answer settings for clients within the given CIDRs.
+type ClientGroup struct {
+	Name       string        `mapstructure:"name" validate:"required"`
+	CIDRs      []string      `mapstructure:"cidr" validate:"required,min=1,dive,cidr"`
+	TTL        time.Duration `mapstructure:"ttl" validate:"gte=0"`
+	MaxAnswers int           `mapstructure:"max_answers" validate:"gte=0"`
+	Domains    []string      `mapstructure:"domains" validate:"dive,fqdn"`
+	// Forward lets a group restricted to domains have the names nothing serves here forwarded
+	// to the upstreams, with forward_unknown or mode proxy.
+	Forward bool `mapstructure:"forward"`
+	// Prefer restricts answers to the candidates within these CIDRs whenever there are any.
+	Prefer []string `mapstructure:"prefer" validate:"dive,cidr"`
+}
+
+// Networks parses the CIDRs of this client group.
+func (cg *ClientGroup) Networks() ([]*net.IPNet, error) {
+	return parseNetworks(cg.CIDRs)
+}
+
+// PreferredNetworks parses the preferred candidate CIDRs of this client group.
+func (cg *ClientGroup) PreferredNetworks() ([]*net.IPNet, error) {
+	return parseNetworks(cg.Prefer)
+}
+
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	result := make([]*net.IPNet, len(cidrs))
+	for i, cidrStr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidrStr)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = ipNet
+	}
+	return result, nil
+}
+
+// ClientACL parses the allowed and denied client CIDRs of the server.
+func (cfg *Config) ClientACL() (allow, deny []*net.IPNet, err error) {
+	return parseACL(cfg.AllowClients, cfg.DenyClients)
+}
+
+// ClientACL parses the allowed and denied client CIDRs of the domain.
+func (sc *ScanConfig) ClientACL() (allow, deny []*net.IPNet, err error) {
+	return parseACL(sc.AllowClients, sc.DenyClients)
+}
+
+func parseACL(allowCIDRs, denyCIDRs []string) (allow, deny []*net.IPNet, err error) {
+	if allow, err = parseNetworks(allowCIDRs); err != nil {
+		return nil, nil, err
+	}
+	if deny, err = parseNetworks(denyCIDRs); err != nil {
+		return nil, nil, err
+	}
+	return allow, deny, nil
 }
 
 // ScanConfig defines scan settings for a single domain.
 type ScanConfig struct {
-	Domain     string   `mapstructure:"domain" validate:"required,fqdn"`
-	CIDRs      []string `mapstructure:"cidr" validate:"required,min=1,dive,cidr"`
+	Enabled   *bool    `mapstructure:"enabled"`
+	Domain    string   `mapstructure:"domain" validate:"required,fqdn"`
+	Aliases   []string `mapstructure:"aliases" validate:"dive,fqdn"`
+	AliasMode string   `mapstructure:"alias_mode" default:"records" validate:"oneof=records cname"`
+	ServeSNI  bool     `mapstructure:"serve_sni"`
+	// CatchAll serves the records of the domain for every name no other domain, alias,
+	// static record or SRV name serves.
+	CatchAll   bool     `mapstructure:"catch_all"`
+	CIDRs      []string `mapstructure:"cidr" validate:"required,min=1,dive,cidr|http_url"`
 	SNI        string   `mapstructure:"sni" default:"{{ .args.sni }}"`
 	Timeout    int      `mapstructure:"timeout" default:"{{ .args.timeout }}" validate:"gt=0"`
 	Port       int      `mapstructure:"port" default:"{{ .args.port }}" validate:"gte=1,lte=65535"`
 	Path       string   `mapstructure:"path" default:"{{ .args.path }}" validate:"required,path"`
 	StatusCode int      `mapstructure:"status_code" default:"{{ .args.status_code }}" validate:"gte=0,lte=599"`
 
+	// CIDRRefresh is how long the lists of the URL entries of CIDRs are used before they are
+	// downloaded again.
+	CIDRRefresh time.Duration `mapstructure:"cidr_refresh" default:"24h" validate:"gt=0"`
+	// ExcludeCIDRs are skipped when sampling CIDRs, the global exclude_cidr is added on parse.
+	ExcludeCIDRs []string `mapstructure:"exclude_cidr" validate:"dive,cidr"`
+
 	SamplesMinimum int     `mapstructure:"sample_min" default:"{{ .args.sample_min }}" validate:"gte=0"`
 	SamplesMaximum int     `mapstructure:"sample_max" default:"{{ .args.sample_max }}" validate:"gte=0"`
 	SamplesChance  float64 `mapstructure:"sample_chance" default:"{{ .args.sample_chance }}" validate:"gte=0,lte=1"`
 
 	HTTPOnly bool   `mapstructure:"http_only" default:"{{ .args.http_only }}"`
 	Program  string `mapstructure:"program"`
+	// ProgramRefresh renders the program again once this long passed, for templates reading
+	// values that change at runtime. The VM is rebuilt when the rendered program changed.
+	ProgramRefresh time.Duration `mapstructure:"program_refresh" validate:"gte=0"`
 
 	Limit int `mapstructure:"result_limit" default:"4" validate:"gt=0"`
+	// CandidatePool keeps accepting IPs until this many passed the checks, then serves the
+	// result_limit fastest of them instead of the first ones.
+	CandidatePool int     `mapstructure:"candidate_pool" validate:"omitempty,gtefield=Limit"`
+	LatencyFactor float64 `mapstructure:"latency_factor" validate:"omitempty,gte=1"`
+	// GracePeriod keeps serving IPs that left the accepted set for this long, re-checking them meanwhile.
+	GracePeriod time.Duration `mapstructure:"grace_period" validate:"gte=0"`
+	// StaleWindow tops up cycles accepting fewer than result_limit IPs with the previous records
+	// validated within it.
+	StaleWindow time.Duration `mapstructure:"stale_window" validate:"gte=0"`
+	// PublishGroup couples the domains sharing it, their new records are published together.
+	PublishGroup string `mapstructure:"publish_group"`
+
+	ConfidenceHalfLife time.Duration `mapstructure:"confidence_half_life" validate:"gte=0"`
+	ConfidenceBoost    float64       `mapstructure:"confidence_boost" default:"1" validate:"gt=0,lte=1"`
+	MinConfidence      float64       `mapstructure:"min_confidence" validate:"gte=0,lte=1"`
 
-	vm *vm.VM
+	ServeDisabled  bool     `mapstructure:"serve_disabled"`
+	Paused         bool     `mapstructure:"paused"`
+	PausedResponse string   `mapstructure:"paused_response" default:"last_known_good" validate:"oneof=last_known_good fallback servfail forward"`
+	FallbackIPs    []string `mapstructure:"fallback_ips" validate:"dive,ip"`
+	// OtherTypes selects the answer to queries of types other than A and AAAA without static records.
+	OtherTypes string `mapstructure:"other_types" default:"nodata" validate:"oneof=nodata forward"`
+	// Compress and Padding override the global compress and edns.padding for this domain.
+	Compress *bool `mapstructure:"compress"`
+	Padding  *int  `mapstructure:"padding" validate:"omitempty,gte=0,lte=4096"`
+
+	// AllowClients and DenyClients restrict the clients answered for this domain, on top of the
+	// global lists.
+	AllowClients []string `mapstructure:"allow_clients" validate:"dive,cidr"`
+	DenyClients  []string `mapstructure:"deny_clients" validate:"dive,cidr"`
+
+	SRV         SRVConfig        `mapstructure:"srv"`
+	HTTPS       HTTPSConfig      `mapstructure:"https"`
+	HTTP        HTTPCheck        `mapstructure:"http"`
+	HTTP3       HTTP3Check       `mapstructure:"http3"`
+	Certificate CertificateCheck `mapstructure:"certificate"`
+	Speed       SpeedCheck       `mapstructure:"speed"`
+	Resolve     ResolveCheck     `mapstructure:"resolve"`
+	Client      ClientProfile    `mapstructure:"client"`
+
+	AnswerPolicy       AnswerPolicyConfig `mapstructure:"answer_policy"`
+	AnswersPerResponse int                `mapstructure:"answers_per_response" validate:"gte=0"`
+	AnswerOrder        string             `mapstructure:"answer_order" default:"fixed" validate:"oneof=fixed latency random"`
+	Rotation           string             `mapstructure:"rotation" default:"none" validate:"oneof=none shift shuffle"`
+	CIDRPruning        CIDRPruning        `mapstructure:"cidr_pruning"`
+	Source             SourceConfig       `mapstructure:"source"`
+	Pool               PoolConfig         `mapstructure:"pool"`
+	Bootstrap          BootstrapConfig    `mapstructure:"bootstrap"`
+
+	vmMu        sync.Mutex
+	vm          *vm.VM
+	programHash [sha256.Size]byte
+	renderedAt  time.Time
 }
 
-// ReadCIDRs parses CIDR strings into iterators.
-func (sc *ScanConfig) ReadCIDRs() ([]*cidr.Iterator, error) {
-	result := make([]*cidr.Iterator, len(sc.CIDRs))
-	var err error
-	for i, cidrStr := range sc.CIDRs {
-		result[i], err = cidr.NewIPv4CIDR(cidrStr)
-		if err != nil {
-			return result, err
+// SRVConfig publishes the records of a domain as SRV records of Service below it, weighted by
+// the scores of the IPs, for consumers that balance by weight. It is disabled when Service is empty.
+type SRVConfig struct {
+	// Service is the _service._proto prefix of the SRV name, such as _https._tcp.
+	Service  string `mapstructure:"service" validate:"omitempty,srvservice"`
+	Port     int    `mapstructure:"port" validate:"gte=0,lte=65535"`
+	Priority uint16 `mapstructure:"priority"`
+}
+
+// SRVName returns the owner name of the SRV records of the domain, empty when they are disabled.
+func (sc *ScanConfig) SRVName() string {
+	if sc.SRV.Service == "" {
+		return ""
+	}
+	return dns.CanonicalName(sc.SRV.Service + "." + sc.Domain)
+}
+
+// SRVPort returns the port of the SRV records, the scanned port unless srv.port is set.
+func (sc *ScanConfig) SRVPort() uint16 {
+	if sc.SRV.Port > 0 {
+		return uint16(sc.SRV.Port) //nolint:gosec // validated, lte=65535
+	}
+	return uint16(sc.Port) //nolint:gosec // validated, lte=65535
+}
+
+// HTTPSConfig answers HTTPS and SVCB queries for the domain with a service record carrying the
+// servable IPs as ipv4hint and ipv6hint, browsers query it before the address records.
+type HTTPSConfig struct {
+	Enabled bool     `mapstructure:"enabled"`
+	ALPN    []string `mapstructure:"alpn" validate:"dive,required,max=255"`
+	// Port is announced when it is not 443, the scanned port unless set.
+	Port int `mapstructure:"port" validate:"gte=0,lte=65535"`
+}
+
+// HTTPSPort returns the port announced in the HTTPS records, the scanned port unless https.port is set.
+func (sc *ScanConfig) HTTPSPort() uint16 {
+	if sc.HTTPS.Port > 0 {
+		return uint16(sc.HTTPS.Port) //nolint:gosec // validated, lte=65535
+	}
+	return uint16(sc.Port) //nolint:gosec // validated, lte=65535
+}
+
+// PoolConfig smooths the healthy pool size of a domain, the number of IPs accepted by a cycle,
+// with an exponential moving average weighting the newest cycle by smoothing, so a single noisy
+// cycle does not trip the decisions based on it. The fallback IPs are served along with the
+// accepted ones while the smoothed size is below min_size, zero disables it.
+type PoolConfig struct {
+	Smoothing float64 `mapstructure:"smoothing" default:"0.3" validate:"gt=0,lte=1"`
+	MinSize   int     `mapstructure:"min_size" validate:"gte=0"`
+}
+
+// Record sources, scan probes the sampled CIDRs, the others take their IPs from elsewhere.
+const (
+	SourceScan    = "scan"
+	SourceStatic  = "static"
+	SourceResolve = "resolve"
+	SourceHTTP    = "http"
+)
+
+// SourceConfig selects where the IPs of a domain come from. IPs of sources other than scan are
+// served as they are, unless Check runs the checks of the domain on them first.
+type SourceConfig struct {
+	Type    string        `mapstructure:"type" default:"scan" validate:"oneof=scan static resolve http"`
+	IPs     []string      `mapstructure:"ips" validate:"required_if=Type static,dive,ip"`
+	Name    string        `mapstructure:"name" validate:"required_if=Type resolve,omitempty,fqdn"`
+	URL     string        `mapstructure:"url" validate:"required_if=Type http,omitempty,url"`
+	Timeout time.Duration `mapstructure:"timeout" default:"10s" validate:"gt=0"`
+	Check   bool          `mapstructure:"check"`
+}
+
+// BootstrapConfig seeds the records of a domain at startup with the addresses Resolver answers
+// for Name, the SNI of the domain by default, keeping the ones passing its checks while the first
+// cycle scans its CIDRs. It is disabled when Resolver is empty.
+type BootstrapConfig struct {
+	Resolver string        `mapstructure:"resolver" validate:"omitempty,hostport"`
+	Name     string        `mapstructure:"name" validate:"omitempty,fqdn"`
+	Timeout  time.Duration `mapstructure:"timeout" default:"5s" validate:"gt=0"`
+}
+
+// BootstrapName returns the name resolved to bootstrap the records of the domain.
+func (sc *ScanConfig) BootstrapName() string {
+	if sc.Bootstrap.Name != "" {
+		return sc.Bootstrap.Name
+	}
+	return dns.Fqdn(cmp.Or(sc.SNI, strings.TrimPrefix(sc.Domain, "*.")))
+}
+
+// CIDRPruning stops spending probes on CIDRs that were fully probed without a single accepted IP
+// for after_cycles consecutive cycles, it is disabled when after_cycles is zero.
+type CIDRPruning struct {
+	AfterCycles int    `mapstructure:"after_cycles" validate:"gte=0"`
+	Mode        string `mapstructure:"mode" default:"skip" validate:"oneof=skip deprioritize"`
+}
+
+// Pruning modes, skip stops probing a pruned CIDR, deprioritize probes a single IP of it per cycle.
+const (
+	PruneSkip         = "skip"
+	PruneDeprioritize = "deprioritize"
+)
+
+// defaultMaxRedirects is the redirect limit of HTTP checks without max_redirects.
+const defaultMaxRedirects = 5
+
+// HTTPCheck configures the native HTTP check executed after the program succeeds.
+type HTTPCheck struct {
+	FollowRedirects bool `mapstructure:"follow_redirects"`
+	// MaxRedirects is a pointer so an explicit 0, failing on any redirect, is told apart from unset.
+	MaxRedirects      *int       `mapstructure:"max_redirects" validate:"omitempty,gte=0"`
+	SameHostRedirects bool       `mapstructure:"same_host_redirects"`
+	Expect            HTTPExpect `mapstructure:"expect"`
+}
+
+// RedirectLimit returns the maximum redirects followed, max_redirects or 5 when it is unset.
+func (hc HTTPCheck) RedirectLimit() int {
+	if hc.MaxRedirects == nil {
+		return defaultMaxRedirects
+	}
+	return *hc.MaxRedirects
+}
+
+// HTTPExpect holds assertions applied to the HTTP check response.
+type HTTPExpect struct {
+	RedirectLocation string `mapstructure:"redirect_location"`
+	// BodyContains and BodyRegex must both match the start of the body, to tell the origin apart
+	// from block pages served with the expected status.
+	BodyContains string `mapstructure:"body_contains"`
+	BodyRegex    string `mapstructure:"body_regex"`
+	// Headers maps response headers to regular expressions one of their values must match, an
+	// empty expression only requires the header. AbsentHeaders must not be in the response.
+	Headers       map[string]string `mapstructure:"headers"`
+	AbsentHeaders []string          `mapstructure:"absent_headers" validate:"dive,required"`
+}
+
+// Relations of a candidate IP to the official answers of the SNI.
+const (
+	RelationIn        = "in"
+	RelationNear      = "near"
+	RelationUnrelated = "unrelated"
+)
+
+// HTTP3Check requests path over HTTP/3 from every candidate, after a QUIC handshake with the SNI.
+type HTTP3Check struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Port is the UDP port of the QUIC listener, the port of the domain when zero.
+	Port int `mapstructure:"port" validate:"omitempty,gte=1,lte=65535"`
+}
+
+// CertificateCheck pins the leaf certificate candidates present for the SNI, so IPs terminating TLS
+// with the certificate of another origin are rejected.
+type CertificateCheck struct {
+	// SPKI lists the accepted base64 SHA-256 digests of the subject public key info of the leaf.
+	SPKI []string `mapstructure:"spki"`
+	// Issuer and Subject are regular expressions matched against the distinguished names of the leaf.
+	Issuer  string `mapstructure:"issuer"`
+	Subject string `mapstructure:"subject"`
+}
+
+// Enabled reports whether the certificate check pins anything.
+func (cc CertificateCheck) Enabled() bool {
+	return len(cc.SPKI) > 0 || cc.Issuer != "" || cc.Subject != ""
+}
+
+// SpeedCheck downloads a file from every candidate and rejects the ones transferring it slower
+// than a minimum rate.
+type SpeedCheck struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Path serves at least size_kb, the path of the domain when empty.
+	Path    string        `mapstructure:"path" validate:"omitempty,path"`
+	SizeKB  int           `mapstructure:"size_kb" default:"1024" validate:"gt=0"`
+	MinKBps float64       `mapstructure:"min_kbps" validate:"required_if=Enabled true,gte=0"`
+	Timeout time.Duration `mapstructure:"timeout" default:"10s" validate:"gt=0"`
+}
+
+// ResolveCheck compares candidates with the answers a resolver gives for the SNI.
+type ResolveCheck struct {
+	Enabled    bool     `mapstructure:"enabled"`
+	Resolver   string   `mapstructure:"resolver" validate:"omitempty,hostport"`
+	NearPrefix int      `mapstructure:"near_prefix" default:"24" validate:"gte=0,lte=128"`
+	Accept     []string `mapstructure:"accept" validate:"dive,oneof=in near unrelated"`
+}
+
+// Enabled reports whether the native HTTP check has anything to do.
+func (hc HTTPCheck) Enabled() bool {
+	return hc.FollowRedirects || hc.Expect.RedirectLocation != "" || hc.Expect.BodyContains != "" ||
+		hc.Expect.BodyRegex != "" || len(hc.Expect.Headers) > 0 || len(hc.Expect.AbsentHeaders) > 0
+}
+
+// Answer orders, fixed keeps the order of the answer policy, latency serves the fastest records
+// first and random shuffles every response.
+const (
+	OrderFixed   = "fixed"
+	OrderLatency = "latency"
+	OrderRandom  = "random"
+)
+
+// Answer rotations, shift moves the first answer by one on every response, shuffle randomizes
+// the order of every response.
+const (
+	RotationNone    = "none"
+	RotationShift   = "shift"
+	RotationShuffle = "shuffle"
+)
+
+// AnswerPolicyConfig selects the answer policy of a domain, see package policy for the built-ins.
+type AnswerPolicyConfig struct {
+	Name  string `mapstructure:"name" default:"all" validate:"required"`
+	Count int    `mapstructure:"count" validate:"gte=0"`
+}
+
+// orders reports whether the policy orders the records itself, every policy but all does.
+func (a AnswerPolicyConfig) orders() bool {
+	return a.Name != "all"
+}
+
+// TLS ClientHello fingerprints of probes.
+const (
+	FingerprintChrome  = "chrome"
+	FingerprintFirefox = "firefox"
+	FingerprintSafari  = "safari"
+	FingerprintEdge    = "edge"
+	FingerprintIOS     = "ios"
+	FingerprintAndroid = "android"
+)
+
+// ClientProfile shapes the HTTP and TLS characteristics of native checks.
+type ClientProfile struct {
+	UserAgent    string   `mapstructure:"user_agent"`
+	ALPN         []string `mapstructure:"alpn" validate:"dive,required"`
+	CipherSuites []string `mapstructure:"cipher_suites" validate:"dive,ciphersuite"`
+	Fingerprint  string   `mapstructure:"fingerprint" validate:"omitempty,oneof=chrome firefox safari edge ios android"`
+	// RequireALPN rejects candidates not negotiating this protocol, h3 is negotiated by the http3 check.
+	RequireALPN string `mapstructure:"require_alpn" validate:"omitempty,oneof=h2 http/1.1 h3"`
+}
+
+// RequiresTLSALPN reports whether the TLS handshake of probes must negotiate RequireALPN.
+func (cp ClientProfile) RequiresTLSALPN() bool {
+	return cp.RequireALPN != "" && cp.RequireALPN != "h3"
+}
+
+// ShapesTLS reports whether the profile changes the TLS ClientHello of probes.
+func (cp ClientProfile) ShapesTLS() bool {
+	return cp.Fingerprint != "" || len(cp.ALPN) > 0 || len(cp.CipherSuites) > 0
+}
+
+// NativeHTTP reports whether HTTP expectations are checked natively instead of by the program.
+func (sc *ScanConfig) NativeHTTP() bool {
+	return sc.HTTP.Enabled() || sc.Client.UserAgent != ""
+}
+
+// NativeTLS reports whether the TLS handshake is checked natively instead of by the program.
+func (sc *ScanConfig) NativeTLS() bool {
+	return (sc.Client.ShapesTLS() || sc.Client.RequiresTLSALPN() || sc.Certificate.Enabled()) && !sc.HTTPOnly
+}
+
+// CountsTraffic reports whether a native check of the domain counts its bytes against
+// max_bytes_per_cycle, only the TLS, HTTP and speed checks do. The program and HTTP/3 checks
+// open their own connections.
+func (sc *ScanConfig) CountsTraffic() bool {
+	return sc.NativeTLS() || sc.NativeHTTP() || sc.Speed.Enabled
+}
+
+// Responses served for a domain while it is paused.
+const (
+	PausedLastKnownGood = "last_known_good"
+	PausedFallback      = "fallback"
+	PausedServFail      = "servfail"
+	PausedForward       = "forward"
+)
+
+// Answers to queries of a domain for types it has no records of, nodata answers without records,
+// forward relays them to the upstream resolvers.
+const (
+	OtherTypesNoData  = "nodata"
+	OtherTypesForward = "forward"
+)
+
+// Answers served for aliases, records copies the A/AAAA records of the domain
+// under the alias name, cname answers with a CNAME to the domain followed by its records.
+const (
+	AliasRecords = "records"
+	AliasCNAME   = "cname"
+)
+
+// IsEnabled reports whether the domain is enabled, domains are enabled unless set otherwise.
+func (sc *ScanConfig) IsEnabled() bool {
+	return sc.Enabled == nil || *sc.Enabled
+}
+
+// Suspended reports whether the domain is not scanned but still answered using paused_response.
+func (sc *ScanConfig) Suspended() bool {
+	return sc.Paused || !sc.IsEnabled()
+}
+
+// ServedAliases returns the aliases of the domain, followed by its SNI hostname when serve_sni is
+// set and the SNI is a hostname other than the domain.
+func (sc *ScanConfig) ServedAliases() []string {
+	if !sc.ServeSNI || sc.SNI == "" || net.ParseIP(sc.SNI) != nil || strings.Contains(sc.SNI, "*") {
+		return sc.Aliases
+	}
+	sni := dns.CanonicalName(sc.SNI)
+	if sni == dns.CanonicalName(sc.Domain) || slices.Contains(sc.Aliases, sni) {
+		return sc.Aliases
+	}
+	return append(slices.Clone(sc.Aliases), sni)
+}
+
+// Fallback returns the parsed fallback IPs of this domain.
+func (sc *ScanConfig) Fallback() []net.IP {
+	result := make([]net.IP, 0, len(sc.FallbackIPs))
+	for _, ipStr := range sc.FallbackIPs {
+		if ip := net.ParseIP(ipStr); ip != nil {
+			result = append(result, ip)
 		}
 	}
-	return result, err
+	return result
 }
 
-// ReadCIDRsSamples builds sampled IP sequences from configured CIDRs.
-func (sc *ScanConfig) ReadCIDRsSamples() ([]iter.Seq[net.IP], error) {
-	cidrs, err := sc.ReadCIDRs()
+// ReadCIDRsSamples returns a sampled address sequence for every configured CIDR, without the
+// addresses of the excluded CIDRs. When keep is not nil, only the addresses it keeps are sampled,
+// the others do not count against sample_min and sample_max. IPv4 ranges are walked in order,
+// IPv6 ranges are sampled as described in sampleIPv6. The sequence of a CIDR list URL samples
+// every listed CIDR in turn, the list is downloaded again once cidr_refresh passed and kept as is
+// when that fails.
+func (sc *ScanConfig) ReadCIDRsSamples(ctx context.Context, keep func(net.IP) bool) ([]iter.Seq[net.IP], error) {
+	networks, err := parseNetworks(sc.ExcludeCIDRs)
 	if err != nil {
 		return nil, err
 	}
-	samples := make([]iter.Seq[net.IP], len(cidrs))
-	for i, iter := range cidrs {
-		samples[i] = iter.SeqSampled(sc.SamplesChance, sc.SamplesMaximum, sc.SamplesMinimum)
+	exclude := ipFilter{networks: networks, keep: keep}
+	samples := make([]iter.Seq[net.IP], len(sc.CIDRs))
+	for i, entry := range sc.CIDRs {
+		if !isCIDRListURL(entry) {
+			if samples[i], err = sc.sampleCIDR(entry, exclude); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		listed, err := remoteCIDRs.get(ctx, entry, sc.CIDRRefresh, time.Now())
+		if err != nil {
+			if len(listed) == 0 {
+				return nil, err
+			}
+			log.Of(ctx).Warn("failed to refresh cidr list, keeping the previous one", zap.Error(err))
+		}
+		seqs := make([]iter.Seq[net.IP], len(listed))
+		for j, cidrStr := range listed {
+			if seqs[j], err = sc.sampleCIDR(cidrStr, exclude); err != nil {
+				return nil, err
+			}
+		}
+		samples[i] = func(yield func(net.IP) bool) {
+			for _, seq := range seqs {
+				for ip := range seq {
+					if !yield(ip) {
+						return
+					}
+				}
+			}
+		}
 	}
 	return samples, nil
 }
 
+// sampleCIDR returns the sampled address sequence of cidrStr.
+func (sc *ScanConfig) sampleCIDR(cidrStr string, exclude ipFilter) (iter.Seq[net.IP], error) {
+	ip, ipNet, err := net.ParseCIDR(cidrStr)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() == nil {
+		return sampleIPv6(ipNet, sc.SamplesChance, sc.SamplesMaximum, sc.SamplesMinimum, exclude), nil
+	}
+	it, err := cidr.NewIPv4CIDR(cidrStr)
+	if err != nil {
+		return nil, err
+	}
+	return sampleIPv4(it, sc.SamplesChance, sc.SamplesMaximum, sc.SamplesMinimum, exclude), nil
+}
+
 // BuildVM creates and caches the execution VM for this scan configuration.
 func (sc *ScanConfig) BuildVM() (*vm.VM, error) {
+	sc.vmMu.Lock()
+	defer sc.vmMu.Unlock()
 	if sc.vm != nil {
 		return sc.vm, nil
 	}
+	program, err := sc.renderProgram()
+	if err != nil {
+		return nil, err
+	}
+	vmRuntime, err := vm.New([]byte(program))
+	if err == nil {
+		sc.vm, sc.programHash, sc.renderedAt = vmRuntime, sha256.Sum256([]byte(program)), time.Now()
+	}
+	return vmRuntime, err
+}
+
+// RefreshVM renders the program again once ProgramRefresh passed since it was last rendered, and
+// rebuilds the cached VM when the hash of the rendered program changed. It reports whether the VM
+// was rebuilt, on errors the previous VM is kept.
+func (sc *ScanConfig) RefreshVM(now time.Time) (bool, error) {
+	sc.vmMu.Lock()
+	defer sc.vmMu.Unlock()
+	if sc.ProgramRefresh <= 0 || sc.vm == nil || now.Sub(sc.renderedAt) < sc.ProgramRefresh {
+		return false, nil
+	}
+	sc.renderedAt = now
+	program, err := sc.renderProgram()
+	if err != nil {
+		return false, err
+	}
+	hash := sha256.Sum256([]byte(program))
+	if hash == sc.programHash {
+		return false, nil
+	}
+	vmRuntime, err := vm.New([]byte(program))
+	if err != nil {
+		return false, err
+	}
+	sc.vm, sc.programHash = vmRuntime, hash
+	return true, nil
+}
+
+// renderProgram evaluates the program template, the default one if Program is empty.
+func (sc *ScanConfig) renderProgram() (string, error) {
 	defaultProgram := `
 tls.connect port={{ .Port }} sni={{ .SNI }} timeout={{ .Timeout }}
-{{ if gt .StatusCode 0 -}} tls.http.get header.host={{ .SNI }} path={{ .Path }} expect.status={{ .StatusCode }} {{- end -}}
+{{ if and (gt .StatusCode 0) (not .NativeHTTP) -}} tls.http.get header.host={{ .SNI }} path={{ .Path }} expect.status={{ .StatusCode }} {{- end -}}
 `
 	if sc.HTTPOnly {
 		defaultProgram = `
 tcp.connect port={{ .Port }} timeout={{ .Timeout }}
-{{ if gt .StatusCode 0 -}} http.get port={{ .Port }} path={{ .Path }} expect.status={{ .StatusCode }} headers.host={{ .SNI }} timeout={{ .Timeout }} {{- end -}}
+{{ if and (gt .StatusCode 0) (not .NativeHTTP) -}} http.get port={{ .Port }} path={{ .Path }} expect.status={{ .StatusCode }} headers.host={{ .SNI }} timeout={{ .Timeout }} {{- end -}}
 `
 	}
-	programStr := cmp.Or(sc.Program, defaultProgram)
-	program, err := template.EvaluateTemplate(programStr, sc)
-	if err != nil {
-		return nil, err
-	}
-	vmRuntime, err := vm.New([]byte(program))
-	if err == nil {
-		sc.vm = vmRuntime
-	}
-	return vmRuntime, err
+	return template.EvaluateTemplate(cmp.Or(sc.Program, defaultProgram), sc)
 }
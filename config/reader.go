@@ -3,10 +3,16 @@ package config
 import (
 	"context"
 	"fmt"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
 
 	"github.com/fmotalleb/go-tools/config"
 	"github.com/fmotalleb/go-tools/decoder"
 	"github.com/fmotalleb/go-tools/defaulter"
+	"github.com/fmotalleb/go-tools/log"
+	"github.com/miekg/dns"
 )
 
 // Parse reads configuration from file and applies defaults from runtime args.
@@ -33,7 +39,41 @@ func Parse(ctx context.Context, dst *Config, path string, args map[string]any) e
 			v.CIDRs = getCIDRs(args)
 		}
 	}
-	return dst.Validate()
+	normalizeNames(dst)
+	start := time.Now()
+	if err := dst.Validate(); err != nil {
+		return err
+	}
+	for _, v := range dst.Domains {
+		v.ExcludeCIDRs = slices.Concat(dst.ExcludeCIDRs, v.ExcludeCIDRs)
+	}
+	log.Of(ctx).Info("config validated",
+		zap.Int("domains", len(dst.Domains)),
+		zap.Duration("duration", time.Since(start)),
+	)
+	return nil
+}
+
+// normalizeNames lowercases the names of domains, aliases and client group domains and adds
+// their trailing dot, the form they are matched against queries in.
+func normalizeNames(cfg *Config) {
+	canonical := func(name string) string {
+		if name == "" {
+			return name
+		}
+		return dns.CanonicalName(name)
+	}
+	for _, domainCfg := range cfg.Domains {
+		domainCfg.Domain = canonical(domainCfg.Domain)
+		for i, alias := range domainCfg.Aliases {
+			domainCfg.Aliases[i] = canonical(alias)
+		}
+	}
+	for _, group := range cfg.ClientGroups {
+		for i, domain := range group.Domains {
+			group.Domains[i] = canonical(domain)
+		}
+	}
 }
 
 func getCIDRs(args map[string]any) []string {
@@ -2,8 +2,10 @@ package config
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -36,6 +38,72 @@ domains:
 	if len(cfg.Domains[0].CIDRs) != 1 || cfg.Domains[0].CIDRs[0] != "198.51.100.0/24" {
 		t.Fatalf("domain cidr fallback not applied: got %#v", cfg.Domains[0].CIDRs)
 	}
+	if cfg.EDNS.UDPSize != 1232 || cfg.EDNS.ClientSubnet {
+		t.Fatalf("edns = %+v, want the default UDP size without client subnet", cfg.EDNS)
+	}
+	if cfg.Rcodes.UnknownName != RcodeNXDomain || cfg.Rcodes.OutsideZones != RcodeRefused {
+		t.Fatalf("rcodes = %+v, want NXDOMAIN for unknown names and REFUSED outside zones", cfg.Rcodes)
+	}
+	if cfg.RescanOnMiss.Enabled || cfg.RescanOnMiss.MinInterval != 30*time.Second {
+		t.Fatalf("rescan_on_miss = %+v, want disabled with a 30s min_interval", cfg.RescanOnMiss)
+	}
+	if cfg.QueryLog.File != "" || cfg.QueryLog.MaxSizeMB != 100 {
+		t.Fatalf("query_log = %+v, want disabled with a 100MB max size", cfg.QueryLog)
+	}
+}
+
+func TestParseKeepsDisabledDomains(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    enabled: false
+  - domain: "other.example.com."
+`)
+
+	var cfg Config
+	if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+	if cfg.Domains[0].IsEnabled() {
+		t.Fatal("domains[0] enabled, want disabled")
+	}
+	if !cfg.Domains[1].IsEnabled() {
+		t.Fatal("domains[1] disabled, want enabled by default")
+	}
+}
+
+func TestParseNormalizesNames(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "Edge.Example.com"
+    aliases: ["WWW.example.com"]
+client_groups:
+  - name: office
+    cidr: ["10.0.0.0/8"]
+    domains: ["EDGE.example.com"]
+`)
+
+	var cfg Config
+	if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+	if got := cfg.Domains[0].Domain; got != "edge.example.com." {
+		t.Errorf("domain = %q, want edge.example.com.", got)
+	}
+	if got := cfg.Domains[0].Aliases; len(got) != 1 || got[0] != "www.example.com." {
+		t.Errorf("aliases = %q, want [www.example.com.]", got)
+	}
+	if got := cfg.ClientGroups[0].Domains; len(got) != 1 || got[0] != "edge.example.com." {
+		t.Errorf("client group domains = %q, want [edge.example.com.]", got)
+	}
 }
 
 func TestParseRejectsInvalidPath(t *testing.T) {
@@ -109,6 +177,1003 @@ interval: 1m
 	}
 }
 
+func TestParseRejectsFallbackWithoutIPs(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    paused: true
+    paused_response: fallback
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil {
+		t.Fatal("Parse() expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "fallback_ips: is required when paused_response is fallback") {
+		t.Fatalf("Parse() error = %q, want fallback_ips validation error", err)
+	}
+}
+
+func TestParseRejectsInvalidClientGroup(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+client_groups:
+  - name: guests
+    cidr: ["192.168.50.0/33"]
+domains:
+  - domain: "edge.example.com."
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil {
+		t.Fatal("Parse() expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), `cidr: invalid CIDR "192.168.50.0/33"`) {
+		t.Fatalf("Parse() error = %q, want client group cidr validation error", err)
+	}
+}
+
+func TestParseRejectsIncompleteACME(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+acme:
+  enabled: true
+domains:
+  - domain: "edge.example.com."
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil {
+		t.Fatal("Parse() expected error, got nil")
+	}
+	for _, want := range []string{"acme.domains: is required", "acme.cache_dir: is required", "acme: requires http_listen"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse() error = %q, want %q", err, want)
+		}
+	}
+}
+
+func TestParseRejectsChaosWithoutToken(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+http_listen: 127.0.0.1:8080
+interval: 1m
+chaos: true
+domains:
+  - domain: "edge.example.com."
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil || !strings.Contains(err.Error(), "chaos: requires api_token") {
+		t.Fatalf("Parse() error = %v, want chaos api_token validation error", err)
+	}
+}
+
+func TestParseValidatesUpstreams(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+forward_unknown: true
+upstreams:
+  - 1.1.1.1:53
+  - tls://dns.example.net
+  - https://dns.example.net/dns-query
+  - quic://dns.example.net
+domains:
+  - domain: "edge.example.com."
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil {
+		t.Fatal("Parse() expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), `invalid upstream "quic://dns.example.net"`) {
+		t.Fatalf("Parse() error = %q, want upstream validation error", err)
+	}
+	if strings.Count(err.Error(), "invalid upstream") != 1 {
+		t.Fatalf("Parse() error = %q, want only the quic upstream rejected", err)
+	}
+}
+
+func TestParseRejectsForwardUnknownWithoutUpstream(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+forward_unknown: true
+domains:
+  - domain: "edge.example.com."
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil || !strings.Contains(err.Error(), "forward_unknown: requires upstream") {
+		t.Fatalf("Parse() error = %v, want forward_unknown validation error", err)
+	}
+}
+
+func TestParseRejectsProxyModeWithoutUpstream(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+mode: proxy
+domains:
+  - domain: "edge.example.com."
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil || !strings.Contains(err.Error(), "mode: proxy requires upstream") {
+		t.Fatalf("Parse() error = %v, want mode validation error", err)
+	}
+}
+
+func TestParseRejectsSecondCatchAll(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    catch_all: true
+  - domain: "api.example.com."
+    catch_all: true
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil || !strings.Contains(err.Error(), "domains[1]: catch_all: domains[0] is already the catch-all") {
+		t.Fatalf("Parse() error = %v, want catch_all validation error", err)
+	}
+}
+
+func TestParseRejectsBootstrapInPublishGroup(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    publish_group: edge
+    bootstrap:
+      resolver: 1.1.1.1:53
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil || !strings.Contains(err.Error(), "domains[0]: bootstrap: not supported with publish_group") {
+		t.Fatalf("Parse() error = %v, want bootstrap validation error", err)
+	}
+}
+
+func TestParseRejectsHTTP3WithHTTPOnly(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    http_only: true
+    http3:
+      enabled: true
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil || !strings.Contains(err.Error(), "domains[0]: http3: not supported with http_only") {
+		t.Fatalf("Parse() error = %v, want http3 validation error", err)
+	}
+}
+
+func TestParseRejectsUnsatisfiableRequiredALPN(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    client:
+      alpn: ["http/1.1"]
+      require_alpn: h2
+  - domain: "quic.example.com."
+    client:
+      require_alpn: h3
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil ||
+		!strings.Contains(err.Error(), "domains[0]: client.require_alpn: h2 is not offered by client.alpn") ||
+		!strings.Contains(err.Error(), "domains[1]: client.require_alpn: h3 requires http3.enabled") {
+		t.Fatalf("Parse() error = %v, want require_alpn validation errors", err)
+	}
+}
+
+func TestParseRejectsSpeedCheckWithoutMinimum(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    speed:
+      enabled: true
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil || !strings.Contains(err.Error(), "min_kbps") {
+		t.Fatalf("Parse() error = %v, want speed validation error", err)
+	}
+}
+
+func TestParseRejectsInvalidPatterns(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    http:
+      expect:
+        body_regex: "[a-"
+        headers:
+          CF-RAY: ""
+          Server: "(cloudflare"
+    certificate:
+      spki: ["c2hvcnQ="]
+      issuer: "O=(Acme"
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil ||
+		!strings.Contains(err.Error(), `domains[0]: certificate.spki[0]: "c2hvcnQ=" is not a base64 SHA-256 digest`) ||
+		!strings.Contains(err.Error(), "domains[0]: certificate.issuer: error parsing regexp") ||
+		!strings.Contains(err.Error(), "domains[0]: http.expect.body_regex: error parsing regexp") ||
+		!strings.Contains(err.Error(), "domains[0]: http.expect.headers.server: error parsing regexp") {
+		t.Fatalf("Parse() error = %v, want pattern validation errors", err)
+	}
+}
+
+func TestParseRejectsForwardOtherTypesWithoutUpstream(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    other_types: forward
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil || !strings.Contains(err.Error(), "domains[0]: other_types: forward requires upstream") {
+		t.Fatalf("Parse() error = %v, want other_types validation error", err)
+	}
+}
+
+func TestParseValidatesWildcardDomains(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "*.cdn.example.com."
+  - domain: "edge.*.example.com."
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil {
+		t.Fatal("Parse() expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), `domains[1]: domain: a wildcard must be the whole leftmost label`) {
+		t.Fatalf("Parse() error = %q, want wildcard validation error", err)
+	}
+	if strings.Contains(err.Error(), "domains[0]") {
+		t.Fatalf("Parse() error = %q, want the leading wildcard accepted", err)
+	}
+}
+
+func TestParseRejectsCollidingAliases(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    aliases: ["www.example.com."]
+  - domain: "www.example.com."
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil || !strings.Contains(err.Error(), "domains[0]: alias www.example.com. is already served by domains[1]") {
+		t.Fatalf("Parse() error = %v, want alias collision error", err)
+	}
+}
+
+func TestParseValidatesStaticRecords(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+static_records:
+  - name: "mail.example.com."
+    type: A
+    value: "2001:db8::1"
+  - name: "edge.example.com."
+    type: CNAME
+    value: "other.example.com."
+  - name: "edge.example.com."
+    type: TXT
+    value: "v=spf1 -all"
+  - name: "edge.example.com."
+    type: MX
+    value: "10 mail.example.com."
+  - name: "mail.example.com."
+    type: MX
+    value: "mail.example.com."
+  - name: "www.example.com."
+    type: A
+    value: "192.0.2.1"
+  - name: "WWW.example.com."
+    type: CNAME
+    value: "other.example.com."
+  - name: "cdn.example.com."
+    type: CNAME
+    value: "other.example.com."
+domains:
+  - domain: "edge.example.com."
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil {
+		t.Fatal("Parse() expected error, got nil")
+	}
+	for _, want := range []string{
+		`static_records[0]: value: invalid IPv4 address "2001:db8::1"`,
+		"static_records[1]: CNAME records of edge.example.com. would shadow a scanned domain",
+		"static_records[4]: value:",
+		"static_records[6]: CNAME record of WWW.example.com. must be the only record of the name",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse() error = %q, want %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "static_records[2]") {
+		t.Errorf("Parse() error = %q, want the TXT record accepted", err)
+	}
+	if strings.Contains(err.Error(), "static_records[3]") {
+		t.Errorf("Parse() error = %q, want the MX record accepted", err)
+	}
+	if strings.Contains(err.Error(), "static_records[5]") || strings.Contains(err.Error(), "static_records[7]") {
+		t.Errorf("Parse() error = %q, want the A record and the lone CNAME record accepted", err)
+	}
+}
+
+func TestParseAppliesZoneDefaults(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+zones:
+  - name: "example.com."
+    ns: ["ns1.example.com."]
+domains:
+  - domain: "edge.example.com."
+`)
+
+	var cfg Config
+	if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+	zone := cfg.Zones[0]
+	if zone.TTL != time.Hour || zone.NegativeTTL != 5*time.Minute || zone.Expire != 168*time.Hour {
+		t.Fatalf("zone = %+v, want default timers", zone)
+	}
+	if zone.DNSSEC.Enabled || zone.DNSSEC.Algorithm != "ECDSAP256SHA256" || zone.DNSSEC.SignatureValidity != 168*time.Hour {
+		t.Fatalf("zone dnssec = %+v, want disabled with default settings", zone.DNSSEC)
+	}
+}
+
+func TestParseValidatesRecordSources(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+  - domain: "feed.example.com."
+    source:
+      type: http
+  - domain: "mirror.example.com."
+    source:
+      type: static
+      ips: ["192.0.2.1"]
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil || !strings.Contains(err.Error(), "domains[1]") {
+		t.Fatalf("Parse() error = %v, want the http source without url rejected", err)
+	}
+	if strings.Contains(err.Error(), "domains[0]") || strings.Contains(err.Error(), "domains[2]") {
+		t.Fatalf("Parse() error = %q, want the scan and static sources accepted", err)
+	}
+}
+
+func TestParseValidatesShard(t *testing.T) {
+	t.Parallel()
+
+	for shard, valid := range map[string]bool{"0/1": true, "2/3": true, "3/3": false, "1": false, "a/2": false} {
+		cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+shard: "`+shard+`"
+domains:
+  - domain: "edge.example.com."
+`)
+		var cfg Config
+		err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+		if valid && err != nil {
+			t.Errorf("Parse(shard %s) returned error: %v", shard, err)
+		}
+		if !valid && (err == nil || !strings.Contains(err.Error(), "shard: must be i/N")) {
+			t.Errorf("Parse(shard %s) error = %v, want shard validation error", shard, err)
+		}
+	}
+}
+
+func TestParseValidatesMaxBytes(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+max_bytes_per_cycle: 1000000
+domains:
+  - domain: "edge.example.com."
+    client:
+      user_agent: "helios"
+  - domain: "api.example.com."
+  - domain: "off.example.com."
+    enabled: false
+`)
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil || !strings.Contains(err.Error(), "domains[1]: max_bytes_per_cycle") {
+		t.Fatalf("Parse() error = %v, want the domain without native checks rejected", err)
+	}
+	if strings.Contains(err.Error(), "domains[0]") || strings.Contains(err.Error(), "domains[2]") {
+		t.Fatalf("Parse() error = %q, want the domain with a native check and the disabled one accepted", err)
+	}
+}
+
+func TestParseValidatesScanJitter(t *testing.T) {
+	t.Parallel()
+
+	for jitter, valid := range map[string]bool{"0s": true, "59s": true, "1m": false, "2m": false} {
+		cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+scan_jitter: `+jitter+`
+domains:
+  - domain: "edge.example.com."
+`)
+		var cfg Config
+		err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+		if rejected := err != nil && strings.Contains(err.Error(), "scan_jitter: must be below interval"); rejected == valid {
+			t.Fatalf("Parse() with scan_jitter %s error = %v, want valid = %t", jitter, err, valid)
+		}
+	}
+}
+
+func TestParseRejectsConflictingAnswerOptions(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    rotation: shift
+    answer_policy:
+      name: round_robin
+  - domain: "api.example.com."
+    rotation: shuffle
+    answer_policy:
+      count: 2
+  - domain: "fast.example.com."
+    answer_order: latency
+    answer_policy:
+      name: random_n
+      count: 2
+  - domain: "mixed.example.com."
+    answer_order: random
+    rotation: shift
+  - domain: "ok.example.com."
+    answer_order: latency
+    answers_per_response: 2
+  - domain: "subset.example.com."
+    answers_per_response: 2
+    answer_policy:
+      count: 3
+`)
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil {
+		t.Fatal("Parse() returned no error, want the conflicting answer options rejected")
+	}
+	for _, want := range []string{
+		"domains[0]: rotation: conflicts with answer_policy round_robin",
+		"domains[2]: answer_order: conflicts with answer_policy random_n",
+		"domains[3]: rotation: conflicts with answer_order random",
+		"domains[5]: answers_per_response: conflicts with answer_policy all",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("Parse() error = %q, want %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "domains[1]") || strings.Contains(err.Error(), "domains[4]") {
+		t.Fatalf("Parse() error = %q, want the options accepted with the all policy", err)
+	}
+}
+
+func TestParseKeepsZeroMaxRedirects(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    http:
+      follow_redirects: true
+      max_redirects: 0
+  - domain: "api.example.com."
+    http:
+      follow_redirects: true
+`)
+	var cfg Config
+	if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+	if got := cfg.Domains[0].HTTP.RedirectLimit(); got != 0 {
+		t.Fatalf("RedirectLimit() = %d, want 0 when set to 0", got)
+	}
+	if got := cfg.Domains[1].HTTP.RedirectLimit(); got != defaultMaxRedirects {
+		t.Fatalf("RedirectLimit() = %d, want %d when unset", got, defaultMaxRedirects)
+	}
+}
+
+func TestParseServesSNIAsAlias(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    sni: "Origin.Example.com"
+    serve_sni: true
+    aliases: ["www.example.com."]
+`)
+
+	var cfg Config
+	if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+	if got := cfg.Domains[0].ServedAliases(); !slices.Equal(got, []string{"www.example.com.", "origin.example.com."}) {
+		t.Fatalf("ServedAliases() = %v, want the aliases followed by the SNI hostname", got)
+	}
+
+	cfgPath = writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    sni: "origin.example.com"
+    serve_sni: true
+  - domain: "origin.example.com."
+`)
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil || !strings.Contains(err.Error(), "domains[0]: alias origin.example.com. is already served by domains[1]") {
+		t.Fatalf("Parse() error = %v, want the SNI hostname to collide with the other domain", err)
+	}
+}
+
+func TestParseValidatesZoneTransfers(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+listen_tcp: false
+zones:
+  - name: "example.com."
+    ns: ["ns1.example.com."]
+    dnssec:
+      enabled: true
+    transfer:
+      allow: ["203.0.113.0/24"]
+  - name: "example.net."
+    ns: ["ns1.example.net."]
+    transfer:
+      notify: ["203.0.113.10:53"]
+domains:
+  - domain: "edge.example.com."
+`)
+
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	for _, want := range []string{
+		"zones[0]: transfer: is not supported with dnssec",
+		"zones[0]: transfer: requires listen_tcp",
+		"zones[1]: transfer.notify: requires transfer.allow",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("Parse() error = %v, want %q", err, want)
+		}
+	}
+}
+
+func TestParseClientACL(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+allow_clients: ["10.0.0.0/8"]
+deny_clients: ["10.9.0.0/16"]
+domains:
+  - domain: "edge.example.com."
+    deny_clients: ["2001:db8::/32"]
+`)
+	var cfg Config
+	if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+	allow, deny, err := cfg.ClientACL()
+	if err != nil || len(allow) != 1 || len(deny) != 1 || allow[0].String() != "10.0.0.0/8" {
+		t.Fatalf("ClientACL() = %v, %v, %v", allow, deny, err)
+	}
+	allow, deny, err = cfg.Domains[0].ClientACL()
+	if err != nil || len(allow) != 0 || len(deny) != 1 || deny[0].String() != "2001:db8::/32" {
+		t.Fatalf("domain ClientACL() = %v, %v, %v", allow, deny, err)
+	}
+
+	cfgPath = writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+allow_clients: ["10.0.0.1"]
+domains:
+  - domain: "edge.example.com."
+`)
+	if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err == nil {
+		t.Fatal("Parse() accepted an allow_clients entry that is not a CIDR")
+	}
+}
+
+func TestParseMergesExcludedCIDRs(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+exclude_cidr: ["198.51.100.0/26"]
+domains:
+  - domain: "edge.example.com."
+    exclude_cidr: ["198.51.100.64/26"]
+  - domain: "origin.example.com."
+`)
+	var cfg Config
+	if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+	if got := cfg.Domains[0].ExcludeCIDRs; !slices.Equal(got, []string{"198.51.100.0/26", "198.51.100.64/26"}) {
+		t.Fatalf("domains[0].ExcludeCIDRs = %v, want the global and the domain ranges", got)
+	}
+	samples, err := cfg.Domains[0].ReadCIDRsSamples(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("ReadCIDRsSamples() returned error: %v", err)
+	}
+	for ip := range samples[0] {
+		if ip[len(ip)-1] < 128 {
+			t.Fatalf("ReadCIDRsSamples() yielded excluded %s", ip)
+		}
+	}
+	if got := cfg.Domains[1].ExcludeCIDRs; !slices.Equal(got, []string{"198.51.100.0/26"}) {
+		t.Fatalf("domains[1].ExcludeCIDRs = %v, want the global range", got)
+	}
+
+	cfgPath = writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    exclude_cidr: ["198.51.100.1"]
+`)
+	if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err == nil {
+		t.Fatal("Parse() accepted an exclude_cidr entry that is not a CIDR")
+	}
+}
+
+func TestParseIPLists(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+ip_lists:
+  deny: ["/etc/helios/blocked.txt"]
+domains:
+  - domain: "edge.example.com."
+`)
+	var cfg Config
+	if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+	if cfg.IPLists.ReloadInterval != 10*time.Second {
+		t.Fatalf("ip_lists.reload_interval = %s, want the 10s default", cfg.IPLists.ReloadInterval)
+	}
+
+	cfgPath = writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+ip_lists:
+  allow: [""]
+domains:
+  - domain: "edge.example.com."
+`)
+	if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err == nil {
+		t.Fatal("Parse() accepted an empty ip_lists.allow path")
+	}
+}
+
+func TestParseAcceptsCIDRListURLs(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    cidr: ["https://www.cloudflare.com/ips-v4", "2606:4700::/32"]
+`)
+	var cfg Config
+	if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+	if cfg.Domains[0].CIDRRefresh != 24*time.Hour {
+		t.Fatalf("cidr_refresh = %s, want the 24h default", cfg.Domains[0].CIDRRefresh)
+	}
+
+	cfgPath = writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    cidr: ["ftp://example.com/ips-v4"]
+`)
+	if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err == nil {
+		t.Fatal("Parse() accepted a cidr entry that is neither a CIDR nor an HTTP URL")
+	}
+}
+
+func TestParseValidatesSRV(t *testing.T) {
+	t.Parallel()
+
+	for service, valid := range map[string]bool{"_https._tcp": true, "_sip._udp": true, "https._tcp": false, "_https": false, "_a._b._c": false} {
+		cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    srv:
+      service: "`+service+`"
+`)
+		var cfg Config
+		err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+		if valid && err != nil {
+			t.Errorf("Parse(srv.service %s) returned error: %v", service, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("Parse(srv.service %s) accepted an invalid service", service)
+		}
+		if valid && err == nil {
+			if name := cfg.Domains[0].SRVName(); name != service+".edge.example.com." {
+				t.Errorf("SRVName() = %q", name)
+			}
+			if port := cfg.Domains[0].SRVPort(); int(port) != cfg.Domains[0].Port {
+				t.Errorf("SRVPort() = %d, want the scanned port %d", port, cfg.Domains[0].Port)
+			}
+		}
+	}
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "*.cdn.example.com."
+    sni: cdn.example.com
+    srv:
+      service: _https._tcp
+`)
+	var cfg Config
+	if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err == nil {
+		t.Fatal("Parse() accepted srv on a wildcard domain")
+	}
+}
+
+func TestParseDnstap(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+dnstap:
+  socket: /run/dnstap.sock
+domains:
+  - domain: "edge.example.com."
+`)
+	var cfg Config
+	if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+	if !cfg.Dnstap.Enabled() || cfg.Dnstap.Buffer != 1024 {
+		t.Fatalf("Dnstap = %+v, want enabled with the default buffer", cfg.Dnstap)
+	}
+
+	cfgPath = writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+dnstap:
+  socket: /run/dnstap.sock
+  file: /var/log/queries.dnstap
+domains:
+  - domain: "edge.example.com."
+`)
+	if err := Parse(context.Background(), &Config{}, cfgPath, defaultArgs()); err == nil {
+		t.Fatal("Parse() accepted both a dnstap socket and file")
+	}
+}
+
+func TestParseCompilesPrograms(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := writeTestConfig(t, `
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "a.example.com."
+  - domain: "b.example.com."
+    program: "no.such.instruction"
+  - domain: "c.example.com."
+    path: "relative"
+  - domain: "d.example.com."
+`)
+	var cfg Config
+	err := Parse(context.Background(), &cfg, cfgPath, defaultArgs())
+	if err == nil {
+		t.Fatal("Parse() accepted a program that does not compile")
+	}
+	msg := err.Error()
+	program, path := strings.Index(msg, "domains[1]: program:"), strings.Index(msg, "domains[2]:")
+	if program < 0 || path < 0 || program > path {
+		t.Fatalf("Parse() error = %q, want the errors of domains[1] and domains[2] in order", msg)
+	}
+	if cfg.Domains[0].vm == nil || cfg.Domains[3].vm == nil {
+		t.Fatal("programs of valid domains were not compiled")
+	}
+}
+
+func TestRefreshVMRebuildsChangedPrograms(t *testing.T) {
+	t.Parallel()
+
+	portFile := filepath.Join(t.TempDir(), "port")
+	if err := os.WriteFile(portFile, []byte("443"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfgPath := writeTestConfig(t, fmt.Sprintf(`
+listen: 127.0.0.1:5657
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    program: 'tcp.connect port={{ file %q | trim }} timeout=1s'
+    program_refresh: 1m
+`, portFile))
+	var cfg Config
+	if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+	sc := cfg.Domains[0]
+	initial := sc.vm
+	now := time.Now().Add(2 * time.Minute)
+
+	if rebuilt, err := sc.RefreshVM(now); err != nil || rebuilt || sc.vm != initial {
+		t.Fatalf("RefreshVM() = %v, %v, want the VM kept while the program is unchanged", rebuilt, err)
+	}
+	if err := os.WriteFile(portFile, []byte("8443"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt, err := sc.RefreshVM(now.Add(time.Second)); err != nil || rebuilt {
+		t.Fatalf("RefreshVM() = %v, %v, want no refresh before program_refresh passed", rebuilt, err)
+	}
+	if rebuilt, err := sc.RefreshVM(now.Add(time.Minute)); err != nil || !rebuilt || sc.vm == initial {
+		t.Fatalf("RefreshVM() = %v, %v, want the VM rebuilt for the changed program", rebuilt, err)
+	}
+}
+
+func TestParseListenAddresses(t *testing.T) {
+	t.Parallel()
+
+	for raw, want := range map[string][]string{
+		`listen: 127.0.0.1:5657`:                       {"127.0.0.1:5657"},
+		`listen: ["127.0.0.1:5657", "[::1]:5657"]`:     {"127.0.0.1:5657", "[::1]:5657"},
+		"listen:\n  - 127.0.0.1:5657\n  - 10.0.0.1:53": {"127.0.0.1:5657", "10.0.0.1:53"},
+	} {
+		cfgPath := writeTestConfig(t, raw+`
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+`)
+		var cfg Config
+		if err := Parse(context.Background(), &cfg, cfgPath, defaultArgs()); err != nil {
+			t.Fatalf("Parse(%q) returned error: %v", raw, err)
+		}
+		if !slices.Equal(cfg.Listen, want) {
+			t.Fatalf("Parse(%q) listen = %v, want %v", raw, cfg.Listen, want)
+		}
+	}
+
+	cfgPath := writeTestConfig(t, `
+listen: ["127.0.0.1:5657", "127.0.0.1:5657"]
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+`)
+	if err := Parse(context.Background(), &Config{}, cfgPath, defaultArgs()); err == nil {
+		t.Fatal("Parse() accepted a duplicate listen address")
+	}
+}
+
 func writeTestConfig(t *testing.T, body string) string {
 	t.Helper()
 
@@ -123,6 +1188,8 @@ func defaultArgs() map[string]any {
 	return map[string]any{
 		"args": map[string]any{
 			"listen":        "127.0.0.1:5353",
+			"http_listen":   "",
+			"max_workers":   50,
 			"interval":      (10 * time.Minute).Nanoseconds(),
 			"cidrs":         []string{"198.51.100.0/24"},
 			"sni":           "origin.example.com",
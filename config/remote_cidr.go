@@ -0,0 +1,136 @@
+package config
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// cidrListTimeout bounds the download of a remote CIDR list.
+	cidrListTimeout = 10 * time.Second
+	// maxCIDRListBytes caps the size of a remote CIDR list.
+	maxCIDRListBytes = 1 << 20
+)
+
+// remoteCIDRs caches the lists of the URL entries of cidr, shared by every domain and kept
+// across config reloads.
+var remoteCIDRs = newCIDRListCache(http.DefaultClient)
+
+// isCIDRListURL reports whether a cidr entry is the URL of a CIDR list rather than a CIDR.
+func isCIDRListURL(entry string) bool {
+	return strings.HasPrefix(entry, "https://") || strings.HasPrefix(entry, "http://")
+}
+
+// SeedCIDRList sets the CIDRs served for url until it is downloaded for the first time, and
+// whenever no download succeeded yet, e.g. built-in copies of well-known lists for offline use.
+func SeedCIDRList(url string, cidrs []string) {
+	remoteCIDRs.seed(url, cidrs)
+}
+
+// cidrListCache holds the downloaded CIDR lists by URL.
+type cidrListCache struct {
+	client *http.Client
+	mu     sync.Mutex
+	lists  map[string]*cidrList
+}
+
+// cidrList is the last known content of a remote list, fetchedAt is zero until it was downloaded.
+type cidrList struct {
+	mu        sync.Mutex
+	cidrs     []string
+	fetchedAt time.Time
+}
+
+func newCIDRListCache(client *http.Client) *cidrListCache {
+	return &cidrListCache{client: client, lists: make(map[string]*cidrList)}
+}
+
+func (c *cidrListCache) list(url string) *cidrList {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.lists[url]
+	if !ok {
+		l = new(cidrList)
+		c.lists[url] = l
+	}
+	return l
+}
+
+func (c *cidrListCache) seed(url string, cidrs []string) {
+	l := c.list(url)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.fetchedAt.IsZero() {
+		l.cidrs = cidrs
+	}
+}
+
+// get returns the CIDRs listed at url, downloaded again once refresh passed since the last
+// download. When a download fails the previous list is returned along with the error, and the
+// download is retried on the next call.
+func (c *cidrListCache) get(ctx context.Context, url string, refresh time.Duration, now time.Time) ([]string, error) {
+	l := c.list(url)
+	// Domains sharing the list wait for a single download.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.fetchedAt.IsZero() && now.Sub(l.fetchedAt) < refresh {
+		return l.cidrs, nil
+	}
+	cidrs, err := c.fetch(ctx, url)
+	if err != nil {
+		return l.cidrs, fmt.Errorf("fetch %s: %w", url, err)
+	}
+	l.cidrs, l.fetchedAt = cidrs, now
+	return cidrs, nil
+}
+
+func (c *cidrListCache) fetch(ctx context.Context, url string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, cidrListTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return parseCIDRList(io.LimitReader(resp.Body, maxCIDRListBytes))
+}
+
+// parseCIDRList reads one CIDR per line, empty lines and the text after a # are ignored. A list
+// without any CIDR is rejected, as it would stop the scans of the domains using it.
+func parseCIDRList(r io.Reader) ([]string, error) {
+	var cidrs []string
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		cidrs = append(cidrs, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(cidrs) == 0 {
+		return nil, errors.New("no CIDR listed")
+	}
+	return cidrs, nil
+}
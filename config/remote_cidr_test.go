@@ -0,0 +1,104 @@
+package config
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseCIDRList(t *testing.T) {
+	t.Parallel()
+
+	got, err := parseCIDRList(strings.NewReader("173.245.48.0/20\n# comment\n\n103.21.244.0/22 # edge\n2400:cb00::/32\n"))
+	if err != nil {
+		t.Fatalf("parseCIDRList() returned error: %v", err)
+	}
+	if want := []string{"173.245.48.0/20", "103.21.244.0/22", "2400:cb00::/32"}; !slices.Equal(got, want) {
+		t.Fatalf("parseCIDRList() = %v, want %v", got, want)
+	}
+	for _, body := range []string{"<html>blocked</html>\n", "# nothing\n"} {
+		if _, err := parseCIDRList(strings.NewReader(body)); err == nil {
+			t.Fatalf("parseCIDRList(%q) accepted a list without CIDRs", body)
+		}
+	}
+}
+
+func TestCIDRListCacheRefresh(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "192.0.2.0/24\n198.51.100.0/24\n")
+	}))
+	t.Cleanup(srv.Close)
+
+	cache := newCIDRListCache(srv.Client())
+	now := time.Now()
+	want := []string{"192.0.2.0/24", "198.51.100.0/24"}
+	for _, at := range []time.Time{now, now.Add(time.Minute)} {
+		got, err := cache.get(context.Background(), srv.URL, time.Hour, at)
+		if err != nil || !slices.Equal(got, want) {
+			t.Fatalf("get() = %v, %v, want %v", got, err, want)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("downloaded %d times within cidr_refresh, want once", n)
+	}
+
+	failing.Store(true)
+	got, err := cache.get(context.Background(), srv.URL, time.Hour, now.Add(2*time.Hour))
+	if err == nil || !slices.Equal(got, want) {
+		t.Fatalf("get() = %v, %v, want the previous list along with the error", got, err)
+	}
+
+	cache.seed(srv.URL+"/seeded", []string{"203.0.113.0/24"})
+	got, err = cache.get(context.Background(), srv.URL+"/seeded", time.Hour, now)
+	if err == nil || !slices.Equal(got, []string{"203.0.113.0/24"}) {
+		t.Fatalf("get() = %v, %v, want the seeded list along with the error", got, err)
+	}
+	if got, err = cache.get(context.Background(), srv.URL+"/missing", time.Hour, now); err == nil || len(got) != 0 {
+		t.Fatalf("get() = %v, %v, want an error without a list", got, err)
+	}
+}
+
+func TestReadCIDRsSamplesFromList(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "192.0.2.0/30\n198.51.100.0/31\n")
+	}))
+	t.Cleanup(srv.Close)
+
+	sc := &ScanConfig{
+		CIDRs:         []string{"203.0.113.0/31", srv.URL + "/ips-v4"},
+		CIDRRefresh:   time.Hour,
+		SamplesChance: 1,
+	}
+	samples, err := sc.ReadCIDRsSamples(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("ReadCIDRsSamples() returned error: %v", err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("ReadCIDRsSamples() returned %d sequences, want one per cidr entry", len(samples))
+	}
+	var got []string
+	for ip := range samples[1] {
+		got = append(got, ip.String())
+	}
+	want := []string{"192.0.2.0", "192.0.2.1", "192.0.2.2", "192.0.2.3", "198.51.100.0", "198.51.100.1"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("list samples = %v, want every listed CIDR in turn", got)
+	}
+}
@@ -1,13 +1,23 @@
 package config
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"maps"
 	"net"
+	"net/url"
 	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
 	"strings"
 	"sync"
 
+	"golang.org/x/sync/errgroup"
+
 	"github.com/go-playground/validator/v10"
 	"github.com/miekg/dns"
 )
@@ -30,6 +40,10 @@ func validatorInstance() *validator.Validate {
 		_ = validateInst.RegisterValidation("hostport", validateHostPort)
 		_ = validateInst.RegisterValidation("fqdn", validateFQDN)
 		_ = validateInst.RegisterValidation("path", validateHTTPPath)
+		_ = validateInst.RegisterValidation("ciphersuite", validateCipherSuite)
+		_ = validateInst.RegisterValidation("upstream", validateUpstream)
+		_ = validateInst.RegisterValidation("shard", validateShard)
+		_ = validateInst.RegisterValidation("srvservice", validateSRVService)
 		validateInst.RegisterStructValidation(validateScanConfigStruct, ScanConfig{})
 	})
 	return validateInst
@@ -61,14 +75,99 @@ func validateHTTPPath(fl validator.FieldLevel) bool {
 	return strings.HasPrefix(value, "/")
 }
 
+func validateCipherSuite(fl validator.FieldLevel) bool {
+	value, ok := fl.Field().Interface().(string)
+	if !ok {
+		return false
+	}
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, s := range suites {
+			if s.Name == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateUpstream accepts host:port, udp://, tcp:// or tls:// followed by a host
+// with an optional port, and https:// URLs.
+func validateUpstream(fl validator.FieldLevel) bool {
+	value, ok := fl.Field().Interface().(string)
+	if !ok || strings.TrimSpace(value) == "" {
+		return false
+	}
+	scheme, rest, found := strings.Cut(value, "://")
+	if !found {
+		_, _, err := net.SplitHostPort(value)
+		return err == nil
+	}
+	switch scheme {
+	case "udp", "tcp", "tls":
+		if _, _, err := net.SplitHostPort(rest); err == nil {
+			return true
+		}
+		return rest != "" && !strings.ContainsAny(rest, "/?#")
+	case "https":
+		u, err := url.Parse(value)
+		return err == nil && u.Host != ""
+	}
+	return false
+}
+
+func validateShard(fl validator.FieldLevel) bool {
+	value, ok := fl.Field().Interface().(string)
+	if !ok {
+		return false
+	}
+	_, _, err := parseShard(value)
+	return err == nil
+}
+
+// validateSRVService accepts a _service._proto pair such as _https._tcp.
+func validateSRVService(fl validator.FieldLevel) bool {
+	value, ok := fl.Field().Interface().(string)
+	if !ok {
+		return false
+	}
+	service, proto, found := strings.Cut(value, ".")
+	if !found || len(service) < 2 || !strings.HasPrefix(service, "_") || !strings.HasPrefix(proto, "_") {
+		return false
+	}
+	_, valid := dns.IsDomainName(value)
+	return valid && !strings.Contains(proto, ".") && len(proto) > 1
+}
+
 func validateScanConfigStruct(sl validator.StructLevel) {
-	cfg, ok := sl.Current().Interface().(ScanConfig)
+	// Domains are held by pointer, the config is not copied along with its cached VM.
+	if !sl.Current().CanAddr() {
+		return
+	}
+	cfg, ok := sl.Current().Addr().Interface().(*ScanConfig)
 	if !ok {
 		return
 	}
 	if cfg.SamplesMaximum > 0 && cfg.SamplesMinimum > cfg.SamplesMaximum {
 		sl.ReportError(cfg.SamplesMinimum, "sample_min", "sample_min", "sample_bounds", "")
 	}
+	if strings.Contains(strings.TrimPrefix(cfg.Domain, "*."), "*") {
+		sl.ReportError(cfg.Domain, "domain", "domain", "wildcard", "")
+	}
+	for _, alias := range cfg.Aliases {
+		if strings.Contains(alias, "*") {
+			sl.ReportError(cfg.Aliases, "aliases", "aliases", "alias_wildcard", "")
+			break
+		}
+	}
+	if cfg.AliasMode == AliasCNAME && strings.HasPrefix(cfg.Domain, "*.") && len(cfg.ServedAliases()) > 0 {
+		sl.ReportError(cfg.AliasMode, "alias_mode", "alias_mode", "cname_wildcard", "")
+	}
+	if cfg.SRV.Service != "" && strings.HasPrefix(cfg.Domain, "*.") {
+		sl.ReportError(cfg.SRV.Service, "srv", "srv", "srv_wildcard", "")
+	}
+	if cfg.PausedResponse == PausedFallback && len(cfg.FallbackIPs) == 0 {
+		sl.ReportError(cfg.FallbackIPs, "fallback_ips", "fallback_ips", "required_for_fallback", "")
+	}
 }
 
 // Validate checks whether the parsed configuration is usable.
@@ -80,16 +179,327 @@ func (cfg *Config) Validate() error {
 		errs = append(errs, formatValidationErrors(err, ""))
 	}
 
+	errs = append(errs, cfg.validateACME()...)
+
+	if cfg.ForwardUnknown && len(cfg.UpstreamList()) == 0 {
+		errs = append(errs, errors.New("forward_unknown: requires upstream"))
+	}
+	if cfg.Proxy() && len(cfg.UpstreamList()) == 0 {
+		errs = append(errs, errors.New("mode: proxy requires upstream"))
+	}
+	errs = append(errs, cfg.validateCatchAll()...)
+	errs = append(errs, cfg.validateZoneTransfers()...)
+
+	if cfg.ScanJitter > 0 && cfg.ScanJitter >= cfg.UpdateInterval {
+		errs = append(errs, errors.New("scan_jitter: must be below interval, the delay would stretch every cycle past it"))
+	}
+
+	if cfg.Chaos && cfg.HTTPListen == "" {
+		errs = append(errs, errors.New("chaos: requires http_listen, faults are toggled through the HTTP API"))
+	}
+	if cfg.Chaos && cfg.APIToken == "" {
+		errs = append(errs, errors.New("chaos: requires api_token, the HTTP API toggling faults is authenticated"))
+	}
+
+	errs = append(errs, cfg.validateMaxBytes()...)
+	errs = append(errs, cfg.validateStaticRecords(v)...)
+	errs = append(errs, cfg.validateAliases()...)
+	errs = append(errs, cfg.validateDomains(v)...)
+	return errors.Join(errs...)
+}
+
+// validateACME checks that an enabled acme section has the domains, the cache and the listener it
+// needs.
+func (cfg *Config) validateACME() []error {
+	if !cfg.ACME.Enabled {
+		return nil
+	}
+	var errs []error
+	if len(cfg.ACME.Domains) == 0 {
+		errs = append(errs, errors.New("acme.domains: is required when acme is enabled"))
+	}
+	if cfg.ACME.CacheDir == "" {
+		errs = append(errs, errors.New("acme.cache_dir: is required when acme is enabled"))
+	}
+	if cfg.HTTPListen == "" {
+		errs = append(errs, errors.New("acme: requires http_listen, the listener served over TLS"))
+	}
+	return errs
+}
+
+// validateZoneTransfers checks that the zones allowing transfers can serve them.
+func (cfg *Config) validateZoneTransfers() []error {
+	var errs []error
+	for i, zone := range cfg.Zones {
+		if len(zone.Transfer.Allow) == 0 {
+			if len(zone.Transfer.Notify) > 0 {
+				errs = append(errs, fmt.Errorf("zones[%d]: transfer.notify: requires transfer.allow", i))
+			}
+			continue
+		}
+		if zone.DNSSEC.Enabled {
+			errs = append(errs, fmt.Errorf("zones[%d]: transfer: is not supported with dnssec, answers are signed on the fly", i))
+		}
+		if !cfg.TCPEnabled() {
+			errs = append(errs, fmt.Errorf("zones[%d]: transfer: requires listen_tcp", i))
+		}
+	}
+	return errs
+}
+
+// validateMaxBytes checks that max_bytes_per_cycle can count the traffic of every enabled domain.
+func (cfg *Config) validateMaxBytes() []error {
+	if cfg.MaxBytes <= 0 {
+		return nil
+	}
+	var errs []error
+	for i, domainCfg := range cfg.Domains {
+		if domainCfg != nil && domainCfg.IsEnabled() && !domainCfg.CountsTraffic() {
+			errs = append(errs, fmt.Errorf("domains[%d]: max_bytes_per_cycle: requires a native TLS, HTTP or speed check, the traffic of the program is not counted", i))
+		}
+	}
+	return errs
+}
+
+// validateAliases checks that no alias is a name already served by another domain or alias.
+func (cfg *Config) validateAliases() []error {
+	served := make(map[string]int, len(cfg.Domains))
+	for i, domainCfg := range cfg.Domains {
+		if domainCfg != nil {
+			served[domainCfg.Domain] = i
+		}
+	}
+	var errs []error
 	for i, domainCfg := range cfg.Domains {
 		if domainCfg == nil {
-			errs = append(errs, fmt.Errorf("domains[%d]: must not be null", i))
 			continue
 		}
-		if err := v.Struct(domainCfg); err != nil {
-			errs = append(errs, formatValidationErrors(err, fmt.Sprintf("domains[%d]: ", i)))
+		for _, alias := range domainCfg.ServedAliases() {
+			if first, ok := served[alias]; ok {
+				errs = append(errs, fmt.Errorf("domains[%d]: alias %s is already served by domains[%d]", i, alias, first))
+				continue
+			}
+			served[alias] = i
 		}
 	}
-	return errors.Join(errs...)
+	return errs
+}
+
+// validateDomains checks every domain and compiles its scan program, on a bounded pool of workers
+// so configs with many domains reload quickly. Errors are returned in domain order.
+func (cfg *Config) validateDomains(v *validator.Validate) []error {
+	domainErrs := make([][]error, len(cfg.Domains))
+	hasUpstream := len(cfg.UpstreamList()) > 0
+	var group errgroup.Group
+	group.SetLimit(runtime.GOMAXPROCS(0))
+	for i, domainCfg := range cfg.Domains {
+		group.Go(func() error {
+			domainErrs[i] = validateDomain(v, i, domainCfg, hasUpstream)
+			return nil
+		})
+	}
+	_ = group.Wait()
+	return slices.Concat(domainErrs...)
+}
+
+// validateCatchAll checks that at most one domain is the catch-all, and that no name is left
+// for forward_unknown or proxy mode to forward when one is.
+func (cfg *Config) validateCatchAll() []error {
+	catchAll := -1
+	var errs []error
+	for i, domainCfg := range cfg.Domains {
+		if domainCfg == nil || !domainCfg.CatchAll {
+			continue
+		}
+		if catchAll >= 0 {
+			errs = append(errs, fmt.Errorf("domains[%d]: catch_all: domains[%d] is already the catch-all", i, catchAll))
+			continue
+		}
+		catchAll = i
+	}
+	if catchAll < 0 {
+		return errs
+	}
+	if cfg.ForwardUnknown {
+		errs = append(errs, errors.New("forward_unknown: no name is unknown with a catch_all domain"))
+	}
+	if cfg.Proxy() {
+		errs = append(errs, errors.New("mode: proxy is not supported with a catch_all domain"))
+	}
+	return errs
+}
+
+func validateDomain(v *validator.Validate, i int, domainCfg *ScanConfig, hasUpstream bool) []error {
+	if domainCfg == nil {
+		return []error{fmt.Errorf("domains[%d]: must not be null", i)}
+	}
+	errs := slices.Concat(
+		validateDomainModes(i, domainCfg, hasUpstream),
+		validateDomainChecks(i, domainCfg),
+		validateDomainAnswers(i, domainCfg),
+	)
+	if err := v.Struct(domainCfg); err != nil {
+		// The program is built from the other fields, it is only compiled once they are valid.
+		return append([]error{formatValidationErrors(err, fmt.Sprintf("domains[%d]: ", i))}, errs...)
+	}
+	if _, err := domainCfg.BuildVM(); err != nil {
+		errs = append(errs, fmt.Errorf("domains[%d]: program: %w", i, err))
+	}
+	return errs
+}
+
+// validateDomainModes checks the options of the domain at index i that exclude each other or
+// need upstreams.
+func validateDomainModes(i int, domainCfg *ScanConfig, hasUpstream bool) []error {
+	var errs []error
+	if domainCfg.PausedResponse == PausedForward && !hasUpstream {
+		errs = append(errs, fmt.Errorf("domains[%d]: paused_response: forward requires upstream", i))
+	}
+	if domainCfg.OtherTypes == OtherTypesForward && !hasUpstream {
+		errs = append(errs, fmt.Errorf("domains[%d]: other_types: forward requires upstream", i))
+	}
+	if domainCfg.HTTP3.Enabled && domainCfg.HTTPOnly {
+		errs = append(errs, fmt.Errorf("domains[%d]: http3: not supported with http_only", i))
+	}
+	if domainCfg.Certificate.Enabled() && domainCfg.HTTPOnly {
+		errs = append(errs, fmt.Errorf("domains[%d]: certificate: not supported with http_only", i))
+	}
+	if domainCfg.Bootstrap.Resolver != "" && domainCfg.PublishGroup != "" {
+		errs = append(errs, fmt.Errorf("domains[%d]: bootstrap: not supported with publish_group", i))
+	}
+	return errs
+}
+
+// validateDomainChecks compiles the patterns of the native checks of the domain at index i and
+// checks its required ALPN is offered.
+func validateDomainChecks(i int, domainCfg *ScanConfig) []error {
+	var errs []error
+	if _, err := regexp.Compile(domainCfg.HTTP.Expect.BodyRegex); err != nil {
+		errs = append(errs, fmt.Errorf("domains[%d]: http.expect.body_regex: %w", i, err))
+	}
+	if alpn := domainCfg.Client.RequireALPN; alpn != "" {
+		switch {
+		case domainCfg.HTTPOnly:
+			errs = append(errs, fmt.Errorf("domains[%d]: client.require_alpn: not supported with http_only", i))
+		case alpn == "h3" && !domainCfg.HTTP3.Enabled:
+			errs = append(errs, fmt.Errorf("domains[%d]: client.require_alpn: h3 requires http3.enabled", i))
+		case alpn != "h3" && len(domainCfg.Client.ALPN) > 0 && !slices.Contains(domainCfg.Client.ALPN, alpn):
+			errs = append(errs, fmt.Errorf("domains[%d]: client.require_alpn: %s is not offered by client.alpn", i, alpn))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(domainCfg.HTTP.Expect.Headers)) {
+		if _, err := regexp.Compile(domainCfg.HTTP.Expect.Headers[name]); err != nil {
+			errs = append(errs, fmt.Errorf("domains[%d]: http.expect.headers.%s: %w", i, name, err))
+		}
+	}
+	for _, err := range validateCertificateCheck(domainCfg.Certificate) {
+		errs = append(errs, fmt.Errorf("domains[%d]: certificate.%w", i, err))
+	}
+	return errs
+}
+
+// validateDomainAnswers checks that at most one option of the domain at index i orders its
+// answers.
+func validateDomainAnswers(i int, domainCfg *ScanConfig) []error {
+	var errs []error
+	// The order of a policy would be undone by the options reordering its answers.
+	if domainCfg.Rotation != RotationNone && domainCfg.AnswerPolicy.orders() {
+		errs = append(errs, fmt.Errorf("domains[%d]: rotation: conflicts with answer_policy %s", i, domainCfg.AnswerPolicy.Name))
+	}
+	// Records would be picked twice, by the policy and at random.
+	if domainCfg.AnswersPerResponse > 0 && (domainCfg.AnswerPolicy.orders() || domainCfg.AnswerPolicy.Count > 0) {
+		errs = append(errs, fmt.Errorf("domains[%d]: answers_per_response: conflicts with answer_policy %s", i, domainCfg.AnswerPolicy.Name))
+	}
+	if domainCfg.AnswerOrder != OrderFixed {
+		if domainCfg.AnswerPolicy.orders() {
+			errs = append(errs, fmt.Errorf("domains[%d]: answer_order: conflicts with answer_policy %s", i, domainCfg.AnswerPolicy.Name))
+		}
+		if domainCfg.Rotation != RotationNone {
+			errs = append(errs, fmt.Errorf("domains[%d]: rotation: conflicts with answer_order %s", i, domainCfg.AnswerOrder))
+		}
+	}
+	return errs
+}
+
+// validateCertificateCheck checks the SPKI digests and compiles the patterns of cc.
+func validateCertificateCheck(cc CertificateCheck) []error {
+	var errs []error
+	for j, digest := range cc.SPKI {
+		if raw, err := base64.StdEncoding.DecodeString(digest); err != nil || len(raw) != sha256.Size {
+			errs = append(errs, fmt.Errorf("spki[%d]: %q is not a base64 SHA-256 digest", j, digest))
+		}
+	}
+	if _, err := regexp.Compile(cc.Issuer); err != nil {
+		errs = append(errs, fmt.Errorf("issuer: %w", err))
+	}
+	if _, err := regexp.Compile(cc.Subject); err != nil {
+		errs = append(errs, fmt.Errorf("subject: %w", err))
+	}
+	return errs
+}
+
+// validateStaticRecords checks every static record, and that A, AAAA and CNAME records
+// do not shadow the answers of a scanned domain or alias. TXT and MX records may be set on them.
+// A CNAME record must be the only record of its name.
+func (cfg *Config) validateStaticRecords(v *validator.Validate) []error {
+	scanned := make(map[string]struct{})
+	for _, domainCfg := range cfg.Domains {
+		if domainCfg == nil {
+			continue
+		}
+		for _, name := range append([]string{domainCfg.Domain}, domainCfg.ServedAliases()...) {
+			scanned[dns.CanonicalName(name)] = struct{}{}
+		}
+	}
+	errs := make([]error, 0)
+	// names counts the valid static records of every name, cnames lists the valid CNAME records.
+	names := make(map[string]int)
+	var cnames []int
+	for i, rec := range cfg.StaticRecords {
+		prefix := fmt.Sprintf("static_records[%d]: ", i)
+		if err := v.Struct(rec); err != nil {
+			errs = append(errs, formatValidationErrors(err, prefix))
+			continue
+		}
+		names[dns.CanonicalName(rec.Name)]++
+		if rec.Type == "CNAME" {
+			cnames = append(cnames, i)
+		}
+		if err := validateStaticValue(rec); err != nil {
+			errs = append(errs, fmt.Errorf("%svalue: %w", prefix, err))
+		}
+		if _, ok := scanned[dns.CanonicalName(rec.Name)]; ok && rec.Type != "TXT" && rec.Type != "MX" {
+			errs = append(errs, fmt.Errorf("%s%s records of %s would shadow a scanned domain", prefix, rec.Type, rec.Name))
+		}
+	}
+	for _, i := range cnames {
+		if name := cfg.StaticRecords[i].Name; names[dns.CanonicalName(name)] > 1 {
+			errs = append(errs, fmt.Errorf("static_records[%d]: CNAME record of %s must be the only record of the name", i, name))
+		}
+	}
+	return errs
+}
+
+func validateStaticValue(rec StaticRecord) error {
+	switch rec.Type {
+	case "A":
+		if ip := net.ParseIP(rec.Value); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid IPv4 address %q", rec.Value)
+		}
+	case "AAAA":
+		if ip := net.ParseIP(rec.Value); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid IPv6 address %q", rec.Value)
+		}
+	case "CNAME":
+		if _, ok := dns.IsDomainName(rec.Value); !ok || !dns.IsFqdn(rec.Value) {
+			return fmt.Errorf("must be a valid FQDN (got %q)", rec.Value)
+		}
+	case "MX":
+		if _, _, err := ParseMX(rec.Value); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Validate checks whether the per-domain scan configuration is usable.
@@ -97,7 +507,6 @@ func (sc *ScanConfig) Validate() error {
 	return formatValidationErrors(validatorInstance().Struct(sc), "")
 }
 
-//nolint:gocritic,gocyclo  // not an important function
 func formatValidationErrors(err error, prefix string) error {
 	if err == nil {
 		return nil
@@ -109,42 +518,67 @@ func formatValidationErrors(err error, prefix string) error {
 
 	list := make([]error, 0, len(verrs))
 	for _, verr := range verrs {
-		field := verr.Field()
-		switch field {
-		case "Domains":
-			field = "domains"
-		}
-		switch verr.Tag() {
-		case "required":
-			list = append(list, fmt.Errorf("%s%s: is required", prefix, field))
-		case "min":
-			if field == "domains" {
-				list = append(list, fmt.Errorf("%sdomains: must contain at least one item", prefix))
-				continue
-			}
-			list = append(list, fmt.Errorf("%s%s: must contain at least one item", prefix, field))
-		case "hostport":
-			list = append(list, fmt.Errorf("%slisten: invalid address", prefix))
-		case "fqdn":
-			list = append(list, fmt.Errorf("%sdomain: must be a valid FQDN (got %q)", prefix, verr.Value()))
-		case "cidr":
-			list = append(list, fmt.Errorf("%scidr: invalid CIDR %q", prefix, verr.Value()))
-		case "path":
-			list = append(list, fmt.Errorf("%spath: must start with '/' (got %q)", prefix, verr.Value()))
-		case "gt":
-			list = append(list, fmt.Errorf("%s%s: must be greater than zero", prefix, field))
-		case "gte":
-			list = append(list, fmt.Errorf("%s%s: out of range", prefix, field))
-		case "lte":
-			list = append(list, fmt.Errorf("%s%s: out of range", prefix, field))
-		case "sample_bounds":
-			list = append(list, fmt.Errorf(
-				"%ssample_min: must be less than or equal to sample_max when sample_max > 0",
-				prefix,
-			))
-		default:
-			list = append(list, fmt.Errorf("%s%s: validation failed on %s", prefix, field, verr.Tag()))
-		}
+		list = append(list, formatValidationError(verr, prefix))
 	}
 	return errors.Join(list...)
 }
+
+//nolint:gocritic,gocyclo  // not an important function
+func formatValidationError(verr validator.FieldError, prefix string) error {
+	field := verr.Field()
+	switch field {
+	case "Domains":
+		field = "domains"
+	}
+	switch verr.Tag() {
+	case "required", "min":
+		if field == "domains" {
+			return fmt.Errorf("%sdomains: must contain at least one item", prefix)
+		}
+		if verr.Tag() == "required" {
+			return fmt.Errorf("%s%s: is required", prefix, field)
+		}
+		return fmt.Errorf("%s%s: must contain at least one item", prefix, field)
+	case "hostport":
+		return fmt.Errorf("%s%s: invalid address", prefix, field)
+	case "fqdn":
+		return fmt.Errorf("%s%s: must be a valid FQDN (got %q)", prefix, field, verr.Value())
+	case "ip":
+		return fmt.Errorf("%s%s: invalid IP %q", prefix, field, verr.Value())
+	case "oneof":
+		return fmt.Errorf("%s%s: must be one of [%s] (got %q)", prefix, field, verr.Param(), verr.Value())
+	case "cidr":
+		return fmt.Errorf("%scidr: invalid CIDR %q", prefix, verr.Value())
+	case "iscolor":
+		return fmt.Errorf("%s%s: invalid color %q", prefix, field, verr.Value())
+	case "url":
+		return fmt.Errorf("%s%s: invalid URL %q", prefix, field, verr.Value())
+	case "upstream":
+		return fmt.Errorf("%s%s: invalid upstream %q", prefix, field, verr.Value())
+	case "shard":
+		return fmt.Errorf("%s%s: must be i/N with 0 <= i < N, got %q", prefix, field, verr.Value())
+	case "ciphersuite":
+		return fmt.Errorf("%s%s: unknown cipher suite %q", prefix, field, verr.Value())
+	case "path":
+		return fmt.Errorf("%spath: must start with '/' (got %q)", prefix, verr.Value())
+	case "gt":
+		return fmt.Errorf("%s%s: must be greater than zero", prefix, field)
+	case "gte", "lte":
+		return fmt.Errorf("%s%s: out of range", prefix, field)
+	case "sample_bounds":
+		return fmt.Errorf(
+			"%ssample_min: must be less than or equal to sample_max when sample_max > 0",
+			prefix,
+		)
+	case "wildcard":
+		return fmt.Errorf("%sdomain: a wildcard must be the whole leftmost label (got %q)", prefix, verr.Value())
+	case "alias_wildcard":
+		return fmt.Errorf("%saliases: must not contain wildcards", prefix)
+	case "cname_wildcard":
+		return fmt.Errorf("%salias_mode: cname cannot point to a wildcard domain", prefix)
+	case "required_for_fallback":
+		return fmt.Errorf("%sfallback_ips: is required when paused_response is fallback", prefix)
+	default:
+		return fmt.Errorf("%s%s: validation failed on %s", prefix, field, verr.Tag())
+	}
+}
@@ -0,0 +1,232 @@
+// Package devserver runs a local origin that stands in for a provider edge, so configs and scan
+// programs can be tested end-to-end without probing real ranges.
+package devserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	mrand "math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/fmotalleb/go-tools/log"
+)
+
+const (
+	shutdownTimeout = 5 * time.Second
+	certValidity    = 24 * time.Hour
+	serialBits      = 128
+	// defaultName is the certificate name of TLS clients that send no SNI.
+	defaultName = "localhost"
+)
+
+// Options controls the behavior of the development server.
+type Options struct {
+	// Listen is the TLS listen address, empty disables it.
+	Listen string
+	// HTTPListen is the plain HTTP listen address, empty disables it.
+	HTTPListen string
+	// Status is the status code of every HTTP response.
+	Status int
+	// Latency delays the first read of every connection, before the TLS handshake.
+	Latency time.Duration
+	// FailureRate is the fraction of connections closed right after they are accepted.
+	FailureRate float64
+}
+
+// Server answers every request with the configured status, presenting a self-signed
+// certificate for whatever SNI the client asks for.
+type Server struct {
+	opts  Options
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+}
+
+// New returns a server for opts.
+func New(opts Options) *Server {
+	return &Server{opts: opts, certs: make(map[string]*tls.Certificate)}
+}
+
+// Serve binds the configured addresses and blocks until ctx is done or a listener fails.
+func (s *Server) Serve(ctx context.Context) error {
+	if s.opts.Listen == "" && s.opts.HTTPListen == "" {
+		return errors.New("no listen address configured")
+	}
+	logger := log.Of(ctx)
+	type binding struct {
+		listener net.Listener
+		tls      bool
+	}
+	var bound []binding
+	for _, l := range []struct {
+		addr string
+		tls  bool
+	}{{s.opts.Listen, true}, {s.opts.HTTPListen, false}} {
+		if l.addr == "" {
+			continue
+		}
+		listener, err := new(net.ListenConfig).Listen(ctx, "tcp", l.addr)
+		if err != nil {
+			for _, b := range bound {
+				_ = b.listener.Close()
+			}
+			return err
+		}
+		bound = append(bound, binding{listener, l.tls})
+	}
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	for _, b := range bound {
+		logger.Info("devserver started",
+			zap.String("listen", b.listener.Addr().String()),
+			zap.Bool("tls", b.tls),
+			zap.Int("status", s.opts.Status),
+			zap.Duration("latency", s.opts.Latency),
+			zap.Float64("failure_rate", s.opts.FailureRate),
+		)
+		group.Go(func() error {
+			return s.serve(groupCtx, b.listener, b.tls)
+		})
+	}
+	return group.Wait()
+}
+
+// serve answers connections of l, over TLS when useTLS is set, until ctx is done.
+func (s *Server) serve(ctx context.Context, l net.Listener, useTLS bool) error {
+	logger := log.Of(ctx)
+	server := &http.Server{
+		Handler:           http.HandlerFunc(s.handle),
+		ReadHeaderTimeout: shutdownTimeout,
+		ErrorLog:          zap.NewStdLog(logger),
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Warn("devserver shutdown failed", zap.Error(err))
+		}
+	}()
+
+	var err error
+	l = faultyListener{Listener: l, opts: s.opts}
+	if useTLS {
+		server.TLSConfig = &tls.Config{
+			GetCertificate: s.certificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+		err = server.ServeTLS(l, "", "")
+	} else {
+		err = server.Serve(l)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.TLS != nil {
+		w.Header().Set("X-Served-SNI", r.TLS.ServerName)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(s.opts.Status)
+	_, _ = w.Write([]byte("helios-dns devserver " + strconv.Itoa(s.opts.Status) + "\n"))
+}
+
+// certificate returns a self-signed certificate for the requested server name, minting it on
+// first use.
+func (s *Server) certificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := hello.ServerName
+	if name == "" {
+		name = defaultName
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cert, ok := s.certs[name]; ok {
+		return cert, nil
+	}
+	cert, err := selfSigned(name)
+	if err != nil {
+		return nil, err
+	}
+	s.certs[name] = cert
+	return cert, nil
+}
+
+func selfSigned(name string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), serialBits))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.DNSNames, template.IPAddresses = nil, []net.IP{ip}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// faultyListener drops a share of the accepted connections and delays the others.
+type faultyListener struct {
+	net.Listener
+	opts Options
+}
+
+func (l faultyListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.opts.FailureRate > 0 && mrand.Float64() < l.opts.FailureRate { //nolint:gosec // simulated failures need no cryptographic randomness
+			_ = conn.Close()
+			continue
+		}
+		if l.opts.Latency <= 0 {
+			return conn, nil
+		}
+		return &slowConn{Conn: conn, latency: l.opts.Latency}, nil
+	}
+}
+
+// slowConn delays its first read, which covers both the TLS handshake and plain requests.
+type slowConn struct {
+	net.Conn
+	latency time.Duration
+	once    sync.Once
+}
+
+func (c *slowConn) Read(b []byte) (int, error) {
+	c.once.Do(func() { time.Sleep(c.latency) })
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,83 @@
+package devserver
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func startTestServer(t *testing.T, opts Options, useTLS bool) string {
+	t.Helper()
+
+	l, err := new(net.ListenConfig).Listen(t.Context(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = New(opts).serve(ctx, l, useTLS)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return l.Addr().String()
+}
+
+func TestServeHonorsSNIAndStatus(t *testing.T) {
+	t.Parallel()
+
+	addr := startTestServer(t, Options{Status: http.StatusTeapot, Latency: 50 * time.Millisecond}, true)
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			ServerName:         "edge.example.com",
+			InsecureSkipVerify: true, //nolint:gosec // the devserver certificate is self-signed
+		}},
+	}
+	t.Cleanup(client.CloseIdleConnections)
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "https://"+addr+"/anything", http.NoBody)
+	if err != nil {
+		t.Fatalf("NewRequestWithContext() returned error: %v", err)
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Get() returned error: %v", err)
+	}
+	defer resp.Body.Close()
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("response took %s, want at least the configured latency", elapsed)
+	}
+	if resp.StatusCode != http.StatusTeapot {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusTeapot)
+	}
+	if sni := resp.Header.Get("X-Served-SNI"); sni != "edge.example.com" {
+		t.Fatalf("X-Served-SNI = %q, want edge.example.com", sni)
+	}
+	if names := resp.TLS.PeerCertificates[0].DNSNames; len(names) != 1 || names[0] != "edge.example.com" {
+		t.Fatalf("certificate names = %v, want the requested SNI", names)
+	}
+}
+
+func TestServeFailureRateDropsConnections(t *testing.T) {
+	t.Parallel()
+
+	addr := startTestServer(t, Options{Status: http.StatusOK, FailureRate: 1}, false)
+	client := &http.Client{Timeout: 5 * time.Second}
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "http://"+addr+"/", http.NoBody)
+	if err != nil {
+		t.Fatalf("NewRequestWithContext() returned error: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err == nil {
+		_ = resp.Body.Close()
+		t.Fatal("Get() succeeded, want the connection dropped")
+	}
+}
@@ -0,0 +1,51 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDeadlineListenerBoundsWrites(t *testing.T) {
+	t.Parallel()
+
+	inner, err := new(net.ListenConfig).Listen(t.Context(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	l := deadlineListener{Listener: inner, timeout: 50 * time.Millisecond}
+	t.Cleanup(func() { _ = l.Close() })
+
+	// The client never reads, so the server's socket buffers fill up and writes block.
+	client, err := new(net.Dialer).DialContext(t.Context(), "tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	chunk := make([]byte, 64<<10)
+	done := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := conn.Write(chunk); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("Write() error = %v, want a deadline error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write() to a client that does not read did not time out")
+	}
+}
@@ -4,23 +4,85 @@ package dns
 import (
 	"context"
 	"net"
+	"time"
 
 	"github.com/fmotalleb/go-tools/log"
 	"github.com/miekg/dns"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 )
 
-// Serve starts a UDP DNS server and blocks until it exits.
-func Serve(ctx context.Context, listenAddr string, h dns.Handler) error {
+// Options controls how the DNS listener is started.
+type Options struct {
+	// BindRetry is how long to keep retrying when the address is in use.
+	BindRetry time.Duration
+	// TCP also serves DNS over TCP on the same address.
+	TCP bool
+	// OnReady is called once every listener is bound.
+	OnReady func()
+	// WriteTimeout bounds each write of an answer over TCP, zero disables it.
+	WriteTimeout time.Duration
+	// DrainTimeout is how long queries in flight may take to be answered once the context is
+	// done, zero drops them.
+	DrainTimeout time.Duration
+	// MaxQuestions is the most questions a query may carry, queries with more are rejected with
+	// FORMERR before being parsed. Zero keeps the default of one.
+	MaxQuestions int
+}
+
+// Serve starts a UDP (and optionally TCP) DNS server and blocks until it exits.
+func Serve(ctx context.Context, listenAddr string, h dns.Handler, opts Options) error {
 	logger := log.Of(ctx)
 	listener := new(net.ListenConfig)
-	l, err := listener.ListenPacket(ctx, "udp", listenAddr)
+	pc, err := BindWithRetry(ctx, opts.BindRetry, func() (net.PacketConn, error) {
+		return listener.ListenPacket(ctx, "udp", listenAddr)
+	})
 	if err != nil {
 		logger.Error("failed to start server", zap.Error(err))
 		return err
 	}
-	logger.Info("dns server started", zap.String("listen", listenAddr))
-	if serverErr := dns.ActivateAndServe(nil, l, h); serverErr != nil {
+	accept := acceptQuestions(opts.MaxQuestions)
+	servers := []*dns.Server{{PacketConn: pc, Handler: h, MsgAcceptFunc: accept}}
+	var l net.Listener
+	if opts.TCP {
+		l, err = BindWithRetry(ctx, opts.BindRetry, func() (net.Listener, error) {
+			return listener.Listen(ctx, "tcp", listenAddr)
+		})
+		if err != nil {
+			_ = pc.Close()
+			logger.Error("failed to start tcp server", zap.Error(err))
+			return err
+		}
+		if opts.WriteTimeout > 0 {
+			l = deadlineListener{Listener: l, timeout: opts.WriteTimeout}
+		}
+		servers = append(servers, &dns.Server{Listener: l, Handler: h, MsgAcceptFunc: accept})
+	}
+	logger.Info("dns server started", zap.String("listen", listenAddr), zap.Bool("tcp", opts.TCP))
+	if opts.OnReady != nil {
+		opts.OnReady()
+	}
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	for _, srv := range servers {
+		started, done := make(chan struct{}), make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+		group.Go(func() error {
+			defer close(done)
+			return srv.ActivateAndServe()
+		})
+		go func() {
+			// Stop once the context is done or another server failed.
+			<-groupCtx.Done()
+			select {
+			case <-started:
+			case <-done:
+				return
+			}
+			drain(ctx, srv, opts.DrainTimeout, logger)
+		}()
+	}
+	if serverErr := group.Wait(); serverErr != nil {
 		select {
 		case <-ctx.Done():
 			return nil
@@ -30,3 +92,30 @@ func Serve(ctx context.Context, listenAddr string, h dns.Handler) error {
 	}
 	return nil
 }
+
+// acceptQuestions returns the default accept func of miekg/dns, which rejects any query without
+// exactly one question, allowing up to maxQuestions instead.
+func acceptQuestions(maxQuestions int) dns.MsgAcceptFunc {
+	return func(dh dns.Header) dns.MsgAcceptAction {
+		if dh.Qdcount > 1 && int(dh.Qdcount) <= maxQuestions {
+			dh.Qdcount = 1
+		}
+		return dns.DefaultMsgAcceptFunc(dh)
+	}
+}
+
+// drain stops srv from reading new queries and waits for the ones in flight to be answered,
+// within timeout. A UDP socket is closed once it elapsed, dropping the answers not written yet.
+func drain(ctx context.Context, srv *dns.Server, timeout time.Duration, logger *zap.Logger) {
+	drainCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	start := time.Now()
+	if err := srv.ShutdownContext(drainCtx); err != nil {
+		logger.Warn("dns queries still in flight after the drain timeout",
+			zap.Duration("drain_timeout", timeout),
+			zap.Error(err),
+		)
+		return
+	}
+	logger.Debug("dns queries drained", zap.Duration("duration", time.Since(start)))
+}
@@ -0,0 +1,76 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestServeDrainsQueriesInFlight(t *testing.T) {
+	t.Parallel()
+
+	// Reserve a free port, Serve binds it again.
+	pc, err := new(net.ListenConfig).ListenPacket(t.Context(), "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := pc.LocalAddr().String()
+	_ = pc.Close()
+
+	received := make(chan struct{})
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		close(received)
+		time.Sleep(100 * time.Millisecond)
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		_ = w.WriteMsg(msg)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	ready := make(chan struct{})
+	go func() {
+		served <- Serve(ctx, addr, handler, Options{OnReady: func() { close(ready) }, DrainTimeout: time.Second})
+	}()
+	<-ready
+
+	answered := make(chan error, 1)
+	go func() {
+		query := new(dns.Msg)
+		query.SetQuestion("edge.example.com.", dns.TypeA)
+		_, err := dns.Exchange(query, addr)
+		answered <- err
+	}()
+	<-received
+	cancel()
+
+	if err := <-answered; err != nil {
+		t.Fatalf("Exchange() returned error: %v, want the query in flight answered", err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("Serve() returned error: %v", err)
+	}
+}
+
+func TestAcceptQuestions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		max       int
+		questions uint16
+		want      dns.MsgAcceptAction
+	}{
+		{max: 0, questions: 1, want: dns.MsgAccept},
+		{max: 0, questions: 2, want: dns.MsgReject},
+		{max: 1, questions: 0, want: dns.MsgReject},
+		{max: 3, questions: 3, want: dns.MsgAccept},
+		{max: 3, questions: 4, want: dns.MsgReject},
+	}
+	for _, tt := range tests {
+		if got := acceptQuestions(tt.max)(dns.Header{Qdcount: tt.questions}); got != tt.want {
+			t.Errorf("acceptQuestions(%d) for %d questions = %v, want %v", tt.max, tt.questions, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+
+	"github.com/fmotalleb/go-tools/log"
+	"go.uber.org/zap"
+)
+
+const (
+	initialBindBackoff = 100 * time.Millisecond
+	maxBindBackoff     = 5 * time.Second
+	bindBackoffFactor  = 2
+)
+
+// BindWithRetry calls bind until it succeeds, retrying address conflicts with
+// exponential backoff for up to retryFor. A zero retryFor disables retries.
+func BindWithRetry[T any](ctx context.Context, retryFor time.Duration, bind func() (T, error)) (T, error) {
+	logger := log.Of(ctx)
+	deadline := time.Now().Add(retryFor)
+	backoff := initialBindBackoff
+	for {
+		result, err := bind()
+		if err == nil || !errors.Is(err, syscall.EADDRINUSE) || time.Now().Add(backoff).After(deadline) {
+			return result, err
+		}
+		logger.Warn("address in use, retrying bind", zap.Duration("backoff", backoff), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*bindBackoffFactor, maxBindBackoff)
+	}
+}
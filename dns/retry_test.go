@@ -0,0 +1,59 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBindWithRetry(t *testing.T) {
+	t.Parallel()
+
+	inUse := fmt.Errorf("listen tcp 127.0.0.1:53: %w", syscall.EADDRINUSE)
+	flaky := func(failures int, err error) (func() (int, error), *int) {
+		calls := 0
+		return func() (int, error) {
+			calls++
+			if calls <= failures {
+				return 0, err
+			}
+			return calls, nil
+		}, &calls
+	}
+
+	bind, calls := flaky(2, inUse)
+	if got, err := BindWithRetry(context.Background(), time.Minute, bind); err != nil || got != 3 || *calls != 3 {
+		t.Fatalf("BindWithRetry() = %d, %v after %d attempts, want the third attempt to bind", got, err, *calls)
+	}
+
+	permission := fmt.Errorf("listen tcp 127.0.0.1:53: %w", syscall.EACCES)
+	bind, calls = flaky(1, permission)
+	if _, err := BindWithRetry(context.Background(), time.Minute, bind); !errors.Is(err, syscall.EACCES) || *calls != 1 {
+		t.Fatalf("BindWithRetry() = %v after %d attempts, want other errors returned right away", err, *calls)
+	}
+
+	bind, calls = flaky(1, inUse)
+	if _, err := BindWithRetry(context.Background(), 0, bind); !errors.Is(err, syscall.EADDRINUSE) || *calls != 1 {
+		t.Fatalf("BindWithRetry() = %v after %d attempts, want no retry without retry_for", err, *calls)
+	}
+
+	// Retries stop once the next backoff would pass the deadline.
+	bind, calls = flaky(100, inUse)
+	start := time.Now()
+	if _, err := BindWithRetry(context.Background(), 250*time.Millisecond, bind); !errors.Is(err, syscall.EADDRINUSE) {
+		t.Fatalf("BindWithRetry() = %v, want the conflict once retry_for passed", err)
+	}
+	if elapsed := time.Since(start); *calls != 2 || elapsed > time.Second {
+		t.Fatalf("BindWithRetry() made %d attempts in %s, want 2 within retry_for", *calls, elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bind, _ = flaky(100, inUse)
+	if _, err := BindWithRetry(ctx, time.Minute, bind); !errors.Is(err, context.Canceled) {
+		t.Fatalf("BindWithRetry() = %v, want the cancellation of ctx", err)
+	}
+}
@@ -0,0 +1,201 @@
+// Package dnstap streams the queries and responses of the server in the dnstap format, to a
+// unix socket read by a dnstap collector or to a file.
+package dnstap
+
+import (
+	"context"
+	"net"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/go-tools/log"
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/privacy"
+)
+
+const (
+	// version is reported in the version field of every frame.
+	version = "helios-dns"
+
+	writeTimeout = 5 * time.Second
+	minBackoff   = time.Second
+	maxBackoff   = 30 * time.Second
+	// backoffFactor multiplies the delay between failed connection attempts.
+	backoffFactor = 2
+	// filePerm keeps dnstap files group-readable for collectors.
+	filePerm = 0o640
+)
+
+var droppedFrames = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "helios_dns_dnstap_dropped_total",
+		Help: "Total dnstap frames dropped because the output was not keeping up or not connected.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(droppedFrames)
+}
+
+// Writer queues dnstap frames and writes them from Run, so logging never blocks answering.
+// Frames are dropped while the queue is full. A nil writer logs nothing.
+type Writer struct {
+	cfg        config.DnstapConfig
+	identity   []byte
+	frames     chan []byte
+	anonymizer *privacy.Anonymizer
+}
+
+// New returns a writer for cfg, nil when dnstap is disabled. Client addresses are written as
+// anonymized by anonymizer.
+func New(cfg config.DnstapConfig, anonymizer *privacy.Anonymizer) *Writer {
+	if !cfg.Enabled() {
+		return nil
+	}
+	identity := cfg.Identity
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	return &Writer{
+		cfg:        cfg,
+		identity:   []byte(identity),
+		frames:     make(chan []byte, cfg.Buffer),
+		anonymizer: anonymizer,
+	}
+}
+
+// Wrap returns w logging r as received now, and logging every message written to it as a
+// response to r.
+func (t *Writer) Wrap(w dns.ResponseWriter, r *dns.Msg) dns.ResponseWriter {
+	if t == nil {
+		return w
+	}
+	tw := &responseWriter{ResponseWriter: w, tap: t, received: time.Now()}
+	t.log(event{client: w.RemoteAddr(), server: w.LocalAddr(), query: tw.received, at: tw.received}, r)
+	return tw
+}
+
+// log queues e with msg packed as its message.
+func (t *Writer) log(e event, msg *dns.Msg) {
+	packed, err := msg.Pack()
+	if err != nil {
+		return
+	}
+	e.packed = packed
+	e.client = t.anonymizer.Addr(e.client)
+	select {
+	case t.frames <- e.marshal(t.identity, []byte(version)):
+	default:
+		droppedFrames.Inc()
+	}
+}
+
+// Run writes the queued frames until ctx is done. A lost socket is reconnected with a backoff,
+// frames queued meanwhile are dropped once the queue is full.
+func (t *Writer) Run(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	logger := log.Of(ctx).With(zap.String("component", "dnstap"))
+	if t.cfg.File != "" {
+		// Every run, after a reload or a restart, appends a stream of its own to the file.
+		file, err := os.OpenFile(t.cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, filePerm)
+		if err != nil {
+			return err
+		}
+		fw, err := newFrameWriter(file, false)
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+		t.drain(ctx, fw, logger)
+		if err := fw.Close(); err != nil {
+			logger.Warn("failed to close dnstap file", zap.Error(err))
+		}
+		return nil
+	}
+	backoff := minBackoff
+	for ctx.Err() == nil {
+		fw, err := t.dial(ctx)
+		if err != nil {
+			logger.Warn("failed to connect to dnstap socket", zap.String("socket", t.cfg.Socket), zap.Error(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+			backoff = min(backoffFactor*backoff, maxBackoff)
+			continue
+		}
+		backoff = minBackoff
+		logger.Info("connected to dnstap socket", zap.String("socket", t.cfg.Socket))
+		done := t.drain(ctx, fw, logger)
+		_ = fw.Close()
+		if done {
+			return nil
+		}
+	}
+	return nil
+}
+
+// dial connects to the socket and negotiates the stream.
+func (t *Writer) dial(ctx context.Context) (*frameWriter, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", t.cfg.Socket)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(writeTimeout))
+	fw, err := newFrameWriter(conn, true)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return fw, nil
+}
+
+// drain writes queued frames to fw, flushing whenever the queue is empty. It returns true when
+// ctx is done and false when writing failed.
+func (t *Writer) drain(ctx context.Context, fw *frameWriter, logger *zap.Logger) bool {
+	for {
+		var frame []byte
+		select {
+		case <-ctx.Done():
+			return true
+		case frame = <-t.frames:
+		}
+		err := fw.write(frame)
+		if err == nil && len(t.frames) == 0 {
+			err = fw.Flush()
+		}
+		if err != nil {
+			droppedFrames.Inc()
+			logger.Warn("failed to write dnstap frame", zap.Error(err))
+			return false
+		}
+	}
+}
+
+// responseWriter logs the messages written through it as responses.
+type responseWriter struct {
+	dns.ResponseWriter
+	tap      *Writer
+	received time.Time
+}
+
+func (w *responseWriter) WriteMsg(msg *dns.Msg) error {
+	err := w.ResponseWriter.WriteMsg(msg)
+	w.tap.log(event{
+		response: true,
+		client:   w.RemoteAddr(),
+		server:   w.LocalAddr(),
+		query:    w.received,
+		at:       time.Now(),
+	}, msg)
+	return err
+}
@@ -0,0 +1,259 @@
+package dnstap
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+type fakeResponseWriter struct {
+	dns.ResponseWriter
+	written []*dns.Msg
+}
+
+func (w *fakeResponseWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(192, 0, 2, 7), Port: 5353}
+}
+
+func (w *fakeResponseWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+
+func (w *fakeResponseWriter) WriteMsg(msg *dns.Msg) error {
+	w.written = append(w.written, msg)
+	return nil
+}
+
+func TestWriterFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "queries.dnstap")
+	tap := New(config.DnstapConfig{File: path, Identity: "edge-1", Buffer: 8}, nil)
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() { done <- tap.Run(ctx) }()
+
+	query := new(dns.Msg)
+	query.SetQuestion("edge.example.com.", dns.TypeA)
+	w := tap.Wrap(&fakeResponseWriter{}, query)
+	reply := new(dns.Msg)
+	reply.SetReply(query)
+	if err := w.WriteMsg(reply); err != nil {
+		t.Fatal(err)
+	}
+	waitEmpty(t, tap)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	// A reload runs a new writer, which appends its stream to the file.
+	ctx, cancel = context.WithCancel(t.Context())
+	cancel()
+	if err := New(config.DnstapConfig{File: path, Buffer: 8}, nil).Run(ctx); err != nil {
+		t.Fatalf("Run() after a reload error = %v", err)
+	}
+
+	data, err := os.ReadFile(path) //nolint:gosec // path is in the temp dir of the test
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, controls := readStream(t, bytes.NewReader(data))
+	if want := []uint32{controlStart, controlStop, controlStart, controlStop}; !slices.Equal(controls, want) {
+		t.Fatalf("control frames = %v, want %v", controls, want)
+	}
+	if len(frames) != 2 {
+		t.Fatalf("got %d data frames, want a query and a response", len(frames))
+	}
+	for i, wantType := range []uint64{authQuery, authResponse} {
+		checkMessageFrame(t, i, frames[i], wantType)
+	}
+}
+
+// checkMessageFrame checks that frame i is a MESSAGE of wantType about the query of the client
+// of fakeResponseWriter for edge.example.com.
+func checkMessageFrame(t *testing.T, i int, frame []byte, wantType uint64) {
+	t.Helper()
+	fields := decode(t, frame)
+	if string(fields[dnstapIdentity]) != "edge-1" || fields[dnstapType][0] != typeMessage {
+		t.Fatalf("frame %d = %v, want identity edge-1 of type MESSAGE", i, fields)
+	}
+	msg := decode(t, fields[dnstapMessage])
+	if msg[messageType][0] != byte(wantType) {
+		t.Fatalf("frame %d message type = %d, want %d", i, msg[messageType][0], wantType)
+	}
+	if !net.IP(msg[messageQueryAddress]).Equal(net.IPv4(192, 0, 2, 7)) || msg[messageSocketProtocol][0] != protocolUDP {
+		t.Fatalf("frame %d client = %v over %v, want 192.0.2.7 over UDP", i, msg[messageQueryAddress], msg[messageSocketProtocol])
+	}
+	packed := msg[messageQueryMessage]
+	if wantType == authResponse {
+		packed = msg[messageResponseMessage]
+	}
+	parsed := new(dns.Msg)
+	if err := parsed.Unpack(packed); err != nil || parsed.Question[0].Name != "edge.example.com." || parsed.Response != (wantType == authResponse) {
+		t.Fatalf("frame %d dns message = %v (%v)", i, parsed, err)
+	}
+}
+
+func TestWriterSocketHandshake(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "dnstap.sock")
+	listener, err := new(net.ListenConfig).Listen(t.Context(), "unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	defer listener.Close()
+	tap := New(config.DnstapConfig{Socket: path, Buffer: 8}, nil)
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go func() { _ = tap.Run(ctx) }()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if kind := readControl(t, conn); kind != controlReady {
+		t.Fatalf("first control frame = %#x, want READY", kind)
+	}
+	accept := binary.BigEndian.AppendUint32(nil, 0)
+	accept = binary.BigEndian.AppendUint32(accept, 4)
+	accept = binary.BigEndian.AppendUint32(accept, controlAccept)
+	if _, err := conn.Write(accept); err != nil {
+		t.Fatal(err)
+	}
+	if kind := readControl(t, conn); kind != controlStart {
+		t.Fatalf("second control frame = %#x, want START", kind)
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion("edge.example.com.", dns.TypeA)
+	tap.Wrap(&fakeResponseWriter{}, query)
+	var length [4]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		t.Fatal(err)
+	}
+	frame := make([]byte, binary.BigEndian.Uint32(length[:]))
+	if _, err := io.ReadFull(conn, frame); err != nil {
+		t.Fatal(err)
+	}
+	if fields := decode(t, frame); fields[dnstapMessage] == nil {
+		t.Fatalf("data frame = %v, want a dnstap message", fields)
+	}
+}
+
+func TestNilWriter(t *testing.T) {
+	t.Parallel()
+
+	tap := New(config.DnstapConfig{}, nil)
+	if tap != nil {
+		t.Fatalf("New() = %v, want nil when disabled", tap)
+	}
+	w := &fakeResponseWriter{}
+	if got := tap.Wrap(w, new(dns.Msg)); got != dns.ResponseWriter(w) {
+		t.Fatal("nil writer wrapped the response writer")
+	}
+	if err := tap.Run(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitEmpty(t *testing.T, tap *Writer) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(tap.frames) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("frames were not written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// readStream returns the data frames and the control frame types of a stream.
+func readStream(t *testing.T, r io.Reader) ([][]byte, []uint32) {
+	t.Helper()
+	var frames [][]byte
+	var controls []uint32
+	for {
+		var length [4]byte
+		if _, err := io.ReadFull(r, length[:]); err == io.EOF {
+			return frames, controls
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		n := binary.BigEndian.Uint32(length[:])
+		// A zero length escapes a control frame, which carries its own length.
+		control := n == 0
+		if control {
+			if _, err := io.ReadFull(r, length[:]); err != nil {
+				t.Fatal(err)
+			}
+			n = binary.BigEndian.Uint32(length[:])
+		}
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			t.Fatal(err)
+		}
+		if control {
+			controls = append(controls, binary.BigEndian.Uint32(frame))
+			continue
+		}
+		frames = append(frames, frame)
+	}
+}
+
+func readControl(t *testing.T, r io.Reader) uint32 {
+	t.Helper()
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	frame := make([]byte, binary.BigEndian.Uint32(header[4:]))
+	if _, err := io.ReadFull(r, frame); err != nil {
+		t.Fatal(err)
+	}
+	return binary.BigEndian.Uint32(frame)
+}
+
+// decode returns the fields of a protobuf message, varints encoded as a single byte.
+func decode(t *testing.T, b []byte) map[protowire.Number][]byte {
+	t.Helper()
+	fields := make(map[protowire.Number][]byte)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, m := protowire.ConsumeVarint(b)
+			fields[num], n = []byte{byte(v)}, m
+		case protowire.Fixed32Type:
+			_, n = protowire.ConsumeFixed32(b)
+		case protowire.BytesType:
+			fields[num], n = protowire.ConsumeBytes(b)
+		default:
+			t.Fatalf("unexpected wire type %v", typ)
+		}
+		if n < 0 {
+			t.Fatalf("invalid field %d: %v", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return fields
+}
@@ -0,0 +1,115 @@
+package dnstap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Frame Streams control frames, see https://github.com/farsightsec/fstrm.
+const (
+	controlAccept = 0x01
+	controlStart  = 0x02
+	controlStop   = 0x03
+	controlReady  = 0x04
+	controlFinish = 0x05
+
+	controlFieldContentType = 0x01
+	maxControlFrameLength   = 512
+
+	contentType = "protobuf:dnstap.Dnstap"
+)
+
+// frameWriter writes a Frame Streams stream of dnstap frames. Bidirectional streams, used over
+// sockets, negotiate the content type with the reader before starting.
+type frameWriter struct {
+	w    *bufio.Writer
+	r    io.Reader
+	conn io.Closer
+}
+
+// newFrameWriter starts a stream on conn, which is read for the handshake when bidirectional.
+func newFrameWriter(conn io.ReadWriteCloser, bidirectional bool) (*frameWriter, error) {
+	fw := &frameWriter{w: bufio.NewWriter(conn), conn: conn}
+	if bidirectional {
+		fw.r = conn
+		if err := fw.writeControl(controlReady, true); err != nil {
+			return nil, err
+		}
+		if err := fw.readControl(controlAccept); err != nil {
+			return nil, err
+		}
+	}
+	if err := fw.writeControl(controlStart, true); err != nil {
+		return nil, err
+	}
+	return fw, nil
+}
+
+// write buffers a data frame, Flush sends the buffered frames.
+func (fw *frameWriter) write(frame []byte) error {
+	if err := binary.Write(fw.w, binary.BigEndian, uint32(len(frame))); err != nil { //nolint:gosec // frames hold a DNS message
+		return err
+	}
+	_, err := fw.w.Write(frame)
+	return err
+}
+
+// Flush sends the buffered frames.
+func (fw *frameWriter) Flush() error {
+	return fw.w.Flush()
+}
+
+// Close stops the stream, waiting for the reader to finish on bidirectional streams, and closes
+// the connection.
+func (fw *frameWriter) Close() error {
+	if conn, ok := fw.conn.(net.Conn); ok {
+		_ = conn.SetDeadline(time.Now().Add(writeTimeout))
+	}
+	err := fw.writeControl(controlStop, false)
+	if err == nil && fw.r != nil {
+		err = fw.readControl(controlFinish)
+	}
+	return errors.Join(err, fw.conn.Close())
+}
+
+// writeControl writes and flushes a control frame, carrying the content type when withType is set.
+func (fw *frameWriter) writeControl(kind uint32, withType bool) error {
+	frame := binary.BigEndian.AppendUint32(nil, kind)
+	if withType {
+		frame = binary.BigEndian.AppendUint32(frame, controlFieldContentType)
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(contentType)))
+		frame = append(frame, contentType...)
+	}
+	// A control frame is escaped by a zero data frame length.
+	header := binary.BigEndian.AppendUint32(nil, 0)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(frame))) //nolint:gosec // control frames are a few bytes
+	if _, err := fw.w.Write(append(header, frame...)); err != nil {
+		return err
+	}
+	return fw.w.Flush()
+}
+
+// readControl reads a control frame from the reader and checks it is of the wanted kind.
+func (fw *frameWriter) readControl(want uint32) error {
+	var header [8]byte
+	if _, err := io.ReadFull(fw.r, header[:]); err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint32(header[4:])
+	if binary.BigEndian.Uint32(header[:4]) != 0 || length < 4 || length > maxControlFrameLength {
+		return errors.New("invalid frame streams control frame")
+	}
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(fw.r, frame); err != nil {
+		return err
+	}
+	if kind := binary.BigEndian.Uint32(frame); kind != want {
+		return fmt.Errorf("unexpected frame streams control frame %#x, want %#x", kind, want)
+	}
+	return nil
+}
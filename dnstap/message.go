@@ -0,0 +1,126 @@
+package dnstap
+
+import (
+	"net"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers and enum values of dnstap.proto, see https://dnstap.info.
+const (
+	dnstapIdentity = 1
+	dnstapVersion  = 2
+	dnstapMessage  = 14
+	dnstapType     = 15
+
+	typeMessage = 1
+
+	messageType             = 1
+	messageSocketFamily     = 2
+	messageSocketProtocol   = 3
+	messageQueryAddress     = 4
+	messageResponseAddress  = 5
+	messageQueryPort        = 6
+	messageResponsePort     = 7
+	messageQueryTimeSec     = 8
+	messageQueryTimeNsec    = 9
+	messageQueryMessage     = 10
+	messageResponseTimeSec  = 12
+	messageResponseTimeNsec = 13
+	messageResponseMessage  = 14
+
+	authQuery    = 1
+	authResponse = 2
+
+	familyINET  = 1
+	familyINET6 = 2
+
+	protocolUDP = 1
+	protocolTCP = 2
+)
+
+// event is a query or a response seen by the server.
+type event struct {
+	response bool
+	client   net.Addr
+	server   net.Addr
+	query    time.Time
+	at       time.Time
+	packed   []byte
+}
+
+// marshal encodes e as a dnstap.Dnstap message.
+func (e event) marshal(identity, version []byte) []byte {
+	var msg []byte
+	kind := uint64(authQuery)
+	if e.response {
+		kind = authResponse
+	}
+	msg = appendVarint(msg, messageType, kind)
+	clientIP, clientPort, protocol := addrParts(e.client)
+	serverIP, serverPort, _ := addrParts(e.server)
+	if clientIP != nil {
+		family := uint64(familyINET6)
+		if ip4 := clientIP.To4(); ip4 != nil {
+			family, clientIP = familyINET, ip4
+		}
+		msg = appendVarint(msg, messageSocketFamily, family)
+		msg = appendVarint(msg, messageSocketProtocol, protocol)
+		msg = appendBytes(msg, messageQueryAddress, clientIP)
+		msg = appendVarint(msg, messageQueryPort, clientPort)
+	}
+	if serverIP != nil {
+		if ip4 := serverIP.To4(); ip4 != nil {
+			serverIP = ip4
+		}
+		msg = appendBytes(msg, messageResponseAddress, serverIP)
+		msg = appendVarint(msg, messageResponsePort, serverPort)
+	}
+	msg = appendTime(msg, messageQueryTimeSec, messageQueryTimeNsec, e.query)
+	if e.response {
+		msg = appendTime(msg, messageResponseTimeSec, messageResponseTimeNsec, e.at)
+		msg = appendBytes(msg, messageResponseMessage, e.packed)
+	} else {
+		msg = appendBytes(msg, messageQueryMessage, e.packed)
+	}
+
+	var frame []byte
+	frame = appendBytes(frame, dnstapIdentity, identity)
+	frame = appendBytes(frame, dnstapVersion, version)
+	frame = appendBytes(frame, dnstapMessage, msg)
+	return appendVarint(frame, dnstapType, typeMessage)
+}
+
+// addrParts returns the address, port and dnstap socket protocol of addr.
+func addrParts(addr net.Addr) (net.IP, uint64, uint64) {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP, uint64(addr.Port), protocolUDP //nolint:gosec // ports are 16 bits
+	case *net.TCPAddr:
+		return addr.IP, uint64(addr.Port), protocolTCP //nolint:gosec // ports are 16 bits
+	default:
+		return nil, 0, 0
+	}
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendFixed32(b []byte, num protowire.Number, v uint32) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed32Type)
+	return protowire.AppendFixed32(b, v)
+}
+
+// appendTime appends t as the seconds and nanoseconds fields secNum and nsecNum.
+func appendTime(b []byte, secNum, nsecNum protowire.Number, t time.Time) []byte {
+	b = appendVarint(b, secNum, uint64(t.Unix()))            //nolint:gosec // times after 1970
+	return appendFixed32(b, nsecNum, uint32(t.Nanosecond())) //nolint:gosec // below 1e9
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
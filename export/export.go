@@ -0,0 +1,183 @@
+// Package export appends probe outcomes to CSV or Parquet files for offline analysis.
+package export
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+const (
+	filePrefix = "probes-"
+	dirPerm    = 0o750
+	// filePerm keeps exports group-readable for collectors.
+	filePerm = 0o640
+)
+
+// ErrParquetUnsupported is returned for the parquet format by builds without Parquet support.
+var ErrParquetUnsupported = errors.New("parquet export is not built in, rebuild without the no_parquet and minimal tags")
+
+// Row is the outcome of a single probe.
+type Row struct {
+	Time      time.Time `parquet:"time,timestamp(millisecond)" json:"time"`
+	Domain    string    `parquet:"domain" json:"domain"`
+	IP        string    `parquet:"ip" json:"ip"`
+	Success   bool      `parquet:"success" json:"success"`
+	LatencyMS float64   `parquet:"latency_ms" json:"latency_ms"`
+	Step      string    `parquet:"step,optional" json:"step,omitempty"`
+	Error     string    `parquet:"error,optional" json:"error,omitempty"`
+}
+
+var csvHeader = []string{"time", "domain", "ip", "success", "latency_ms", "step", "error"}
+
+func (r Row) csvRecord() []string {
+	return []string{
+		r.Time.UTC().Format(time.RFC3339Nano),
+		r.Domain,
+		r.IP,
+		strconv.FormatBool(r.Success),
+		strconv.FormatFloat(r.LatencyMS, 'f', 3, 64),
+		r.Step,
+		r.Error,
+	}
+}
+
+// Exporter writes the rows of each update cycle to rotated files, a file is appended to until it
+// is older than rotate_every. Parquet files hold a row group per cycle and are only readable once
+// rotated or closed, as their footer is written last.
+type Exporter struct {
+	cfg config.ExportConfig
+
+	mu     sync.Mutex
+	file   *os.File
+	rows   rowWriter
+	opened time.Time
+}
+
+// rowWriter appends rows to an open export file.
+type rowWriter interface {
+	write(rows []Row) error
+	// close completes the file, without closing it.
+	close() error
+}
+
+// New returns an exporter writing into the configured directory.
+func New(cfg config.ExportConfig) (*Exporter, error) {
+	if cfg.Format == config.ExportParquet && !ParquetSupported {
+		return nil, ErrParquetUnsupported
+	}
+	if err := os.MkdirAll(cfg.Dir, dirPerm); err != nil {
+		return nil, err
+	}
+	return &Exporter{cfg: cfg}, nil
+}
+
+// Write exports the rows of a cycle.
+func (e *Exporter) Write(rows []Row) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	if e.file != nil && now.Sub(e.opened) >= e.cfg.RotateEvery {
+		if err := e.closeFile(); err != nil {
+			return err
+		}
+	}
+	if e.file == nil {
+		f, err := os.OpenFile(e.path(now), os.O_CREATE|os.O_WRONLY|os.O_APPEND, filePerm)
+		if err != nil {
+			return err
+		}
+		e.file, e.opened = f, now
+		if e.cfg.Format == config.ExportParquet {
+			e.rows = newParquetWriter(f)
+		} else {
+			e.rows = newCSVWriter(f)
+		}
+		if err := e.prune(); err != nil {
+			return err
+		}
+	}
+	return e.rows.write(rows)
+}
+
+// csvWriter writes the header before the first rows of a file.
+type csvWriter struct {
+	w       *csv.Writer
+	started bool
+}
+
+func newCSVWriter(f *os.File) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(f)}
+}
+
+func (c *csvWriter) write(rows []Row) error {
+	if !c.started {
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+		c.started = true
+	}
+	for _, row := range rows {
+		if err := c.w.Write(row.csvRecord()); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) close() error {
+	return nil
+}
+
+// Close completes and closes the current file.
+func (e *Exporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.closeFile()
+}
+
+func (e *Exporter) closeFile() error {
+	if e.file == nil {
+		return nil
+	}
+	err := errors.Join(e.rows.close(), e.file.Close())
+	e.file, e.rows = nil, nil
+	return err
+}
+
+func (e *Exporter) path(now time.Time) string {
+	name := fmt.Sprintf("%s%s.%s", filePrefix, now.UTC().Format("20060102T150405.000Z"), e.cfg.Format)
+	return filepath.Join(e.cfg.Dir, name)
+}
+
+// prune removes the oldest export files beyond max_files.
+func (e *Exporter) prune() error {
+	if e.cfg.MaxFiles <= 0 {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(e.cfg.Dir, filePrefix+"*."+e.cfg.Format))
+	if err != nil {
+		return err
+	}
+	// Timestamped names sort chronologically.
+	slices.Sort(files)
+	for len(files) > e.cfg.MaxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
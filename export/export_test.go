@@ -0,0 +1,48 @@
+package export
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func testRows() []Row {
+	return []Row{
+		{Time: time.Now(), Domain: "edge.example.com.", IP: "192.0.2.1", Success: true, LatencyMS: 12.5},
+		{Time: time.Now(), Domain: "edge.example.com.", IP: "192.0.2.2", Step: "program[0]", Error: "timeout"},
+	}
+}
+
+func TestExporterCSVRotation(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	e, err := New(config.ExportConfig{Dir: dir, Format: config.ExportCSV, RotateEvery: time.Nanosecond, MaxFiles: 2})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+	for range 3 {
+		if err = e.Write(testRows()); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "probes-*.csv"))
+	if len(files) != 2 {
+		t.Fatalf("export files = %v, want 2 after pruning", files)
+	}
+	data, err := os.ReadFile(files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || lines[0] != strings.Join(csvHeader, ",") {
+		t.Fatalf("csv = %q, want a header and two rows", lines)
+	}
+}
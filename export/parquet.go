@@ -0,0 +1,33 @@
+//go:build !no_parquet && !minimal
+
+package export
+
+import (
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// ParquetSupported tells whether the parquet format is built in, the no_parquet and minimal
+// build tags leave it out.
+const ParquetSupported = true
+
+// parquetWriter writes the rows of every cycle as a row group.
+type parquetWriter struct {
+	w *parquet.GenericWriter[Row]
+}
+
+func newParquetWriter(out io.Writer) rowWriter {
+	return &parquetWriter{w: parquet.NewGenericWriter[Row](out)}
+}
+
+func (p *parquetWriter) write(rows []Row) error {
+	if _, err := p.w.Write(rows); err != nil {
+		return err
+	}
+	return p.w.Flush()
+}
+
+func (p *parquetWriter) close() error {
+	return p.w.Close()
+}
@@ -0,0 +1,16 @@
+//go:build no_parquet || minimal
+
+package export
+
+import (
+	"io"
+)
+
+// ParquetSupported tells whether the parquet format is built in, the no_parquet and minimal
+// build tags leave it out.
+const ParquetSupported = false
+
+// newParquetWriter is never called, New rejects the parquet format.
+func newParquetWriter(io.Writer) rowWriter {
+	return nil
+}
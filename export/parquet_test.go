@@ -0,0 +1,72 @@
+//go:build !no_parquet && !minimal
+
+package export
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestExporterParquet(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	e, err := New(config.ExportConfig{Dir: dir, Format: config.ExportParquet, RotateEvery: time.Hour})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	for range 2 {
+		if err = e.Write(testRows()); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err = e.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "probes-*.parquet"))
+	if len(files) != 1 {
+		t.Fatalf("export files = %v, want 1 before rotate_every elapsed", files)
+	}
+	rows, err := parquet.ReadFile[Row](files[0])
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if len(rows) != 4 || rows[1].Step != "program[0]" {
+		t.Fatalf("rows = %+v, want the rows of both cycles", rows)
+	}
+}
+
+func TestExporterParquetRotation(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	e, err := New(config.ExportConfig{Dir: dir, Format: config.ExportParquet, RotateEvery: time.Nanosecond, MaxFiles: 2})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+	for range 3 {
+		if err = e.Write(testRows()); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "probes-*.parquet"))
+	if len(files) != 2 {
+		t.Fatalf("export files = %v, want 2 after pruning", files)
+	}
+	// The rotated file was completed when the next one was opened.
+	rows, err := parquet.ReadFile[Row](files[0])
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("rows = %+v, want the rows of a single cycle", rows)
+	}
+}
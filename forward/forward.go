@@ -0,0 +1,135 @@
+// Package forward relays DNS queries to upstream resolvers with health-based failover.
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/go-tools/log"
+	"github.com/miekg/dns"
+)
+
+var errNoUpstream = errors.New("no upstream configured")
+
+// Forwarder sends queries to the first healthy upstream, in configured order.
+type Forwarder struct {
+	upstreams []*upstream
+	// threshold is the count of consecutive failures marking an upstream unhealthy.
+	threshold int32
+}
+
+type upstream struct {
+	addr      string
+	transport exchanger
+	healthy   atomic.Bool
+	// failures counts the failed exchanges since the last success.
+	failures atomic.Int32
+}
+
+// New returns a forwarder for addrs, all upstreams start healthy and are marked unhealthy after
+// threshold consecutive failures, at least one. See parseUpstream for the accepted address formats.
+func New(addrs []string, timeout time.Duration, threshold int) (*Forwarder, error) {
+	f := &Forwarder{
+		upstreams: make([]*upstream, len(addrs)),
+		threshold: int32(min(max(threshold, 1), math.MaxInt32)), //nolint:gosec // clamped to the int32 range
+	}
+	for i, addr := range addrs {
+		transport, err := parseUpstream(addr, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %q: %w", addr, err)
+		}
+		u := &upstream{addr: addr, transport: transport}
+		u.healthy.Store(true)
+		updateUpstreamHealth(addr, true)
+		f.upstreams[i] = u
+	}
+	return f, nil
+}
+
+// Enabled reports whether any upstream is configured.
+func (f *Forwarder) Enabled() bool {
+	return len(f.upstreams) > 0
+}
+
+// Exchange forwards r, trying healthy upstreams first and unhealthy ones as a last resort.
+func (f *Forwarder) Exchange(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	if len(f.upstreams) == 0 {
+		return nil, errNoUpstream
+	}
+	errs := make([]error, 0, len(f.upstreams))
+	for _, wantHealthy := range []bool{true, false} {
+		for _, u := range f.upstreams {
+			if u.healthy.Load() != wantHealthy {
+				continue
+			}
+			resp, err := f.exchange(ctx, u, r)
+			if err == nil {
+				return resp, nil
+			}
+			errs = append(errs, err)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (f *Forwarder) exchange(ctx context.Context, u *upstream, r *dns.Msg) (*dns.Msg, error) {
+	resp, rtt, err := u.transport.Exchange(ctx, r)
+	if err != nil {
+		recordUpstreamError(u.addr)
+		if u.failures.Add(1) >= f.threshold {
+			f.setHealthy(u, false)
+		}
+		return nil, err
+	}
+	recordUpstreamLatency(u.addr, rtt)
+	u.failures.Store(0)
+	f.setHealthy(u, true)
+	return resp, nil
+}
+
+func (f *Forwarder) setHealthy(u *upstream, healthy bool) {
+	if u.healthy.Swap(healthy) != healthy {
+		updateUpstreamHealth(u.addr, healthy)
+	}
+}
+
+// Run health-checks every upstream each interval until ctx is done.
+func (f *Forwarder) Run(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 || len(f.upstreams) == 0 {
+		return nil
+	}
+	logger := log.Of(ctx).Named("forward")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, u := range f.upstreams {
+			wasHealthy := u.healthy.Load()
+			_, err := f.exchange(ctx, u, healthProbe())
+			if healthy := u.healthy.Load(); healthy != wasHealthy {
+				logger.Info("upstream health changed",
+					zap.String("upstream", u.addr),
+					zap.Bool("healthy", healthy),
+					zap.Error(err),
+				)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// healthProbe is the query sent to upstreams during health checks.
+func healthProbe() *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(".", dns.TypeNS)
+	return msg
+}
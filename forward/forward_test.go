@@ -0,0 +1,113 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fakeExchanger answers every query unless down is set, and counts the queries it received.
+type fakeExchanger struct {
+	down    atomic.Bool
+	queries atomic.Int32
+}
+
+func (e *fakeExchanger) Exchange(_ context.Context, r *dns.Msg) (*dns.Msg, time.Duration, error) {
+	e.queries.Add(1)
+	if e.down.Load() {
+		return nil, 0, errors.New("upstream down")
+	}
+	resp := new(dns.Msg)
+	resp.SetReply(r)
+	return resp, time.Millisecond, nil
+}
+
+func newTestForwarder(t *testing.T, threshold int, transports ...*fakeExchanger) *Forwarder {
+	t.Helper()
+
+	addrs := []string{"192.0.2.1:53", "192.0.2.2:53"}[:len(transports)]
+	f, err := New(addrs, time.Second, threshold)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	for i, transport := range transports {
+		f.upstreams[i].transport = transport
+	}
+	return f
+}
+
+func TestExchangeFailsOver(t *testing.T) {
+	t.Parallel()
+
+	primary, secondary := new(fakeExchanger), new(fakeExchanger)
+	f := newTestForwarder(t, 2, primary, secondary)
+	query := new(dns.Msg)
+	query.SetQuestion("example.org.", dns.TypeA)
+
+	primary.down.Store(true)
+	for i := range 2 {
+		if _, err := f.Exchange(context.Background(), query); err != nil {
+			t.Fatalf("Exchange() %d returned error: %v", i, err)
+		}
+	}
+	if primary.queries.Load() != 2 || secondary.queries.Load() != 2 {
+		t.Fatalf("queries = %d and %d, want both upstreams tried until the threshold", primary.queries.Load(), secondary.queries.Load())
+	}
+	if f.upstreams[0].healthy.Load() {
+		t.Fatal("primary is healthy after reaching the failure threshold")
+	}
+	// An unhealthy upstream is skipped while a healthy one answers.
+	if _, err := f.Exchange(context.Background(), query); err != nil {
+		t.Fatalf("Exchange() returned error: %v", err)
+	}
+	if primary.queries.Load() != 2 {
+		t.Fatalf("primary got %d queries, want it skipped once unhealthy", primary.queries.Load())
+	}
+
+	// Unhealthy upstreams are the last resort once every healthy one failed.
+	primary.down.Store(false)
+	secondary.down.Store(true)
+	if _, err := f.Exchange(context.Background(), query); err != nil {
+		t.Fatalf("Exchange() returned error: %v", err)
+	}
+	if !f.upstreams[0].healthy.Load() {
+		t.Fatal("primary is unhealthy after answering")
+	}
+
+	primary.down.Store(true)
+	if _, err := f.Exchange(context.Background(), query); err == nil {
+		t.Fatal("Exchange() returned no error with every upstream down")
+	}
+}
+
+func TestExchangeFailureThreshold(t *testing.T) {
+	t.Parallel()
+
+	upstream := new(fakeExchanger)
+	f := newTestForwarder(t, 3, upstream)
+	query := new(dns.Msg)
+	query.SetQuestion("example.org.", dns.TypeA)
+
+	upstream.down.Store(true)
+	for range 2 {
+		_, _ = f.Exchange(context.Background(), query)
+	}
+	// A success resets the count of consecutive failures.
+	upstream.down.Store(false)
+	_, _ = f.Exchange(context.Background(), query)
+	upstream.down.Store(true)
+	for range 2 {
+		_, _ = f.Exchange(context.Background(), query)
+	}
+	if !f.upstreams[0].healthy.Load() {
+		t.Fatal("upstream is unhealthy below the threshold of consecutive failures")
+	}
+	_, _ = f.Exchange(context.Background(), query)
+	if f.upstreams[0].healthy.Load() {
+		t.Fatal("upstream is healthy after three consecutive failures")
+	}
+}
@@ -0,0 +1,63 @@
+package forward
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Latency buckets start at 1ms and double up to about 2s.
+const (
+	latencyBucketStart  = 0.001
+	latencyBucketFactor = 2
+	latencyBucketCount  = 12
+)
+
+var (
+	upstreamLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "helios_dns_upstream_latency_seconds",
+			Help:    "Round trip time of queries sent to upstream resolvers.",
+			Buckets: prometheus.ExponentialBuckets(latencyBucketStart, latencyBucketFactor, latencyBucketCount),
+		},
+		[]string{"upstream"},
+	)
+	upstreamErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helios_dns_upstream_errors_total",
+			Help: "Total failed queries sent to upstream resolvers.",
+		},
+		[]string{"upstream"},
+	)
+	upstreamHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helios_dns_upstream_healthy",
+			Help: "Whether an upstream resolver is considered healthy (1) or not (0).",
+		},
+		[]string{"upstream"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		upstreamLatency,
+		upstreamErrors,
+		upstreamHealthy,
+	)
+}
+
+func recordUpstreamLatency(upstream string, rtt time.Duration) {
+	upstreamLatency.WithLabelValues(upstream).Observe(rtt.Seconds())
+}
+
+func recordUpstreamError(upstream string) {
+	upstreamErrors.WithLabelValues(upstream).Inc()
+}
+
+func updateUpstreamHealth(upstream string, healthy bool) {
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	upstreamHealthy.WithLabelValues(upstream).Set(value)
+}
@@ -0,0 +1,149 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultDNSPort = "53"
+	defaultDoTPort = "853"
+	dohMediaType   = "application/dns-message"
+)
+
+// exchanger sends a single query to an upstream and returns the answer with its round trip time.
+type exchanger interface {
+	Exchange(ctx context.Context, r *dns.Msg) (*dns.Msg, time.Duration, error)
+}
+
+// parseUpstream builds the transport of an upstream address, which is one of
+// host:port or udp://host:port (UDP, retried over TCP when truncated),
+// tcp://host:port, tls://host:port (DNS over TLS) or an https:// URL (DNS over HTTPS).
+func parseUpstream(addr string, timeout time.Duration) (exchanger, error) {
+	scheme, rest, found := strings.Cut(addr, "://")
+	if !found {
+		scheme, rest = "udp", addr
+	}
+	switch scheme {
+	case "udp":
+		hostPort, err := withDefaultPort(rest, defaultDNSPort)
+		if err != nil {
+			return nil, err
+		}
+		return &dnsExchanger{
+			addr:     hostPort,
+			client:   &dns.Client{Net: "udp", Timeout: timeout},
+			fallback: &dns.Client{Net: "tcp", Timeout: timeout},
+		}, nil
+	case "tcp":
+		hostPort, err := withDefaultPort(rest, defaultDNSPort)
+		if err != nil {
+			return nil, err
+		}
+		return &dnsExchanger{addr: hostPort, client: &dns.Client{Net: "tcp", Timeout: timeout}}, nil
+	case "tls":
+		hostPort, err := withDefaultPort(rest, defaultDoTPort)
+		if err != nil {
+			return nil, err
+		}
+		host, _, _ := net.SplitHostPort(hostPort)
+		return &dnsExchanger{addr: hostPort, client: &dns.Client{
+			Net:     "tcp-tls",
+			Timeout: timeout,
+			TLSConfig: &tls.Config{
+				ServerName: host,
+				MinVersion: tls.VersionTLS12,
+			},
+		}}, nil
+	case "https":
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		if u.Host == "" {
+			return nil, errors.New("missing upstream host")
+		}
+		return &dohExchanger{url: u.String(), client: &http.Client{Timeout: timeout}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", scheme)
+	}
+}
+
+func withDefaultPort(hostPort, port string) (string, error) {
+	if hostPort == "" {
+		return "", errors.New("missing upstream host")
+	}
+	if _, _, err := net.SplitHostPort(hostPort); err == nil {
+		return hostPort, nil
+	}
+	return net.JoinHostPort(strings.Trim(hostPort, "[]"), port), nil
+}
+
+// dnsExchanger speaks plain DNS or DNS over TLS, fallback is used for truncated answers.
+type dnsExchanger struct {
+	addr     string
+	client   *dns.Client
+	fallback *dns.Client
+}
+
+func (e *dnsExchanger) Exchange(ctx context.Context, r *dns.Msg) (*dns.Msg, time.Duration, error) {
+	resp, rtt, err := e.client.ExchangeContext(ctx, r, e.addr)
+	if err == nil && resp.Truncated && e.fallback != nil {
+		return e.fallback.ExchangeContext(ctx, r, e.addr)
+	}
+	return resp, rtt, err
+}
+
+// dohExchanger speaks DNS over HTTPS (RFC 8484) with POST requests.
+type dohExchanger struct {
+	url    string
+	client *http.Client
+}
+
+func (e *dohExchanger) Exchange(ctx context.Context, r *dns.Msg) (*dns.Msg, time.Duration, error) {
+	// The message ID is zeroed to keep responses cacheable, as RFC 8484 recommends.
+	query := r.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+
+	start := time.Now()
+	httpResp, err := e.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("upstream returned HTTP %d", httpResp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, 0, err
+	}
+	rtt := time.Since(start)
+	resp := new(dns.Msg)
+	if err := resp.Unpack(body); err != nil {
+		return nil, 0, err
+	}
+	resp.Id = r.Id
+	return resp, rtt, nil
+}
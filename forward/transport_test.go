@@ -0,0 +1,100 @@
+package forward
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseUpstream(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in      string
+		wantNet string
+		want    string
+	}{
+		{in: "1.1.1.1:53", wantNet: "udp", want: "1.1.1.1:53"},
+		{in: "udp://9.9.9.9", wantNet: "udp", want: "9.9.9.9:53"},
+		{in: "tcp://[2606:4700::1111]", wantNet: "tcp", want: "[2606:4700::1111]:53"},
+		{in: "tls://dns.example.net", wantNet: "tcp-tls", want: "dns.example.net:853"},
+	}
+	for _, tt := range tests {
+		transport, err := parseUpstream(tt.in, time.Second)
+		if err != nil {
+			t.Fatalf("parseUpstream(%q) returned error: %v", tt.in, err)
+		}
+		e, ok := transport.(*dnsExchanger)
+		if !ok {
+			t.Fatalf("parseUpstream(%q) = %T, want *dnsExchanger", tt.in, transport)
+		}
+		if e.client.Net != tt.wantNet || e.addr != tt.want {
+			t.Errorf("parseUpstream(%q) = %s %s, want %s %s", tt.in, e.client.Net, e.addr, tt.wantNet, tt.want)
+		}
+	}
+	if _, err := parseUpstream("quic://dns.example.net", time.Second); err == nil {
+		t.Error("parseUpstream(quic://) expected error, got nil")
+	}
+}
+
+func TestDoHExchange(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != dohMediaType {
+			http.Error(w, "bad content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		query := new(dns.Msg)
+		if err := query.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := new(dns.Msg)
+		resp.SetReply(query)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, 1),
+		})
+		packed, _ := resp.Pack()
+		w.Header().Set("Content-Type", dohMediaType)
+		_, _ = w.Write(packed)
+	}))
+	// The handshake rejected below is logged otherwise.
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	f, err := New([]string{server.URL + "/dns-query"}, time.Second, 1)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	doh := f.upstreams[0].transport.(*dohExchanger)
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.org.", dns.TypeA)
+	if _, _, err = doh.Exchange(context.Background(), query); err == nil {
+		t.Fatal("Exchange() with an untrusted certificate expected error, got nil")
+	}
+	// The client of the test server trusts its certificate.
+	doh.client.Transport = server.Client().Transport
+
+	resp, err := f.Exchange(context.Background(), query)
+	if err != nil {
+		t.Fatalf("Exchange() returned error: %v", err)
+	}
+	if resp.Id != query.Id {
+		t.Errorf("response id = %d, want %d", resp.Id, query.Id)
+	}
+	if len(resp.Answer) != 1 {
+		t.Fatalf("answers = %v, want a single A record", resp.Answer)
+	}
+}
@@ -0,0 +1,39 @@
+package heliostest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+
+	"github.com/fmotalleb/helios-dns/check"
+)
+
+// errRejected fails the candidates rejected by Accept.
+var errRejected = errors.New("rejected by the test")
+
+// CheckFunc is a [check.Checker] calling itself.
+type CheckFunc func(ctx context.Context, ip net.IP) error
+
+// Check implements [check.Checker].
+func (f CheckFunc) Check(ctx context.Context, ip net.IP) error {
+	return f(ctx, ip)
+}
+
+func (CheckFunc) String() string {
+	return "heliostest"
+}
+
+// Accept returns a check passing the candidates listed in ips and failing the others.
+func Accept(ips ...string) check.Checker {
+	accepted := make([]net.IP, len(ips))
+	for i, raw := range ips {
+		accepted[i] = net.ParseIP(raw)
+	}
+	return CheckFunc(func(_ context.Context, ip net.IP) error {
+		if slices.ContainsFunc(accepted, ip.Equal) {
+			return nil
+		}
+		return errRejected
+	})
+}
@@ -0,0 +1,177 @@
+// Package heliostest runs helios-dns in-process for integration tests of configs and of programs
+// embedding it. Candidates are probed by fake checks instead of the network, and the records
+// found are served on a local DNS listener.
+package heliostest
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/check"
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/internal/testhook"
+	"github.com/fmotalleb/helios-dns/server"
+)
+
+// queryTimeout bounds the queries of Query and Exchange.
+const (
+	queryTimeout = 2 * time.Second
+	// Defaults of Args.
+	defaultWorkers   = 8
+	defaultPort      = 443
+	defaultSampleMax = 8
+	configPerm       = 0o600
+)
+
+// Options configures a test server.
+type Options struct {
+	// Checks replace the program and native checks of every domain, every candidate passes
+	// without checks.
+	Checks []check.Checker
+	// Store holds the records, a new memory store when nil.
+	Store server.RecordStore
+	// Logger defaults to a no-op logger.
+	Logger *zap.Logger
+}
+
+// Server is a handler serving a config on a local DNS listener, over UDP and TCP on the same
+// port. It is closed by the cleanup of the test that started it.
+type Server struct {
+	// Addr is the address of the DNS listener.
+	Addr    string
+	Handler *server.Handler
+	Config  config.Config
+}
+
+// Args returns the defaults of the CLI flags used by ParseConfig. Candidates are sampled from
+// 192.0.2.0/24 with every address picked, up to 8 per CIDR.
+func Args() map[string]any {
+	return map[string]any{
+		"args": map[string]any{
+			"listen":        "127.0.0.1:0",
+			"http_listen":   "",
+			"max_workers":   defaultWorkers,
+			"interval":      time.Minute.String(),
+			"cidrs":         []string{"192.0.2.0/24"},
+			"sni":           "",
+			"path":          "/",
+			"timeout":       time.Second.Nanoseconds(),
+			"port":          defaultPort,
+			"status_code":   0,
+			"sample_min":    0,
+			"sample_max":    defaultSampleMax,
+			"sample_chance": 1.0,
+			"http_only":     false,
+		},
+	}
+}
+
+// ParseConfig parses and validates the YAML config body with the defaults of Args.
+func ParseConfig(tb testing.TB, body string) config.Config {
+	tb.Helper()
+
+	path := filepath.Join(tb.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), configPerm); err != nil {
+		tb.Fatal(err)
+	}
+	var cfg config.Config
+	if err := config.Parse(context.Background(), &cfg, path, Args()); err != nil {
+		tb.Fatalf("parse config: %v", err)
+	}
+	return cfg
+}
+
+// Start builds the handler of cfg and serves it. Nothing is scanned until Scan is called.
+func Start(tb testing.TB, cfg config.Config, opts Options) *Server {
+	tb.Helper()
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	handler, err := server.NewHandler(cfg, logger, opts.Store)
+	if err != nil {
+		tb.Fatalf("build handler: %v", err)
+	}
+	checks := opts.Checks
+	if len(checks) == 0 {
+		checks = []check.Checker{CheckFunc(func(context.Context, net.IP) error { return nil })}
+	}
+	if err = testhook.UseChecks(handler, checks); err != nil {
+		tb.Fatalf("use checks: %v", err)
+	}
+
+	pc, err := new(net.ListenConfig).ListenPacket(context.Background(), "udp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	l, err := new(net.ListenConfig).Listen(context.Background(), "tcp", pc.LocalAddr().String())
+	if err != nil {
+		_ = pc.Close()
+		tb.Fatal(err)
+	}
+	for _, srv := range []*dns.Server{
+		{PacketConn: pc, Handler: handler},
+		{Listener: l, Handler: handler},
+	} {
+		started := make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+		go func() { _ = srv.ActivateAndServe() }()
+		<-started
+		tb.Cleanup(func() { _ = srv.Shutdown() })
+	}
+	return &Server{Addr: pc.LocalAddr().String(), Handler: handler, Config: cfg}
+}
+
+// Scan runs one update cycle, probing the candidates of every domain with the checks of the
+// server, and publishes the records found.
+func (s *Server) Scan(tb testing.TB) {
+	tb.Helper()
+
+	if err := s.Handler.Scan(tb.Context(), s.Config); err != nil {
+		tb.Fatalf("scan: %v", err)
+	}
+}
+
+// Query asks the server for the qtype records of name over UDP.
+func (s *Server) Query(tb testing.TB, name string, qtype uint16) *dns.Msg {
+	tb.Helper()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	return s.Exchange(tb, msg, "udp")
+}
+
+// Exchange sends msg to the server over network, udp or tcp, and returns the response.
+func (s *Server) Exchange(tb testing.TB, msg *dns.Msg, network string) *dns.Msg {
+	tb.Helper()
+
+	client := &dns.Client{Net: network, Timeout: queryTimeout}
+	resp, _, err := client.ExchangeContext(tb.Context(), msg, s.Addr)
+	if err != nil {
+		tb.Fatalf("exchange %s: %v", msg.Question[0].Name, err)
+	}
+	return resp
+}
+
+// AnswerIPs returns the addresses of the A and AAAA records answering msg, in order.
+func AnswerIPs(msg *dns.Msg) []net.IP {
+	var ips []net.IP
+	for _, rr := range msg.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			ips = append(ips, rr.A)
+		case *dns.AAAA:
+			ips = append(ips, rr.AAAA)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,40 @@
+package heliostest_test
+
+import (
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/check"
+	"github.com/fmotalleb/helios-dns/heliostest"
+)
+
+func TestServerAnswersScannedRecords(t *testing.T) {
+	t.Parallel()
+
+	cfg := heliostest.ParseConfig(t, `
+domains:
+  - domain: "edge.example.com."
+    cidr: ["192.0.2.0/29"]
+`)
+	srv := heliostest.Start(t, cfg, heliostest.Options{
+		Checks: []check.Checker{heliostest.Accept("192.0.2.3", "198.51.100.1")},
+	})
+
+	if resp := srv.Query(t, "edge.example.com", dns.TypeA); len(resp.Answer) != 0 {
+		t.Fatalf("answers before the scan = %v, want none", resp.Answer)
+	}
+	srv.Scan(t)
+	resp := srv.Query(t, "edge.example.com", dns.TypeA)
+	want := []net.IP{net.ParseIP("192.0.2.3")}
+	if ips := heliostest.AnswerIPs(resp); !slices.EqualFunc(ips, want, net.IP.Equal) {
+		t.Fatalf("answers = %v, want %v", ips, want)
+	}
+	tcp := new(dns.Msg)
+	tcp.SetQuestion("edge.example.com.", dns.TypeA)
+	if resp := srv.Exchange(t, tcp, "tcp"); len(resp.Answer) != 1 {
+		t.Fatalf("answers over tcp = %v, want the scanned record", resp.Answer)
+	}
+}
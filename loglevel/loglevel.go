@@ -0,0 +1,97 @@
+// Package loglevel switches the level of a running logger, so debug logs can be turned on for a
+// live instance without a restart.
+package loglevel
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type contextKey struct{}
+
+// Switch filters the entries of a logger by a level that can change at runtime.
+type Switch struct {
+	level zap.AtomicLevel
+}
+
+// New returns a switch starting at initial.
+func New(initial zapcore.Level) *Switch {
+	return &Switch{level: zap.NewAtomicLevelAt(initial)}
+}
+
+// Wrap returns logger filtered by the level of s. Entries below the level logger was built with
+// are dropped whatever the switch says, so logger should be built at debug level.
+func (s *Switch) Wrap(logger *zap.Logger) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return levelCore{Core: core, level: s.level}
+	}))
+}
+
+// Level returns the current level.
+func (s *Switch) Level() zapcore.Level {
+	return s.level.Level()
+}
+
+// SetLevel changes the level of every logger wrapped by s.
+func (s *Switch) SetLevel(level zapcore.Level) {
+	s.level.SetLevel(level)
+}
+
+// Toggle flips between debug and info, any other level switches to debug. It returns the new level.
+func (s *Switch) Toggle() zapcore.Level {
+	next := zapcore.DebugLevel
+	if s.Level() == zapcore.DebugLevel {
+		next = zapcore.InfoLevel
+	}
+	s.SetLevel(next)
+	return next
+}
+
+// Parse returns the level named by text, such as debug or info.
+func Parse(text string) (zapcore.Level, error) {
+	level, err := zapcore.ParseLevel(text)
+	if err != nil {
+		return level, fmt.Errorf("invalid log level %q", text)
+	}
+	return level, nil
+}
+
+// WithSwitch attaches s to ctx.
+func WithSwitch(ctx context.Context, s *Switch) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the switch attached to ctx, or nil.
+func FromContext(ctx context.Context) *Switch {
+	s, _ := ctx.Value(contextKey{}).(*Switch)
+	return s
+}
+
+// levelCore drops the entries below level before they reach Core.
+type levelCore struct {
+	zapcore.Core
+	level zap.AtomicLevel
+}
+
+func (c levelCore) Enabled(level zapcore.Level) bool {
+	return c.level.Enabled(level) && c.Core.Enabled(level)
+}
+
+func (c levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(entry.Level) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
+
+// Level reports the effective level to [zapcore.LevelOf].
+func (c levelCore) Level() zapcore.Level {
+	return max(c.level.Level(), zapcore.LevelOf(c.Core))
+}
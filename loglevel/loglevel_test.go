@@ -0,0 +1,58 @@
+package loglevel
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSwitchFiltersByLevel(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	levels := New(zapcore.InfoLevel)
+	logger := levels.Wrap(zap.New(core)).With(zap.String("component", "test"))
+
+	logger.Debug("hidden")
+	logger.Info("shown")
+	if got := levels.Toggle(); got != zapcore.DebugLevel {
+		t.Fatalf("Toggle() = %s, want debug", got)
+	}
+	logger.Debug("shown after toggle")
+	if zapcore.LevelOf(logger.Core()) != zapcore.DebugLevel {
+		t.Fatalf("LevelOf() = %s, want debug", zapcore.LevelOf(logger.Core()))
+	}
+	if got := levels.Toggle(); got != zapcore.InfoLevel {
+		t.Fatalf("Toggle() = %s, want info", got)
+	}
+	logger.Debug("hidden again")
+
+	var messages []string
+	for _, entry := range logs.All() {
+		messages = append(messages, entry.Message)
+		if entry.ContextMap()["component"] != "test" {
+			t.Fatalf("entry %q lost the fields of With", entry.Message)
+		}
+	}
+	if len(messages) != 2 || messages[0] != "shown" || messages[1] != "shown after toggle" {
+		t.Fatalf("logged %v, want only the entries enabled at the time", messages)
+	}
+}
+
+func TestSwitchContext(t *testing.T) {
+	t.Parallel()
+
+	if FromContext(context.Background()) != nil {
+		t.Fatal("FromContext() without a switch is not nil")
+	}
+	levels := New(zapcore.InfoLevel)
+	if FromContext(WithSwitch(context.Background(), levels)) != levels {
+		t.Fatal("FromContext() did not return the attached switch")
+	}
+	if _, err := Parse("loud"); err == nil {
+		t.Fatal("Parse(loud) returned no error")
+	}
+}
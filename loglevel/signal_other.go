@@ -0,0 +1,12 @@
+//go:build !unix
+
+package loglevel
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// ToggleOnSignal does nothing, there is no SIGUSR2 on this platform. Use the HTTP API instead.
+func (s *Switch) ToggleOnSignal(context.Context, *zap.Logger) {}
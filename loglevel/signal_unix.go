@@ -0,0 +1,29 @@
+//go:build unix
+
+package loglevel
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// ToggleOnSignal toggles s between debug and info on every SIGUSR2 until ctx is done.
+func (s *Switch) ToggleOnSignal(ctx context.Context, logger *zap.Logger) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sig:
+				logger.Warn("log level changed by signal", zap.Stringer("level", s.Toggle()))
+			}
+		}
+	}()
+}
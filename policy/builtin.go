@@ -0,0 +1,96 @@
+package policy
+
+import (
+	"math/rand/v2"
+	"slices"
+	"sync/atomic"
+)
+
+// Names of the built-in policies.
+const (
+	All        = "all"
+	RoundRobin = "round_robin"
+	Latency    = "latency"
+	RandomN    = "random_n"
+)
+
+func init() {
+	Register(All, func(opts Options) (AnswerPolicy, error) {
+		return allPolicy{count: opts.Count}, nil
+	})
+	Register(RoundRobin, func(opts Options) (AnswerPolicy, error) {
+		return &roundRobinPolicy{count: opts.Count}, nil
+	})
+	Register(Latency, func(opts Options) (AnswerPolicy, error) {
+		return latencyPolicy{count: opts.Count}, nil
+	})
+	Register(RandomN, func(opts Options) (AnswerPolicy, error) {
+		return randomPolicy{count: opts.Count}, nil
+	})
+}
+
+// allPolicy serves candidates in stored order.
+type allPolicy struct {
+	count int
+}
+
+func (p allPolicy) Select(_ Query, candidates []Candidate) []Candidate {
+	return truncate(candidates, p.count)
+}
+
+// roundRobinPolicy rotates the first candidate on every answer.
+type roundRobinPolicy struct {
+	count int
+	next  atomic.Uint64
+}
+
+func (p *roundRobinPolicy) Select(_ Query, candidates []Candidate) []Candidate {
+	if len(candidates) == 0 {
+		return candidates
+	}
+	start := int((p.next.Add(1) - 1) % uint64(len(candidates))) //nolint:gosec // less than len(candidates)
+	rotated := make([]Candidate, 0, len(candidates))
+	rotated = append(rotated, candidates[start:]...)
+	rotated = append(rotated, candidates[:start]...)
+	return truncate(rotated, p.count)
+}
+
+// latencyPolicy serves the fastest candidates first.
+type latencyPolicy struct {
+	count int
+}
+
+func (p latencyPolicy) Select(_ Query, candidates []Candidate) []Candidate {
+	sorted := slices.Clone(candidates)
+	slices.SortStableFunc(sorted, compareLatency)
+	return truncate(sorted, p.count)
+}
+
+// compareLatency orders by latency, candidates without a measured latency last.
+func compareLatency(a, b Candidate) int {
+	switch {
+	case a.Latency == b.Latency:
+		return 0
+	case a.Latency == 0:
+		return 1
+	case b.Latency == 0:
+		return -1
+	case a.Latency < b.Latency:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// randomPolicy serves count random candidates.
+type randomPolicy struct {
+	count int
+}
+
+func (p randomPolicy) Select(_ Query, candidates []Candidate) []Candidate {
+	shuffled := slices.Clone(candidates)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return truncate(shuffled, p.count)
+}
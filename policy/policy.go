@@ -0,0 +1,85 @@
+// Package policy selects and orders the records served in an answer.
+//
+// Every domain uses one AnswerPolicy, chosen by name through its answer_policy setting.
+// Embedders can add policies by calling Register before the server starts.
+package policy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Candidate is a record that may be served.
+type Candidate struct {
+	IP         net.IP
+	Latency    time.Duration
+	Confidence float64
+}
+
+// Query describes the question being answered.
+type Query struct {
+	Name   string
+	Qtype  uint16
+	Client net.IP
+}
+
+// AnswerPolicy selects the records of an answer from the servable candidates, in answer order.
+// Implementations must be safe for concurrent use and must not modify candidates in place.
+type AnswerPolicy interface {
+	Select(q Query, candidates []Candidate) []Candidate
+}
+
+// Options are the per-domain settings passed to a policy factory.
+type Options struct {
+	// Count is the maximum number of records to return, zero means all.
+	Count int
+}
+
+// Factory builds a policy for a single domain.
+type Factory func(opts Options) (AnswerPolicy, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a policy available under name, replacing any policy registered with that name.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+// New builds the policy registered under name.
+func New(name string, opts Options) (AnswerPolicy, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown answer policy %q, registered: %v", name, Names())
+	}
+	return factory(opts)
+}
+
+// Names returns the registered policy names in sorted order.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// truncate returns at most count candidates, zero means all.
+func truncate(candidates []Candidate, count int) []Candidate {
+	if count > 0 && len(candidates) > count {
+		return candidates[:count]
+	}
+	return candidates
+}
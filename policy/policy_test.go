@@ -0,0 +1,57 @@
+package policy
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func candidates() []Candidate {
+	return []Candidate{
+		{IP: net.IPv4(192, 0, 2, 1), Latency: 30 * time.Millisecond},
+		{IP: net.IPv4(192, 0, 2, 2)},
+		{IP: net.IPv4(192, 0, 2, 3), Latency: 10 * time.Millisecond},
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	t.Parallel()
+
+	latency, err := New(Latency, Options{Count: 2})
+	if err != nil {
+		t.Fatalf("New(latency) error = %v", err)
+	}
+	got := latency.Select(Query{}, candidates())
+	if len(got) != 2 || !got[0].IP.Equal(net.IPv4(192, 0, 2, 3)) || !got[1].IP.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Errorf("latency.Select() = %v, want fastest first and unmeasured last", got)
+	}
+
+	rr, err := New(RoundRobin, Options{})
+	if err != nil {
+		t.Fatalf("New(round_robin) error = %v", err)
+	}
+	first := rr.Select(Query{}, candidates())[0]
+	second := rr.Select(Query{}, candidates())[0]
+	if first.IP.Equal(second.IP) {
+		t.Errorf("round_robin served %s first twice", first.IP)
+	}
+
+	// The counter wraps around without ever yielding a negative start.
+	rr.(*roundRobinPolicy).next.Store(math.MaxUint64)
+	if got := rr.Select(Query{}, candidates()); !got[0].IP.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Errorf("round_robin.Select() at the counter limit = %v, want the first candidate first", got)
+	}
+
+	random, err := New(RandomN, Options{Count: 1})
+	if err != nil {
+		t.Fatalf("New(random_n) error = %v", err)
+	}
+	if got := random.Select(Query{}, candidates()); len(got) != 1 {
+		t.Errorf("random_n.Select() = %v, want a single candidate", got)
+	}
+
+	if _, err := New("unknown", Options{}); err == nil {
+		t.Error("New(unknown) error = nil")
+	}
+}
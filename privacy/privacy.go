@@ -0,0 +1,104 @@
+// Package privacy anonymizes client addresses before they are logged or exposed, for operators
+// who may not keep them.
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+const (
+	// modeHash replaces addresses with their HMAC, the other mode truncates them.
+	modeHash = "hash"
+
+	ipv4Bits = 8 * net.IPv4len
+	ipv6Bits = 8 * net.IPv6len
+)
+
+// Anonymizer replaces client addresses with truncated or hashed ones. A nil anonymizer returns
+// addresses as they are.
+type Anonymizer struct {
+	hash       bool
+	ipv4Prefix int
+	ipv6Prefix int
+	key        []byte
+}
+
+// New returns an anonymizer for cfg, nil when anonymization is disabled.
+func New(cfg config.PrivacyConfig) *Anonymizer {
+	if cfg.Mode == "" {
+		return nil
+	}
+	return &Anonymizer{
+		hash:       cfg.Mode == modeHash,
+		ipv4Prefix: cfg.IPv4Prefix,
+		ipv6Prefix: cfg.IPv6Prefix,
+		key:        []byte(cfg.Key),
+	}
+}
+
+// IP returns the anonymized ip, of the same family. A hashed address is the leading bytes of its
+// HMAC, the same address always hashes to the same one.
+func (a *Anonymizer) IP(ip net.IP) net.IP {
+	if a == nil || ip == nil {
+		return ip
+	}
+	prefix, bits := a.ipv6Prefix, ipv6Bits
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, prefix, bits = ip4, a.ipv4Prefix, ipv4Bits
+	}
+	if a.hash {
+		return net.IP(a.sum(ip)[:len(ip)])
+	}
+	return ip.Mask(net.CIDRMask(prefix, bits))
+}
+
+// Addr returns addr with its address anonymized, the port and the network are kept.
+func (a *Anonymizer) Addr(addr net.Addr) net.Addr {
+	if a == nil {
+		return addr
+	}
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return &net.UDPAddr{IP: a.IP(addr.IP), Port: addr.Port}
+	case *net.TCPAddr:
+		return &net.TCPAddr{IP: a.IP(addr.IP), Port: addr.Port}
+	default:
+		return addr
+	}
+}
+
+// Subnet returns the subnet of ip with ipv4Prefix or ipv6Prefix bits, empty when ip is nil. It is
+// truncated further when the anonymizer truncates to fewer bits, and replaced with the hex of its
+// HMAC when the anonymizer hashes.
+func (a *Anonymizer) Subnet(ip net.IP, ipv4Prefix, ipv6Prefix int) string {
+	if ip == nil {
+		return ""
+	}
+	prefix, bits := ipv6Prefix, ipv6Bits
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, prefix, bits = ip4, ipv4Prefix, ipv4Bits
+	}
+	if a != nil && !a.hash {
+		if bits == ipv4Bits {
+			prefix = min(prefix, a.ipv4Prefix)
+		} else {
+			prefix = min(prefix, a.ipv6Prefix)
+		}
+	}
+	subnet := (&net.IPNet{IP: ip.Mask(net.CIDRMask(prefix, bits)), Mask: net.CIDRMask(prefix, bits)}).String()
+	if a != nil && a.hash {
+		return hex.EncodeToString(a.sum([]byte(subnet))[:8])
+	}
+	return subnet
+}
+
+func (a *Anonymizer) sum(data []byte) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
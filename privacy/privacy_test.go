@@ -0,0 +1,63 @@
+package privacy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestAnonymizerTruncates(t *testing.T) {
+	t.Parallel()
+
+	a := New(config.PrivacyConfig{Mode: "truncate", IPv4Prefix: 24, IPv6Prefix: 48})
+	addr := a.Addr(&net.UDPAddr{IP: net.ParseIP("192.0.2.77"), Port: 5353}).(*net.UDPAddr)
+	if !addr.IP.Equal(net.ParseIP("192.0.2.0")) || addr.Port != 5353 {
+		t.Fatalf("Addr() = %v, want 192.0.2.0:5353", addr)
+	}
+	if ip := a.IP(net.ParseIP("2001:db8:1:2::1")); !ip.Equal(net.ParseIP("2001:db8:1::")) {
+		t.Fatalf("IP() = %v, want 2001:db8:1::", ip)
+	}
+	// The anonymizer truncating to fewer bits wins over the subnet asked for.
+	wide := New(config.PrivacyConfig{Mode: "truncate", IPv4Prefix: 16, IPv6Prefix: 48})
+	if subnet := wide.Subnet(net.ParseIP("192.0.2.77"), 24, 48); subnet != "192.0.0.0/16" {
+		t.Fatalf("Subnet() = %q, want 192.0.0.0/16", subnet)
+	}
+}
+
+func TestAnonymizerHashes(t *testing.T) {
+	t.Parallel()
+
+	a := New(config.PrivacyConfig{Mode: "hash", IPv4Prefix: 24, IPv6Prefix: 48, Key: "secret"})
+	client := net.ParseIP("192.0.2.77")
+	hashed := a.IP(client)
+	if len(hashed) != net.IPv4len || hashed.Equal(client) {
+		t.Fatalf("IP() = %v, want a different IPv4 address", hashed)
+	}
+	if again := a.IP(net.ParseIP("192.0.2.77")); !again.Equal(hashed) {
+		t.Fatalf("IP() = %v then %v, want the same address hashed alike", hashed, again)
+	}
+	other := New(config.PrivacyConfig{Mode: "hash", Key: "other"})
+	if ip := other.IP(client); ip.Equal(hashed) {
+		t.Fatalf("IP() = %v with both keys, want the hash to depend on the key", ip)
+	}
+	if subnet := a.Subnet(client, 24, 48); subnet != a.Subnet(net.ParseIP("192.0.2.1"), 24, 48) || len(subnet) != 16 {
+		t.Fatalf("Subnet() = %q, want the same hash for the addresses of a subnet", subnet)
+	}
+}
+
+func TestNilAnonymizer(t *testing.T) {
+	t.Parallel()
+
+	var a *Anonymizer
+	if a != New(config.PrivacyConfig{}) {
+		t.Fatal("New() returned an anonymizer without a mode")
+	}
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.77"), Port: 53}
+	if got := a.Addr(addr); got != addr {
+		t.Fatalf("Addr() = %v, want %v", got, addr)
+	}
+	if subnet := a.Subnet(addr.IP, 24, 48); subnet != "192.0.2.0/24" {
+		t.Fatalf("Subnet() = %q, want 192.0.2.0/24", subnet)
+	}
+}
@@ -0,0 +1,106 @@
+// Package querylog writes a structured line per answered query to a rotated file, apart from the
+// application log.
+package querylog
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/privacy"
+)
+
+// flushInterval bounds how long entries stay buffered before they reach the file.
+const (
+	flushInterval = time.Second
+	megabyte      = 1 << 20
+)
+
+// Logger logs the answers written through the response writers it wraps. A nil logger logs nothing.
+type Logger struct {
+	logger     *zap.Logger
+	buffer     *zapcore.BufferedWriteSyncer
+	file       *rotatingFile
+	anonymizer *privacy.Anonymizer
+}
+
+// New opens the query log file of cfg, client addresses are logged as anonymized by anonymizer.
+func New(cfg config.QueryLogConfig, anonymizer *privacy.Anonymizer) (*Logger, error) {
+	file, err := openRotatingFile(cfg.File, int64(cfg.MaxSizeMB)*megabyte, cfg.RotateEvery, cfg.MaxFiles)
+	if err != nil {
+		return nil, err
+	}
+	encoder := zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+		TimeKey:    "time",
+		EncodeTime: zapcore.RFC3339NanoTimeEncoder,
+	})
+	buffer := &zapcore.BufferedWriteSyncer{WS: file, FlushInterval: flushInterval}
+	return &Logger{
+		logger:     zap.New(zapcore.NewCore(encoder, buffer, zapcore.InfoLevel)),
+		buffer:     buffer,
+		file:       file,
+		anonymizer: anonymizer,
+	}, nil
+}
+
+// Wrap returns w logging the answers written to it, timed from now.
+func (l *Logger) Wrap(w dns.ResponseWriter) dns.ResponseWriter {
+	if l == nil {
+		return w
+	}
+	return &responseWriter{ResponseWriter: w, log: l, received: time.Now()}
+}
+
+// Close flushes the buffered entries and closes the file.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	return errors.Join(l.buffer.Stop(), l.file.Close())
+}
+
+// log writes an entry per question of msg, they share the rcode and answer count of the message.
+func (l *Logger) log(client net.Addr, msg *dns.Msg, duration time.Duration) {
+	fields := []zap.Field{
+		zap.String("client", addrHost(l.anonymizer.Addr(client))),
+		zap.String("protocol", client.Network()),
+		zap.String("rcode", dns.RcodeToString[msg.Rcode]),
+		zap.Int("answers", len(msg.Answer)),
+		zap.Float64("duration_ms", float64(duration)/float64(time.Millisecond)),
+	}
+	if len(msg.Question) == 0 {
+		l.logger.Info("", fields...)
+		return
+	}
+	for _, q := range msg.Question {
+		l.logger.Info("", append(fields, zap.String("name", q.Name), zap.String("type", dns.TypeToString[q.Qtype]))...)
+	}
+}
+
+// addrHost returns the address of addr without its port.
+func addrHost(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// responseWriter logs the messages written through it.
+type responseWriter struct {
+	dns.ResponseWriter
+	log      *Logger
+	received time.Time
+}
+
+func (w *responseWriter) WriteMsg(msg *dns.Msg) error {
+	err := w.ResponseWriter.WriteMsg(msg)
+	w.log.log(w.RemoteAddr(), msg, time.Since(w.received))
+	return err
+}
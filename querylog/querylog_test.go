@@ -0,0 +1,134 @@
+package querylog
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+type fakeResponseWriter struct {
+	dns.ResponseWriter
+}
+
+func (fakeResponseWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(192, 0, 2, 7), Port: 5353}
+}
+
+func (fakeResponseWriter) WriteMsg(*dns.Msg) error { return nil }
+
+func TestLoggerWritesEntries(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "queries.log")
+	logger, err := New(config.QueryLogConfig{File: path, MaxSizeMB: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := new(dns.Msg)
+	query.SetQuestion("edge.example.com.", dns.TypeAAAA)
+	query.Question = append(query.Question, dns.Question{Name: "api.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	reply := new(dns.Msg)
+	reply.SetRcode(query, dns.RcodeNameError)
+	reply.Question = query.Question
+	if err = logger.Wrap(fakeResponseWriter{}).WriteMsg(reply); err != nil {
+		t.Fatal(err)
+	}
+	if err = logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path) //nolint:gosec // path is in the temp dir of the test
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		t.Fatal("query log is empty")
+	}
+	var entry map[string]any
+	if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid entry %q: %v", scanner.Text(), err)
+	}
+	want := map[string]any{
+		"client":   "192.0.2.7",
+		"protocol": "udp",
+		"name":     "edge.example.com.",
+		"type":     "AAAA",
+		"rcode":    "NXDOMAIN",
+		"answers":  float64(0),
+	}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Fatalf("entry[%q] = %v, want %v in %s", key, entry[key], value, scanner.Text())
+		}
+	}
+	if _, ok := entry["duration_ms"]; !ok {
+		t.Fatalf("entry %s has no duration_ms", scanner.Text())
+	}
+	if !scanner.Scan() {
+		t.Fatal("query log has no entry for the second question")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid entry %q: %v", scanner.Text(), err)
+	}
+	if entry["name"] != "api.example.com." || entry["type"] != "A" || entry["rcode"] != "NXDOMAIN" {
+		t.Fatalf("second entry = %s, want the second question with the rcode of the message", scanner.Text())
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "queries.log")
+	// Files sharing the prefix of the log are not rotated ones.
+	unrelated := path + ".bak"
+	if err := os.WriteFile(unrelated, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := openRotatingFile(path, 10, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	// Writes within the same millisecond rotate into distinct files.
+	for range 5 {
+		if _, err = file.Write([]byte("0123456789")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rotated, err := file.rotatedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 3 {
+		t.Fatalf("rotated files = %v, want the newest 3", rotated)
+	}
+	if _, err = os.Stat(unrelated); err != nil {
+		t.Fatalf("unrelated file was pruned: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != 10 {
+		t.Fatalf("current file = %v, %v, want a single write", info, err)
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	t.Parallel()
+
+	var logger *Logger
+	w := fakeResponseWriter{}
+	if got := logger.Wrap(w); got != dns.ResponseWriter(w) {
+		t.Fatal("nil logger wrapped the response writer")
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
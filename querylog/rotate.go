@@ -0,0 +1,163 @@
+package querylog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedFormat timestamps rotated files, it sorts chronologically. Files rotated within the same
+// millisecond get a sequence suffix, see rotatedName.
+const (
+	rotatedFormat = "20060102T150405.000Z"
+	dirPerm       = 0o750
+	// filePerm keeps query logs group-readable for log shippers.
+	filePerm = 0o640
+)
+
+// rotatingFile appends to path and moves it aside once it grows past maxSize bytes or gets older
+// than maxAge, keeping the newest maxFiles rotated files.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	maxFiles int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, maxFiles int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), dirPerm); err != nil {
+		return nil, err
+	}
+	r := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxFiles: maxFiles}
+	if err := r.open(time.Now()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write appends p, rotating the file first when p does not fit in it anymore.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if r.size > 0 && (r.size+int64(len(p)) > r.maxSize || r.maxAge > 0 && now.Sub(r.opened) >= r.maxAge) {
+		if err := r.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Sync flushes the file to disk.
+func (r *rotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Sync()
+}
+
+// Close closes the file.
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+func (r *rotatingFile) open(now time.Time) error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, filePerm)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	r.file, r.size, r.opened = file, info.Size(), now
+	return nil
+}
+
+func (r *rotatingFile) rotate(now time.Time) error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	// The file is reopened even when it could not be moved aside, so logging goes on.
+	renameErr := os.Rename(r.path, r.rotatedName(now))
+	if err := r.open(now); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	return r.prune()
+}
+
+// rotatedName returns the free name the file is moved to when rotated at now: path followed by the
+// timestamp, and a -NNN sequence when a file was already rotated within the same millisecond.
+func (r *rotatingFile) rotatedName(now time.Time) string {
+	base := r.path + "." + now.UTC().Format(rotatedFormat)
+	name := base
+	for seq := 1; seq < 1000; seq++ {
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s-%03d", base, seq)
+	}
+	return name
+}
+
+// rotatedFiles returns the files rotated from path, oldest first. Other files sharing its prefix
+// are left out.
+func (r *rotatingFile) rotatedFiles() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(r.path))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(r.path) + "."
+	var files []string
+	for _, entry := range entries {
+		suffix, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		stamp, seq, sequenced := strings.Cut(suffix, "-")
+		if _, err := time.Parse(rotatedFormat, stamp); err != nil {
+			continue
+		}
+		if _, err := strconv.Atoi(seq); sequenced && (len(seq) != 3 || err != nil) {
+			continue
+		}
+		files = append(files, filepath.Join(filepath.Dir(r.path), entry.Name()))
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// prune removes the oldest rotated files beyond maxFiles.
+func (r *rotatingFile) prune() error {
+	if r.maxFiles <= 0 {
+		return nil
+	}
+	files, err := r.rotatedFiles()
+	if err != nil {
+		return err
+	}
+	for len(files) > r.maxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// Package report sends errors and panics to a Sentry compatible sink, such as Sentry or GlitchTip.
+package report
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// addrPattern matches candidates for IP addresses, optionally with a port, which are
+// scrubbed from reported messages once they parse as such.
+var addrPattern = regexp.MustCompile(`[0-9A-Fa-f:.\[\]]*[:.][0-9A-Fa-f:.\[\]]*`)
+
+// sink sends the events of a Reporter.
+type sink interface {
+	capture(err error, tags map[string]string)
+	recover(rec any)
+	flush()
+}
+
+// Reporter deduplicates errors and reports them, a nil *Reporter discards everything.
+type Reporter struct {
+	sink      sink
+	window    time.Duration
+	maxEvents int
+	now       func() time.Time
+
+	mu          sync.Mutex
+	lastSent    map[string]time.Time
+	windowStart time.Time
+	sent        int
+}
+
+// New returns a reporter sending to the DSN of cfg.
+func New(cfg config.ReportingConfig) (*Reporter, error) {
+	dst, err := newSentrySink(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Reporter{
+		sink:      dst,
+		window:    cfg.DedupeWindow,
+		maxEvents: cfg.MaxEvents,
+		now:       time.Now,
+		lastSent:  make(map[string]time.Time),
+	}, nil
+}
+
+// Capture reports err with tags, unless the same error was reported within the dedupe window
+// or the window already holds max_events events.
+func (r *Reporter) Capture(err error, tags map[string]string) {
+	if r == nil || err == nil || !r.allow(fingerprint(err.Error(), tags)) {
+		return
+	}
+	r.sink.capture(err, tags)
+}
+
+// Recover reports a panic of the calling goroutine and panics again, it must be deferred.
+func (r *Reporter) Recover() {
+	if r == nil {
+		return
+	}
+	if rec := recover(); rec != nil {
+		r.sink.recover(rec)
+		r.sink.flush()
+		panic(rec)
+	}
+}
+
+// CapturePanic reports a panic recovered by the caller, deduplicated like [Reporter.Capture].
+// The event is flushed in the background so the caller, e.g. a DNS query, is not held up by the
+// sink, call [Reporter.Close] to wait for it.
+func (r *Reporter) CapturePanic(rec any) {
+	if r == nil || rec == nil || !r.allow(fingerprint(fmt.Sprint(rec), nil)) {
+		return
+	}
+	r.sink.recover(rec)
+	go r.sink.flush()
+}
+
+// Go wraps fn so an error it returns is reported with tags, and a panic is reported before it propagates.
+func (r *Reporter) Go(fn func() error, tags map[string]string) func() error {
+	return func() error {
+		defer r.Recover()
+		err := fn()
+		r.Capture(err, tags)
+		return err
+	}
+}
+
+// Close flushes buffered events.
+func (r *Reporter) Close() {
+	if r != nil {
+		r.sink.flush()
+	}
+}
+
+func (r *Reporter) allow(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if now.Sub(r.windowStart) >= r.window {
+		r.windowStart, r.sent = now, 0
+		for k, at := range r.lastSent {
+			if now.Sub(at) >= r.window {
+				delete(r.lastSent, k)
+			}
+		}
+	}
+	if at, ok := r.lastSent[key]; ok && now.Sub(at) < r.window {
+		return false
+	}
+	if r.maxEvents > 0 && r.sent >= r.maxEvents {
+		return false
+	}
+	r.lastSent[key] = now
+	r.sent++
+	return true
+}
+
+// fingerprint identifies duplicates by the scrubbed message and the tags.
+func fingerprint(msg string, tags map[string]string) string {
+	var b strings.Builder
+	b.WriteString(scrubText(msg))
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		b.WriteString("|" + k + "=" + tags[k])
+	}
+	return b.String()
+}
+
+func scrubText(s string) string {
+	return addrPattern.ReplaceAllStringFunc(s, func(match string) string {
+		// Punctuation following an address, as in "dial 10.0.0.1:53: timeout", is kept.
+		addr := strings.TrimRight(match, ":.")
+		suffix := match[len(addr):]
+		if net.ParseIP(addr) != nil {
+			return "[ip]" + suffix
+		}
+		if host, port, err := net.SplitHostPort(addr); err == nil && net.ParseIP(host) != nil {
+			return "[ip]:" + port + suffix
+		}
+		return match
+	})
+}
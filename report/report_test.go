@@ -0,0 +1,97 @@
+package report
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllowDedupesAndCaps(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(0, 0)
+	r := &Reporter{
+		window:    time.Minute,
+		maxEvents: 2,
+		now:       func() time.Time { return now },
+		lastSent:  make(map[string]time.Time),
+	}
+	if !r.allow("a") {
+		t.Fatal("allow(a) = false for the first event")
+	}
+	if r.allow("a") {
+		t.Fatal("allow(a) = true for a duplicate within the window")
+	}
+	if !r.allow("b") {
+		t.Fatal("allow(b) = false below max_events")
+	}
+	if r.allow("c") {
+		t.Fatal("allow(c) = true above max_events")
+	}
+	now = now.Add(time.Minute)
+	if !r.allow("a") {
+		t.Fatal("allow(a) = false after the window elapsed")
+	}
+}
+
+// blockingSink counts the panics it receives, its flush blocks until release is closed.
+type blockingSink struct {
+	recovered int
+	flushed   chan struct{}
+	release   chan struct{}
+}
+
+func (*blockingSink) capture(error, map[string]string) {}
+
+func (s *blockingSink) recover(any) { s.recovered++ }
+
+func (s *blockingSink) flush() {
+	<-s.release
+	s.flushed <- struct{}{}
+}
+
+func TestCapturePanicFlushesInBackground(t *testing.T) {
+	t.Parallel()
+
+	s := &blockingSink{flushed: make(chan struct{}, 1), release: make(chan struct{})}
+	r := &Reporter{
+		sink:     s,
+		window:   time.Minute,
+		now:      time.Now,
+		lastSent: make(map[string]time.Time),
+	}
+	done := make(chan struct{})
+	go func() {
+		r.CapturePanic("boom")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("CapturePanic() blocked on the flush")
+	}
+	if s.recovered != 1 {
+		t.Fatalf("recovered %d panics, want 1", s.recovered)
+	}
+	close(s.release)
+	select {
+	case <-s.flushed:
+	case <-time.After(time.Second):
+		t.Fatal("CapturePanic() never flushed the event")
+	}
+}
+
+func TestScrubText(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"dial udp 10.0.0.1:53: timeout":        "dial udp [ip]:53: timeout",
+		"client 2001:db8::1 refused":           "client [ip] refused",
+		"read [2001:db8::1]:853: reset":        "read [ip]:853: reset",
+		"cycle took 12:30:45 at version 1.2.3": "cycle took 12:30:45 at version 1.2.3",
+	}
+	for in, want := range tests {
+		if got := scrubText(in); got != want {
+			t.Errorf("scrubText(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
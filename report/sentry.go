@@ -0,0 +1,64 @@
+//go:build !no_sentry && !minimal
+
+package report
+
+import (
+	"time"
+
+	"github.com/getsentry/sentry-go"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// SentrySupported tells whether reporting to Sentry is built in, the no_sentry and minimal build
+// tags leave it out.
+const SentrySupported = true
+
+const flushTimeout = 2 * time.Second
+
+// sentrySink sends events to a Sentry compatible sink.
+type sentrySink struct {
+	hub *sentry.Hub
+}
+
+func newSentrySink(cfg config.ReportingConfig) (sink, error) {
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:         cfg.DSN,
+		Environment: cfg.Environment,
+		SampleRate:  cfg.SampleRate,
+		BeforeSend:  scrub,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sentrySink{hub: sentry.NewHub(client, sentry.NewScope())}, nil
+}
+
+func (s sentrySink) capture(err error, tags map[string]string) {
+	s.hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetTags(tags)
+		s.hub.CaptureException(err)
+	})
+}
+
+func (s sentrySink) recover(rec any) {
+	s.hub.Recover(rec)
+}
+
+func (s sentrySink) flush() {
+	s.hub.Flush(flushTimeout)
+}
+
+// scrub removes user data and IP addresses from event before it is sent.
+func scrub(event *sentry.Event, _ *sentry.EventHint) *sentry.Event {
+	event.User = sentry.User{}
+	event.Request = nil
+	event.Message = scrubText(event.Message)
+	for i := range event.Exception {
+		event.Exception[i].Value = scrubText(event.Exception[i].Value)
+	}
+	for _, b := range event.Breadcrumbs {
+		b.Message = scrubText(b.Message)
+	}
+	return event
+}
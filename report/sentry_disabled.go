@@ -0,0 +1,17 @@
+//go:build no_sentry || minimal
+
+package report
+
+import (
+	"errors"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// SentrySupported tells whether reporting to Sentry is built in, the no_sentry and minimal build
+// tags leave it out.
+const SentrySupported = false
+
+func newSentrySink(config.ReportingConfig) (sink, error) {
+	return nil, errors.New("error reporting is not built in, rebuild without the no_sentry and minimal tags")
+}
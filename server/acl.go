@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net"
+	"slices"
+)
+
+// Address sizes of each family in bits.
+const (
+	ipv4Bits = 8 * net.IPv4len
+	ipv6Bits = 8 * net.IPv6len
+)
+
+// clientACL restricts the clients answered by their source address: a denied network always
+// wins, and when allow is set the source must be within it. A nil ACL allows every client.
+type clientACL struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func newClientACL(allow, deny []*net.IPNet) *clientACL {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil
+	}
+	return &clientACL{allow: allow, deny: deny}
+}
+
+func (a *clientACL) allows(ip net.IP) bool {
+	if a == nil {
+		return true
+	}
+	contains := func(n *net.IPNet) bool { return ip != nil && n.Contains(ip) }
+	if slices.ContainsFunc(a.deny, contains) {
+		return false
+	}
+	return len(a.allow) == 0 || slices.ContainsFunc(a.allow, contains)
+}
+
+// permits reports whether the source at ip may be answered for name, by the global ACL and the
+// ACL of the domain serving name, including its SRV names.
+func (d *Handler) permits(ip net.IP, name string) bool {
+	if !d.acl.allows(ip) {
+		return false
+	}
+	key, _, ok := d.lookupDomain(name)
+	if !ok {
+		key, ok = d.srvKey(name)
+	}
+	return !ok || d.domainACLs[key].allows(ip)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestClientACL(t *testing.T) {
+	t.Parallel()
+
+	_, office, _ := net.ParseCIDR("10.0.0.0/8")
+	_, guests, _ := net.ParseCIDR("10.9.0.0/16")
+	if newClientACL(nil, nil) != nil {
+		t.Fatal("newClientACL() without networks is not nil")
+	}
+	acl := newClientACL([]*net.IPNet{office}, []*net.IPNet{guests})
+	cases := map[string]bool{
+		"10.1.2.3":    true,
+		"10.9.2.3":    false,
+		"192.0.2.1":   false,
+		"2001:db8::1": false,
+	}
+	for ip, want := range cases {
+		if got := acl.allows(net.ParseIP(ip)); got != want {
+			t.Errorf("allows(%s) = %v, want %v", ip, got, want)
+		}
+	}
+	if acl.allows(nil) {
+		t.Error("allows(nil) = true, want unknown sources refused when allow is set")
+	}
+	denyOnly := newClientACL(nil, []*net.IPNet{guests})
+	if !denyOnly.allows(net.ParseIP("192.0.2.1")) || denyOnly.allows(net.ParseIP("10.9.0.1")) {
+		t.Error("deny only acl must answer everyone but the denied networks")
+	}
+}
+
+func TestServeDNSRefusesDeniedClients(t *testing.T) {
+	t.Parallel()
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, office, _ := net.ParseCIDR("10.0.0.0/8")
+	h := newTestHandler(t)
+	h.domainACLs = map[string]*clientACL{"private.example.com.": newClientACL(nil, []*net.IPNet{loopback})}
+	for _, key := range []string{"edge.example.com.", "private.example.com."} {
+		h.domains[key] = &config.ScanConfig{Domain: key}
+		h.UpdateRecords(key, []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	}
+	addr := startTestDNS(t, h)
+
+	exchange := func(addr, name string) int {
+		t.Helper()
+		query := new(dns.Msg)
+		query.SetQuestion(name, dns.TypeA)
+		resp, err := dns.Exchange(query, addr)
+		if err != nil {
+			t.Fatalf("exchange %s: %v", name, err)
+		}
+		return resp.Rcode
+	}
+	if rcode := exchange(addr, "edge.example.com."); rcode != dns.RcodeSuccess {
+		t.Fatalf("edge rcode = %s, want NOERROR", dns.RcodeToString[rcode])
+	}
+	if rcode := exchange(addr, "private.example.com."); rcode != dns.RcodeRefused {
+		t.Fatalf("private rcode = %s, want REFUSED for a denied client", dns.RcodeToString[rcode])
+	}
+
+	restricted := newTestHandler(t)
+	restricted.acl = newClientACL([]*net.IPNet{office}, nil)
+	restricted.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com."}
+	restricted.UpdateRecords("edge.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	if rcode := exchange(startTestDNS(t, restricted), "edge.example.com."); rcode != dns.RcodeRefused {
+		t.Fatalf("edge rcode = %s, want REFUSED outside allow_clients", dns.RcodeToString[rcode])
+	}
+}
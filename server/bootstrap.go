@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/go-tools/log"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/source"
+)
+
+// bootstrapRecords seeds the domains of cfg with bootstrap enabled with the addresses their
+// resolver answers for them, keeping the ones passing their checks. It runs along the first
+// cycle, on its workers and budget, domains that already have records when their bootstrap
+// completes are left untouched.
+func bootstrapRecords(ctx context.Context, cfg config.Config, h *Handler) {
+	cycleID := rand.Text()
+	logger := log.Of(ctx).With(zap.String("cycle_id", cycleID))
+	cycle := cycleResources{
+		id:           cycleID,
+		workerTokens: h.limits.workerTokens,
+		budget:       h.limits.currentBudget(),
+		probes:       newProbeLog(false, h.probeWebhook),
+	}
+	var domains sync.WaitGroup
+	for _, domainCfg := range cfg.Domains {
+		if domainCfg.Bootstrap.Resolver == "" || !domainCfg.IsEnabled() || domainCfg.Paused {
+			continue
+		}
+		domains.Go(func() {
+			bootstrapDomain(ctx, domainCfg, h, logger.With(zap.String("domain", domainCfg.Domain)), cycle)
+		})
+	}
+	domains.Wait()
+}
+
+func bootstrapDomain(
+	ctx context.Context,
+	domainCfg *config.ScanConfig,
+	h *Handler,
+	logger *zap.Logger,
+	cycle cycleResources,
+) {
+	name := domainCfg.BootstrapName()
+	runner, err := h.newRunner(domainCfg)
+	if err != nil {
+		logger.Warn("failed to build VM for bootstrap", zap.Error(err))
+		return
+	}
+	src := &scanSource{
+		cfg:    domainCfg,
+		h:      h,
+		logger: logger,
+		cycle:  cycle,
+		runner: runner,
+		candidates: &source.Resolve{
+			Name:     name,
+			Resolver: resolverAt(domainCfg.Bootstrap.Resolver),
+			Timeout:  domainCfg.Bootstrap.Timeout,
+		},
+	}
+	accepted, err := src.Records(ctx)
+	if err != nil {
+		logger.Warn("failed to bootstrap records", zap.String("name", name), zap.Error(err))
+		return
+	}
+	accepted = limitRecords(accepted, normalizeLimit(domainCfg.Limit))
+	if len(accepted) == 0 {
+		logger.Info("no bootstrap address passed the checks", zap.String("name", name))
+		return
+	}
+	records := make([]Record, len(accepted))
+	for i, a := range accepted {
+		records[i] = Record{IP: a.IP, Latency: a.Latency}
+	}
+	if !h.seedRecords(domainCfg.Domain, records) {
+		logger.Debug("domain scanned before its bootstrap completed, bootstrap records dropped")
+		return
+	}
+	logger.Info("records bootstrapped", zap.String("name", name), zap.Int("accepted_ips", len(records)))
+}
+
+// resolverAt returns a resolver sending its queries to the DNS server at addr.
+func resolverAt(addr string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// seedRecords publishes records for key unless it already has records, it reports whether it did.
+func (d *Handler) seedRecords(key string, records []Record) bool {
+	defer d.serials.Persist()
+	d.rwMux.Lock()
+	defer d.rwMux.Unlock()
+	if _, ok := d.store.Get(key); ok {
+		return false
+	}
+	d.generation++
+	d.updateLocked(key, records, time.Now())
+	d.generations[key] = d.generation
+	return true
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+func TestBootstrapRecords(t *testing.T) {
+	t.Parallel()
+
+	origin, err := new(net.ListenConfig).Listen(t.Context(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = origin.Close() })
+	resolver := startTestDNS(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		if q := r.Question[0]; q.Qtype == dns.TypeA && q.Name == "origin.example.com." {
+			hdr := dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}
+			// Nothing listens on 127.0.0.2, only 127.0.0.1 passes the checks.
+			msg.Answer = append(msg.Answer,
+				&dns.A{Hdr: hdr, A: net.IPv4(127, 0, 0, 2)},
+				&dns.A{Hdr: hdr, A: net.IPv4(127, 0, 0, 1)},
+			)
+		}
+		_ = w.WriteMsg(msg)
+	}))
+	cfg := parseScanTestConfig(t, fmt.Sprintf(`
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    bootstrap:
+      resolver: %q
+      name: "origin.example.com."
+  - domain: "scanned.example.com."
+    bootstrap:
+      resolver: %q
+      name: "origin.example.com."
+`, resolver, resolver), origin.Addr().(*net.TCPAddr).Port)
+	h, err := NewHandler(cfg, zap.NewNop(), nil)
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+	h.UpdateRecords("scanned.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+
+	bootstrapRecords(context.Background(), cfg, h)
+
+	snapshot := h.Snapshot()
+	if records := snapshot["edge.example.com."].Records; len(records) != 1 || !records[0].IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("edge records = %v, want the bootstrap address passing the checks", records)
+	}
+	if records := snapshot["scanned.example.com."].Records; len(records) != 1 || !records[0].IP.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("scanned records = %v, want the records of the scan kept", records)
+	}
+	if used := h.limits.startCycle().used(); used == 0 {
+		t.Fatal("first cycle budget used = 0, want the bootstrap probes counted against it")
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// probeBudget caps the number of probes and the bytes they transfer during a single update cycle.
+type probeBudget struct {
+	limit    int64
+	count    atomic.Int64
+	maxBytes int64
+	bytes    atomic.Int64
+}
+
+// newProbeBudget returns a budget of limit probes transferring up to maxBytes bytes, zero or
+// less means unlimited.
+func newProbeBudget(limit int, maxBytes int64) *probeBudget {
+	return &probeBudget{limit: int64(limit), maxBytes: maxBytes}
+}
+
+// take consumes one probe, it reports false once the budget is exhausted.
+// The bytes of a probe are only known once it completed, so the byte cap is
+// checked before each probe and may be exceeded by the probes in flight.
+func (b *probeBudget) take() bool {
+	if b.maxBytes > 0 && b.bytes.Load() >= b.maxBytes {
+		return false
+	}
+	n := b.count.Add(1)
+	if b.limit > 0 && n > b.limit {
+		b.count.Add(-1)
+		return false
+	}
+	return true
+}
+
+// spend records the bytes transferred by a probe.
+func (b *probeBudget) spend(bytes int64) {
+	b.bytes.Add(bytes)
+}
+
+func (b *probeBudget) used() int64 {
+	return b.count.Load()
+}
+
+func (b *probeBudget) usedBytes() int64 {
+	return b.bytes.Load()
+}
+
+// scanLimits holds the worker tokens of max_workers and the budget of the running update cycle.
+// They are shared by the cycles and by the scans running between or along them, such as rescans,
+// so those do not add their own workers and probes on top of the configured limits.
+type scanLimits struct {
+	workerTokens chan struct{}
+	maxProbes    int
+	maxBytes     int64
+
+	mu     sync.Mutex
+	budget *probeBudget
+	// claimed is set once a cycle took budget, the next cycle starts a new one.
+	claimed bool
+}
+
+func newScanLimits(cfg config.Config) *scanLimits {
+	return &scanLimits{
+		workerTokens: make(chan struct{}, normalizeMaxWorkers(cfg.MaxWorkers)),
+		maxProbes:    cfg.MaxProbes,
+		maxBytes:     cfg.MaxBytes,
+		budget:       newProbeBudget(cfg.MaxProbes, cfg.MaxBytes),
+	}
+}
+
+// startCycle returns the budget of a new update cycle. The first cycle takes over the budget the
+// scans started before it already draw on.
+func (l *scanLimits) startCycle() *probeBudget {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.claimed {
+		l.budget = newProbeBudget(l.maxProbes, l.maxBytes)
+	}
+	l.claimed = true
+	return l.budget
+}
+
+// currentBudget returns the budget of the running or last update cycle.
+func (l *scanLimits) currentBudget() *probeBudget {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.budget
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestProbeBudgetCapsBytes(t *testing.T) {
+	t.Parallel()
+
+	budget := newProbeBudget(0, 100)
+	if !budget.take() {
+		t.Fatal("take() = false, want the first probe allowed")
+	}
+	budget.spend(60)
+	if !budget.take() {
+		t.Fatal("take() = false, want a probe allowed below the byte cap")
+	}
+	budget.spend(60)
+	if budget.take() {
+		t.Fatal("take() = true, want probes refused once the byte cap is reached")
+	}
+	if got := budget.used(); got != 2 {
+		t.Errorf("used() = %d, want 2", got)
+	}
+}
+
+func TestScanLimitsShareTheCycleBudget(t *testing.T) {
+	t.Parallel()
+
+	limits := newScanLimits(config.Config{MaxWorkers: 2, MaxProbes: 1})
+	if n := cap(limits.workerTokens); n != 2 {
+		t.Fatalf("worker tokens = %d, want max_workers", n)
+	}
+	// A scan running before the first cycle, such as a bootstrap, spends the budget of that cycle.
+	if !limits.currentBudget().take() {
+		t.Fatal("take() = false, want the first probe allowed")
+	}
+	first := limits.startCycle()
+	if first.take() {
+		t.Fatal("take() = true in the first cycle, want the probe of the earlier scan counted")
+	}
+	second := limits.startCycle()
+	if second == first || limits.currentBudget() != second {
+		t.Fatal("startCycle() kept the budget of the previous cycle")
+	}
+	if !limits.currentBudget().take() {
+		t.Fatal("take() = false, want a fresh budget for the next cycle")
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"slices"
+
+	"github.com/miekg/dns"
+)
+
+// PresentTXT implements [certs.ChallengeSolver].
+func (d *Handler) PresentTXT(name, value string) {
+	d.rwMux.Lock()
+	defer d.rwMux.Unlock()
+	key := dns.CanonicalName(name)
+	d.txt[key] = append(d.txt[key], value)
+}
+
+// CleanUpTXT implements [certs.ChallengeSolver].
+func (d *Handler) CleanUpTXT(name, value string) {
+	d.rwMux.Lock()
+	defer d.rwMux.Unlock()
+	key := dns.CanonicalName(name)
+	d.txt[key] = slices.DeleteFunc(d.txt[key], func(v string) bool { return v == value })
+	if len(d.txt[key]) == 0 {
+		delete(d.txt, key)
+	}
+}
+
+// answerTXT adds the published TXT records of name to msg, it reports false if there are none.
+func (d *Handler) answerTXT(msg *dns.Msg, name string) bool {
+	d.rwMux.RLock()
+	values := slices.Clone(d.txt[dns.CanonicalName(name)])
+	d.rwMux.RUnlock()
+	if len(values) == 0 {
+		return false
+	}
+	for _, value := range values {
+		msg.Answer = append(msg.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   name,
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassINET,
+				Ttl:    0,
+			},
+			Txt: []string{value},
+		})
+	}
+	return true
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestChallengeTXTLifecycle(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.PresentTXT("_acme-challenge.Edge.Example.com.", "token-a")
+	h.PresentTXT("_acme-challenge.edge.example.com.", "token-b")
+	if got := h.txt["_acme-challenge.edge.example.com."]; len(got) != 2 {
+		t.Fatalf("txt values = %v, want 2 values under the canonical name", got)
+	}
+	h.CleanUpTXT("_acme-challenge.edge.example.com.", "token-a")
+	h.CleanUpTXT("_acme-challenge.edge.example.com.", "token-b")
+	if len(h.txt) != 0 {
+		t.Fatalf("txt = %v, want empty after clean up", h.txt)
+	}
+}
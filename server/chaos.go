@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/mithra/vm"
+
+	"github.com/fmotalleb/helios-dns/check"
+)
+
+// Kinds of injected faults, used as metric labels.
+const (
+	faultFailure = "failure"
+	faultLatency = "latency"
+	faultEmpty   = "empty"
+)
+
+var errInjectedFailure = errors.New("injected failure")
+
+// fault describes the artificial failures injected into the scans of a domain.
+type fault struct {
+	FailureRate  float64
+	Latency      time.Duration
+	EmptyResults bool
+}
+
+func (f fault) validate() error {
+	if f.FailureRate < 0 || f.FailureRate > 1 {
+		return errors.New("failure_rate must be between 0 and 1")
+	}
+	if f.Latency < 0 {
+		return errors.New("latency must not be negative")
+	}
+	return nil
+}
+
+// apply delays res by the injected latency and fails it with the injected failure rate.
+func (f fault) apply(ctx context.Context, domain string, res vm.Result) vm.Result {
+	if f.Latency > 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(f.Latency):
+		}
+		res.Duration += f.Latency
+		recordFaultInjected(domain, faultLatency)
+	}
+	if res.Success && f.FailureRate > 0 && rand.Float64() < f.FailureRate { //nolint:gosec // fault injection needs no cryptographic randomness
+		res.Success = false
+		res.Error = &check.Error{Check: "chaos", Err: errInjectedFailure}
+		recordFaultInjected(domain, faultFailure)
+	}
+	return res
+}
+
+// faultInjector holds the faults toggled through the chaos API, keyed by domain.
+// A nil injector injects nothing.
+type faultInjector struct {
+	mu     sync.RWMutex
+	faults map[string]fault
+}
+
+func newFaultInjector() *faultInjector {
+	return &faultInjector{faults: make(map[string]fault)}
+}
+
+func (fi *faultInjector) get(domain string) (fault, bool) {
+	if fi == nil {
+		return fault{}, false
+	}
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+	f, ok := fi.faults[domain]
+	return f, ok
+}
+
+func (fi *faultInjector) set(domain string, f fault) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.faults[domain] = f
+}
+
+func (fi *faultInjector) clear(domain string) bool {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	_, ok := fi.faults[domain]
+	delete(fi.faults, domain)
+	return ok
+}
+
+func (fi *faultInjector) snapshot() map[string]fault {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+	result := make(map[string]fault, len(fi.faults))
+	for domain, f := range fi.faults {
+		result[domain] = f
+	}
+	return result
+}
+
+// faultView is the JSON form of a fault used by the chaos API.
+type faultView struct {
+	FailureRate  float64 `json:"failure_rate"`
+	Latency      string  `json:"latency,omitempty"`
+	EmptyResults bool    `json:"empty_results"`
+}
+
+func (v faultView) fault() (fault, error) {
+	f := fault{FailureRate: v.FailureRate, EmptyResults: v.EmptyResults}
+	if v.Latency != "" {
+		latency, err := time.ParseDuration(v.Latency)
+		if err != nil {
+			return f, err
+		}
+		f.Latency = latency
+	}
+	return f, f.validate()
+}
+
+func newFaultView(f fault) faultView {
+	v := faultView{FailureRate: f.FailureRate, EmptyResults: f.EmptyResults}
+	if f.Latency > 0 {
+		v.Latency = f.Latency.String()
+	}
+	return v
+}
+
+// registerChaosAPI adds the fault injection endpoints to mux, the requests changing faults must
+// carry token as a bearer token.
+func registerChaosAPI(mux *http.ServeMux, handler *Handler, token string) {
+	mux.HandleFunc("GET /api/chaos", func(w http.ResponseWriter, _ *http.Request) {
+		faults := handler.chaos.snapshot()
+		views := make(map[string]faultView, len(faults))
+		for domain, f := range faults {
+			views[domain] = newFaultView(f)
+		}
+		writeJSON(w, http.StatusOK, views)
+	})
+	mux.Handle("PUT /api/chaos/{domain}", requireToken(token, func(w http.ResponseWriter, r *http.Request) {
+		domain := r.PathValue("domain")
+		if _, ok := handler.domains[domain]; !ok {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "unknown domain " + domain})
+			return
+		}
+		var view faultView
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxChaosBody)).Decode(&view); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid body: " + err.Error()})
+			return
+		}
+		f, err := view.fault()
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		handler.chaos.set(domain, f)
+		handler.logger.Warn("chaos fault injected", zap.String("domain", domain), zap.Any("fault", view))
+		writeJSON(w, http.StatusOK, newFaultView(f))
+	}))
+	mux.Handle("DELETE /api/chaos/{domain}", requireToken(token, func(w http.ResponseWriter, r *http.Request) {
+		domain := r.PathValue("domain")
+		if !handler.chaos.clear(domain) {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "no fault injected for " + domain})
+			return
+		}
+		handler.logger.Info("chaos fault cleared", zap.String("domain", domain))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+const maxChaosBody = 4 << 10
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/mithra/vm"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestFaultApply(t *testing.T) {
+	t.Parallel()
+
+	f, err := faultView{FailureRate: 1, Latency: "1ms"}.fault()
+	if err != nil {
+		t.Fatalf("fault() error = %v", err)
+	}
+	res := f.apply(context.Background(), "edge.example.com.", vm.Result{Success: true, Duration: time.Millisecond})
+	if res.Success || res.Error == nil {
+		t.Fatalf("apply() = %+v, want an injected failure", res)
+	}
+	if res.Duration != 2*time.Millisecond {
+		t.Fatalf("duration = %s, want the injected latency added", res.Duration)
+	}
+
+	if _, err := (faultView{FailureRate: 2}).fault(); err == nil {
+		t.Fatal("fault() accepted a failure rate above 1")
+	}
+}
+
+func TestNilFaultInjector(t *testing.T) {
+	t.Parallel()
+
+	var fi *faultInjector
+	if _, ok := fi.get("edge.example.com."); ok {
+		t.Fatal("nil injector reported a fault")
+	}
+}
+
+func TestChaosAPI(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.chaos = newFaultInjector()
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{Domain: key}
+	mux := http.NewServeMux()
+	registerChaosAPI(mux, h, "secret")
+
+	requestWithToken := func(method, target, body, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	request := func(method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		return requestWithToken(method, target, body, "secret")
+	}
+
+	for _, tc := range []struct {
+		target string
+		body   string
+		token  string
+		code   int
+	}{
+		{target: "/api/chaos/" + key, body: `{"empty_results":true}`, code: http.StatusUnauthorized},
+		{target: "/api/chaos/" + key, body: `{"empty_results":true}`, token: "wrong", code: http.StatusUnauthorized},
+		{target: "/api/chaos/other.example.com.", body: `{"failure_rate":0.5}`, token: "secret", code: http.StatusNotFound},
+		{target: "/api/chaos/" + key, body: `{`, token: "secret", code: http.StatusBadRequest},
+		{target: "/api/chaos/" + key, body: `{"failure_rate":2}`, token: "secret", code: http.StatusBadRequest},
+		{target: "/api/chaos/" + key, body: `{"latency":"soon"}`, token: "secret", code: http.StatusBadRequest},
+		{target: "/api/chaos/" + key, body: `{"latency":"-1s"}`, token: "secret", code: http.StatusBadRequest},
+	} {
+		if rec := requestWithToken(http.MethodPut, tc.target, tc.body, tc.token); rec.Code != tc.code {
+			t.Fatalf("PUT %s %s with token %q = %d, want %d", tc.target, tc.body, tc.token, rec.Code, tc.code)
+		}
+	}
+	if faults := h.chaos.snapshot(); len(faults) != 0 {
+		t.Fatalf("faults = %v, want none set by rejected requests", faults)
+	}
+
+	rec := request(http.MethodPut, "/api/chaos/"+key, `{"failure_rate":0.5,"latency":"200ms","empty_results":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT = %d %s, want 200", rec.Code, rec.Body)
+	}
+	want := fault{FailureRate: 0.5, Latency: 200 * time.Millisecond, EmptyResults: true}
+	if f, ok := h.chaos.get(key); !ok || f != want {
+		t.Fatalf("fault = %+v, want %+v", f, want)
+	}
+
+	rec = request(http.MethodGet, "/api/chaos", "")
+	var views map[string]faultView
+	if err := json.Unmarshal(rec.Body.Bytes(), &views); err != nil {
+		t.Fatalf("GET body %q: %v", rec.Body, err)
+	}
+	if wantViews := map[string]faultView{key: {FailureRate: 0.5, Latency: "200ms", EmptyResults: true}}; !maps.Equal(views, wantViews) {
+		t.Fatalf("GET = %v, want %v", views, wantViews)
+	}
+
+	// The second authorized DELETE finds no fault left.
+	for _, tc := range []struct {
+		token string
+		code  int
+	}{
+		{code: http.StatusUnauthorized},
+		{token: "secret", code: http.StatusNoContent},
+		{token: "secret", code: http.StatusNotFound},
+	} {
+		if rec = requestWithToken(http.MethodDelete, "/api/chaos/"+key, "", tc.token); rec.Code != tc.code {
+			t.Fatalf("DELETE with token %q = %d, want %d", tc.token, rec.Code, tc.code)
+		}
+	}
+	if _, ok := h.chaos.get(key); ok {
+		t.Fatal("fault still injected after DELETE")
+	}
+}
+
+// signalCheck passes every IP and reports each probe on its channel.
+type signalCheck chan net.IP
+
+func (c signalCheck) Check(_ context.Context, ip net.IP) error {
+	c <- ip
+	return nil
+}
+
+func (signalCheck) String() string { return "signal" }
+
+func TestChaosLatencyReleasesWorker(t *testing.T) {
+	t.Parallel()
+
+	cfg := parseScanTestConfig(t, `
+interval: 1m
+max_workers: 1
+domains:
+  - domain: "edge.example.com."
+    cidr: ["192.0.2.1/32", "192.0.2.2/32"]
+    result_limit: 2
+`, 443)
+	h, err := NewHandler(cfg, zap.NewNop(), nil)
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+	probed := make(signalCheck, 2)
+	if err := h.useChecks(probed); err != nil {
+		t.Fatalf("useChecks() returned error: %v", err)
+	}
+	h.chaos = newFaultInjector()
+	h.chaos.set("edge.example.com.", fault{Latency: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = h.Scan(ctx, cfg)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	// The single worker probes the second IP while the first one is delayed.
+	for range 2 {
+		select {
+		case <-probed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("second IP not probed while the injected latency of the first one was served")
+		}
+	}
+}
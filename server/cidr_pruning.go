@@ -0,0 +1,256 @@
+package server
+
+import (
+	"errors"
+	"iter"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// Overrides of the pruning decision set through the API.
+const (
+	overrideAuto = "auto"
+	overrideKeep = "keep"
+	overrideSkip = "skip"
+)
+
+var errUnknownCIDR = errors.New("unknown cidr")
+
+var cidrPrunedGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "helios_dns_cidr_pruned",
+		Help: "Whether a CIDR of a domain is currently pruned from scans (1) or not (0).",
+	},
+	[]string{"domain", "cidr"},
+)
+
+func init() {
+	prometheus.MustRegister(cidrPrunedGauge)
+}
+
+// cidrCounters collect the outcome of one CIDR during a scan.
+type cidrCounters struct {
+	probes    atomic.Int64
+	successes atomic.Int64
+	// exhausted is set once every sampled IP of the CIDR was handed to the workers.
+	exhausted atomic.Bool
+}
+
+// unproductive reports whether the CIDR was fully probed without a single success.
+func (c *cidrCounters) unproductive() bool {
+	return c.exhausted.Load() && c.probes.Load() > 0 && c.successes.Load() == 0
+}
+
+type cidrState struct {
+	misses   int
+	pruned   bool
+	override string
+	// probed counts the scans that probed a single IP of the deprioritized CIDR, so each
+	// probes another one.
+	probed int
+}
+
+// cidrTracker counts consecutive unproductive cycles of each CIDR, keyed by domain and CIDR.
+// A nil tracker prunes nothing.
+type cidrTracker struct {
+	mu     sync.Mutex
+	states map[string]map[string]*cidrState
+}
+
+func newCIDRTracker() *cidrTracker {
+	return &cidrTracker{states: make(map[string]map[string]*cidrState)}
+}
+
+// stateLocked returns the state of cidr in domain, creating it if needed.
+func (t *cidrTracker) stateLocked(domain, cidr string) *cidrState {
+	byCIDR, ok := t.states[domain]
+	if !ok {
+		byCIDR = make(map[string]*cidrState)
+		t.states[domain] = byCIDR
+	}
+	state, ok := byCIDR[cidr]
+	if !ok {
+		state = &cidrState{override: overrideAuto}
+		byCIDR[cidr] = state
+	}
+	return state
+}
+
+// filter returns samples without the pruned CIDRs of cfg, or limited to a single IP rotating
+// across scans when cfg deprioritizes them. When every CIDR was pruned automatically all of them are probed,
+// so a domain is never starved of candidates.
+func (t *cidrTracker) filter(cfg *config.ScanConfig, samples []iter.Seq[net.IP]) []iter.Seq[net.IP] {
+	if t == nil {
+		return samples
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]iter.Seq[net.IP], len(samples))
+	remaining, pinned := 0, false
+	for i, seq := range samples {
+		state := t.stateLocked(cfg.Domain, cfg.CIDRs[i])
+		pinned = pinned || state.override == overrideSkip
+		switch {
+		case !t.skippedLocked(state):
+			out[i] = seq
+			remaining++
+		case state.override == overrideAuto && cfg.CIDRPruning.Mode == config.PruneDeprioritize:
+			out[i] = nthIP(seq, state.probed)
+			state.probed++
+		default:
+			out[i] = func(func(net.IP) bool) {}
+		}
+	}
+	if remaining == 0 && !pinned {
+		return samples
+	}
+	return out
+}
+
+// observe records the outcome of a scan of cfg, counters are indexed like cfg.CIDRs. Only a
+// complete scan counts unproductive CIDRs, a canceled one may have dropped probes of IPs already
+// handed to the workers.
+func (t *cidrTracker) observe(cfg *config.ScanConfig, counters []cidrCounters, complete bool, logger *zap.Logger) {
+	if t == nil || cfg.CIDRPruning.AfterCycles <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range counters {
+		c := &counters[i]
+		cidr := cfg.CIDRs[i]
+		state := t.stateLocked(cfg.Domain, cidr)
+		switch {
+		case c.successes.Load() > 0:
+			state.misses = 0
+		case complete && c.unproductive():
+			state.misses++
+		default:
+			continue
+		}
+		pruned := state.misses >= cfg.CIDRPruning.AfterCycles
+		if pruned != state.pruned {
+			logger.Info("cidr pruning changed",
+				zap.String("cidr", cidr),
+				zap.Bool("pruned", pruned),
+				zap.Int("unproductive_cycles", state.misses),
+			)
+		}
+		state.pruned = pruned
+		cidrPrunedGauge.WithLabelValues(cfg.Domain, cidr).Set(boolToFloat(t.skippedLocked(state)))
+	}
+}
+
+func (t *cidrTracker) skippedLocked(state *cidrState) bool {
+	return state.override == overrideSkip || (state.override == overrideAuto && state.pruned)
+}
+
+// setOverride pins the pruning decision of cidr, auto also forgets its unproductive cycles.
+func (t *cidrTracker) setOverride(cfg *config.ScanConfig, cidr, override string) error {
+	switch override {
+	case overrideAuto, overrideKeep, overrideSkip:
+	default:
+		return errors.New("override must be one of auto, keep, skip")
+	}
+	if !slices.Contains(cfg.CIDRs, cidr) {
+		return errUnknownCIDR
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.stateLocked(cfg.Domain, cidr)
+	state.override = override
+	if override == overrideAuto {
+		state.misses, state.pruned = 0, false
+	}
+	cidrPrunedGauge.WithLabelValues(cfg.Domain, cidr).Set(boolToFloat(t.skippedLocked(state)))
+	return nil
+}
+
+// cidrView is the JSON form of the pruning state of a CIDR.
+type cidrView struct {
+	CIDR               string `json:"cidr"`
+	UnproductiveCycles int    `json:"unproductive_cycles"`
+	Pruned             bool   `json:"pruned"`
+	Override           string `json:"override"`
+}
+
+func (t *cidrTracker) views(cfg *config.ScanConfig) []cidrView {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]cidrView, len(cfg.CIDRs))
+	for i, cidr := range cfg.CIDRs {
+		state := t.stateLocked(cfg.Domain, cidr)
+		out[i] = cidrView{
+			CIDR:               cidr,
+			UnproductiveCycles: state.misses,
+			Pruned:             t.skippedLocked(state),
+			Override:           state.override,
+		}
+	}
+	return out
+}
+
+// registerCIDRAPI adds the CIDR pruning endpoints to mux. The endpoint overriding pruning is only
+// added with a token, which its requests must carry as a bearer token.
+func registerCIDRAPI(mux *http.ServeMux, handler *Handler, token string) {
+	mux.HandleFunc("GET /api/domains/{domain}/cidrs", func(w http.ResponseWriter, r *http.Request) {
+		domainCfg, ok := handler.domains[r.PathValue("domain")]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "unknown domain " + r.PathValue("domain")})
+			return
+		}
+		writeJSON(w, http.StatusOK, handler.cidrs.views(domainCfg))
+	})
+	if token == "" {
+		return
+	}
+	mux.Handle("PUT /api/domains/{domain}/cidrs", requireToken(token, func(w http.ResponseWriter, r *http.Request) {
+		domainCfg, ok := handler.domains[r.PathValue("domain")]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "unknown domain " + r.PathValue("domain")})
+			return
+		}
+		err := handler.cidrs.setOverride(domainCfg, r.FormValue("cidr"), r.FormValue("override"))
+		if errors.Is(err, errUnknownCIDR) {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "unknown cidr " + r.FormValue("cidr")})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, handler.cidrs.views(domainCfg))
+	}))
+}
+
+// nthIP limits seq to its address at index n, wrapping around when seq is shorter.
+func nthIP(seq iter.Seq[net.IP], n int) iter.Seq[net.IP] {
+	return func(yield func(net.IP) bool) {
+		var skipped []net.IP
+		for ip := range seq {
+			if len(skipped) == n {
+				yield(ip)
+				return
+			}
+			skipped = append(skipped, ip)
+		}
+		if len(skipped) > 0 {
+			yield(skipped[n%len(skipped)])
+		}
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
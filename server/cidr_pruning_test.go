@@ -0,0 +1,178 @@
+package server
+
+import (
+	"iter"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestCIDRTrackerPrunesUnproductiveCIDRs(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.ScanConfig{
+		Domain:      "edge.example.com.",
+		CIDRs:       []string{"192.0.2.0/24", "198.51.100.0/24"},
+		CIDRPruning: config.CIDRPruning{AfterCycles: 2, Mode: config.PruneSkip},
+	}
+	tracker := newCIDRTracker()
+	for range 2 {
+		counters := make([]cidrCounters, 2)
+		for i := range counters {
+			counters[i].exhausted.Store(true)
+			counters[i].probes.Add(3)
+		}
+		counters[1].successes.Add(1)
+		tracker.observe(cfg, counters, true, zap.NewNop())
+	}
+
+	got := sampleCounts(tracker.filter(cfg, testSamples()))
+	if !slices.Equal(got, []int{0, 3}) {
+		t.Fatalf("sampled per CIDR = %v, want the first CIDR skipped", got)
+	}
+
+	cfg.CIDRPruning.Mode = config.PruneDeprioritize
+	if got = sampleCounts(tracker.filter(cfg, testSamples())); !slices.Equal(got, []int{1, 3}) {
+		t.Fatalf("sampled per CIDR = %v, want a single IP of the deprioritized CIDR", got)
+	}
+
+	if err := tracker.setOverride(cfg, "192.0.2.0/24", overrideKeep); err != nil {
+		t.Fatalf("setOverride() returned error: %v", err)
+	}
+	if got = sampleCounts(tracker.filter(cfg, testSamples())); !slices.Equal(got, []int{3, 3}) {
+		t.Fatalf("sampled per CIDR = %v, want the kept CIDR fully probed", got)
+	}
+	if err := tracker.setOverride(cfg, "203.0.113.0/24", overrideSkip); err == nil {
+		t.Fatal("setOverride() for an unknown CIDR expected error, got nil")
+	}
+}
+
+func TestCIDRTrackerNeverStarvesDomain(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.ScanConfig{
+		Domain:      "edge.example.com.",
+		CIDRs:       []string{"192.0.2.0/24", "198.51.100.0/24"},
+		CIDRPruning: config.CIDRPruning{AfterCycles: 1, Mode: config.PruneSkip},
+	}
+	tracker := newCIDRTracker()
+	counters := make([]cidrCounters, 2)
+	for i := range counters {
+		counters[i].exhausted.Store(true)
+		counters[i].probes.Add(3)
+	}
+	tracker.observe(cfg, counters, true, zap.NewNop())
+
+	if got := sampleCounts(tracker.filter(cfg, testSamples())); !slices.Equal(got, []int{3, 3}) {
+		t.Fatalf("sampled per CIDR = %v, want every CIDR probed once all are pruned", got)
+	}
+}
+
+func TestCIDRTrackerIgnoresCanceledScans(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.ScanConfig{
+		Domain:      "edge.example.com.",
+		CIDRs:       []string{"192.0.2.0/24", "198.51.100.0/24"},
+		CIDRPruning: config.CIDRPruning{AfterCycles: 1, Mode: config.PruneSkip},
+	}
+	tracker := newCIDRTracker()
+	counters := make([]cidrCounters, 2)
+	counters[0].exhausted.Store(true)
+	counters[0].probes.Add(3)
+	counters[1].successes.Add(1)
+	tracker.observe(cfg, counters, false, zap.NewNop())
+
+	if views := tracker.views(cfg); views[0].Pruned || views[0].UnproductiveCycles != 0 {
+		t.Fatalf("views = %+v, want no unproductive cycle counted for a canceled scan", views)
+	}
+}
+
+func TestCIDRTrackerRotatesDeprioritizedIP(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.ScanConfig{
+		Domain:      "edge.example.com.",
+		CIDRs:       []string{"192.0.2.0/24", "198.51.100.0/24"},
+		CIDRPruning: config.CIDRPruning{AfterCycles: 1, Mode: config.PruneDeprioritize},
+	}
+	tracker := newCIDRTracker()
+	counters := make([]cidrCounters, 2)
+	counters[0].exhausted.Store(true)
+	counters[0].probes.Add(3)
+	counters[1].successes.Add(1)
+	tracker.observe(cfg, counters, true, zap.NewNop())
+
+	var got []string
+	for range 4 {
+		for ip := range tracker.filter(cfg, testSamples())[0] {
+			got = append(got, ip.String())
+		}
+	}
+	if want := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.1"}; !slices.Equal(got, want) {
+		t.Fatalf("probed IPs of the deprioritized CIDR = %v, want %v", got, want)
+	}
+}
+
+func TestCIDRAPI(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.cidrs = newCIDRTracker()
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{Domain: key, CIDRs: []string{"192.0.2.0/24"}}
+	target := "/api/domains/" + key + "/cidrs?cidr=192.0.2.0/24&override=skip"
+	request := func(mux *http.ServeMux, token string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPut, target, http.NoBody)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	open := http.NewServeMux()
+	registerCIDRAPI(open, h, "")
+	if code := request(open, ""); code != http.StatusMethodNotAllowed {
+		t.Fatalf("PUT without api_token = %d, want the override endpoint disabled", code)
+	}
+	mux := http.NewServeMux()
+	registerCIDRAPI(mux, h, "secret")
+	for _, token := range []string{"", "wrong"} {
+		if code := request(mux, token); code != http.StatusUnauthorized {
+			t.Fatalf("PUT with token %q = %d, want 401", token, code)
+		}
+	}
+	if views := h.cidrs.views(h.domains[key]); views[0].Override != overrideAuto {
+		t.Fatalf("views = %+v, want no override set by rejected requests", views)
+	}
+	if code := request(mux, "secret"); code != http.StatusOK {
+		t.Fatalf("PUT = %d, want 200", code)
+	}
+	if views := h.cidrs.views(h.domains[key]); views[0].Override != overrideSkip || !views[0].Pruned {
+		t.Fatalf("views = %+v, want the CIDR skipped", views)
+	}
+}
+
+func testSamples() []iter.Seq[net.IP] {
+	seq := slices.Values([]net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 2), net.IPv4(192, 0, 2, 3)})
+	return []iter.Seq[net.IP]{seq, seq}
+}
+
+func sampleCounts(samples []iter.Seq[net.IP]) []int {
+	counts := make([]int, len(samples))
+	for i, seq := range samples {
+		for range seq {
+			counts[i]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"net"
+	"slices"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/policy"
+)
+
+// clientPolicy holds the per-client settings used to build an answer.
+type clientPolicy struct {
+	group      string
+	ttl        uint32
+	maxAnswers int
+	domains    map[string]struct{}
+	// forward lets a group restricted to domains have unknown names forwarded upstream.
+	forward bool
+	// prefer holds the networks whose candidates are answered to this client when there are any.
+	prefer []*net.IPNet
+}
+
+// allows reports whether the policy permits answering for domain.
+func (p clientPolicy) allows(domain string) bool {
+	if len(p.domains) == 0 {
+		return true
+	}
+	_, ok := p.domains[domain]
+	return ok
+}
+
+// allowsForward reports whether the policy permits forwarding a query for domain, a name that
+// nothing serves here, to the upstreams.
+func (p clientPolicy) allowsForward(domain string) bool {
+	return p.forward || p.allows(domain)
+}
+
+// allowsQuery reports whether the policy permits a query for domain, forwarded to the upstreams
+// when forwarded is set.
+func (p clientPolicy) allowsQuery(domain string, forwarded bool) bool {
+	if forwarded {
+		return p.allowsForward(domain)
+	}
+	return p.allows(domain)
+}
+
+// limit trims candidates to the maximum answer count of the policy.
+func (p clientPolicy) limit(candidates []policy.Candidate) []policy.Candidate {
+	if p.maxAnswers > 0 && len(candidates) > p.maxAnswers {
+		return candidates[:p.maxAnswers]
+	}
+	return candidates
+}
+
+// preferred keeps the candidates within the preferred networks of the policy, or all of them
+// when none matches.
+func (p clientPolicy) preferred(candidates []policy.Candidate) []policy.Candidate {
+	if len(p.prefer) == 0 {
+		return candidates
+	}
+	out := make([]policy.Candidate, 0, len(candidates))
+	for _, c := range candidates {
+		if slices.ContainsFunc(p.prefer, func(n *net.IPNet) bool { return n.Contains(c.IP) }) {
+			out = append(out, c)
+		}
+	}
+	if len(out) == 0 {
+		return candidates
+	}
+	return out
+}
+
+type clientGroup struct {
+	networks []*net.IPNet
+	policy   clientPolicy
+}
+
+func buildClientGroups(groups []config.ClientGroup, defaultTTL uint32) ([]clientGroup, error) {
+	result := make([]clientGroup, 0, len(groups))
+	for _, g := range groups {
+		networks, err := g.Networks()
+		if err != nil {
+			return nil, err
+		}
+		prefer, err := g.PreferredNetworks()
+		if err != nil {
+			return nil, err
+		}
+		cp := clientPolicy{
+			group:      g.Name,
+			ttl:        defaultTTL,
+			maxAnswers: g.MaxAnswers,
+			forward:    g.Forward,
+			prefer:     prefer,
+		}
+		if g.TTL > 0 {
+			cp.ttl = uint32(g.TTL.Seconds())
+		}
+		if len(g.Domains) > 0 {
+			cp.domains = make(map[string]struct{}, len(g.Domains))
+			for _, domain := range g.Domains {
+				cp.domains[dns.CanonicalName(domain)] = struct{}{}
+			}
+		}
+		result = append(result, clientGroup{networks: networks, policy: cp})
+	}
+	return result, nil
+}
+
+// queryClient is the sender of a query. Its client group, and so the domains it may query, is
+// chosen by its source address, while subnet, its EDNS Client Subnet when enabled and the source
+// address otherwise, only selects the answers: any client can send any subnet. Queries of the
+// watchdog are selfTest ones, answered outside of any client group.
+type queryClient struct {
+	source   net.IP
+	subnet   net.IP
+	selfTest bool
+}
+
+// policyOf returns the policy of the group of the source of from, preferring the networks of the
+// group of its subnet.
+func (d *Handler) policyOf(from queryClient) clientPolicy {
+	if from.selfTest {
+		return clientPolicy{ttl: d.ttl}
+	}
+	p := d.policyFor(from.source)
+	if !from.subnet.Equal(from.source) {
+		p.prefer = d.policyFor(from.subnet).prefer
+	}
+	return p
+}
+
+// policyFor returns the policy of the first client group containing ip.
+func (d *Handler) policyFor(ip net.IP) clientPolicy {
+	if ip != nil {
+		for _, g := range d.clientGroups {
+			for _, network := range g.networks {
+				if network.Contains(ip) {
+					return g.policy
+				}
+			}
+		}
+	}
+	return clientPolicy{ttl: d.ttl}
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	if addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"cmp"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/privacy"
+)
+
+const (
+	// maxClientSubnets bounds the subnets tracked by clientStats, the least active ones are
+	// dropped when it is reached.
+	maxClientSubnets = 4096
+	// defaultClientsLimit is the number of subnets returned by /api/clients without a limit.
+	defaultClientsLimit = 20
+
+	clientPrefixV4 = 24
+	clientPrefixV6 = 48
+)
+
+// clientStats counts the queries of every client subnet, /24 for IPv4 and /48 for IPv6, as
+// anonymized by anonymizer. A nil clientStats counts nothing.
+type clientStats struct {
+	mu         sync.Mutex
+	since      time.Time
+	total      uint64
+	subnets    map[string]*subnetCount
+	anonymizer *privacy.Anonymizer
+}
+
+type subnetCount struct {
+	queries  uint64
+	lastSeen time.Time
+}
+
+func newClientStats(anonymizer *privacy.Anonymizer) *clientStats {
+	return &clientStats{since: time.Now(), subnets: make(map[string]*subnetCount), anonymizer: anonymizer}
+}
+
+// record counts a query from ip.
+func (s *clientStats) record(ip net.IP, now time.Time) {
+	if s == nil {
+		return
+	}
+	subnet := s.anonymizer.Subnet(ip, clientPrefixV4, clientPrefixV6)
+	if subnet == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total++
+	count, ok := s.subnets[subnet]
+	if !ok {
+		if len(s.subnets) >= maxClientSubnets {
+			s.prune()
+		}
+		count = new(subnetCount)
+		s.subnets[subnet] = count
+	}
+	count.queries++
+	count.lastSeen = now
+}
+
+// prune drops the subnets with at most the median count of queries. The caller must hold the lock.
+func (s *clientStats) prune() {
+	counts := make([]uint64, 0, len(s.subnets))
+	for _, count := range s.subnets {
+		counts = append(counts, count.queries)
+	}
+	slices.Sort(counts)
+	threshold := counts[len(counts)/2]
+	for subnet, count := range s.subnets {
+		if count.queries <= threshold {
+			delete(s.subnets, subnet)
+		}
+	}
+}
+
+type clientsResponse struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	// Since is when counting started, counts are kept in memory only.
+	Since   time.Time    `json:"since"`
+	Queries uint64       `json:"queries"`
+	Subnets []subnetView `json:"subnets"`
+}
+
+type subnetView struct {
+	Subnet   string    `json:"subnet"`
+	Queries  uint64    `json:"queries"`
+	Share    float64   `json:"share"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// top returns the limit subnets with the most queries.
+func (s *clientStats) top(limit int) clientsResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := clientsResponse{
+		GeneratedAt: time.Now(),
+		Since:       s.since,
+		Queries:     s.total,
+		Subnets:     make([]subnetView, 0, len(s.subnets)),
+	}
+	for subnet, count := range s.subnets {
+		resp.Subnets = append(resp.Subnets, subnetView{
+			Subnet:   subnet,
+			Queries:  count.queries,
+			Share:    float64(count.queries) / float64(s.total),
+			LastSeen: count.lastSeen,
+		})
+	}
+	slices.SortFunc(resp.Subnets, func(a, b subnetView) int {
+		return cmp.Or(cmp.Compare(b.Queries, a.Queries), cmp.Compare(a.Subnet, b.Subnet))
+	})
+	resp.Subnets = resp.Subnets[:min(limit, len(resp.Subnets))]
+	return resp
+}
+
+func handleClients(w http.ResponseWriter, r *http.Request, handler *Handler) {
+	limit, err := parseNonNegative(r.URL.Query(), "limit")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	if limit == 0 {
+		limit = defaultClientsLimit
+	}
+	writeJSON(w, http.StatusOK, handler.clients.top(limit))
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientStatsTop(t *testing.T) {
+	t.Parallel()
+
+	stats := newClientStats(nil)
+	now := time.Now()
+	for _, raw := range []string{"192.0.2.1", "192.0.2.200", "198.51.100.7", "2001:db8:1:2::1", "2001:db8:1:ff::1", "192.0.2.3"} {
+		stats.record(net.ParseIP(raw), now)
+	}
+	stats.record(nil, now)
+
+	resp := stats.top(2)
+	if resp.Queries != 6 || len(resp.Subnets) != 2 {
+		t.Fatalf("top(2) = %+v, want 6 queries and 2 subnets", resp)
+	}
+	if first := resp.Subnets[0]; first.Subnet != "192.0.2.0/24" || first.Queries != 3 || first.Share != 0.5 {
+		t.Fatalf("first subnet = %+v, want 192.0.2.0/24 with 3 queries", first)
+	}
+	if second := resp.Subnets[1]; second.Subnet != "2001:db8:1::/48" || second.Queries != 2 {
+		t.Fatalf("second subnet = %+v, want 2001:db8:1::/48 with 2 queries", second)
+	}
+}
+
+func TestClientStatsBounded(t *testing.T) {
+	t.Parallel()
+
+	stats := newClientStats(nil)
+	now := time.Now()
+	busy := net.IPv4(203, 0, 113, 1)
+	stats.record(busy, now)
+	stats.record(busy, now)
+	for i := range maxClientSubnets + 10 {
+		stats.record(net.ParseIP(fmt.Sprintf("10.%d.%d.1", i/256, i%256)), now)
+	}
+	if len(stats.subnets) > maxClientSubnets {
+		t.Fatalf("tracked %d subnets, want at most %d", len(stats.subnets), maxClientSubnets)
+	}
+	if top := stats.top(1).Subnets; len(top) != 1 || top[0].Subnet != "203.0.113.0/24" {
+		t.Fatalf("top subnet = %+v, want the busy subnet to survive pruning", top)
+	}
+}
+
+func TestHandleClients(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.clients = newClientStats(nil)
+	h.clients.record(net.IPv4(192, 0, 2, 1), time.Now())
+
+	rec := httptest.NewRecorder()
+	handleClients(rec, httptest.NewRequest(http.MethodGet, "/api/clients?limit=5", nil), h)
+	var resp clientsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || len(resp.Subnets) != 1 {
+		t.Fatalf("GET /api/clients = %s (%v)", rec.Body, err)
+	}
+
+	rec = httptest.NewRecorder()
+	handleClients(rec, httptest.NewRequest(http.MethodGet, "/api/clients?limit=-1", nil), h)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status for a negative limit = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/fmotalleb/go-tools/log"
+
+	"github.com/fmotalleb/mithra/vm"
+
+	"github.com/fmotalleb/helios-dns/check"
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// DomainComparison compares the checks of a domain in two configs over the same candidates.
+type DomainComparison struct {
+	Domain     string `json:"domain" yaml:"domain"`
+	Candidates int    `json:"candidates" yaml:"candidates"`
+	// OldAccepted and NewAccepted count the candidates passing the checks of each config.
+	OldAccepted int `json:"old_accepted" yaml:"old_accepted"`
+	NewAccepted int `json:"new_accepted" yaml:"new_accepted"`
+	// OldMedian and NewMedian are the median latencies of the accepted candidates.
+	OldMedian string `json:"old_median" yaml:"old_median"`
+	NewMedian string `json:"new_median" yaml:"new_median"`
+	// Changes lists the candidates accepted by one config only.
+	Changes []CandidateChange `json:"changes,omitempty" yaml:"changes,omitempty"`
+	Detail  string            `json:"detail,omitempty" yaml:"detail,omitempty"`
+}
+
+// CandidateChange is a candidate whose outcome differs between the configs, the step is the
+// check that rejected it.
+type CandidateChange struct {
+	IP         string `json:"ip" yaml:"ip"`
+	Old        bool   `json:"old" yaml:"old"`
+	New        bool   `json:"new" yaml:"new"`
+	OldLatency string `json:"old_latency" yaml:"old_latency"`
+	NewLatency string `json:"new_latency" yaml:"new_latency"`
+	OldStep    string `json:"old_step,omitempty" yaml:"old_step,omitempty"`
+	NewStep    string `json:"new_step,omitempty" yaml:"new_step,omitempty"`
+}
+
+// ScanComparison lists the comparisons of the domains of two configs.
+type ScanComparison struct {
+	Domains []DomainComparison `json:"domains" yaml:"domains"`
+}
+
+// Header implements the table output of the CLI.
+func (c ScanComparison) Header() []string {
+	return []string{"DOMAIN", "CANDIDATES", "OLD", "NEW", "ONLY OLD", "ONLY NEW", "OLD MEDIAN", "NEW MEDIAN", "DETAIL"}
+}
+
+// Rows implements the table output of the CLI.
+func (c ScanComparison) Rows() [][]string {
+	rows := make([][]string, len(c.Domains))
+	for i, d := range c.Domains {
+		var onlyOld, onlyNew int
+		for _, change := range d.Changes {
+			if change.Old {
+				onlyOld++
+			} else {
+				onlyNew++
+			}
+		}
+		rows[i] = []string{
+			d.Domain,
+			strconv.Itoa(d.Candidates),
+			strconv.Itoa(d.OldAccepted),
+			strconv.Itoa(d.NewAccepted),
+			strconv.Itoa(onlyOld),
+			strconv.Itoa(onlyNew),
+			d.OldMedian,
+			d.NewMedian,
+			d.Detail,
+		}
+	}
+	return rows
+}
+
+// CompareScans probes the same candidates of every domain with the checks of oldCfg and newCfg
+// and reports the differences. The candidates are sampled once from the CIDRs of both configs,
+// and each candidate is probed by both configs back to back, so both see the same network
+// conditions. Every candidate is probed, result_limit does not stop the comparison early.
+func CompareScans(ctx context.Context, oldCfg, newCfg config.Config) (ScanComparison, error) {
+	var report ScanComparison
+	maxWorkers := normalizeMaxWorkers(newCfg.MaxWorkers)
+	for _, domainCfg := range newCfg.Domains {
+		i := slices.IndexFunc(oldCfg.Domains, func(sc *config.ScanConfig) bool { return sc.Domain == domainCfg.Domain })
+		if i < 0 {
+			report.Domains = append(report.Domains, DomainComparison{Domain: domainCfg.Domain, Detail: "only in the new config"})
+			continue
+		}
+		result, err := compareDomain(ctx, oldCfg.Domains[i], domainCfg, maxWorkers)
+		if err != nil {
+			return report, err
+		}
+		report.Domains = append(report.Domains, result)
+	}
+	for _, domainCfg := range oldCfg.Domains {
+		if !slices.ContainsFunc(newCfg.Domains, func(sc *config.ScanConfig) bool { return sc.Domain == domainCfg.Domain }) {
+			report.Domains = append(report.Domains, DomainComparison{Domain: domainCfg.Domain, Detail: "only in the old config"})
+		}
+	}
+	return report, nil
+}
+
+// compareDomain probes the candidates of a domain with both of its configs.
+func compareDomain(ctx context.Context, oldCfg, newCfg *config.ScanConfig, maxWorkers int) (DomainComparison, error) {
+	result := DomainComparison{Domain: newCfg.Domain}
+	if oldCfg.Source.Type != config.SourceScan || newCfg.Source.Type != config.SourceScan {
+		result.Detail = "source is not scan"
+		return result, nil
+	}
+	candidates, err := compareCandidates(ctx, oldCfg, newCfg)
+	if err != nil {
+		return result, err
+	}
+	oldRunner, err := check.NewRunner(oldCfg)
+	if err != nil {
+		return result, err
+	}
+	newRunner, err := check.NewRunner(newCfg)
+	if err != nil {
+		return result, err
+	}
+	logger := log.Of(ctx).With(zap.String("domain", newCfg.Domain))
+	oldResults := make([]vm.Result, len(candidates))
+	newResults := make([]vm.Result, len(candidates))
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(maxWorkers)
+	for i, ip := range candidates {
+		group.Go(func() error {
+			// Alternate which config probes first, so neither benefits from warmed up paths.
+			if i%2 == 0 {
+				oldResults[i] = runScan(groupCtx, oldRunner, logger, ip)
+				newResults[i] = runScan(groupCtx, newRunner, logger, ip)
+			} else {
+				newResults[i] = runScan(groupCtx, newRunner, logger, ip)
+				oldResults[i] = runScan(groupCtx, oldRunner, logger, ip)
+			}
+			return nil
+		})
+	}
+	_ = group.Wait()
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	result.Candidates = len(candidates)
+	var oldLatencies, newLatencies []time.Duration
+	for i, ip := range candidates {
+		oldRes, newRes := oldResults[i], newResults[i]
+		if oldRes.Success {
+			oldLatencies = append(oldLatencies, oldRes.Duration)
+		}
+		if newRes.Success {
+			newLatencies = append(newLatencies, newRes.Duration)
+		}
+		if oldRes.Success == newRes.Success {
+			continue
+		}
+		result.Changes = append(result.Changes, CandidateChange{
+			IP:         ip.String(),
+			Old:        oldRes.Success,
+			New:        newRes.Success,
+			OldLatency: oldRes.Duration.Round(time.Microsecond).String(),
+			NewLatency: newRes.Duration.Round(time.Microsecond).String(),
+			OldStep:    check.Step(oldRes),
+			NewStep:    check.Step(newRes),
+		})
+	}
+	result.OldAccepted, result.OldMedian = len(oldLatencies), medianLatency(oldLatencies)
+	result.NewAccepted, result.NewMedian = len(newLatencies), medianLatency(newLatencies)
+	return result, nil
+}
+
+// compareCandidates samples the CIDRs of both configs once, without duplicates.
+func compareCandidates(ctx context.Context, oldCfg, newCfg *config.ScanConfig) ([]net.IP, error) {
+	var candidates []net.IP
+	seen := make(map[string]struct{})
+	for _, cfg := range []*config.ScanConfig{oldCfg, newCfg} {
+		samples, err := cfg.ReadCIDRsSamples(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, sample := range samples {
+			for ip := range sample {
+				ip = normalizeIP(ip)
+				if _, ok := seen[ip.String()]; !ok {
+					seen[ip.String()] = struct{}{}
+					candidates = append(candidates, ip)
+				}
+			}
+		}
+	}
+	return candidates, nil
+}
+
+func medianLatency(latencies []time.Duration) string {
+	if len(latencies) == 0 {
+		return ""
+	}
+	slices.Sort(latencies)
+	return latencies[(len(latencies)-1)/2].Round(time.Microsecond).String()
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestCompareScans(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+	parse := func(body string) config.Config { return parseScanTestConfig(t, body, port) }
+	oldCfg := parse(fmt.Sprintf(`
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    status_code: %d
+`, http.StatusNoContent))
+	newCfg := parse(fmt.Sprintf(`
+interval: 1m
+domains:
+  - domain: "edge.example.com."
+    status_code: %d
+  - domain: "new.example.com."
+`, http.StatusOK))
+
+	report, err := CompareScans(context.Background(), oldCfg, newCfg)
+	if err != nil {
+		t.Fatalf("CompareScans() returned error: %v", err)
+	}
+	if len(report.Domains) != 2 {
+		t.Fatalf("CompareScans() = %+v, want both domains", report)
+	}
+	edge := report.Domains[0]
+	if edge.Candidates != 1 || edge.OldAccepted != 1 || edge.NewAccepted != 0 || len(edge.Changes) != 1 {
+		t.Fatalf("edge comparison = %+v, want the candidate accepted by the old config only", edge)
+	}
+	if change := edge.Changes[0]; change.IP != "127.0.0.1" || !change.Old || change.New || change.NewStep == "" {
+		t.Errorf("change = %+v, want 127.0.0.1 rejected by a step of the new config", change)
+	}
+	if report.Domains[1].Detail != "only in the new config" {
+		t.Errorf("new domain = %+v, want it reported as only in the new config", report.Domains[1])
+	}
+}
+
+// parseScanTestConfig parses body with defaults probing 127.0.0.1 over plain TCP on port.
+func parseScanTestConfig(t *testing.T, body string, port int) config.Config {
+	t.Helper()
+
+	args := map[string]any{
+		"args": map[string]any{
+			"listen":        "127.0.0.1:5353",
+			"http_listen":   "",
+			"max_workers":   4,
+			"interval":      time.Minute.Nanoseconds(),
+			"cidrs":         []string{"127.0.0.1/32"},
+			"sni":           "edge.example.com",
+			"path":          "/",
+			"timeout":       time.Second.Nanoseconds(),
+			"port":          port,
+			"status_code":   0,
+			"sample_min":    1,
+			"sample_max":    1,
+			"sample_chance": 1.0,
+			"http_only":     true,
+		},
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var cfg config.Config
+	if err := config.Parse(context.Background(), &cfg, path, args); err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+	return cfg
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+const (
+	// typeNXNAME marks a compact denial of existence (RFC 9824) in NSEC type bitmaps.
+	typeNXNAME uint16 = 128
+	// maxCachedSignatures bounds the signature cache, it is emptied once full.
+	maxCachedSignatures = 4096
+	// signatureSkew backdates inceptions to tolerate resolvers with slow clocks.
+	signatureSkew = time.Hour
+	// dnskeyProtocol is the only valid protocol of a DNSKEY (RFC 4034).
+	dnskeyProtocol = 3
+	// keyBits is the size of generated ECDSA P-256 keys, ED25519 keys ignore it.
+	keyBits = 256
+	// Private keys are only readable by the owner, public keys by everyone.
+	keyDirPerm     = 0o700
+	privateKeyPerm = 0o600
+	publicKeyPerm  = 0o644
+)
+
+// zoneSigner signs the records of a zone on the fly.
+type zoneSigner struct {
+	ksk, zsk         *dns.DNSKEY
+	kskPriv, zskPriv crypto.Signer
+	validity         time.Duration
+	now              func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cachedSignature
+}
+
+type cachedSignature struct {
+	sig      *dns.RRSIG
+	signedAt time.Time
+}
+
+// setupDNSSEC attaches a signer to every zone with DNSSEC enabled.
+func setupDNSSEC(zones []*zone, cfgs []config.ZoneConfig, logger *zap.Logger) error {
+	for _, c := range cfgs {
+		if !c.DNSSEC.Enabled {
+			continue
+		}
+		name := dns.CanonicalName(c.Name)
+		i := slices.IndexFunc(zones, func(z *zone) bool { return z.name == name })
+		signer, err := newZoneSigner(name, zones[i].ttl, c.DNSSEC)
+		if err != nil {
+			return fmt.Errorf("zone %s: %w", name, err)
+		}
+		zones[i].signer = signer
+		if c.DNSSEC.KeyDir == "" {
+			logger.Warn("dnssec keys are not persisted, the DS record changes on every start", zap.String("zone", name))
+		}
+		logger.Info("dnssec enabled",
+			zap.String("zone", name),
+			zap.String("ds", signer.ksk.ToDS(dns.SHA256).String()),
+		)
+	}
+	return nil
+}
+
+func newZoneSigner(name string, ttl uint32, cfg config.DNSSECConfig) (*zoneSigner, error) {
+	s := &zoneSigner{
+		validity: cfg.SignatureValidity,
+		now:      time.Now,
+		cache:    make(map[string]cachedSignature),
+	}
+	alg := dns.StringToAlgorithm[cfg.Algorithm]
+	var err error
+	if s.ksk, s.kskPriv, err = loadKey(cfg.KeyDir, name, "ksk", dns.ZONE|dns.SEP, alg); err != nil {
+		return nil, err
+	}
+	if s.zsk, s.zskPriv, err = loadKey(cfg.KeyDir, name, "zsk", dns.ZONE, alg); err != nil {
+		return nil, err
+	}
+	s.ksk.Hdr.Ttl, s.zsk.Hdr.Ttl = ttl, ttl
+	return s, nil
+}
+
+// loadKey reads the key of the given role from dir, generating and storing it when missing.
+// An empty dir generates a key that only lives in memory.
+func loadKey(dir, zoneName, role string, flags uint16, alg uint8) (*dns.DNSKEY, crypto.Signer, error) {
+	base := filepath.Join(dir, "K"+strings.TrimSuffix(zoneName, ".")+"."+role)
+	if dir != "" {
+		key, priv, err := readKey(base)
+		if err == nil {
+			if key.Flags != flags || key.Algorithm != alg {
+				return nil, nil, fmt.Errorf("%s key %s.key does not match the configured algorithm", role, base)
+			}
+			return key, priv, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, err
+		}
+	}
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zoneName, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET},
+		Flags:     flags,
+		Protocol:  dnskeyProtocol,
+		Algorithm: alg,
+	}
+	generated, err := key.Generate(keyBits)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate %s key: %w", role, err)
+	}
+	priv, ok := generated.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("generate %s key: unsupported key type %T", role, generated)
+	}
+	if dir == "" {
+		return key, priv, nil
+	}
+	if err := os.MkdirAll(dir, keyDirPerm); err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(base+".private", []byte(key.PrivateKeyString(priv)), privateKeyPerm); err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(base+".key", []byte(key.String()+"\n"), publicKeyPerm); err != nil {
+		return nil, nil, err
+	}
+	return key, priv, nil
+}
+
+func readKey(base string) (*dns.DNSKEY, crypto.Signer, error) {
+	data, err := os.ReadFile(base + ".key") //nolint:gosec // keys are read from the configured key_dir
+	if err != nil {
+		return nil, nil, err
+	}
+	rr, err := dns.NewRR(string(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse %s.key: %w", base, err)
+	}
+	key, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s.key does not hold a DNSKEY record", base)
+	}
+	f, err := os.Open(base + ".private") //nolint:gosec // keys are read from the configured key_dir
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	priv, err := key.ReadPrivateKey(f, base+".private")
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s.private: unsupported key type %T", base, priv)
+	}
+	return key, signer, nil
+}
+
+// keys returns the DNSKEY records of the zone, owned by name as queried.
+func (s *zoneSigner) keys(name string) []dns.RR {
+	keys := []*dns.DNSKEY{s.ksk, s.zsk}
+	out := make([]dns.RR, 0, len(keys))
+	for _, key := range keys {
+		key = dns.Copy(key).(*dns.DNSKEY)
+		key.Hdr.Name = name
+		out = append(out, key)
+	}
+	return out
+}
+
+// sign returns the RRSIG of rrset, which must share owner, type and TTL, reusing a cached
+// signature until half of its validity elapsed.
+func (s *zoneSigner) sign(zoneName string, rrset []dns.RR) (*dns.RRSIG, error) {
+	var b strings.Builder
+	for _, rr := range rrset {
+		b.WriteString(strings.ToLower(rr.String()))
+		b.WriteByte('\n')
+	}
+	cacheKey := b.String()
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cached, ok := s.cache[cacheKey]; ok && now.Sub(cached.signedAt) < s.validity/2 {
+		return dns.Copy(cached.sig).(*dns.RRSIG), nil
+	}
+	key, priv := s.zsk, s.zskPriv
+	if rrset[0].Header().Rrtype == dns.TypeDNSKEY {
+		key, priv = s.ksk, s.kskPriv
+	}
+	hdr := rrset[0].Header()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: hdr.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: hdr.Ttl},
+		Algorithm:  key.Algorithm,
+		KeyTag:     key.KeyTag(),
+		SignerName: zoneName,
+		// Signature times are serial numbers, they wrap modulo 2^32 (RFC 4034, section 3.1.5).
+		Inception:  uint32(now.Add(-signatureSkew).Unix()), //nolint:gosec // wraps by design
+		Expiration: uint32(now.Add(s.validity).Unix()),     //nolint:gosec // wraps by design
+	}
+	if err := sig.Sign(priv, rrset); err != nil {
+		return nil, err
+	}
+	if len(s.cache) >= maxCachedSignatures {
+		clear(s.cache)
+	}
+	s.cache[cacheKey] = cachedSignature{sig: sig, signedAt: now}
+	return dns.Copy(sig).(*dns.RRSIG), nil
+}
+
+// secure proves negative answers with NSEC records and signs every RRset of a signed zone, when
+// r sets the DO bit and the question is in a signed zone.
+func (d *Handler) secure(r, msg *dns.Msg, logger *zap.Logger) *dns.Msg {
+	opt := r.IsEdns0()
+	if opt == nil || !opt.Do() || len(msg.Question) == 0 {
+		return msg
+	}
+	q := msg.Question[0]
+	z := d.zoneFor(q.Name)
+	if z == nil || z.signer == nil {
+		return msg
+	}
+	if len(msg.Answer) == 0 {
+		switch {
+		case msg.Rcode == dns.RcodeNameError:
+			// Compact denial of existence: the name is claimed to exist without any data.
+			msg.Rcode = dns.RcodeSuccess
+			msg.Ns = append(msg.Ns, d.nsec(z, q.Name, []uint16{dns.TypeRRSIG, dns.TypeNSEC, typeNXNAME}))
+		case msg.Rcode == dns.RcodeSuccess && q.Qtype == dns.TypeNSEC:
+			msg.Answer = []dns.RR{d.nsec(z, q.Name, d.typesAt(z, q.Name))}
+			msg.Ns = nil
+		case msg.Rcode == dns.RcodeSuccess:
+			types := slices.DeleteFunc(d.typesAt(z, q.Name), func(t uint16) bool { return t == q.Qtype })
+			msg.Ns = append(msg.Ns, d.nsec(z, q.Name, types))
+		}
+	}
+	var err error
+	if msg.Answer, err = d.signSection(msg.Answer); err == nil {
+		msg.Ns, err = d.signSection(msg.Ns)
+	}
+	if err != nil {
+		logger.Warn("failed to sign answer", zap.Error(err))
+		msg.Rcode = dns.RcodeServerFailure
+		msg.Answer, msg.Ns = nil, nil
+	}
+	return msg
+}
+
+// signSection appends the RRSIG of each RRset of rrs that lies within a signed zone.
+func (d *Handler) signSection(rrs []dns.RR) ([]dns.RR, error) {
+	if len(rrs) == 0 {
+		return rrs, nil
+	}
+	rrsets := groupRRsets(rrs)
+	out := make([]dns.RR, 0, len(rrs)+len(rrsets))
+	for _, rrset := range rrsets {
+		out = append(out, rrset...)
+		z := d.zoneFor(rrset[0].Header().Name)
+		if z == nil || z.signer == nil {
+			continue
+		}
+		sig, err := z.signer.sign(z.name, rrset)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sig)
+	}
+	return out, nil
+}
+
+// groupRRsets splits rrs into RRsets keeping their order, records of a set are assumed adjacent
+// except for answers mixing A and AAAA records.
+func groupRRsets(rrs []dns.RR) [][]dns.RR {
+	var sets [][]dns.RR
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		i := slices.IndexFunc(sets, func(set []dns.RR) bool {
+			first := set[0].Header()
+			return first.Rrtype == hdr.Rrtype && strings.EqualFold(first.Name, hdr.Name)
+		})
+		if i < 0 {
+			sets = append(sets, []dns.RR{rr})
+			continue
+		}
+		sets[i] = append(sets[i], rr)
+	}
+	return sets
+}
+
+// nsec returns an NSEC record for name covering only name itself, as online signers do.
+func (d *Handler) nsec(z *zone, name string, types []uint16) *dns.NSEC {
+	slices.Sort(types)
+	return &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: z.negativeTTL},
+		NextDomain: "\\000." + dns.CanonicalName(name),
+		TypeBitMap: slices.Compact(types),
+	}
+}
+
+// typesAt returns the record types served at name in zone z, for NSEC type bitmaps.
+func (d *Handler) typesAt(z *zone, name string) []uint16 {
+	types := []uint16{dns.TypeRRSIG, dns.TypeNSEC}
+	if z.isApex(name) {
+		types = append(types, dns.TypeSOA, dns.TypeNS, dns.TypeDNSKEY)
+	}
+	canonical := dns.CanonicalName(name)
+	for _, rr := range d.static[canonical] {
+		types = append(types, rr.Header().Rrtype)
+	}
+	key, domainCfg, local := d.lookupDomain(name)
+	if _, alias := d.aliases[canonical]; alias && domainCfg.AliasMode == config.AliasCNAME {
+		return append(types, dns.TypeCNAME)
+	}
+	d.rwMux.RLock()
+	defer d.rwMux.RUnlock()
+	if len(d.txt[canonical]) > 0 {
+		types = append(types, dns.TypeTXT)
+	}
+	if !local {
+		return types
+	}
+	records, _ := d.store.Get(key)
+	for _, rec := range records {
+		if rec.IP.To4() != nil {
+			types = append(types, dns.TypeA)
+		} else {
+			types = append(types, dns.TypeAAAA)
+		}
+	}
+	return types
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestSecureSignsAnswersAndDenials(t *testing.T) {
+	t.Parallel()
+
+	zoneCfg := config.ZoneConfig{
+		Name:        "example.com.",
+		NameServers: []string{"ns1.example.com."},
+		TTL:         time.Hour,
+		NegativeTTL: 5 * time.Minute,
+		DNSSEC: config.DNSSECConfig{
+			Enabled:           true,
+			KeyDir:            t.TempDir(),
+			Algorithm:         "ECDSAP256SHA256",
+			SignatureValidity: 24 * time.Hour,
+		},
+	}
+	h := newTestHandler(t)
+	h.zones = buildZones([]config.ZoneConfig{zoneCfg})
+	if err := setupDNSSEC(h.zones, []config.ZoneConfig{zoneCfg}, zap.NewNop()); err != nil {
+		t.Fatalf("setupDNSSEC() returned error: %v", err)
+	}
+	signer := h.zones[0].signer
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com."}
+	h.UpdateRecords("edge.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+
+	resolve := func(name string, qtype uint16) *dns.Msg {
+		query := new(dns.Msg)
+		query.SetQuestion(name, qtype)
+		query.SetEdns0(1232, true)
+		msg := h.secure(query, h.authorize(h.resolve(query, queryClient{}, zap.NewNop())), zap.NewNop())
+		return h.edns(query, msg, nil)
+	}
+
+	msg := resolve("edge.example.com.", dns.TypeA)
+	if opt := msg.IsEdns0(); opt == nil || !opt.Do() {
+		t.Fatalf("answer = %v, want the DO bit echoed", msg)
+	}
+	verifyRRSIG(t, msg.Answer, dns.TypeA, signer.zsk)
+
+	msg = resolve("example.com.", dns.TypeDNSKEY)
+	verifyRRSIG(t, msg.Answer, dns.TypeDNSKEY, signer.ksk)
+
+	msg = resolve("missing.example.com.", dns.TypeA)
+	if msg.Rcode != dns.RcodeSuccess {
+		t.Fatalf("missing name rcode = %s, want a compact denial with NOERROR", dns.RcodeToString[msg.Rcode])
+	}
+	verifyRRSIG(t, msg.Ns, dns.TypeNSEC, signer.zsk)
+	verifyRRSIG(t, msg.Ns, dns.TypeSOA, signer.zsk)
+
+	msg = resolve("edge.example.com.", dns.TypeAAAA)
+	verifyRRSIG(t, msg.Ns, dns.TypeNSEC, signer.zsk)
+	for _, rr := range msg.Ns {
+		if nsec, ok := rr.(*dns.NSEC); ok && (slices.Contains(nsec.TypeBitMap, dns.TypeAAAA) || !slices.Contains(nsec.TypeBitMap, dns.TypeA)) {
+			t.Fatalf("NODATA bitmap = %v, want A without AAAA", nsec.TypeBitMap)
+		}
+	}
+
+	// Keys are kept in key_dir and reused on the next start.
+	reloaded, err := newZoneSigner("example.com.", 3600, zoneCfg.DNSSEC)
+	if err != nil {
+		t.Fatalf("newZoneSigner() returned error: %v", err)
+	}
+	if reloaded.ksk.KeyTag() != signer.ksk.KeyTag() || reloaded.zsk.KeyTag() != signer.zsk.KeyTag() {
+		t.Fatal("reloaded keys differ from the stored ones")
+	}
+}
+
+// verifyRRSIG verifies the RRSIG of rrs covering the records of type covered with key.
+func verifyRRSIG(t *testing.T, rrs []dns.RR, covered uint16, key *dns.DNSKEY) {
+	t.Helper()
+	var rrset []dns.RR
+	var sig *dns.RRSIG
+	for _, rr := range rrs {
+		switch {
+		case rr.Header().Rrtype == covered:
+			rrset = append(rrset, rr)
+		case rr.Header().Rrtype == dns.TypeRRSIG && rr.(*dns.RRSIG).TypeCovered == covered:
+			sig = rr.(*dns.RRSIG)
+		}
+	}
+	if sig == nil {
+		t.Fatalf("records %v have no RRSIG covering %s", rrs, dns.TypeToString[covered])
+	}
+	if err := sig.Verify(key, rrset); err != nil {
+		t.Fatalf("RRSIG over %s does not verify: %v", dns.TypeToString[covered], err)
+	}
+}
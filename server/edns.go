@@ -0,0 +1,162 @@
+package server
+
+import (
+	"net"
+	"slices"
+
+	"github.com/miekg/dns"
+)
+
+// clientAddr returns the address answers are selected for, the address of the EDNS Client Subnet
+// option of r when enabled and the source address otherwise, along with the option to echo.
+func (d *Handler) clientAddr(r *dns.Msg, remote net.Addr) (net.IP, *dns.EDNS0_SUBNET) {
+	ip := addrIP(remote)
+	opt := r.IsEdns0()
+	if !d.clientSubnet || opt == nil {
+		return ip, nil
+	}
+	for _, o := range opt.Option {
+		subnet, ok := o.(*dns.EDNS0_SUBNET)
+		if !ok {
+			continue
+		}
+		bits := ipv6Bits
+		if subnet.Family == 1 {
+			bits = ipv4Bits
+		}
+		// A zero source prefix asks for the subnet not to be used, it is still echoed.
+		if subnet.SourceNetmask == 0 || int(subnet.SourceNetmask) > bits || subnet.Address == nil {
+			return ip, subnet
+		}
+		return subnet.Address.Mask(net.CIDRMask(int(subnet.SourceNetmask), bits)), subnet
+	}
+	return ip, nil
+}
+
+// edns replaces the OPT record of msg with one answering the OPT record of r, advertising the
+// configured UDP size, echoing the DO bit and the client subnet option. The scope of the subnet
+// is its source prefix when client groups make answers depend on it, zero otherwise. Extended
+// errors of msg are kept.
+func (d *Handler) edns(r, msg *dns.Msg, subnet *dns.EDNS0_SUBNET) *dns.Msg {
+	errs := extendedErrors(msg)
+	msg.Extra = slices.DeleteFunc(msg.Extra, func(rr dns.RR) bool { return rr.Header().Rrtype == dns.TypeOPT })
+	opt := r.IsEdns0()
+	if opt == nil {
+		return msg
+	}
+	out := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	out.SetUDPSize(max(d.udpSize, dns.MinMsgSize))
+	out.SetDo(opt.Do())
+	if subnet != nil {
+		echo := *subnet
+		echo.SourceScope = 0
+		if len(d.clientGroups) > 0 {
+			echo.SourceScope = subnet.SourceNetmask
+		}
+		out.Option = append(out.Option, &echo)
+	}
+	for _, ede := range errs {
+		out.Option = append(out.Option, ede)
+	}
+	msg.Extra = append(msg.Extra, out)
+	return msg
+}
+
+// withEDE attaches an RFC 8914 extended error to msg, telling why it is not a regular answer. It
+// is carried by an OPT record that [Handler.edns] replaces, so clients without EDNS0 never get it.
+func withEDE(msg *dns.Msg, code uint16, text string) *dns.Msg {
+	return withEDNSOption(msg, &dns.EDNS0_EDE{InfoCode: code, ExtraText: text})
+}
+
+// withEDNSOption adds option to the OPT record of msg, adding one if msg has none.
+func withEDNSOption(msg *dns.Msg, option dns.EDNS0) *dns.Msg {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		opt = &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+		msg.Extra = append(msg.Extra, opt)
+	}
+	opt.Option = append(opt.Option, option)
+	return msg
+}
+
+// truncate fits msg, the answer to r, in the UDP payload size of the client: the size advertised
+// in the OPT record of r capped by the configured one, 512 bytes without EDNS0. Records that do
+// not fit are dropped and the TC bit is set, so the client retries over TCP where answers are
+// sent whole.
+func (d *Handler) truncate(w dns.ResponseWriter, r, msg *dns.Msg) *dns.Msg {
+	size, udp := d.payloadSize(w, r)
+	if !udp {
+		return msg
+	}
+	truncated := msg.Truncated
+	msg.Truncate(size)
+	if msg.Truncated && !truncated {
+		recordTruncated()
+	}
+	return msg
+}
+
+// payloadSize returns the size answers to r fit in, udp is false over TCP where any size fits.
+func (d *Handler) payloadSize(w dns.ResponseWriter, r *dns.Msg) (size int, udp bool) {
+	if _, tcp := w.RemoteAddr().(*net.TCPAddr); tcp {
+		return dns.MaxMsgSize, false
+	}
+	if opt := r.IsEdns0(); opt != nil {
+		return max(min(int(opt.UDPSize()), int(d.udpSize)), dns.MinMsgSize), true
+	}
+	return dns.MinMsgSize, true
+}
+
+// shape compresses msg, the answer to r, and pads it as configured for the domain of its
+// question. Truncated answers stay compressed. As RFC 8467 requires, only answers to queries
+// carrying a padding option are padded, to a multiple of the block size that still fits the
+// payload size of the client.
+func (d *Handler) shape(w dns.ResponseWriter, r, msg *dns.Msg) *dns.Msg {
+	compress, block := d.compress, d.padding
+	if len(msg.Question) > 0 {
+		if _, domainCfg, ok := d.lookupDomain(msg.Question[0].Name); ok {
+			if domainCfg.Compress != nil {
+				compress = *domainCfg.Compress
+			}
+			if domainCfg.Padding != nil {
+				block = *domainCfg.Padding
+			}
+		}
+	}
+	msg.Compress = msg.Compress || compress
+	opt := msg.IsEdns0()
+	if block == 0 || opt == nil || !padded(r) {
+		return msg
+	}
+	// The padding option adds its 4 bytes header to the message.
+	const optionHeader = 4
+	size, _ := d.payloadSize(w, r)
+	length := msg.Len() + optionHeader
+	target := min((length+block-1)/block*block, size)
+	if target < length {
+		return msg
+	}
+	opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, target-length)})
+	return msg
+}
+
+// padded tells whether the query r carries a padding option.
+func padded(r *dns.Msg) bool {
+	opt := r.IsEdns0()
+	return opt != nil && slices.ContainsFunc(opt.Option, func(o dns.EDNS0) bool { return o.Option() == dns.EDNS0PADDING })
+}
+
+// extendedErrors returns the extended errors attached to msg.
+func extendedErrors(msg *dns.Msg) []*dns.EDNS0_EDE {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	var errs []*dns.EDNS0_EDE
+	for _, o := range opt.Option {
+		if ede, ok := o.(*dns.EDNS0_EDE); ok {
+			errs = append(errs, ede)
+		}
+	}
+	return errs
+}
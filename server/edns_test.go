@@ -0,0 +1,266 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestServeDNSUsesClientSubnet(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.udpSize, h.clientSubnet = 1232, true
+	groups, err := buildClientGroups([]config.ClientGroup{{
+		Name:   "eu",
+		CIDRs:  []string{"203.0.113.0/24"},
+		Prefer: []string{"198.51.100.0/24"},
+	}}, h.ttl)
+	if err != nil {
+		t.Fatalf("buildClientGroups() returned error: %v", err)
+	}
+	h.clientGroups = groups
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com."}
+	h.UpdateRecords("edge.example.com.", []Record{
+		{IP: net.IPv4(192, 0, 2, 1).To4()},
+		{IP: net.IPv4(198, 51, 100, 1).To4()},
+	})
+	addr := startTestDNS(t, h)
+	client := &dns.Client{Net: "udp", Timeout: time.Second}
+
+	query := new(dns.Msg)
+	query.SetQuestion("edge.example.com.", dns.TypeA)
+	query.SetEdns0(4096, false)
+	opt := query.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: 24,
+		Address:       net.IPv4(203, 0, 113, 7).To4(),
+	})
+	resp, _, err := client.Exchange(query, addr)
+	if err != nil {
+		t.Fatalf("Exchange() returned error: %v", err)
+	}
+	if len(resp.Answer) != 1 || !resp.Answer[0].(*dns.A).A.Equal(net.IPv4(198, 51, 100, 1)) {
+		t.Fatalf("answer = %v, want only the IP preferred for the client subnet", resp.Answer)
+	}
+	respOpt := resp.IsEdns0()
+	if respOpt == nil || respOpt.UDPSize() != 1232 || len(respOpt.Option) != 1 {
+		t.Fatalf("OPT = %v, want the configured UDP size and the subnet echoed", respOpt)
+	}
+	if subnet := respOpt.Option[0].(*dns.EDNS0_SUBNET); subnet.SourceScope != 24 {
+		t.Fatalf("subnet scope = %d, want 24", subnet.SourceScope)
+	}
+
+	opt.SetVersion(1)
+	if resp, _, err = client.Exchange(query, addr); err != nil {
+		t.Fatalf("Exchange() returned error: %v", err)
+	}
+	if resp.Rcode != dns.RcodeBadVers {
+		t.Fatalf("rcode = %s, want BADVERS for an unknown EDNS version", dns.RcodeToString[resp.Rcode])
+	}
+}
+
+func TestClientSubnetDoesNotChangeGroup(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.udpSize, h.clientSubnet = 1232, true
+	groups, err := buildClientGroups([]config.ClientGroup{
+		{Name: "local", CIDRs: []string{"127.0.0.0/8"}, Domains: []string{"edge.example.com."}},
+		{Name: "trusted", CIDRs: []string{"203.0.113.0/24"}},
+	}, h.ttl)
+	if err != nil {
+		t.Fatalf("buildClientGroups() returned error: %v", err)
+	}
+	h.clientGroups = groups
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com."}
+	h.domains["private.example.com."] = &config.ScanConfig{Domain: "private.example.com."}
+	h.UpdateRecords("private.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+
+	query := new(dns.Msg)
+	query.SetQuestion("private.example.com.", dns.TypeA)
+	query.SetEdns0(4096, false)
+	opt := query.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: 24,
+		Address:       net.IPv4(203, 0, 113, 7).To4(),
+	})
+	client := &dns.Client{Net: "udp", Timeout: time.Second}
+	resp, _, err := client.Exchange(query, startTestDNS(t, h))
+	if err != nil {
+		t.Fatalf("Exchange() returned error: %v", err)
+	}
+	if resp.Rcode != dns.RcodeRefused || len(resp.Answer) != 0 {
+		t.Fatalf("rcode = %s with %d answers, want REFUSED for the group of the source address",
+			dns.RcodeToString[resp.Rcode], len(resp.Answer))
+	}
+}
+
+func TestServeDNSTruncatesOverUDP(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.udpSize = 1232
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{Domain: key}
+	records := make([]Record, 100)
+	for i := range records {
+		records[i] = Record{IP: net.IPv4(192, 0, 2, byte(i+1)).To4()}
+	}
+	h.UpdateRecords(key, records)
+	query := new(dns.Msg)
+	query.SetQuestion(key, dns.TypeA)
+
+	udp := &dns.Client{Net: "udp", Timeout: time.Second}
+	resp, _, err := udp.Exchange(query, startTestDNS(t, h))
+	if err != nil {
+		t.Fatalf("Exchange() over UDP returned error: %v", err)
+	}
+	if !resp.Truncated || len(resp.Answer) == 0 || len(resp.Answer) == len(records) {
+		t.Fatalf("UDP answer has TC=%v and %d records, want it truncated to 512 bytes", resp.Truncated, len(resp.Answer))
+	}
+	// Len computes the size on the wire of compressed messages only.
+	resp.Compress = true
+	if size := resp.Len(); size > dns.MinMsgSize {
+		t.Fatalf("UDP answer is %d bytes, want at most %d", size, dns.MinMsgSize)
+	}
+
+	// A larger advertised size is capped by the configured one.
+	query.SetEdns0(4096, false)
+	if resp, _, err = udp.Exchange(query, startTestDNS(t, h)); err != nil {
+		t.Fatalf("Exchange() over UDP with EDNS0 returned error: %v", err)
+	}
+	resp.Compress = true
+	if !resp.Truncated || resp.Len() > 1232 || resp.IsEdns0() == nil {
+		t.Fatalf("EDNS0 answer has TC=%v, %d bytes and OPT %v, want it truncated to 1232 bytes with OPT", resp.Truncated, resp.Len(), resp.IsEdns0())
+	}
+
+	tcp := &dns.Client{Net: "tcp", Timeout: time.Second}
+	if resp, _, err = tcp.Exchange(query, startTestDNSTCP(t, h)); err != nil {
+		t.Fatalf("Exchange() over TCP returned error: %v", err)
+	}
+	if resp.Truncated || len(resp.Answer) != len(records) {
+		t.Fatalf("TCP answer has TC=%v and %d records, want all %d", resp.Truncated, len(resp.Answer), len(records))
+	}
+}
+
+func TestServeDNSExtendedErrors(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.udpSize = 1232
+	h.domains["warming.example.com."] = &config.ScanConfig{Domain: "warming.example.com."}
+	h.domains["paused.example.com."] = &config.ScanConfig{
+		Domain:         "paused.example.com.",
+		Paused:         true,
+		PausedResponse: config.PausedServFail,
+	}
+	addr := startTestDNS(t, h)
+	client := &dns.Client{Net: "udp", Timeout: time.Second}
+
+	for _, tc := range []struct {
+		name  string
+		rcode int
+		code  uint16
+	}{
+		{name: "warming.example.com.", rcode: dns.RcodeSuccess, code: dns.ExtendedErrorCodeNotReady},
+		{name: "paused.example.com.", rcode: dns.RcodeServerFailure, code: dns.ExtendedErrorCodeOther},
+		{name: "outside.example.org.", rcode: dns.RcodeRefused, code: dns.ExtendedErrorCodeNotAuthoritative},
+	} {
+		query := new(dns.Msg)
+		query.SetQuestion(tc.name, dns.TypeA)
+		query.SetEdns0(4096, false)
+		resp, _, err := client.Exchange(query, addr)
+		if err != nil {
+			t.Fatalf("Exchange(%s) returned error: %v", tc.name, err)
+		}
+		errs := extendedErrors(resp)
+		if resp.Rcode != tc.rcode || len(errs) != 1 || errs[0].InfoCode != tc.code {
+			t.Fatalf("%s: rcode = %s, extended errors = %v, want %s with code %d",
+				tc.name, dns.RcodeToString[resp.Rcode], errs, dns.RcodeToString[tc.rcode], tc.code)
+		}
+	}
+
+	// Clients without EDNS0 get no OPT record.
+	query := new(dns.Msg)
+	query.SetQuestion("warming.example.com.", dns.TypeA)
+	resp, _, err := client.Exchange(query, addr)
+	if err != nil {
+		t.Fatalf("Exchange() returned error: %v", err)
+	}
+	if opt := resp.IsEdns0(); opt != nil {
+		t.Fatalf("OPT = %v, want none for a query without EDNS0", opt)
+	}
+}
+
+func TestServeDNSShapesAnswers(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.udpSize = 1232
+	h.padding = 128
+	compress, noPadding := true, 0
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com.", Compress: &compress}
+	h.domains["plain.example.com."] = &config.ScanConfig{Domain: "plain.example.com.", Padding: &noPadding}
+	records := make([]Record, 8)
+	for i := range records {
+		records[i] = Record{IP: net.IPv4(192, 0, 2, byte(i+1)).To4()}
+	}
+	h.UpdateRecords("edge.example.com.", records)
+	h.UpdateRecords("plain.example.com.", records)
+	addr := startTestDNS(t, h)
+
+	exchange := func(name string, pad bool) (*dns.Msg, int) {
+		t.Helper()
+		query := new(dns.Msg)
+		query.SetQuestion(name, dns.TypeA)
+		query.SetEdns0(1232, false)
+		if pad {
+			opt := query.IsEdns0()
+			opt.Option = append(opt.Option, &dns.EDNS0_PADDING{})
+		}
+		wire, err := query.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := new(net.Dialer).DialContext(t.Context(), "udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err = conn.Write(wire); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, dns.MaxMsgSize)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(buf[:n]); err != nil {
+			t.Fatal(err)
+		}
+		return resp, n
+	}
+
+	resp, size := exchange("edge.example.com.", true)
+	if len(resp.Answer) != len(records) || size%128 != 0 {
+		t.Fatalf("padded answer has %d records in %d bytes, want %d records in a multiple of 128 bytes", len(resp.Answer), size, len(records))
+	}
+	resp, size = exchange("edge.example.com.", false)
+	if size >= resp.Len() {
+		t.Fatalf("answer is %d bytes, want it compressed below %d bytes", size, resp.Len())
+	}
+	if resp, size = exchange("plain.example.com.", true); size != resp.Len() {
+		t.Fatalf("answer is %d bytes, want it neither compressed nor padded (%d bytes)", size, resp.Len())
+	}
+}
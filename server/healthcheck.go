@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// HealthcheckOptions selects what Healthcheck checks, empty addresses are not checked.
+type HealthcheckOptions struct {
+	// DNS is the DNS listen address of the instance.
+	DNS string
+	// Name is queried for an A record that must be answered, an empty query only checks that
+	// the listener answers.
+	Name string
+	// HTTP is the HTTP listen address of the instance, or the base URL of its HTTP server.
+	HTTP string
+	// Ready checks /readyz rather than /healthz.
+	Ready   bool
+	Timeout time.Duration
+}
+
+// HealthCheck is the outcome of one check of Healthcheck.
+type HealthCheck struct {
+	Name     string `json:"name" yaml:"name"`
+	Target   string `json:"target" yaml:"target"`
+	Passed   bool   `json:"passed" yaml:"passed"`
+	Duration string `json:"duration" yaml:"duration"`
+	Detail   string `json:"detail,omitempty" yaml:"detail,omitempty"`
+}
+
+// HealthReport lists the checks of Healthcheck, the instance is healthy if every check passed.
+type HealthReport struct {
+	Healthy bool          `json:"healthy" yaml:"healthy"`
+	Checks  []HealthCheck `json:"checks" yaml:"checks"`
+}
+
+// Header implements the table output of the CLI.
+func (r HealthReport) Header() []string {
+	return []string{"CHECK", "TARGET", "RESULT", "DURATION", "DETAIL"}
+}
+
+// Rows implements the table output of the CLI.
+func (r HealthReport) Rows() [][]string {
+	rows := make([][]string, len(r.Checks))
+	for i, c := range r.Checks {
+		result := "pass"
+		if !c.Passed {
+			result = "FAIL"
+		}
+		rows[i] = []string{c.Name, c.Target, result, c.Duration, c.Detail}
+	}
+	return rows
+}
+
+// run executes check against target and records its outcome.
+func (r *HealthReport) run(name string, target string, check func() (string, error)) {
+	start := time.Now()
+	detail, err := check()
+	if err != nil {
+		detail = err.Error()
+		r.Healthy = false
+	}
+	r.Checks = append(r.Checks, HealthCheck{
+		Name:     name,
+		Target:   target,
+		Passed:   err == nil,
+		Duration: time.Since(start).Round(time.Microsecond).String(),
+		Detail:   detail,
+	})
+}
+
+// Healthcheck checks a running instance through its listeners, as a container health probe.
+func Healthcheck(ctx context.Context, opts HealthcheckOptions) HealthReport {
+	report := HealthReport{Healthy: true}
+	if opts.DNS != "" {
+		addr := loopbackAddr(opts.DNS)
+		report.run("dns", addr, func() (string, error) {
+			return checkDNS(ctx, addr, opts.Name, opts.Timeout)
+		})
+	}
+	if opts.HTTP != "" {
+		url := healthURL(opts.HTTP, opts.Ready)
+		report.run("http", url, func() (string, error) {
+			return checkHTTP(ctx, url, opts.Timeout)
+		})
+	}
+	return report
+}
+
+// checkDNS queries the listener at addr, for an A record of name unless it is empty.
+func checkDNS(ctx context.Context, addr string, name string, timeout time.Duration) (string, error) {
+	query := new(dns.Msg)
+	query.Id = dns.Id()
+	if name != "" {
+		query.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	}
+	client := &dns.Client{Net: "udp", Timeout: timeout}
+	resp, rtt, err := client.ExchangeContext(ctx, query, addr)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return fmt.Sprintf("answered in %s", rtt.Round(time.Microsecond)), nil
+	}
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) == 0 {
+		return "", fmt.Errorf("%s answered with %s and %d records", name, dns.RcodeToString[resp.Rcode], len(resp.Answer))
+	}
+	return fmt.Sprintf("%s answered with %d records", name, len(resp.Answer)), nil
+}
+
+// healthURL returns the URL of the health endpoint of the HTTP server at addr.
+func healthURL(addr string, ready bool) string {
+	path := "/healthz"
+	if ready {
+		path = "/readyz"
+	}
+	if strings.Contains(addr, "://") {
+		return strings.TrimSuffix(addr, "/") + path
+	}
+	return "http://" + loopbackAddr(addr) + path
+}
+
+func checkHTTP(ctx context.Context, url string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return "", errors.New(resp.Status)
+	}
+	return resp.Status, nil
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestHealthcheck(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{Domain: key, ConfidenceBoost: 1}
+	h.UpdateRecords(key, []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	dnsAddr := startTestDNS(t, h)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/readyz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	report := Healthcheck(context.Background(), HealthcheckOptions{
+		DNS:     dnsAddr,
+		Name:    "edge.example.com",
+		HTTP:    srv.Listener.Addr().String(),
+		Timeout: time.Second,
+	})
+	if !report.Healthy || len(report.Checks) != 2 {
+		t.Fatalf("report = %+v, want both checks passed", report)
+	}
+
+	report = Healthcheck(context.Background(), HealthcheckOptions{HTTP: srv.URL, Ready: true, Timeout: time.Second})
+	if report.Healthy {
+		t.Fatalf("report = %+v, want unhealthy while /readyz fails", report)
+	}
+
+	report = Healthcheck(context.Background(), HealthcheckOptions{DNS: dnsAddr, Name: "other.example.com", Timeout: time.Second})
+	if report.Healthy {
+		t.Fatalf("report = %+v, want unhealthy for a name without records", report)
+	}
+}
+
+func TestHealthcheckEmptyQuery(t *testing.T) {
+	t.Parallel()
+
+	report := Healthcheck(context.Background(), HealthcheckOptions{DNS: startTestDNS(t, newTestHandler(t)), Timeout: time.Second})
+	if !report.Healthy {
+		t.Fatalf("report = %+v, want the listener answering", report)
+	}
+}
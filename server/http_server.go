@@ -1,19 +1,29 @@
 package server
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"embed"
 	"encoding/json"
+	"errors"
+	"io"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 
 	"github.com/fmotalleb/go-tools/log"
 
+	"github.com/fmotalleb/helios-dns/certs"
 	"github.com/fmotalleb/helios-dns/config"
+	dnsServer "github.com/fmotalleb/helios-dns/dns"
+	"github.com/fmotalleb/helios-dns/loglevel"
+	"github.com/fmotalleb/helios-dns/policy"
 )
 
 //go:embed static/*
@@ -21,14 +31,30 @@ var staticFS embed.FS
 
 type statusResponse struct {
 	GeneratedAt time.Time      `json:"generated_at"`
+	Total       int            `json:"total"`
+	Offset      int            `json:"offset"`
 	Domains     []domainStatus `json:"domains"`
 }
 
+// domainStatus omits the fields that were not selected through ?fields=.
 type domainStatus struct {
-	Domain     string     `json:"domain"`
-	IPs        []string   `json:"ips"`
-	LastUpdate string     `json:"last_update"`
-	Config     configView `json:"config"`
+	Domain     string       `json:"domain"`
+	IPs        []string     `json:"ips,omitzero"`
+	Records    []recordView `json:"records,omitzero"`
+	PoolSize   *float64     `json:"pool_size,omitempty"`
+	LastUpdate string       `json:"last_update,omitempty"`
+	Generation uint64       `json:"generation,omitempty"`
+	Config     *configView  `json:"config,omitempty"`
+}
+
+type recordView struct {
+	IP          string  `json:"ip"`
+	Latency     string  `json:"latency"`
+	ValidatedAt string  `json:"validated_at"`
+	Confidence  float64 `json:"confidence"`
+	// Weight is the relative share of traffic suggested for the IP, as in SRV records.
+	Weight   uint16 `json:"weight"`
+	Draining bool   `json:"draining,omitempty"`
 }
 
 type configView struct {
@@ -44,29 +70,27 @@ type configView struct {
 	SamplesChance float64  `json:"sample_chance"`
 	HTTPOnly      bool     `json:"http_only"`
 	ResultLimit   int      `json:"result_limit"`
+	LatencyFactor float64  `json:"latency_factor,omitempty"`
+	Enabled       bool     `json:"enabled"`
+	Paused        bool     `json:"paused"`
+	PausedResp    string   `json:"paused_response,omitempty"`
 }
 
-func serveHTTP(ctx context.Context, addr string, cfg config.Config, handler *dnsHandler) error {
+// serveHTTP runs the HTTP server, over TLS with certificates of certManager when it is not nil.
+func serveHTTP(
+	ctx context.Context,
+	addr string,
+	cfg config.Config,
+	handler *Handler,
+	ready *readiness,
+	certManager *certs.Manager,
+) error {
 	const httpTimeout = 5 * time.Second
 
 	logger := log.Of(ctx)
-	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
-
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFileFS(w, r, staticFS, "static")
-	})
-	mux.HandleFunc("/api/status", func(w http.ResponseWriter, _ *http.Request) {
-		status := buildStatus(cfg, handler.Snapshot())
-		w.Header().Set("Content-Type", "application/json")
-		enc := json.NewEncoder(w)
-		enc.SetIndent("", "  ")
-		_ = enc.Encode(status)
-	})
-
 	server := &http.Server{
 		Addr:              addr,
-		Handler:           mux,
+		Handler:           newHTTPMux(ctx, cfg, handler, ready),
 		ReadHeaderTimeout: httpTimeout,
 	}
 
@@ -79,47 +103,214 @@ func serveHTTP(ctx context.Context, addr string, cfg config.Config, handler *dns
 		}
 	}()
 
-	logger.Info("http server started", zap.String("listen", addr))
-	err := server.ListenAndServe()
-	if err == http.ErrServerClosed {
+	// The HTTPS listener is only opened once there is a certificate to serve.
+	if certManager != nil {
+		select {
+		case <-certManager.Ready():
+		default:
+			logger.Info("waiting for a certificate before serving https", zap.String("listen", addr))
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-certManager.Ready():
+			}
+		}
+	}
+	listener := new(net.ListenConfig)
+	l, err := dnsServer.BindWithRetry(ctx, cfg.BindRetry, func() (net.Listener, error) {
+		return listener.Listen(ctx, "tcp", addr)
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("http server started", zap.String("listen", addr), zap.Bool("tls", certManager != nil))
+	if certManager != nil {
+		server.TLSConfig = &tls.Config{
+			GetCertificate: certManager.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+		err = server.ServeTLS(l, "", "")
+	} else {
+		err = server.Serve(l)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
 		return nil
 	}
 	return err
 }
 
-func buildStatus(cfg config.Config, snapshot map[string]recordSnapshot) statusResponse {
+// newHTTPMux routes the metrics, health, UI and API endpoints of the HTTP server.
+func newHTTPMux(ctx context.Context, cfg config.Config, handler *Handler, ready *readiness) *http.ServeMux {
+	mux := http.NewServeMux()
+	// OpenMetrics is negotiated so scrapers asking for it receive the exemplars of probe durations.
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	))
+	mux.Handle("/readyz", ready)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	mux.Handle("/", uiHandler(cfg.UI))
+	uiTheme := buildUITheme(cfg.UI)
+	mux.HandleFunc("GET /api/ui", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, uiTheme)
+	})
+	mux.Handle("/api/status", gzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseStatusQuery(r.URL.Query())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, buildStatus(cfg, handler.Snapshot(), query))
+	})))
+	mux.HandleFunc("GET /api/resolve", func(w http.ResponseWriter, r *http.Request) {
+		handleResolve(w, r, handler)
+	})
+	mux.Handle("GET /api/latency", gzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleLatency(w, r, handler)
+	})))
+	mux.HandleFunc("GET /api/clients", func(w http.ResponseWriter, r *http.Request) {
+		handleClients(w, r, handler)
+	})
+	if cfg.APIToken != "" {
+		registerRecordsAPI(mux, handler, cfg.APIToken)
+	}
+	registerCIDRAPI(mux, handler, cfg.APIToken)
+	if handler.chaos != nil && cfg.APIToken != "" {
+		registerChaosAPI(mux, handler, cfg.APIToken)
+	}
+	if levels := loglevel.FromContext(ctx); levels != nil {
+		registerLogLevelAPI(mux, levels, cfg.APIToken, log.Of(ctx))
+	}
+	return mux
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// gzipHandler compresses responses of next for clients that accept gzip.
+func gzipHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		next.ServeHTTP(gzipResponseWriter{ResponseWriter: w, writer: gz}, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for part := range strings.SplitSeq(r.Header.Get("Accept-Encoding"), ",") {
+		coding, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(coding, "gzip") {
+			return true
+		}
+	}
+	return false
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	writer io.Writer
+}
+
+func (w gzipResponseWriter) Write(b []byte) (int, error) {
+	return w.writer.Write(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(body)
+}
+
+func buildStatus(cfg config.Config, snapshot map[string]RecordSnapshot, query statusQuery) statusResponse {
 	resp := statusResponse{
 		GeneratedAt: time.Now(),
-		Domains:     make([]domainStatus, 0, len(cfg.Domains)),
+		Offset:      query.offset,
 	}
+	matched := make([]*config.ScanConfig, 0, len(cfg.Domains))
 	for _, domainCfg := range cfg.Domains {
-		entry := domainStatus{
-			Domain: domainCfg.Domain,
-			IPs:    []string{},
-			Config: configView{
-				Domain:        domainCfg.Domain,
-				CIDRs:         domainCfg.CIDRs,
-				SNI:           domainCfg.SNI,
-				Timeout:       (time.Duration(domainCfg.Timeout) * time.Nanosecond).String(),
-				Port:          domainCfg.Port,
-				Path:          domainCfg.Path,
-				StatusCode:    domainCfg.StatusCode,
-				SamplesMin:    domainCfg.SamplesMinimum,
-				SamplesMax:    domainCfg.SamplesMaximum,
-				SamplesChance: domainCfg.SamplesChance,
-				HTTPOnly:      domainCfg.HTTPOnly,
-				ResultLimit:   domainCfg.Limit,
-			},
+		if query.matches(domainCfg.Domain) {
+			matched = append(matched, domainCfg)
 		}
-		if snap, ok := snapshot[domainCfg.Domain]; ok {
+	}
+	resp.Total = len(matched)
+	matched = paginate(matched, query.offset, query.limit)
+	resp.Domains = make([]domainStatus, 0, len(matched))
+	for _, domainCfg := range matched {
+		entry := domainStatus{Domain: domainCfg.Domain}
+		snap, hasSnap := snapshot[domainCfg.Domain]
+		if query.has(fieldIPs) {
+			entry.IPs = ipsToStrings(recordIPs(snap.Records))
+		}
+		if query.has(fieldRecords) {
+			entry.Records = buildRecordViews(snap.Records, domainCfg.ConfidenceHalfLife, resp.GeneratedAt)
+			if hasSnap {
+				entry.PoolSize = &snap.PoolSize
+			}
+		}
+		if query.has(fieldLastUpdate) && hasSnap {
 			entry.LastUpdate = snap.UpdatedAt.Format(time.RFC3339)
-			entry.IPs = ipsToStrings(snap.IPs)
+			entry.Generation = snap.Generation
+		}
+		if query.has(fieldConfig) {
+			entry.Config = buildConfigView(domainCfg)
 		}
 		resp.Domains = append(resp.Domains, entry)
 	}
 	return resp
 }
 
+func buildConfigView(domainCfg *config.ScanConfig) *configView {
+	return &configView{
+		Domain:        domainCfg.Domain,
+		CIDRs:         domainCfg.CIDRs,
+		SNI:           domainCfg.SNI,
+		Timeout:       (time.Duration(domainCfg.Timeout) * time.Nanosecond).String(),
+		Port:          domainCfg.Port,
+		Path:          domainCfg.Path,
+		StatusCode:    domainCfg.StatusCode,
+		SamplesMin:    domainCfg.SamplesMinimum,
+		SamplesMax:    domainCfg.SamplesMaximum,
+		SamplesChance: domainCfg.SamplesChance,
+		HTTPOnly:      domainCfg.HTTPOnly,
+		ResultLimit:   domainCfg.Limit,
+		LatencyFactor: domainCfg.LatencyFactor,
+		Enabled:       domainCfg.IsEnabled(),
+		Paused:        domainCfg.Paused,
+		PausedResp:    domainCfg.PausedResponse,
+	}
+}
+
+func buildRecordViews(records []Record, halfLife time.Duration, now time.Time) []recordView {
+	out := make([]recordView, len(records))
+	candidates := make([]policy.Candidate, len(records))
+	for i, r := range records {
+		out[i] = recordView{
+			IP:          r.IP.String(),
+			Latency:     r.Latency.String(),
+			ValidatedAt: r.ValidatedAt.Format(time.RFC3339),
+			Confidence:  r.confidenceAt(now, halfLife),
+			Draining:    !r.DroppedAt.IsZero(),
+		}
+		candidates[i] = policy.Candidate{IP: r.IP, Latency: r.Latency, Confidence: out[i].Confidence}
+	}
+	for i, weight := range candidateWeights(candidates) {
+		out[i].Weight = weight
+	}
+	return out
+}
+
 func ipsToStrings(ips []net.IP) []string {
 	out := make([]string, len(ips))
 	for i, ip := range ips {
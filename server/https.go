@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/policy"
+)
+
+// httpsPort is the port clients assume for HTTPS records without a port parameter.
+const httpsPort = 443
+
+// servesHTTPS tells whether qtype is answered with an HTTPS record for domainCfg.
+func servesHTTPS(domainCfg *config.ScanConfig, qtype uint16) bool {
+	return domainCfg != nil && domainCfg.HTTPS.Enabled && (qtype == dns.TypeHTTPS || qtype == dns.TypeSVCB)
+}
+
+// answerHTTPS answers an HTTPS or SVCB query for key with a single service record at the owner
+// name, hinting the addresses selected for the client. Both families are selected in one step,
+// so the answer policy advances once per query as it does for A and AAAA queries.
+func (d *Handler) answerHTTPS(
+	msg *dns.Msg,
+	key string,
+	candidates []policy.Candidate,
+	client clientPolicy,
+	clientIP net.IP,
+) *dns.Msg {
+	domainCfg := d.domains[key]
+	name, qtype := msg.Question[0].Name, msg.Question[0].Qtype
+	selected := d.selectAnswers(name, qtype, key, candidates, client, clientIP)
+	v4, v6 := candidatesOfFamily(selected, dns.TypeA), candidatesOfFamily(selected, dns.TypeAAAA)
+	if len(v4) == 0 && len(v6) == 0 {
+		return msg
+	}
+	svcb := dns.SVCB{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: qtype,
+			Class:  dns.ClassINET,
+			Ttl:    client.ttl,
+		},
+		// Service mode, the records describe name itself.
+		Priority: 1,
+		Target:   ".",
+	}
+	// SvcParams must be in increasing key order.
+	if len(domainCfg.HTTPS.ALPN) > 0 {
+		svcb.Value = append(svcb.Value, &dns.SVCBAlpn{Alpn: domainCfg.HTTPS.ALPN})
+	}
+	if port := domainCfg.HTTPSPort(); port != httpsPort {
+		svcb.Value = append(svcb.Value, &dns.SVCBPort{Port: port})
+	}
+	if len(v4) > 0 {
+		svcb.Value = append(svcb.Value, &dns.SVCBIPv4Hint{Hint: candidateIPs(v4)})
+	}
+	if len(v6) > 0 {
+		svcb.Value = append(svcb.Value, &dns.SVCBIPv6Hint{Hint: candidateIPs(v6)})
+	}
+	if qtype == dns.TypeHTTPS {
+		msg.Answer = append(msg.Answer, &dns.HTTPS{SVCB: svcb})
+		return msg
+	}
+	msg.Answer = append(msg.Answer, &svcb)
+	return msg
+}
+
+func candidateIPs(candidates []policy.Candidate) []net.IP {
+	ips := make([]net.IP, len(candidates))
+	for i, c := range candidates {
+		ips[i] = c.IP
+	}
+	return ips
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/policy"
+)
+
+func TestResolveHTTPSHints(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{
+		Domain:          key,
+		Port:            8443,
+		ConfidenceBoost: 1,
+		HTTPS:           config.HTTPSConfig{Enabled: true, ALPN: []string{"h2", "http/1.1"}},
+	}
+	h.UpdateRecords(key, []Record{
+		{IP: net.IPv4(192, 0, 2, 1).To4()},
+		{IP: net.ParseIP("2001:db8::1")},
+	})
+
+	query := new(dns.Msg)
+	query.SetQuestion(key, dns.TypeHTTPS)
+	msg := h.resolve(query, queryClient{}, zap.NewNop())
+	if len(msg.Answer) != 1 {
+		t.Fatalf("answer = %v, want a single HTTPS record", msg.Answer)
+	}
+	rr, ok := msg.Answer[0].(*dns.HTTPS)
+	if !ok {
+		t.Fatalf("answer = %T, want *dns.HTTPS", msg.Answer[0])
+	}
+	if rr.Priority != 1 || rr.Target != "." {
+		t.Fatalf("record = %v, want a service mode record for the owner", rr)
+	}
+	want := []string{"alpn=h2,http/1.1", "port=8443", "ipv4hint=192.0.2.1", "ipv6hint=2001:db8::1"}
+	if len(rr.Value) != len(want) {
+		t.Fatalf("params = %v, want %v", rr.Value, want)
+	}
+	for i, kv := range rr.Value {
+		if got := kv.Key().String() + "=" + kv.String(); got != want[i] {
+			t.Fatalf("param %d = %s, want %s", i, got, want[i])
+		}
+	}
+	if _, err := msg.Pack(); err != nil {
+		t.Fatalf("Pack() = %v", err)
+	}
+}
+
+func TestResolveHTTPSDisabled(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{Domain: key, ConfidenceBoost: 1}
+	h.UpdateRecords(key, []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+
+	query := new(dns.Msg)
+	query.SetQuestion(key, dns.TypeSVCB)
+	msg := h.resolve(query, queryClient{}, zap.NewNop())
+	if msg.Rcode != dns.RcodeSuccess || len(msg.Answer) != 0 {
+		t.Fatalf("rcode = %d, answer = %v, want NODATA", msg.Rcode, msg.Answer)
+	}
+}
+
+func TestResolveHTTPSAdvancesPolicyOnce(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{
+		Domain:          key,
+		Port:            httpsPort,
+		ConfidenceBoost: 1,
+		HTTPS:           config.HTTPSConfig{Enabled: true},
+	}
+	rr, err := policy.New(policy.RoundRobin, policy.Options{})
+	if err != nil {
+		t.Fatalf("New(round_robin) returned error: %v", err)
+	}
+	h.policies[key] = rr
+	h.UpdateRecords(key, []Record{
+		{IP: net.IPv4(192, 0, 2, 1).To4()},
+		{IP: net.IPv4(192, 0, 2, 2).To4()},
+		{IP: net.ParseIP("2001:db8::1")},
+	})
+
+	first := func(qtype uint16) string {
+		t.Helper()
+		query := new(dns.Msg)
+		query.SetQuestion(key, qtype)
+		msg := h.resolve(query, queryClient{}, zap.NewNop())
+		if len(msg.Answer) == 0 {
+			t.Fatalf("%s answer is empty", dns.TypeToString[qtype])
+		}
+		if a, ok := msg.Answer[0].(*dns.A); ok {
+			return a.A.String()
+		}
+		return msg.Answer[0].(*dns.HTTPS).Value[0].(*dns.SVCBIPv4Hint).Hint[0].String()
+	}
+	// The HTTPS query takes a single turn, so the next A query starts at the second address.
+	https, a := first(dns.TypeHTTPS), first(dns.TypeA)
+	if https == a {
+		t.Fatalf("A query after an HTTPS query served %s first again, want the next address", a)
+	}
+}
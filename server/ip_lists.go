@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"iter"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/go-tools/log"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// ipLists holds the IPs of the ip_lists files, a denied IP is never probed nor served, and when
+// allow files list any IP the others are not either. A nil value allows every IP.
+type ipLists struct {
+	allowFiles []string
+	denyFiles  []string
+	interval   time.Duration
+	acl        atomic.Pointer[clientACL]
+	// versions identifies the content of every file at the last load.
+	versions map[string]fileVersion
+}
+
+// fileVersion tells whether a file changed since it was loaded.
+type fileVersion struct {
+	modTime int64
+	size    int64
+}
+
+// newIPLists loads the files of cfg, it returns nil when none is configured.
+func newIPLists(cfg config.IPListsConfig) (*ipLists, error) {
+	if len(cfg.Allow) == 0 && len(cfg.Deny) == 0 {
+		return nil, nil
+	}
+	l := &ipLists{allowFiles: cfg.Allow, denyFiles: cfg.Deny, interval: cfg.ReloadInterval}
+	if _, err := l.reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// allows reports whether ip may be probed and served.
+func (l *ipLists) allows(ip net.IP) bool {
+	return l == nil || l.acl.Load().allows(ip)
+}
+
+// filter drops the IPs that are not allowed from samples.
+func (l *ipLists) filter(samples []iter.Seq[net.IP]) []iter.Seq[net.IP] {
+	if l == nil {
+		return samples
+	}
+	out := make([]iter.Seq[net.IP], len(samples))
+	for i, seq := range samples {
+		out[i] = func(yield func(net.IP) bool) {
+			for ip := range seq {
+				if l.allows(ip) && !yield(ip) {
+					return
+				}
+			}
+		}
+	}
+	return out
+}
+
+// Run polls the files every interval and reloads them when one changed, removing the records of
+// h that are no longer allowed. A file failing to load keeps the previous lists.
+func (l *ipLists) Run(ctx context.Context, h *Handler) error {
+	if l == nil {
+		return nil
+	}
+	logger := log.Of(ctx).With(zap.String("component", "ip_lists"))
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if !l.changed() {
+			continue
+		}
+		acl, err := l.reload()
+		if err != nil {
+			logger.Warn("failed to reload ip lists, keeping the current ones", zap.Error(err))
+			h.reporter.Capture(err, map[string]string{"component": "ip_lists"})
+			continue
+		}
+		removed := h.pruneRecords(acl)
+		logger.Info("ip lists reloaded", zap.Int("removed_records", removed))
+	}
+}
+
+// changed reports whether a file was modified, created or removed since the last load.
+func (l *ipLists) changed() bool {
+	for _, path := range slices.Concat(l.allowFiles, l.denyFiles) {
+		if statFile(path) != l.versions[path] {
+			return true
+		}
+	}
+	return false
+}
+
+// statFile returns the version of the file at path, the zero version if it cannot be read.
+func statFile(path string) fileVersion {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}
+	}
+	return fileVersion{modTime: info.ModTime().UnixNano(), size: info.Size()}
+}
+
+// reload reads every file and swaps the lists in, it returns the new lists. The versions of the
+// files are recorded even when one fails to load, so it is only retried once changed again.
+func (l *ipLists) reload() (*clientACL, error) {
+	l.versions = make(map[string]fileVersion, len(l.allowFiles)+len(l.denyFiles))
+	for _, path := range slices.Concat(l.allowFiles, l.denyFiles) {
+		l.versions[path] = statFile(path)
+	}
+	allow, err := readIPListFiles(l.allowFiles)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := readIPListFiles(l.denyFiles)
+	if err != nil {
+		return nil, err
+	}
+	acl := newClientACL(allow, deny)
+	l.acl.Store(acl)
+	return acl, nil
+}
+
+// readIPListFiles parses the networks listed in the files of paths.
+func readIPListFiles(paths []string) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	for _, path := range paths {
+		f, err := os.Open(path) //nolint:gosec // the ip_lists files are configured by the operator
+		if err != nil {
+			return nil, err
+		}
+		out, err = parseIPList(f, out)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("ip list %s: %w", path, err)
+		}
+	}
+	return out, nil
+}
+
+// parseIPList appends the networks listed in r to out. Lines hold an IP or a CIDR, empty lines
+// and the text after a # are ignored.
+func parseIPList(r io.Reader, out []*net.IPNet) ([]*net.IPNet, error) {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		network, err := parseIPOrCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		out = append(out, network)
+	}
+	return out, scanner.Err()
+}
+
+// parseIPOrCIDR parses a CIDR, or an IP as the network holding it only.
+func parseIPOrCIDR(entry string) (*net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		return network, err
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP %q", entry)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(ipv4Bits, ipv4Bits)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(ipv6Bits, ipv6Bits)}, nil
+}
+
+// pruneRecords removes the records of every domain that acl does not allow, it returns how many
+// records were removed.
+func (d *Handler) pruneRecords(acl *clientACL) int {
+	now := time.Now()
+	defer d.serials.Persist()
+	d.rwMux.Lock()
+	defer d.rwMux.Unlock()
+	removed := 0
+	for key := range d.domains {
+		records, ok := d.store.Get(key)
+		if !ok {
+			continue
+		}
+		kept := make([]Record, 0, len(records))
+		for _, r := range records {
+			if acl.allows(r.IP) {
+				kept = append(kept, r)
+			}
+		}
+		if len(kept) == len(records) {
+			continue
+		}
+		removed += len(records) - len(kept)
+		d.store.Update(key, kept, now)
+		updateRecordMetrics(key, len(kept), now)
+		d.bumpSerial(key)
+	}
+	return removed
+}
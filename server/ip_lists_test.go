@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"iter"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestParseIPList(t *testing.T) {
+	t.Parallel()
+
+	networks, err := parseIPList(strings.NewReader(`
+# known-blocked edges
+192.0.2.0/24
+198.51.100.7   # single IP
+
+2001:db8::1
+`), nil)
+	if err != nil {
+		t.Fatalf("parseIPList() returned error: %v", err)
+	}
+	var got []string
+	for _, n := range networks {
+		got = append(got, n.String())
+	}
+	if want := []string{"192.0.2.0/24", "198.51.100.7/32", "2001:db8::1/128"}; !slices.Equal(got, want) {
+		t.Fatalf("parseIPList() = %v, want %v", got, want)
+	}
+
+	if _, err := parseIPList(strings.NewReader("192.0.2.0/24\nedge.example.com\n"), nil); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("parseIPList() error = %v, want the invalid line", err)
+	}
+}
+
+func TestIPListsFilterAndReload(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	allowPath := filepath.Join(dir, "allow.txt")
+	denyPath := filepath.Join(dir, "deny.txt")
+	writeList := func(path, body string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatalf("write ip list: %v", err)
+		}
+	}
+	writeList(allowPath, "192.0.2.0/24\n")
+	writeList(denyPath, "192.0.2.1\n")
+
+	lists, err := newIPLists(config.IPListsConfig{
+		Allow:          []string{allowPath},
+		Deny:           []string{denyPath},
+		ReloadInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("newIPLists() returned error: %v", err)
+	}
+	samples := lists.filter([]iter.Seq[net.IP]{slices.Values([]net.IP{
+		net.ParseIP("192.0.2.1"),
+		net.ParseIP("192.0.2.2"),
+		net.ParseIP("198.51.100.1"),
+	})})
+	if got := slices.Collect(samples[0]); len(got) != 1 || !got[0].Equal(net.ParseIP("192.0.2.2")) {
+		t.Fatalf("filter() = %v, want only the allowed IP that is not denied", got)
+	}
+
+	const key = edgeDomain
+	h := newTestHandler(t)
+	h.ipLists = lists
+	h.domains[key] = &config.ScanConfig{Domain: key}
+	h.UpdateRecords(key, []Record{
+		{IP: net.ParseIP("192.0.2.1")},
+		{IP: net.ParseIP("192.0.2.2")},
+		{IP: net.ParseIP("192.0.2.3")},
+		{IP: net.ParseIP("198.51.100.1")},
+	})
+	if got := h.servedIPs(key); len(got) != 2 {
+		t.Fatalf("served IPs = %v, want the allowed IPs only", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = lists.Run(ctx, h)
+	}()
+	writeList(denyPath, "192.0.2.1\n192.0.2.3\n")
+	deadline := time.Now().Add(2 * time.Second)
+	for len(h.servedIPs(key)) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("served IPs = %v after the deny list changed, want 192.0.2.2 only", h.servedIPs(key))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	writeList(denyPath, "not an IP\n")
+	time.Sleep(50 * time.Millisecond)
+	if lists.allows(net.ParseIP("192.0.2.3")) {
+		t.Fatal("a list failing to reload replaced the current one")
+	}
+	cancel()
+	<-done
+}
+
+func TestIPListsFilterFallbackAndStatic(t *testing.T) {
+	t.Parallel()
+
+	denyPath := filepath.Join(t.TempDir(), "deny.txt")
+	if err := os.WriteFile(denyPath, []byte("192.0.2.1\n"), 0o600); err != nil {
+		t.Fatalf("write ip list: %v", err)
+	}
+	lists, err := newIPLists(config.IPListsConfig{Deny: []string{denyPath}, ReloadInterval: time.Minute})
+	if err != nil {
+		t.Fatalf("newIPLists() returned error: %v", err)
+	}
+	h := newTestHandler(t)
+	h.ipLists = lists
+	h.domains["paused.example.com."] = &config.ScanConfig{
+		Domain:         "paused.example.com.",
+		Paused:         true,
+		PausedResponse: config.PausedFallback,
+		FallbackIPs:    []string{"192.0.2.1", "192.0.2.3"},
+	}
+	h.static = buildStaticRecords([]config.StaticRecord{
+		{Name: "static.example.com.", Type: "A", Value: "192.0.2.1", TTL: time.Minute},
+		{Name: "static.example.com.", Type: "A", Value: "192.0.2.2", TTL: time.Minute},
+	})
+
+	for name, want := range map[string]string{"paused.example.com.": "192.0.2.3", "static.example.com.": "192.0.2.2"} {
+		query := new(dns.Msg)
+		query.SetQuestion(name, dns.TypeA)
+		msg := h.resolve(query, queryClient{}, zap.NewNop())
+		if len(msg.Answer) != 1 || msg.Answer[0].(*dns.A).A.String() != want {
+			t.Fatalf("resolve(%s) = %v, want only %s, the denied IP left out", name, msg.Answer, want)
+		}
+	}
+}
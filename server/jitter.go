@@ -0,0 +1,31 @@
+package server
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// scanStartDelay returns how long the scan of a domain waits after the start of its cycle, a
+// random duration below jitter so domains sharing an interval do not probe in one burst.
+func scanStartDelay(jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	return rand.N(jitter) //nolint:gosec // jitter needs no cryptographic randomness
+}
+
+// waitScanStart blocks for delay, it reports false if ctx is done first.
+func waitScanStart(ctx context.Context, delay time.Duration) bool {
+	if delay <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
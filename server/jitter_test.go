@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScanStartDelay(t *testing.T) {
+	t.Parallel()
+
+	if delay := scanStartDelay(0); delay != 0 {
+		t.Fatalf("scanStartDelay(0) = %v, want no delay", delay)
+	}
+	seen := make(map[time.Duration]bool)
+	for range 100 {
+		delay := scanStartDelay(time.Minute)
+		if delay < 0 || delay >= time.Minute {
+			t.Fatalf("scanStartDelay(1m) = %v, want it within [0, 1m)", delay)
+		}
+		seen[delay] = true
+	}
+	if len(seen) < 2 {
+		t.Fatal("scanStartDelay() returned the same delay for every domain")
+	}
+}
+
+func TestWaitScanStartStopsWithContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if waitScanStart(ctx, time.Minute) {
+		t.Fatal("waitScanStart() = true, want false once ctx is done")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("waitScanStart() waited out the delay after ctx was done")
+	}
+	if !waitScanStart(context.Background(), time.Millisecond) {
+		t.Fatal("waitScanStart() = false, want true after the delay")
+	}
+}
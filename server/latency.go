@@ -0,0 +1,132 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// latencyHistorySize is the number of publishes whose latencies are kept per domain.
+const latencyHistorySize = 60
+
+// latencyColumn holds the latencies measured for the fresh records of one publish, by IP.
+type latencyColumn struct {
+	at        time.Time
+	latencies map[string]time.Duration
+}
+
+// latencyRing is a fixed size ring buffer of the latest columns of a domain.
+type latencyRing struct {
+	columns [latencyHistorySize]latencyColumn
+	next    int
+	count   int
+}
+
+// ordered returns the columns of the ring, oldest first.
+func (r *latencyRing) ordered() []latencyColumn {
+	out := make([]latencyColumn, 0, r.count)
+	start := (r.next - r.count + latencyHistorySize) % latencyHistorySize
+	for i := range r.count {
+		out = append(out, r.columns[(start+i)%latencyHistorySize])
+	}
+	return out
+}
+
+// latencyHistory keeps the recent latency measurements of the served IPs of every domain.
+// A nil history records nothing.
+type latencyHistory struct {
+	mu      sync.Mutex
+	domains map[string]*latencyRing
+}
+
+func newLatencyHistory() *latencyHistory {
+	return &latencyHistory{domains: make(map[string]*latencyRing)}
+}
+
+// record appends the latencies of records to the history of key, records without a measured
+// latency, such as manual or static ones, are skipped.
+func (h *latencyHistory) record(key string, records []Record, at time.Time) {
+	if h == nil {
+		return
+	}
+	latencies := make(map[string]time.Duration, len(records))
+	for _, r := range records {
+		if r.Latency > 0 {
+			latencies[r.IP.String()] = r.Latency
+		}
+	}
+	if len(latencies) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ring, ok := h.domains[key]
+	if !ok {
+		ring = new(latencyRing)
+		h.domains[key] = ring
+	}
+	ring.columns[ring.next] = latencyColumn{at: at, latencies: latencies}
+	ring.next = (ring.next + 1) % latencyHistorySize
+	ring.count = min(ring.count+1, latencyHistorySize)
+}
+
+type latencyResponse struct {
+	GeneratedAt time.Time       `json:"generated_at"`
+	Domains     []domainLatency `json:"domains"`
+}
+
+// domainLatency is the latency matrix of a domain: IPs maps every IP served within the window
+// to its latency in milliseconds at each of Times, null where it was not served.
+type domainLatency struct {
+	Domain string                `json:"domain"`
+	Times  []time.Time           `json:"times"`
+	IPs    map[string][]*float64 `json:"ips"`
+}
+
+// view returns the latency matrix of every domain of keys.
+func (h *latencyHistory) view(keys []string) []domainLatency {
+	out := make([]domainLatency, 0, len(keys))
+	for _, key := range keys {
+		entry := domainLatency{Domain: key, Times: []time.Time{}, IPs: make(map[string][]*float64)}
+		var columns []latencyColumn
+		if h != nil {
+			h.mu.Lock()
+			if ring, ok := h.domains[key]; ok {
+				columns = ring.ordered()
+			}
+			h.mu.Unlock()
+		}
+		for i, column := range columns {
+			entry.Times = append(entry.Times, column.at)
+			for ip, latency := range column.latencies {
+				row, ok := entry.IPs[ip]
+				if !ok {
+					row = make([]*float64, len(columns))
+					entry.IPs[ip] = row
+				}
+				ms := float64(latency) / float64(time.Millisecond)
+				row[i] = &ms
+			}
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+// handleLatency answers /api/latency with the latency matrix of the configured domains, limited to
+// those selected through ?domain=.
+func handleLatency(w http.ResponseWriter, r *http.Request, handler *Handler) {
+	query := statusQuery{domains: splitList(r.URL.Query()["domain"])}
+	keys := make([]string, 0, len(handler.domains))
+	for key := range handler.domains {
+		if query.matches(key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	writeJSON(w, http.StatusOK, latencyResponse{
+		GeneratedAt: time.Now(),
+		Domains:     handler.latencies.view(keys),
+	})
+}
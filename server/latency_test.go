@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestHandleLatencyReturnsMatrix(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.latencies = newLatencyHistory()
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{Domain: key}
+	h.domains["other.example.com."] = &config.ScanConfig{Domain: "other.example.com."}
+	h.UpdateRecords(key, []Record{
+		{IP: net.IPv4(192, 0, 2, 1).To4(), Latency: 20 * time.Millisecond},
+		{IP: net.IPv4(192, 0, 2, 2).To4(), Latency: 40 * time.Millisecond},
+	})
+	h.UpdateRecords(key, []Record{
+		{IP: net.IPv4(192, 0, 2, 2).To4(), Latency: 30 * time.Millisecond},
+		{IP: net.IPv4(192, 0, 2, 3).To4()},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/latency?domain="+key, http.NoBody)
+	rec := httptest.NewRecorder()
+	handleLatency(rec, req, h)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var resp latencyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Domains) != 1 || resp.Domains[0].Domain != key {
+		t.Fatalf("domains = %+v, want only %s", resp.Domains, key)
+	}
+	matrix := resp.Domains[0]
+	if len(matrix.Times) != 2 {
+		t.Fatalf("times = %v, want a column per publish", matrix.Times)
+	}
+	if first := rowValues(matrix.IPs["192.0.2.1"]); !slices.Equal(first, []float64{20, -1}) {
+		t.Fatalf("192.0.2.1 = %v, want 20ms then no measurement", first)
+	}
+	if second := rowValues(matrix.IPs["192.0.2.2"]); !slices.Equal(second, []float64{40, 30}) {
+		t.Fatalf("192.0.2.2 = %v, want 40ms then 30ms", second)
+	}
+	if _, ok := matrix.IPs["192.0.2.3"]; ok {
+		t.Fatal("a record without a measured latency is part of the matrix")
+	}
+}
+
+// rowValues returns the latencies of row, -1 for the columns without a measurement.
+func rowValues(row []*float64) []float64 {
+	values := make([]float64, len(row))
+	for i, v := range row {
+		values[i] = -1
+		if v != nil {
+			values[i] = *v
+		}
+	}
+	return values
+}
+
+func TestLatencyHistoryKeepsLatestColumns(t *testing.T) {
+	t.Parallel()
+
+	h := newLatencyHistory()
+	start := time.Now()
+	for i := range latencyHistorySize + 5 {
+		h.record("edge.example.com.", []Record{
+			{IP: net.IPv4(192, 0, 2, 1).To4(), Latency: time.Duration(i+1) * time.Millisecond},
+		}, start.Add(time.Duration(i)*time.Second))
+	}
+	view := h.view([]string{"edge.example.com."})[0]
+	if len(view.Times) != latencyHistorySize {
+		t.Fatalf("kept %d columns, want %d", len(view.Times), latencyHistorySize)
+	}
+	row := view.IPs["192.0.2.1"]
+	if *row[0] != 6 || *row[len(row)-1] != latencyHistorySize+5 {
+		t.Fatalf("row spans %vms to %vms, want the latest %d measurements", *row[0], *row[len(row)-1], latencyHistorySize)
+	}
+	if !view.Times[0].Before(view.Times[len(view.Times)-1]) {
+		t.Fatal("columns are not ordered oldest first")
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/go-tools/log"
+)
+
+// defaultStopTimeout bounds how long a component may take to stop once asked to.
+const defaultStopTimeout = 5 * time.Second
+
+// component is a long running part of the server, it runs until its context is canceled.
+// Returning before that is fine for components with nothing to do, returning an error
+// stops the server.
+type component struct {
+	name string
+	run  func(ctx context.Context) error
+	// stopTimeout bounds how long the component may take to stop, defaultStopTimeout when zero.
+	stopTimeout time.Duration
+}
+
+// lifecycle starts components stage by stage and stops the stages in reverse order, so the
+// components a stage depends on keep running until it stopped. For example, listeners stop
+// once the scanner publishing their records did, and query log sinks once the listeners did.
+type lifecycle struct {
+	stages [][]component
+}
+
+// stage adds a stage of components depending on the stages added before it.
+func (l *lifecycle) stage(components ...component) {
+	l.stages = append(l.stages, components)
+}
+
+// runningComponent is a started component.
+type runningComponent struct {
+	component
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Run starts every stage and blocks until ctx is done or a component failed, then stops the
+// stages in reverse order. It returns the error of the first component that failed.
+func (l *lifecycle) Run(ctx context.Context) error {
+	logger := log.Of(ctx)
+	var (
+		failOnce sync.Once
+		failed   = make(chan struct{})
+		failure  error
+	)
+	stages := make([][]*runningComponent, len(l.stages))
+	for i, components := range l.stages {
+		for _, c := range components {
+			// Components are stopped by their stage, not as soon as ctx is done.
+			componentCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+			running := &runningComponent{component: c, cancel: cancel, done: make(chan struct{})}
+			stages[i] = append(stages[i], running)
+			go func() {
+				defer close(running.done)
+				if err := c.run(componentCtx); err != nil && componentCtx.Err() == nil {
+					failOnce.Do(func() {
+						failure = err
+						logger.Error("component failed", zap.String("component", c.name), zap.Error(err))
+						close(failed)
+					})
+				}
+			}()
+			logger.Debug("component started", zap.String("component", c.name))
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		logger.Info("shutting down")
+	case <-failed:
+		logger.Info("shutting down after a component failed")
+	}
+	for i := len(stages) - 1; i >= 0; i-- {
+		var stopped sync.WaitGroup
+		for _, running := range stages[i] {
+			running.cancel()
+			stopped.Go(func() { running.wait(logger) })
+		}
+		stopped.Wait()
+	}
+	return failure
+}
+
+// wait waits for a canceled component to stop, giving up after its stop timeout.
+func (r *runningComponent) wait(logger *zap.Logger) {
+	logger = logger.With(zap.String("component", r.name))
+	timeout := cmp.Or(r.stopTimeout, defaultStopTimeout)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	start := time.Now()
+	select {
+	case <-r.done:
+		logger.Info("component stopped", zap.Duration("duration", time.Since(start)))
+	case <-timer.C:
+		logger.Warn("component did not stop in time, leaving it behind", zap.Duration("timeout", timeout))
+	}
+}
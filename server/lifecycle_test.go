@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLifecycleStopsStagesInReverseOrder(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu      sync.Mutex
+		stopped []string
+	)
+	started := make(chan struct{}, 3)
+	blocking := func(name string) component {
+		return component{name: name, run: func(ctx context.Context) error {
+			started <- struct{}{}
+			<-ctx.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			stopped = append(stopped, name)
+			return ctx.Err()
+		}}
+	}
+	var l lifecycle
+	l.stage(blocking("sink"))
+	l.stage(blocking("listener"))
+	l.stage(blocking("scanner"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- l.Run(ctx) }()
+	for range 3 {
+		<-started
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() returned error: %v", err)
+	}
+	if want := []string{"scanner", "listener", "sink"}; !slices.Equal(stopped, want) {
+		t.Fatalf("stop order = %v, want %v", stopped, want)
+	}
+}
+
+func TestLifecycleStopsOnFailure(t *testing.T) {
+	t.Parallel()
+
+	errBind := errors.New("bind failed")
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	var l lifecycle
+	l.stage(component{name: "sink", run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}})
+	l.stage(component{name: "listener", run: func(context.Context) error { return errBind }})
+	// A component that ignores its context is left behind once its stop timeout elapsed.
+	l.stage(component{name: "stuck", run: func(context.Context) error {
+		<-release
+		return nil
+	}, stopTimeout: 10 * time.Millisecond})
+
+	if err := l.Run(context.Background()); !errors.Is(err, errBind) {
+		t.Fatalf("Run() error = %v, want the error of the failed component", err)
+	}
+}
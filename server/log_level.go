@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/helios-dns/loglevel"
+)
+
+type logLevelResponse struct {
+	Level string `json:"level"`
+}
+
+// registerLogLevelAPI exposes the level of levels, so debug logs can be turned on for a live instance.
+// The endpoint changing the level is only added with a token, which its requests must carry as a
+// bearer token.
+func registerLogLevelAPI(mux *http.ServeMux, levels *loglevel.Switch, token string, logger *zap.Logger) {
+	mux.HandleFunc("GET /api/log-level", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, logLevelResponse{Level: levels.Level().String()})
+	})
+	if token == "" {
+		return
+	}
+	mux.Handle("PUT /api/log-level", requireToken(token, func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("level") == "" {
+			levels.Toggle()
+		} else {
+			level, err := loglevel.Parse(r.FormValue("level"))
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+				return
+			}
+			levels.SetLevel(level)
+		}
+		logger.Warn("log level changed through the api", zap.Stringer("level", levels.Level()))
+		writeJSON(w, http.StatusOK, logLevelResponse{Level: levels.Level().String()})
+	}))
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/fmotalleb/helios-dns/loglevel"
+)
+
+func TestLogLevelAPI(t *testing.T) {
+	t.Parallel()
+
+	levels := loglevel.New(zapcore.InfoLevel)
+	mux := http.NewServeMux()
+	registerLogLevelAPI(mux, levels, "secret", zap.NewNop())
+
+	requestWithToken := func(method, target, token string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, http.NoBody)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		var resp logLevelResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp.Level
+	}
+	request := func(method, target string) (int, string) {
+		t.Helper()
+		return requestWithToken(method, target, "secret")
+	}
+	if code, level := requestWithToken(http.MethodGet, "/api/log-level", ""); code != http.StatusOK || level != "info" {
+		t.Fatalf("GET without a token = %d %q, want 200 info", code, level)
+	}
+	for _, token := range []string{"", "wrong"} {
+		if code, _ := requestWithToken(http.MethodPut, "/api/log-level?level=debug", token); code != http.StatusUnauthorized {
+			t.Fatalf("PUT with token %q = %d, want 401", token, code)
+		}
+	}
+	if levels.Level() != zapcore.InfoLevel {
+		t.Fatalf("level = %s, want info kept after unauthenticated requests", levels.Level())
+	}
+	if code, level := request(http.MethodPut, "/api/log-level"); code != http.StatusOK || level != "debug" {
+		t.Fatalf("PUT without level = %d %q, want the level toggled to debug", code, level)
+	}
+	if code, level := request(http.MethodPut, "/api/log-level?level=warn"); code != http.StatusOK || level != "warn" {
+		t.Fatalf("PUT level=warn = %d %q, want 200 warn", code, level)
+	}
+	if code, _ := request(http.MethodPut, "/api/log-level?level=loud"); code != http.StatusBadRequest {
+		t.Fatalf("PUT level=loud = %d, want 400", code)
+	}
+	if levels.Level() != zapcore.WarnLevel {
+		t.Fatalf("level = %s, want warn kept after an invalid request", levels.Level())
+	}
+
+	readOnly := http.NewServeMux()
+	registerLogLevelAPI(readOnly, levels, "", zap.NewNop())
+	rec := httptest.NewRecorder()
+	readOnly.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/log-level", http.NoBody))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("PUT without api_token = %d, want the endpoint disabled", rec.Code)
+	}
+}
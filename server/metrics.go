@@ -4,9 +4,17 @@ import (
 	"net"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// Probe duration buckets start at 5ms and double up to about 10s.
+const (
+	probeBucketStart  = 0.005
+	probeBucketFactor = 2
+	probeBucketCount  = 12
+)
+
 var (
 	recordCountGauge = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -27,7 +35,7 @@ var (
 			Name: "helios_dns_requests_total",
 			Help: "Total DNS requests received.",
 		},
-		[]string{"domain", "sni"},
+		[]string{"domain", "sni", "qtype"},
 	)
 	dnsAnswerCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -36,6 +44,20 @@ var (
 		},
 		[]string{"domain", "sni"},
 	)
+	dnsDomainResponseCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helios_dns_domain_responses_total",
+			Help: "Total DNS responses by domain, query type and response code.",
+		},
+		[]string{"domain", "sni", "qtype", "rcode"},
+	)
+	dnsResponseCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helios_dns_responses_total",
+			Help: "Total DNS responses by transport protocol, query type and response code, across every name.",
+		},
+		[]string{"protocol", "qtype", "rcode"},
+	)
 	dnsAnswerRecordsCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "helios_dns_answer_records_total",
@@ -57,6 +79,96 @@ var (
 		},
 		[]string{"domain", "sni"},
 	)
+	faultInjectedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helios_dns_chaos_faults_injected_total",
+			Help: "Total artificial faults injected into scans by the chaos mode.",
+		},
+		[]string{"domain", "fault"},
+	)
+	scanDeferredCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helios_dns_scan_deferred_total",
+			Help: "Total domain scans deferred because the probe budget was exhausted.",
+		},
+		[]string{"domain", "sni"},
+	)
+	zoneSerialGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helios_dns_zone_serial",
+			Help: "Current serial per zone.",
+		},
+		[]string{"zone"},
+	)
+	scanLatencyGatedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helios_dns_scan_latency_gated_total",
+			Help: "Total accepted IPs dropped by latency gating.",
+		},
+		[]string{"domain", "sni"},
+	)
+	scanBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helios_dns_scan_bytes_total",
+			Help: "Total bytes transferred by the native checks of scan probes, by direction (sent or received).",
+		},
+		[]string{"domain", "direction"},
+	)
+	scanCycleBytesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helios_dns_scan_cycle_bytes",
+			Help: "Bytes transferred by the native checks of the last scan of a domain, by direction (sent or received).",
+		},
+		[]string{"domain", "direction"},
+	)
+	scanCycleProbesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helios_dns_scan_cycle_probes",
+			Help: "Probes issued by the last scan of a domain.",
+		},
+		[]string{"domain"},
+	)
+	scanProbeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "helios_dns_scan_probe_duration_seconds",
+			Help:    "Duration of scan probes, with the update cycle as exemplar.",
+			Buckets: prometheus.ExponentialBuckets(probeBucketStart, probeBucketFactor, probeBucketCount),
+		},
+		[]string{"domain", "sni", "result"},
+	)
+	dnsWriteTimeoutCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helios_dns_write_timeouts_total",
+			Help: "Total DNS answers dropped because the client did not read them in time.",
+		},
+		[]string{"protocol"},
+	)
+	programRebuildCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helios_dns_program_rebuilds_total",
+			Help: "Total VM rebuilds because the rendered program of a domain changed at runtime.",
+		},
+		[]string{"domain"},
+	)
+	panicCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helios_dns_panics_total",
+			Help: "Total panics recovered by component, such as dns or scan.",
+		},
+		[]string{"component"},
+	)
+	outsideDroppedCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "helios_dns_outside_zones_dropped_total",
+			Help: "Total queries for names outside the served zones dropped by outside_zones_limit.",
+		},
+	)
+	dnsTruncatedCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "helios_dns_truncated_total",
+			Help: "Total UDP answers truncated with the TC bit set because they exceeded the client's payload size.",
+		},
+	)
 )
 
 func init() {
@@ -65,24 +177,97 @@ func init() {
 		lastUpdateGauge,
 		dnsRequestCounter,
 		dnsAnswerCounter,
+		dnsDomainResponseCounter,
+		dnsResponseCounter,
 		dnsAnswerRecordsCounter,
 		scanAcceptedCounter,
 		scanRejectedCounter,
+		scanLatencyGatedCounter,
+		scanProbeDuration,
+		scanBytesCounter,
+		scanCycleBytesGauge,
+		scanCycleProbesGauge,
+		zoneSerialGauge,
+		scanDeferredCounter,
+		faultInjectedCounter,
+		dnsWriteTimeoutCounter,
+		dnsTruncatedCounter,
+		programRebuildCounter,
+		panicCounter,
+		outsideDroppedCounter,
 	)
 }
 
-func updateRecordMetrics(domain string, records []net.IP, updatedAt time.Time) {
-	recordCountGauge.WithLabelValues(domain).Set(float64(len(records)))
+func updateRecordMetrics(domain string, recordCount int, updatedAt time.Time) {
+	recordCountGauge.WithLabelValues(domain).Set(float64(recordCount))
 	lastUpdateGauge.WithLabelValues(domain).Set(float64(updatedAt.Unix()))
 }
 
-func recordDNSRequest(domain string, sni string) {
-	dnsRequestCounter.WithLabelValues(domain, sni).Inc()
+func recordDNSRequest(domain string, sni string, qtype uint16) {
+	dnsRequestCounter.WithLabelValues(domain, sni, qtypeLabel(qtype)).Inc()
+}
+
+func recordWriteTimeout(protocol string) {
+	dnsWriteTimeoutCounter.WithLabelValues(protocol).Inc()
 }
 
-func recordDNSAnswer(domain string, sni string, recordCount int) {
-	dnsAnswerCounter.WithLabelValues(domain, sni).Inc()
-	dnsAnswerRecordsCounter.WithLabelValues(domain, sni).Add(float64(recordCount))
+func recordTruncated() {
+	dnsTruncatedCounter.Inc()
+}
+
+func recordDNSAnswer(domain string, sni string, qtype uint16, rcode int, recordCount int) {
+	dnsDomainResponseCounter.WithLabelValues(domain, sni, qtypeLabel(qtype), rcodeLabel(rcode)).Inc()
+	// The answers of served domains to address queries, as counted before responses were labeled.
+	if domain != otherLabel && rcode == dns.RcodeSuccess && (qtype == dns.TypeA || qtype == dns.TypeAAAA) {
+		dnsAnswerCounter.WithLabelValues(domain, sni).Inc()
+		dnsAnswerRecordsCounter.WithLabelValues(domain, sni).Add(float64(recordCount))
+	}
+}
+
+func recordDNSResponse(protocol string, qtype uint16, rcode int) {
+	dnsResponseCounter.WithLabelValues(protocolLabel(protocol), qtypeLabel(qtype), rcodeLabel(rcode)).Inc()
+}
+
+// otherLabel replaces unbounded label values in metrics.
+const otherLabel = "other"
+
+// trackedQtypes are the query types reported with their own metric label.
+var trackedQtypes = map[uint16]struct{}{
+	dns.TypeA:     {},
+	dns.TypeAAAA:  {},
+	dns.TypeHTTPS: {},
+	dns.TypeSVCB:  {},
+	dns.TypeCNAME: {},
+	dns.TypeMX:    {},
+	dns.TypeTXT:   {},
+	dns.TypeNS:    {},
+	dns.TypeSOA:   {},
+	dns.TypePTR:   {},
+	dns.TypeSRV:   {},
+	dns.TypeANY:   {},
+}
+
+func qtypeLabel(qtype uint16) string {
+	if _, ok := trackedQtypes[qtype]; ok {
+		return dns.TypeToString[qtype]
+	}
+	return otherLabel
+}
+
+// protocolLabel bounds the network of a client address, such as udp or tcp, to the served transports.
+func protocolLabel(network string) string {
+	switch network {
+	case "udp", "tcp":
+		return network
+	}
+	return otherLabel
+}
+
+func rcodeLabel(rcode int) string {
+	if name, ok := dns.RcodeToString[rcode]; ok {
+		return name
+	}
+	return otherLabel
 }
 
 func recordScanResult(domain string, sni string, accepted bool) {
@@ -92,3 +277,57 @@ func recordScanResult(domain string, sni string, accepted bool) {
 	}
 	scanRejectedCounter.WithLabelValues(domain, sni).Inc()
 }
+
+// recordProbeDuration observes the duration of a probe of ip, attaching the update cycle and ip
+// as exemplar so a latency spike leads to the logs of the cycle that probed it.
+func recordProbeDuration(domain string, sni string, accepted bool, duration time.Duration, cycleID string, ip net.IP) {
+	result := "rejected"
+	if accepted {
+		result = "accepted"
+	}
+	observer := scanProbeDuration.WithLabelValues(domain, sni, result)
+	if cycleID == "" {
+		observer.Observe(duration.Seconds())
+		return
+	}
+	observer.(prometheus.ExemplarObserver).ObserveWithExemplar(
+		duration.Seconds(),
+		prometheus.Labels{"cycle_id": cycleID, "ip": ip.String()},
+	)
+}
+
+func recordLatencyGated(domain string, sni string, count int) {
+	scanLatencyGatedCounter.WithLabelValues(domain, sni).Add(float64(count))
+}
+
+func updateZoneSerialMetric(zone string, serial uint32) {
+	zoneSerialGauge.WithLabelValues(zone).Set(float64(serial))
+}
+
+func recordScanDeferred(domain string, sni string) {
+	scanDeferredCounter.WithLabelValues(domain, sni).Inc()
+}
+
+func recordScanCost(domain string, probes int64, sent int64, received int64) {
+	scanBytesCounter.WithLabelValues(domain, "sent").Add(float64(sent))
+	scanBytesCounter.WithLabelValues(domain, "received").Add(float64(received))
+	scanCycleBytesGauge.WithLabelValues(domain, "sent").Set(float64(sent))
+	scanCycleBytesGauge.WithLabelValues(domain, "received").Set(float64(received))
+	scanCycleProbesGauge.WithLabelValues(domain).Set(float64(probes))
+}
+
+func recordProgramRebuild(domain string) {
+	programRebuildCounter.WithLabelValues(domain).Inc()
+}
+
+func recordPanic(component string) {
+	panicCounter.WithLabelValues(component).Inc()
+}
+
+func recordOutsideDropped() {
+	outsideDroppedCounter.Inc()
+}
+
+func recordFaultInjected(domain string, kind string) {
+	faultInjectedCounter.WithLabelValues(domain, kind).Inc()
+}
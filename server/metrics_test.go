@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestRecordProbeDurationAttachesExemplar(t *testing.T) {
+	t.Parallel()
+
+	domain := "exemplar.example.com."
+	recordProbeDuration(domain, "", true, 40*time.Millisecond, "cycle-1", net.IPv4(192, 0, 2, 1))
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather() returned error: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "helios_dns_scan_probe_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if metric.GetLabel()[0].GetValue() != domain {
+				continue
+			}
+			for _, bucket := range metric.GetHistogram().GetBucket() {
+				exemplar := bucket.GetExemplar()
+				if exemplar == nil {
+					continue
+				}
+				labels := make(map[string]string)
+				for _, l := range exemplar.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+				if labels["cycle_id"] != "cycle-1" || labels["ip"] != "192.0.2.1" {
+					t.Fatalf("exemplar labels = %v, want the cycle and the probed IP", labels)
+				}
+				return
+			}
+		}
+	}
+	t.Fatal("no exemplar recorded for the probe duration")
+}
+
+func TestServeDNSCountsResponses(t *testing.T) {
+	h := newTestHandler(t)
+	addr := startTestDNS(t, h)
+	counter := dnsResponseCounter.WithLabelValues("udp", "AAAA", "REFUSED")
+	before := testutil.ToFloat64(counter)
+
+	query := new(dns.Msg)
+	query.SetQuestion("unknown.example.org.", dns.TypeAAAA)
+	if _, err := dns.Exchange(query, addr); err != nil {
+		t.Fatalf("Exchange() returned error: %v", err)
+	}
+	if got := testutil.ToFloat64(counter) - before; got != 1 {
+		t.Fatalf("udp AAAA REFUSED responses grew by %v, want 1", got)
+	}
+}
+
+func TestServeDNSCountsDomainAnswers(t *testing.T) {
+	h := newTestHandler(t)
+	key := "metrics.example.com."
+	h.domains[key] = &config.ScanConfig{Domain: key, SNI: "metrics.example.com"}
+	h.UpdateRecords(key, []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}, {IP: net.IPv4(192, 0, 2, 2).To4()}})
+	addr := startTestDNS(t, h)
+	answers := dnsAnswerCounter.WithLabelValues(key, "metrics.example.com")
+	records := dnsAnswerRecordsCounter.WithLabelValues(key, "metrics.example.com")
+	aResponses := dnsDomainResponseCounter.WithLabelValues(key, "metrics.example.com", "A", "NOERROR")
+	txtResponses := dnsDomainResponseCounter.WithLabelValues(key, "metrics.example.com", "TXT", "NOERROR")
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeTXT} {
+		query := new(dns.Msg)
+		query.SetQuestion(key, qtype)
+		if _, err := dns.Exchange(query, addr); err != nil {
+			t.Fatalf("Exchange() returned error: %v", err)
+		}
+	}
+	if got := testutil.ToFloat64(answers); got != 1 {
+		t.Fatalf("answers = %v, want only the A answer counted", got)
+	}
+	if got := testutil.ToFloat64(records); got != 2 {
+		t.Fatalf("answer records = %v, want 2", got)
+	}
+	if a, txt := testutil.ToFloat64(aResponses), testutil.ToFloat64(txtResponses); a != 1 || txt != 1 {
+		t.Fatalf("domain responses = %v A and %v TXT, want 1 of each", a, txt)
+	}
+}
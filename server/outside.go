@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/privacy"
+)
+
+// outsideThrottle counts the queries of every client subnet for names outside the served zones
+// and drops them once a subnet sent more than limit within a window. A nil outsideThrottle drops
+// nothing.
+type outsideThrottle struct {
+	mu         sync.Mutex
+	limit      int
+	window     time.Duration
+	subnets    map[string]*outsideCount
+	anonymizer *privacy.Anonymizer
+}
+
+type outsideCount struct {
+	start   time.Time
+	queries int
+}
+
+// newOutsideThrottle returns the throttle of cfg, nil when it is disabled. Subnets are the ones
+// of /api/clients, anonymized by anonymizer.
+func newOutsideThrottle(cfg config.OutsideLimitConfig, anonymizer *privacy.Anonymizer) *outsideThrottle {
+	if cfg.Queries == 0 {
+		return nil
+	}
+	return &outsideThrottle{
+		limit:      cfg.Queries,
+		window:     cfg.Window,
+		subnets:    make(map[string]*outsideCount),
+		anonymizer: anonymizer,
+	}
+}
+
+// allow counts a query from ip and reports whether it may be answered. throttled is set for the
+// first query dropped in a window, so offenders are logged once per window.
+func (t *outsideThrottle) allow(ip net.IP, now time.Time) (allowed bool, subnet string, throttled bool) {
+	if t == nil {
+		return true, "", false
+	}
+	subnet = t.anonymizer.Subnet(ip, clientPrefixV4, clientPrefixV6)
+	if subnet == "" {
+		return true, "", false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	count, ok := t.subnets[subnet]
+	if !ok || now.Sub(count.start) >= t.window {
+		if !ok && len(t.subnets) >= maxClientSubnets {
+			t.prune(now)
+		}
+		count = &outsideCount{start: now}
+		t.subnets[subnet] = count
+	}
+	count.queries++
+	return count.queries <= t.limit, subnet, count.queries == t.limit+1
+}
+
+// prune drops the subnets whose window ended, or every subnet when none did. The caller must hold
+// the lock.
+func (t *outsideThrottle) prune(now time.Time) {
+	for subnet, count := range t.subnets {
+		if now.Sub(count.start) >= t.window {
+			delete(t.subnets, subnet)
+		}
+	}
+	if len(t.subnets) >= maxClientSubnets {
+		clear(t.subnets)
+	}
+}
+
+// outsideZones reports whether name is outside every zone and served name, so a query for it is
+// answered with the outside zones rcode.
+func (d *Handler) outsideZones(name string) bool {
+	if d.forwardUnknown {
+		return false
+	}
+	name = dns.CanonicalName(name)
+	if _, _, local := d.lookupDomain(name); local || d.servesOther(name) {
+		return false
+	}
+	return d.zoneFor(name) == nil && !d.hasDescendant(name) && !d.hasServedAncestor(name) && !d.hasRecords(name)
+}
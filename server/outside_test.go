@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestOutsideThrottle(t *testing.T) {
+	t.Parallel()
+
+	throttle := newOutsideThrottle(config.OutsideLimitConfig{Queries: 2, Window: time.Minute}, nil)
+	now := time.Now()
+	client := net.IPv4(192, 0, 2, 7)
+	for i, want := range []bool{true, true, false, false} {
+		allowed, subnet, throttled := throttle.allow(client, now)
+		if allowed != want || throttled != (i == 2) || subnet != "192.0.2.0/24" {
+			t.Fatalf("query %d: allow() = %v, %q, %v, want %v once the limit of the subnet is reached", i, allowed, subnet, throttled, want)
+		}
+	}
+	if allowed, _, _ := throttle.allow(net.IPv4(198, 51, 100, 1), now); !allowed {
+		t.Fatal("allow() dropped another subnet")
+	}
+	if allowed, _, _ := throttle.allow(client, now.Add(time.Minute)); !allowed {
+		t.Fatal("allow() dropped a query of the next window")
+	}
+}
+
+func TestServeDNSDropsThrottledOutsideQueries(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com."}
+	h.outside = newOutsideThrottle(config.OutsideLimitConfig{Queries: 1, Window: time.Minute}, nil)
+	addr := startTestDNS(t, h)
+	client := &dns.Client{Net: "udp", Timeout: 200 * time.Millisecond}
+	query := func(name string) (*dns.Msg, error) {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, dns.TypeA)
+		resp, _, err := client.Exchange(msg, addr)
+		return resp, err
+	}
+
+	if resp, err := query("victim.example.org."); err != nil || resp.Rcode != dns.RcodeRefused {
+		t.Fatalf("first outside query = %v, %v, want REFUSED", resp, err)
+	}
+	if resp, err := query("victim.example.org."); err == nil {
+		t.Fatalf("second outside query = %v, want it dropped", resp)
+	}
+	if resp, err := query("edge.example.com."); err != nil || resp.Rcode == dns.RcodeRefused {
+		t.Fatalf("served query = %v, %v, want it answered", resp, err)
+	}
+}
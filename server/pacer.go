@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// pacedFraction is the share of the interval paced probes are spread over,
+// leaving headroom so a cycle ends before the next one starts.
+const pacedFraction = 0.9
+
+// pacer spaces probes evenly across a cycle. A nil pacer never waits.
+type pacer struct {
+	mu      sync.Mutex
+	spacing time.Duration
+	next    time.Time
+}
+
+// newPacer returns a pacer issuing one probe every spacing, or nil if spacing is not positive.
+func newPacer(spacing time.Duration) *pacer {
+	if spacing <= 0 {
+		return nil
+	}
+	return &pacer{spacing: spacing}
+}
+
+// wait blocks until the next probe slot, it reports false if ctx is done first.
+func (p *pacer) wait(ctx context.Context) bool {
+	if p == nil {
+		return ctx.Err() == nil
+	}
+	p.mu.Lock()
+	slot := time.Now()
+	if p.next.After(slot) {
+		slot = p.next
+	}
+	p.next = slot.Add(p.spacing)
+	p.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(slot))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// plannedProbes estimates the probes of the next cycle: the configured budget, else the
+// previous cycle's count, else the sampling upper bound. Zero means unknown.
+func plannedProbes(cfg config.Config, lastProbes int64) int64 {
+	if cfg.MaxProbes > 0 {
+		return int64(cfg.MaxProbes)
+	}
+	if lastProbes > 0 {
+		return lastProbes
+	}
+	var total int64
+	for _, domainCfg := range cfg.Domains {
+		if domainCfg.Suspended() {
+			continue
+		}
+		if domainCfg.SamplesMaximum <= 0 {
+			return 0
+		}
+		total += int64(len(domainCfg.CIDRs) * domainCfg.SamplesMaximum)
+	}
+	return total
+}
+
+// cyclePacer returns the pacer of the next cycle, nil in fast mode or when the probe count is unknown.
+func cyclePacer(cfg config.Config, lastProbes int64) *pacer {
+	if cfg.ScanMode != config.ScanModePaced {
+		return nil
+	}
+	planned := plannedProbes(cfg, lastProbes)
+	if planned <= 0 {
+		return nil
+	}
+	window := time.Duration(float64(cfg.UpdateInterval) * pacedFraction)
+	return newPacer(window / time.Duration(planned))
+}
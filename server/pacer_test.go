@@ -0,0 +1,35 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestCyclePacer(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{
+		UpdateInterval: 100 * time.Second,
+		ScanMode:       config.ScanModePaced,
+		Domains: []*config.ScanConfig{
+			{CIDRs: []string{"192.0.2.0/24", "198.51.100.0/24"}, SamplesMaximum: 5},
+		},
+	}
+	if got := cyclePacer(cfg, 0).spacing; got != 9*time.Second {
+		t.Errorf("spacing from sampling bound = %s, want 9s", got)
+	}
+	if got := cyclePacer(cfg, 90).spacing; got != time.Second {
+		t.Errorf("spacing from last cycle = %s, want 1s", got)
+	}
+	cfg.MaxProbes = 900
+	if got := cyclePacer(cfg, 90).spacing; got != 100*time.Millisecond {
+		t.Errorf("spacing from probe budget = %s, want 100ms", got)
+	}
+
+	cfg.ScanMode = config.ScanModeFast
+	if p := cyclePacer(cfg, 90); p != nil {
+		t.Errorf("fast mode pacer = %+v, want nil", p)
+	}
+}
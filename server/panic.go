@@ -0,0 +1,25 @@
+package server
+
+import (
+	"runtime/debug"
+
+	"go.uber.org/zap"
+)
+
+// handlePanic logs a panic recovered in component with its stack trace, counts and reports it.
+// With crash_on_panic the panic propagates again, so the process exits as it would without recovery.
+// It must be called from the deferred function that recovered rec.
+func (d *Handler) handlePanic(component string, rec any, logger *zap.Logger) {
+	recordPanic(component)
+	logger.Error("recovered panic",
+		zap.String("component", component),
+		zap.Any("panic", rec),
+		zap.ByteString("stack", debug.Stack()),
+	)
+	d.reporter.CapturePanic(rec)
+	if d.crashOnPanic {
+		// The report is only flushed in the background, it must be sent before the process exits.
+		d.reporter.Close()
+		panic(rec)
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// panicWriter panics when the handler looks up the client address.
+type panicWriter struct {
+	dns.ResponseWriter
+}
+
+func (panicWriter) RemoteAddr() net.Addr {
+	panic("injected panic")
+}
+
+func TestServeDNSRecoversPanic(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	addr := startTestDNS(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		h.ServeDNS(panicWriter{w}, r)
+	}))
+
+	// The second query checks the server survived the first panic.
+	for range 2 {
+		query := new(dns.Msg)
+		query.SetQuestion("edge.example.com.", dns.TypeA)
+		resp, err := dns.Exchange(query, addr)
+		if err != nil {
+			t.Fatalf("Exchange() returned error: %v", err)
+		}
+		if resp.Rcode != dns.RcodeServerFailure {
+			t.Fatalf("Exchange() rcode = %s, want SERVFAIL", dns.RcodeToString[resp.Rcode])
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/policy"
+)
+
+var (
+	poolSizeGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helios_dns_pool_size_smoothed",
+			Help: "Exponential moving average of the number of IPs accepted per cycle for a domain.",
+		},
+		[]string{"domain"},
+	)
+	poolLowGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helios_dns_pool_low",
+			Help: "Whether the smoothed pool size of a domain is below its pool.min_size (1) or not (0).",
+		},
+		[]string{"domain"},
+	)
+	fallbackActiveGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helios_dns_fallback_active",
+			Help: "Whether the fallback IPs of a domain are served since its last cycle (1) or not (0).",
+		},
+		[]string{"domain"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(poolSizeGauge, poolLowGauge, fallbackActiveGauge)
+}
+
+// poolState is the smoothed healthy pool size of a domain.
+type poolState struct {
+	size float64
+	// low is set while size is below the pool.min_size of the domain.
+	low bool
+}
+
+// smoothPool folds the count of IPs accepted by a cycle into the smoothed pool size of key. The
+// caller must hold the write lock.
+func (d *Handler) smoothPool(key string, count int) {
+	var pool config.PoolConfig
+	if domainCfg, ok := d.domains[key]; ok {
+		pool = domainCfg.Pool
+	}
+	state, seen := d.pools[key]
+	size := float64(count)
+	// The first cycle has nothing to smooth against.
+	if seen && pool.Smoothing > 0 {
+		size = pool.Smoothing*size + (1-pool.Smoothing)*state.size
+	}
+	low := pool.MinSize > 0 && size < float64(pool.MinSize)
+	fields := []zap.Field{zap.String("domain", key), zap.Float64("pool_size", size), zap.Int("min_size", pool.MinSize)}
+	switch {
+	case low && !state.low:
+		d.logger.Warn("smoothed pool size below min_size, serving fallback IPs", fields...)
+	case !low && state.low:
+		d.logger.Info("smoothed pool size recovered", fields...)
+	}
+	d.pools[key] = poolState{size: size, low: low}
+	poolSizeGauge.WithLabelValues(key).Set(size)
+	if pool.MinSize > 0 {
+		poolLowGauge.WithLabelValues(key).Set(boolToFloat(low))
+	}
+}
+
+// recordFallback exports whether the fallback IPs of key are served after a cycle left it with
+// servable records, which happens while its pool is low or when none is servable, e.g. every
+// record is below min_confidence. hadServable tells whether any was servable before the cycle.
+// The caller must hold the write lock.
+func (d *Handler) recordFallback(key string, hadServable bool, servable int) {
+	domainCfg, ok := d.domains[key]
+	if !ok || len(domainCfg.FallbackIPs) == 0 {
+		return
+	}
+	if servable == 0 && hadServable {
+		d.logger.Warn("no servable records left, serving fallback IPs", zap.String("domain", key))
+	}
+	active := d.pools[key].low || servable == 0
+	fallbackActiveGauge.WithLabelValues(key).Set(boolToFloat(active))
+}
+
+// withFallback appends the fallback IPs of domainCfg allowed by lists and missing from candidates.
+func withFallback(candidates []policy.Candidate, domainCfg *config.ScanConfig, lists *ipLists) []policy.Candidate {
+	for _, fallback := range fallbackCandidates(domainCfg, lists) {
+		if !containsCandidate(candidates, fallback) {
+			candidates = append(candidates, fallback)
+		}
+	}
+	return candidates
+}
+
+func containsCandidate(candidates []policy.Candidate, c policy.Candidate) bool {
+	for _, candidate := range candidates {
+		if candidate.IP.Equal(c.IP) {
+			return true
+		}
+	}
+	return false
+}
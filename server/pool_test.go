@@ -0,0 +1,123 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestSmoothPool(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{Domain: key, Pool: config.PoolConfig{Smoothing: 0.5, MinSize: 2}}
+	for _, step := range []struct {
+		count int
+		size  float64
+		low   bool
+	}{
+		{count: 4, size: 4},
+		// A single empty cycle does not drop the pool below min_size.
+		{count: 0, size: 2},
+		{count: 0, size: 1, low: true},
+		{count: 4, size: 2.5},
+	} {
+		h.smoothPool(key, step.count)
+		if got := h.pools[key]; got.size != step.size || got.low != step.low {
+			t.Fatalf("after a cycle of %d IPs pool = %+v, want size %v low %v", step.count, got, step.size, step.low)
+		}
+	}
+}
+
+func TestLowPoolServesFallback(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{
+		Domain:          key,
+		ConfidenceBoost: 1,
+		FallbackIPs:     []string{"198.51.100.1"},
+		Pool:            config.PoolConfig{Smoothing: 1, MinSize: 2},
+	}
+	h.UpdateRecords(key, []Record{{IP: net.IPv4(192, 0, 2, 1).To4(), Latency: time.Millisecond}})
+	if !h.pools[key].low {
+		t.Fatalf("pool = %+v, want low", h.pools[key])
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion(key, dns.TypeA)
+	msg := h.resolve(query, queryClient{}, zap.NewNop())
+	if len(msg.Answer) != 2 {
+		t.Fatalf("answer = %v, want the accepted IP and the fallback IP", msg.Answer)
+	}
+}
+
+func TestEmptyScanServesFallback(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := "empty.example.com."
+	h.domains[key] = &config.ScanConfig{
+		Domain:          key,
+		ConfidenceBoost: 1,
+		FallbackIPs:     []string{"198.51.100.1"},
+	}
+	h.UpdateRecords(key, nil)
+	if got := testutil.ToFloat64(fallbackActiveGauge.WithLabelValues(key)); got != 1 {
+		t.Fatalf("helios_dns_fallback_active = %v, want 1", got)
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion(key, dns.TypeA)
+	msg := h.resolve(query, queryClient{}, zap.NewNop())
+	if len(msg.Answer) != 1 || !msg.Answer[0].(*dns.A).A.Equal(net.IPv4(198, 51, 100, 1)) {
+		t.Fatalf("answer = %v, want the fallback IP", msg.Answer)
+	}
+
+	h.UpdateRecords(key, []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	if got := testutil.ToFloat64(fallbackActiveGauge.WithLabelValues(key)); got != 0 {
+		t.Fatalf("helios_dns_fallback_active = %v, want 0 once records are found", got)
+	}
+	msg = h.resolve(query, queryClient{}, zap.NewNop())
+	if len(msg.Answer) != 1 || !msg.Answer[0].(*dns.A).A.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("answer = %v, want only the accepted IP", msg.Answer)
+	}
+}
+
+func TestFallbackActiveBelowMinConfidence(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := "unsure.example.com."
+	h.domains[key] = &config.ScanConfig{
+		Domain:          key,
+		ConfidenceBoost: 0.5,
+		MinConfidence:   0.9,
+		FallbackIPs:     []string{"198.51.100.1"},
+	}
+	h.UpdateRecords(key, []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	if got := testutil.ToFloat64(fallbackActiveGauge.WithLabelValues(key)); got != 1 {
+		t.Fatalf("helios_dns_fallback_active = %v, want 1 while no record reaches min_confidence", got)
+	}
+	query := new(dns.Msg)
+	query.SetQuestion(key, dns.TypeA)
+	msg := h.resolve(query, queryClient{}, zap.NewNop())
+	if len(msg.Answer) != 1 || !msg.Answer[0].(*dns.A).A.Equal(net.IPv4(198, 51, 100, 1)) {
+		t.Fatalf("answer = %v, want the fallback IP", msg.Answer)
+	}
+
+	// A second cycle raises the confidence of the record above min_confidence.
+	h.UpdateRecords(key, []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	if got := testutil.ToFloat64(fallbackActiveGauge.WithLabelValues(key)); got != 0 {
+		t.Fatalf("helios_dns_fallback_active = %v, want 0 once the record is servable", got)
+	}
+}
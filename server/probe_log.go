@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fmotalleb/mithra/vm"
+
+	"github.com/fmotalleb/helios-dns/check"
+	"github.com/fmotalleb/helios-dns/export"
+)
+
+// probeLog collects the probe outcomes of a cycle for the exporter and passes them on to the
+// probe webhook as they happen. A nil log discards them.
+type probeLog struct {
+	collect bool
+	webhook *probeWebhook
+
+	mu   sync.Mutex
+	rows []export.Row
+}
+
+// newProbeLog returns a log collecting the outcomes when collect is set, nil when there is
+// neither anything to collect nor a webhook.
+func newProbeLog(collect bool, webhook *probeWebhook) *probeLog {
+	if !collect && webhook == nil {
+		return nil
+	}
+	return &probeLog{collect: collect, webhook: webhook}
+}
+
+func (l *probeLog) add(domain string, ip net.IP, res vm.Result) {
+	if l == nil {
+		return
+	}
+	row := export.Row{
+		Time:      time.Now(),
+		Domain:    domain,
+		IP:        normalizeIP(ip).String(),
+		Success:   res.Success,
+		LatencyMS: float64(res.Duration) / float64(time.Millisecond),
+		Step:      check.Step(res),
+	}
+	if res.Error != nil {
+		row.Error = res.Error.Error()
+	}
+	l.webhook.send(row)
+	if !l.collect {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rows = append(l.rows, row)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/go-tools/log"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/export"
+)
+
+var probeWebhookDropped = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "helios_dns_probe_webhook_dropped_total",
+		Help: "Total probe outcomes not delivered to the probe webhook, because its queue was full or a batch failed.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(probeWebhookDropped)
+}
+
+// probeWebhook posts probe outcomes to probe_webhook.url in batches of NDJSON, one outcome per
+// line. A nil webhook discards them.
+type probeWebhook struct {
+	cfg    config.WebhookConfig
+	client *http.Client
+	rows   chan export.Row
+}
+
+// newProbeWebhook returns the webhook of cfg, nil when no url is set.
+func newProbeWebhook(cfg config.WebhookConfig) *probeWebhook {
+	if cfg.URL == "" {
+		return nil
+	}
+	return &probeWebhook{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		rows:   make(chan export.Row, cfg.Buffer),
+	}
+}
+
+// send queues row for the next batch. It never blocks, the row is dropped when the queue is full.
+func (p *probeWebhook) send(row export.Row) {
+	if p == nil {
+		return
+	}
+	select {
+	case p.rows <- row:
+	default:
+		probeWebhookDropped.Inc()
+	}
+}
+
+// Run posts the queued outcomes until ctx is done, then posts what is left.
+func (p *probeWebhook) Run(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	logger := log.Of(ctx).With(zap.String("component", "probe_webhook"))
+	batch := make([]export.Row, 0, p.cfg.BatchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := p.post(ctx, batch); err != nil {
+			probeWebhookDropped.Add(float64(len(batch)))
+			logger.Warn("failed to post probe results", zap.Int("count", len(batch)), zap.Error(err))
+		}
+		batch = batch[:0]
+	}
+	timer := time.NewTimer(p.cfg.FlushInterval)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			for drained := false; !drained; {
+				select {
+				case row := <-p.rows:
+					batch = append(batch, row)
+				default:
+					drained = true
+				}
+			}
+			flush(context.WithoutCancel(ctx))
+			return nil
+		case row := <-p.rows:
+			if len(batch) == 0 {
+				timer.Reset(p.cfg.FlushInterval)
+			}
+			batch = append(batch, row)
+			if len(batch) >= p.cfg.BatchSize {
+				timer.Stop()
+				flush(ctx)
+			}
+		case <-timer.C:
+			flush(ctx)
+		}
+	}
+}
+
+func (p *probeWebhook) post(ctx context.Context, rows []export.Row) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, row := range rows {
+		if err := encoder.Encode(row); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for name, value := range p.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
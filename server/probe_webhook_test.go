@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fmotalleb/mithra/vm"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/export"
+)
+
+func TestProbeWebhookBatches(t *testing.T) {
+	t.Parallel()
+
+	batches := make(chan []export.Row, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Content-Type"); got != "application/x-ndjson" {
+			t.Errorf("Content-Type = %q, want application/x-ndjson", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Authorization = %q, want the configured header", got)
+		}
+		var rows []export.Row
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var row export.Row
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				t.Errorf("invalid NDJSON line %q: %v", scanner.Text(), err)
+			}
+			rows = append(rows, row)
+		}
+		batches <- rows
+	}))
+	defer srv.Close()
+
+	hook := newProbeWebhook(config.WebhookConfig{
+		URL:           srv.URL,
+		Headers:       map[string]string{"Authorization": "Bearer token"},
+		BatchSize:     2,
+		FlushInterval: 50 * time.Millisecond,
+		Timeout:       time.Second,
+		Buffer:        8,
+	})
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go func() { _ = hook.Run(ctx) }()
+
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		hook.send(export.Row{Domain: "edge.example.com.", IP: ip, Success: true})
+	}
+	// A full batch is posted right away, the rest once the flush interval elapsed.
+	for _, want := range []int{2, 1} {
+		select {
+		case rows := <-batches:
+			if len(rows) != want || rows[0].Domain != edgeDomain {
+				t.Fatalf("batch = %+v, want %d outcomes", rows, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no batch of %d outcomes posted", want)
+		}
+	}
+}
+
+func TestProbeLogWithoutExport(t *testing.T) {
+	t.Parallel()
+
+	if newProbeLog(false, nil) != nil {
+		t.Fatal("newProbeLog() without export nor webhook should be nil")
+	}
+	hook := newProbeWebhook(config.WebhookConfig{URL: "http://127.0.0.1:1", Buffer: 1})
+	probes := newProbeLog(false, hook)
+	probes.add("edge.example.com.", net.IPv4(192, 0, 2, 1), vm.Result{Success: true, Duration: time.Millisecond})
+	if len(probes.rows) != 0 || len(hook.rows) != 1 {
+		t.Fatalf("rows = %d, queued = %d, want the outcome queued for the webhook only", len(probes.rows), len(hook.rows))
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+var publishGroupHeldGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "helios_dns_publish_group_held",
+		Help: "1 while a publish group keeps its previous generation because a domain of it is incomplete.",
+	},
+	[]string{"publish_group"},
+)
+
+func init() {
+	prometheus.MustRegister(publishGroupHeldGauge)
+}
+
+// publishGroups holds back the records of domains sharing a publish group until every domain of
+// the group finished the cycle, then publishes them as one generation. A group held back for
+// maxHold publishes the records of its complete domains anyway. A nil value publishes every
+// domain on its own.
+type publishGroups struct {
+	maxHold time.Duration
+	mu      sync.Mutex
+	batches map[string]*publishBatch
+}
+
+// publishHolds tracks since when every held publish group keeps its previous generation, across
+// cycles. The zero value is ready to use.
+type publishHolds struct {
+	mu    sync.Mutex
+	since map[string]time.Time
+}
+
+// hold marks group as held at now unless it already is, it returns since when it is held.
+func (p *publishHolds) hold(group string, now time.Time) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if since, ok := p.since[group]; ok {
+		return since
+	}
+	if p.since == nil {
+		p.since = make(map[string]time.Time)
+	}
+	p.since[group] = now
+	publishGroupHeldGauge.WithLabelValues(group).Set(1)
+	return now
+}
+
+// release marks group as published in full.
+func (p *publishHolds) release(group string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.since, group)
+	publishGroupHeldGauge.WithLabelValues(group).Set(0)
+}
+
+// publishBatch collects the record sets of one publish group during a cycle.
+type publishBatch struct {
+	remaining int
+	// incomplete lists the domains that produced no new records this cycle.
+	incomplete []string
+	sets       map[string][]Record
+}
+
+// newPublishGroups returns the publish groups of the domains scanned this cycle, nil if none.
+func newPublishGroups(domains []*config.ScanConfig, maxHold time.Duration) *publishGroups {
+	batches := make(map[string]*publishBatch)
+	for _, cfg := range domains {
+		if cfg.PublishGroup == "" || !cfg.IsEnabled() || cfg.Paused {
+			continue
+		}
+		batch, ok := batches[cfg.PublishGroup]
+		if !ok {
+			batch = &publishBatch{sets: make(map[string][]Record)}
+			batches[cfg.PublishGroup] = batch
+		}
+		batch.remaining++
+	}
+	if len(batches) == 0 {
+		return nil
+	}
+	return &publishGroups{maxHold: maxHold, batches: batches}
+}
+
+// publish hands the new records of cfg to h, once the rest of its group is done too.
+func (p *publishGroups) publish(h *Handler, cfg *config.ScanConfig, records []Record, logger *zap.Logger) {
+	if p == nil || cfg.PublishGroup == "" {
+		h.UpdateRecords(cfg.Domain, records)
+		return
+	}
+	p.complete(h, cfg, logger, func(batch *publishBatch) {
+		batch.sets[cfg.Domain] = records
+	})
+}
+
+// skip marks cfg as done without new records, which keeps the previous generation of its whole
+// group until it was held for maxHold.
+func (p *publishGroups) skip(h *Handler, cfg *config.ScanConfig, logger *zap.Logger) {
+	if p == nil || cfg.PublishGroup == "" {
+		return
+	}
+	p.complete(h, cfg, logger, func(batch *publishBatch) {
+		batch.incomplete = append(batch.incomplete, cfg.Domain)
+	})
+}
+
+func (p *publishGroups) complete(h *Handler, cfg *config.ScanConfig, logger *zap.Logger, update func(*publishBatch)) {
+	p.mu.Lock()
+	batch, ok := p.batches[cfg.PublishGroup]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	update(batch)
+	batch.remaining--
+	done := batch.remaining == 0
+	p.mu.Unlock()
+	if !done {
+		return
+	}
+
+	groupLogger := logger.With(zap.String("publish_group", cfg.PublishGroup))
+	if len(batch.incomplete) > 0 {
+		slices.Sort(batch.incomplete)
+		held := time.Since(h.publishHolds.hold(cfg.PublishGroup, time.Now()))
+		if p.maxHold <= 0 || held < p.maxHold || len(batch.sets) == 0 {
+			groupLogger.Warn("publish group incomplete, keeping the previous generation",
+				zap.Strings("incomplete", batch.incomplete),
+				zap.Duration("held", held),
+			)
+			return
+		}
+		generation := h.publishRecords(batch.sets)
+		groupLogger.Warn("publish group held too long, published its complete domains",
+			zap.Strings("incomplete", batch.incomplete),
+			zap.Duration("held", held),
+			zap.Uint64("generation", generation),
+		)
+		return
+	}
+	h.publishHolds.release(cfg.PublishGroup)
+	generation := h.publishRecords(batch.sets)
+	groupLogger.Info("publish group published",
+		zap.Uint64("generation", generation),
+		zap.Int("domains", len(batch.sets)),
+	)
+}
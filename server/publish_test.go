@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestPublishGroupsPublishTogether(t *testing.T) {
+	t.Parallel()
+
+	api := &config.ScanConfig{Domain: "api.example.com.", PublishGroup: "service"}
+	cdn := &config.ScanConfig{Domain: "cdn.example.com.", PublishGroup: "service"}
+	solo := &config.ScanConfig{Domain: "solo.example.com."}
+	h := newTestHandler(t)
+	for _, cfg := range []*config.ScanConfig{api, cdn, solo} {
+		h.domains[cfg.Domain] = cfg
+	}
+	logger := zap.NewNop()
+	ip := func(last byte) []Record { return []Record{{IP: net.IPv4(192, 0, 2, last).To4()}} }
+
+	groups := newPublishGroups([]*config.ScanConfig{api, cdn, solo}, 0)
+	groups.publish(h, solo, ip(1), logger)
+	groups.publish(h, api, ip(2), logger)
+	snapshot := h.Snapshot()
+	if _, ok := snapshot[api.Domain]; ok {
+		t.Fatal("api records published before the rest of its group")
+	}
+	if _, ok := snapshot[solo.Domain]; !ok {
+		t.Fatal("records of a domain outside publish groups were held back")
+	}
+	groups.publish(h, cdn, ip(3), logger)
+	snapshot = h.Snapshot()
+	if snapshot[api.Domain].Generation == 0 || snapshot[api.Domain].Generation != snapshot[cdn.Domain].Generation {
+		t.Fatalf("generations = %d and %d, want both domains in one generation",
+			snapshot[api.Domain].Generation, snapshot[cdn.Domain].Generation)
+	}
+
+	// A group member without new records keeps the previous generation of the whole group.
+	groups = newPublishGroups([]*config.ScanConfig{api, cdn}, 0)
+	groups.publish(h, api, ip(4), logger)
+	groups.skip(h, cdn, logger)
+	if got := h.Snapshot()[api.Domain].Records[0].IP; !got.Equal(net.IPv4(192, 0, 2, 2)) {
+		t.Fatalf("api record = %s, want the previous generation kept", got)
+	}
+}
+
+func TestPublishGroupsPublishAfterMaxHold(t *testing.T) {
+	t.Parallel()
+
+	api := &config.ScanConfig{Domain: "api.example.com.", PublishGroup: "held-service"}
+	cdn := &config.ScanConfig{Domain: "cdn.example.com.", PublishGroup: "held-service"}
+	h := newTestHandler(t)
+	h.domains[api.Domain], h.domains[cdn.Domain] = api, cdn
+	logger := zap.NewNop()
+	ip := func(last byte) []Record { return []Record{{IP: net.IPv4(192, 0, 2, last).To4()}} }
+	held := publishGroupHeldGauge.WithLabelValues("held-service")
+
+	groups := newPublishGroups([]*config.ScanConfig{api, cdn}, time.Hour)
+	groups.publish(h, api, ip(1), logger)
+	groups.skip(h, cdn, logger)
+	if _, ok := h.Snapshot()[api.Domain]; ok {
+		t.Fatal("api records published while the group was held for less than max hold")
+	}
+	if got := testutil.ToFloat64(held); got != 1 {
+		t.Fatalf("held gauge = %v, want 1", got)
+	}
+
+	// Once held for max hold, the complete domains are published on their own.
+	h.publishHolds.since["held-service"] = time.Now().Add(-2 * time.Hour)
+	groups = newPublishGroups([]*config.ScanConfig{api, cdn}, time.Hour)
+	groups.publish(h, api, ip(2), logger)
+	groups.skip(h, cdn, logger)
+	if records := h.Snapshot()[api.Domain].Records; len(records) != 1 || !records[0].IP.Equal(net.IPv4(192, 0, 2, 2)) {
+		t.Fatalf("api records = %v, want the complete domain published after max hold", records)
+	}
+
+	groups = newPublishGroups([]*config.ScanConfig{api, cdn}, time.Hour)
+	groups.publish(h, api, ip(3), logger)
+	groups.publish(h, cdn, ip(4), logger)
+	if got := testutil.ToFloat64(held); got != 0 {
+		t.Fatalf("held gauge = %v after a complete cycle, want 0", got)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// answerQuestions answers every question of r, each resolved and signed on its own. Queries
+// rarely carry more than one question, ServeDNS refuses more than max_questions. The answers are
+// merged into one message whose rcode is the first one that is not NOERROR.
+func (d *Handler) answerQuestions(r *dns.Msg, from queryClient, logger *zap.Logger) *dns.Msg {
+	if len(r.Question) == 1 {
+		return d.secure(r, d.authorize(d.resolve(r, from, logger)), logger)
+	}
+	var msg *dns.Msg
+	for _, q := range r.Question {
+		single := r.Copy()
+		single.Question = []dns.Question{q}
+		resp := d.secure(single, d.authorize(d.resolve(single, from, logger)), logger)
+		if msg == nil {
+			msg = resp
+			continue
+		}
+		msg.Question = append(msg.Question, q)
+		if msg.Rcode == dns.RcodeSuccess {
+			msg.Rcode = resp.Rcode
+		}
+		msg.Authoritative = msg.Authoritative && resp.Authoritative
+		msg.Answer = append(msg.Answer, resp.Answer...)
+		msg.Ns = append(msg.Ns, resp.Ns...)
+		for _, rr := range resp.Extra {
+			opt, ok := rr.(*dns.OPT)
+			if !ok {
+				msg.Extra = append(msg.Extra, rr)
+				continue
+			}
+			// Extended errors of every question share a single OPT record.
+			for _, option := range opt.Option {
+				msg = withEDNSOption(msg, option)
+			}
+		}
+	}
+	msg.Answer = dns.Dedup(msg.Answer, nil)
+	msg.Ns = dns.Dedup(msg.Ns, nil)
+	return msg
+}
+
+// answerCount returns how many answer records of msg answer q: every one when it is the only
+// question, the ones owned by its name otherwise.
+func answerCount(msg *dns.Msg, q dns.Question) int {
+	if len(msg.Question) == 1 {
+		return len(msg.Answer)
+	}
+	count := 0
+	for _, rr := range msg.Answer {
+		if strings.EqualFold(rr.Header().Name, q.Name) {
+			count++
+		}
+	}
+	return count
+}
+
+// minimalANY is the answer to ANY queries for served names: a single synthesized HINFO record
+// rather than every record set of the name (RFC 8482). Names that are CNAMEs answer with their
+// CNAME instead, no other record may exist beside it.
+func minimalANY(name string, ttl uint32) dns.RR {
+	return &dns.HINFO{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: ttl},
+		Cpu: "RFC8482",
+	}
+}
+
+// isCNAME reports whether name is answered with a CNAME, as a static record or an alias in cname
+// mode of domainCfg, the domain serving name.
+func (d *Handler) isCNAME(name string, domainCfg *config.ScanConfig) bool {
+	if _, alias := d.aliases[dns.CanonicalName(name)]; alias && domainCfg.AliasMode == config.AliasCNAME {
+		return true
+	}
+	return len(d.staticRecords(name, dns.TypeCNAME, 0)) > 0
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestAnswerQuestionsMergesAnswers(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com."}
+	h.domains["api.example.com."] = &config.ScanConfig{Domain: "api.example.com."}
+	h.UpdateRecords("edge.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	h.UpdateRecords("api.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 2).To4()}})
+
+	query := new(dns.Msg)
+	query.SetQuestion("edge.example.com.", dns.TypeA)
+	query.Question = append(query.Question, dns.Question{Name: "api.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	msg := h.answerQuestions(query, queryClient{}, zap.NewNop())
+	if msg.Rcode != dns.RcodeSuccess || len(msg.Question) != 2 || len(msg.Answer) != 2 {
+		t.Fatalf("answerQuestions() = %v, want an answer for both questions", msg)
+	}
+
+	query.Question = append(query.Question, dns.Question{Name: "www.example.org.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	msg = h.answerQuestions(query, queryClient{}, zap.NewNop())
+	if msg.Rcode != dns.RcodeRefused || len(msg.Answer) != 2 {
+		t.Fatalf("answerQuestions() = %v, want the answers and the rcode of the unknown name", msg)
+	}
+}
+
+func TestServeDNSLimitsQuestions(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.maxQuestions = 2
+	h.domains["limits.example.com."] = &config.ScanConfig{Domain: "limits.example.com."}
+	h.UpdateRecords("limits.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	pc, err := new(net.ListenConfig).ListenPacket(t.Context(), "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	// The listeners admit max_questions, the handler enforces it for every transport.
+	server := &dns.Server{PacketConn: pc, Handler: h, MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept }}
+	go func() { _ = server.ActivateAndServe() }()
+	t.Cleanup(func() { _ = server.Shutdown() })
+	client := &dns.Client{Net: "udp", Timeout: time.Second}
+
+	query := new(dns.Msg)
+	query.SetQuestion("limits.example.com.", dns.TypeA)
+	query.Question = append(query.Question, dns.Question{Name: "limits.example.com.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+	requests := dnsRequestCounter.WithLabelValues("limits.example.com.", "", "AAAA")
+	before := testutil.ToFloat64(requests)
+	resp, _, err := client.Exchange(query, pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Exchange() returned error: %v", err)
+	}
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("rcode = %s with %d answers, want both questions answered", dns.RcodeToString[resp.Rcode], len(resp.Answer))
+	}
+	if got := testutil.ToFloat64(requests) - before; got != 1 {
+		t.Fatalf("requests of the second question = %v, want 1", got)
+	}
+
+	query.Question = append(query.Question, dns.Question{Name: "limits.example.com.", Qtype: dns.TypeTXT, Qclass: dns.ClassINET})
+	if resp, _, err = client.Exchange(query, pc.LocalAddr().String()); err != nil {
+		t.Fatalf("Exchange() returned error: %v", err)
+	}
+	if resp.Rcode != dns.RcodeFormatError || len(resp.Answer) != 0 {
+		t.Fatalf("rcode = %s with %d answers, want FORMERR above max_questions", dns.RcodeToString[resp.Rcode], len(resp.Answer))
+	}
+}
+
+func TestResolveMinimalANY(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com."}
+	h.UpdateRecords("edge.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	h.static = buildStaticRecords([]config.StaticRecord{
+		{Name: "mail.example.com.", Type: "MX", Value: "10 mx.example.net.", TTL: time.Minute},
+		{Name: "www.example.com.", Type: "CNAME", Value: "edge.example.com.", TTL: time.Minute},
+	})
+
+	tests := []struct {
+		name  string
+		rcode int
+		want  []uint16
+	}{
+		{"edge.example.com.", dns.RcodeSuccess, []uint16{dns.TypeHINFO}},
+		{"mail.example.com.", dns.RcodeSuccess, []uint16{dns.TypeHINFO}},
+		{"www.example.com.", dns.RcodeSuccess, []uint16{dns.TypeCNAME, dns.TypeHINFO}},
+		{"www.example.org.", dns.RcodeRefused, nil},
+	}
+	for _, tt := range tests {
+		query := new(dns.Msg)
+		query.SetQuestion(tt.name, dns.TypeANY)
+		msg := h.resolve(query, queryClient{}, zap.NewNop())
+		if msg.Rcode != tt.rcode || len(msg.Answer) != len(tt.want) {
+			t.Fatalf("resolve(%s ANY) = %v, want %d records with rcode %s", tt.name, msg, len(tt.want), dns.RcodeToString[tt.rcode])
+		}
+		for i, rr := range msg.Answer {
+			if rr.Header().Rrtype != tt.want[i] {
+				t.Errorf("resolve(%s ANY) answer[%d] = %v, want %s", tt.name, i, rr, dns.TypeToString[tt.want[i]])
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+)
+
+// readiness tracks which components finished starting.
+type readiness struct {
+	mu         sync.RWMutex
+	components map[string]bool
+}
+
+func newReadiness(components ...string) *readiness {
+	r := &readiness{components: make(map[string]bool, len(components))}
+	for _, c := range components {
+		r.components[c] = false
+	}
+	return r
+}
+
+func (r *readiness) markReady(component string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.components[component] = true
+}
+
+func (r *readiness) state() (map[string]bool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ready := true
+	state := make(map[string]bool, len(r.components))
+	for c, ok := range r.components {
+		state[c] = ok
+		ready = ready && ok
+	}
+	return state, ready
+}
+
+func (r *readiness) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	state, ready := r.state()
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, state)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"math"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/policy"
+)
+
+// Record is a served IP together with its validation metadata.
+type Record struct {
+	IP          net.IP
+	Latency     time.Duration
+	ValidatedAt time.Time
+	// Confidence is the score at ValidatedAt, see [Record.confidenceAt].
+	Confidence float64
+	// DroppedAt is set while the IP is served for the grace period after it left the accepted set.
+	DroppedAt time.Time
+	// Pinned is set on the IPs added through [Handler.AddRecord], they are kept by update cycles
+	// and re-validations until removed.
+	Pinned bool
+}
+
+// confidenceAt returns the confidence of r at now, halving every halfLife.
+func (r Record) confidenceAt(now time.Time, halfLife time.Duration) float64 {
+	if halfLife <= 0 {
+		return r.Confidence
+	}
+	age := now.Sub(r.ValidatedAt)
+	if age <= 0 {
+		return r.Confidence
+	}
+	return r.Confidence * math.Exp2(-float64(age)/float64(halfLife))
+}
+
+func (r Record) clone() Record {
+	r.IP = slices.Clone(r.IP)
+	return r
+}
+
+// normalizeIP returns the canonical form of ip: 4 bytes for IPv4 (including
+// IPv4-mapped IPv6 addresses) and 16 bytes otherwise.
+func normalizeIP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return slices.Clone(v4)
+	}
+	return slices.Clone(ip.To16())
+}
+
+func recordIPs(records []Record) []net.IP {
+	ips := make([]net.IP, len(records))
+	for i, r := range records {
+		ips[i] = r.IP
+	}
+	return ips
+}
+
+func indexOfIP(records []Record, ip net.IP) int {
+	return slices.IndexFunc(records, func(r Record) bool {
+		return r.IP.Equal(ip)
+	})
+}
+
+// sameIPs reports whether a and b hold the same set of IPs.
+func sameIPs(a, b []Record) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, r := range a {
+		if indexOfIP(b, r.IP) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// dedupeRecords normalizes record IPs and drops later duplicates.
+func dedupeRecords(records []Record) []Record {
+	seen := make(map[string]struct{}, len(records))
+	result := make([]Record, 0, len(records))
+	for _, r := range records {
+		r.IP = normalizeIP(r.IP)
+		key := r.IP.String()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		result = append(result, r)
+	}
+	return result
+}
+
+// candidatesOfFamily keeps the IPv4 candidates for A queries and the IPv6 ones for AAAA queries.
+func candidatesOfFamily(candidates []policy.Candidate, qtype uint16) []policy.Candidate {
+	out := make([]policy.Candidate, 0, len(candidates))
+	for _, c := range candidates {
+		if (c.IP.To4() != nil) == (qtype == dns.TypeA) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// fallbackCandidates returns the fallback IPs of a domain that lists allows as fully trusted
+// candidates.
+func fallbackCandidates(domainCfg *config.ScanConfig, lists *ipLists) []policy.Candidate {
+	ips := domainCfg.Fallback()
+	candidates := make([]policy.Candidate, 0, len(ips))
+	for _, ip := range ips {
+		if lists.allows(ip) {
+			candidates = append(candidates, policy.Candidate{IP: ip, Confidence: 1})
+		}
+	}
+	return candidates
+}
+
+// staleRecords returns up to n records of previous missing from current that were validated
+// within window, marked as dropped so they are re-checked until they are accepted again.
+func staleRecords(previous, current []Record, n int, window time.Duration, now time.Time) []Record {
+	var out []Record
+	for _, prev := range previous {
+		if len(out) >= n {
+			break
+		}
+		if indexOfIP(current, prev.IP) >= 0 || now.Sub(prev.ValidatedAt) >= window {
+			continue
+		}
+		if prev.DroppedAt.IsZero() {
+			prev.DroppedAt = now
+		}
+		out = append(out, prev)
+	}
+	return out
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"net"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/go-tools/log"
+
+	"github.com/fmotalleb/helios-dns/check"
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/internal/testhook"
+	"github.com/fmotalleb/helios-dns/source"
+)
+
+// errScanDeferred is returned by a scan that ran out of probe budget before it completed.
+var errScanDeferred = errors.New("probe budget exhausted")
+
+// scanSource probes the sampled CIDRs of a domain with its checks. With candidates set it probes
+// the IPs of that source instead, keeping only the ones passing the checks.
+type scanSource struct {
+	cfg        *config.ScanConfig
+	h          *Handler
+	logger     *zap.Logger
+	cycle      cycleResources
+	runner     *check.Runner
+	samples    []iter.Seq[net.IP]
+	candidates source.RecordSource
+}
+
+// newRecordSource returns the source of the records of cfg for one update cycle.
+func newRecordSource(
+	ctx context.Context,
+	cfg *config.ScanConfig,
+	h *Handler,
+	logger *zap.Logger,
+	cycle cycleResources,
+) (source.RecordSource, error) {
+	s := &scanSource{cfg: cfg, h: h, logger: logger, cycle: cycle}
+	if cfg.Source.Type != config.SourceScan {
+		src, err := source.New(cfg.Source)
+		if err != nil {
+			return nil, err
+		}
+		if !cfg.Source.Check {
+			return src, nil
+		}
+		s.candidates = src
+	} else {
+		samples, err := cfg.ReadCIDRsSamples(log.WithLogger(ctx, logger), cycle.shard.keep())
+		if err != nil {
+			return nil, fmt.Errorf("read CIDR samples: %w", err)
+		}
+		s.samples = h.ipLists.filter(h.cidrs.filter(cfg, samples))
+	}
+	if rebuilt, err := cfg.RefreshVM(time.Now()); err != nil {
+		logger.Warn("failed to refresh program, keeping the current one", zap.Error(err))
+		h.reporter.Capture(err, scanTags(cfg))
+	} else if rebuilt {
+		logger.Info("rendered program changed, VM rebuilt")
+		recordProgramRebuild(cfg.Domain)
+	}
+	runner, err := h.newRunner(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("build VM: %w", err)
+	}
+	s.runner = runner
+	return s, nil
+}
+
+// errNoChecks rejects replacing the checks of the domains with none, which would pass every
+// candidate.
+var errNoChecks = errors.New("no checks given")
+
+func init() {
+	testhook.UseChecks = func(handler any, checks []check.Checker) error {
+		return handler.(*Handler).useChecks(checks...)
+	}
+}
+
+// useChecks probes the candidates of every domain with checks instead of their program and
+// native checks. It lets tests scan without the network, through heliostest for other packages.
+func (d *Handler) useChecks(checks ...check.Checker) error {
+	if len(checks) == 0 {
+		return errNoChecks
+	}
+	d.checksMu.Lock()
+	defer d.checksMu.Unlock()
+	d.checks = append(make([]check.Checker, 0, len(checks)), checks...)
+	return nil
+}
+
+// newRunner returns the runner probing the candidates of cfg.
+func (d *Handler) newRunner(cfg *config.ScanConfig) (*check.Runner, error) {
+	d.checksMu.Lock()
+	checks := d.checks
+	d.checksMu.Unlock()
+	if checks != nil {
+		return check.NewChecksRunner(checks...), nil
+	}
+	return check.NewRunner(cfg)
+}
+
+// Records implements [source.RecordSource].
+func (s *scanSource) Records(ctx context.Context) ([]source.Record, error) {
+	samples := s.samples
+	if s.candidates != nil {
+		records, err := s.candidates.Records(ctx)
+		if err != nil {
+			return nil, err
+		}
+		samples = s.h.ipLists.filter([]iter.Seq[net.IP]{func(yield func(net.IP) bool) {
+			for _, r := range records {
+				if !yield(r.IP) {
+					return
+				}
+			}
+		}})
+	}
+	s.logger.Debug("scan candidates loaded", zap.Int("sequences", len(samples)))
+
+	scan := &domainScan{
+		runner:       s.runner,
+		logger:       s.logger,
+		limit:        max(normalizeLimit(s.cfg.Limit), s.cfg.CandidatePool) + s.h.revalidator.standbySize(),
+		workerTokens: s.cycle.workerTokens,
+		budget:       s.cycle.budget,
+		pace:         s.cycle.pace,
+		probes:       s.cycle.probes,
+		chaos:        s.h.chaos,
+		h:            s.h,
+		domain:       s.cfg.Domain,
+		sni:          s.cfg.SNI,
+		cycleID:      s.cycle.id,
+	}
+	if s.candidates == nil {
+		scan.cidrs = make([]cidrCounters, len(samples))
+	}
+	if draining := s.h.drainingIPs(s.cfg.Domain); len(draining) > 0 {
+		// Re-check IPs in their grace period, their probes are not counted against any CIDR.
+		samples = append(slices.Clone(samples), slices.Values(draining))
+	}
+	accepted, err := collectIPs(ctx, scan, samples)
+	recordScanCost(s.cfg.Domain, scan.probeCount.Load(), scan.traffic.Sent(), scan.traffic.Received())
+	s.logger.Debug("scan cost",
+		zap.Int64("probes", scan.probeCount.Load()),
+		zap.Int64("bytes_sent", scan.traffic.Sent()),
+		zap.Int64("bytes_received", scan.traffic.Received()),
+	)
+	if err != nil || ctx.Err() != nil {
+		return nil, errors.Join(err, ctx.Err())
+	}
+	if s.candidates == nil {
+		s.h.cidrs.observe(s.cfg, scan.cidrs, !scan.canceled, s.logger)
+	}
+	if scan.exhausted.Load() {
+		return accepted, errScanDeferred
+	}
+	return accepted, nil
+}
+
+// limitRecords keeps the first limit records with distinct IPs.
+func limitRecords(records []source.Record, limit int) []source.Record {
+	out := make([]source.Record, 0, min(len(records), limit))
+	for _, r := range records {
+		if len(out) == limit {
+			break
+		}
+		r.IP = normalizeIP(r.IP)
+		if !slices.ContainsFunc(out, func(o source.Record) bool { return o.IP.Equal(r.IP) }) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
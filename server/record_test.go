@@ -0,0 +1,585 @@
+package server
+
+import (
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/forward"
+	"github.com/fmotalleb/helios-dns/policy"
+)
+
+func TestNormalizeIP(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in      string
+		wantLen int
+		want    string
+	}{
+		{in: "1.2.3.4", wantLen: net.IPv4len, want: "1.2.3.4"},
+		{in: "::ffff:1.2.3.4", wantLen: net.IPv4len, want: "1.2.3.4"},
+		{in: "2606:4700::1", wantLen: net.IPv6len, want: "2606:4700::1"},
+	}
+	for _, tt := range tests {
+		got := normalizeIP(net.ParseIP(tt.in))
+		if len(got) != tt.wantLen || got.String() != tt.want {
+			t.Errorf("normalizeIP(%s) = %s (len %d), want %s (len %d)", tt.in, got, len(got), tt.want, tt.wantLen)
+		}
+	}
+}
+
+func TestCandidatesOfFamily(t *testing.T) {
+	t.Parallel()
+
+	candidates := []policy.Candidate{
+		{IP: net.ParseIP("1.2.3.4").To4()},
+		{IP: net.ParseIP("2606:4700::1")},
+		{IP: net.ParseIP("5.6.7.8").To4()},
+	}
+	if got := candidatesOfFamily(candidates, dns.TypeA); len(got) != 2 || got[1].IP.String() != "5.6.7.8" {
+		t.Errorf("candidatesOfFamily(A) = %v, want the IPv4 addresses", got)
+	}
+	if got := candidatesOfFamily(candidates, dns.TypeAAAA); len(got) != 1 || got[0].IP.String() != "2606:4700::1" {
+		t.Errorf("candidatesOfFamily(AAAA) = %v, want the IPv6 address", got)
+	}
+}
+
+func TestUpdateRecordsDedupesMixedRepresentations(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	mapped := net.ParseIP("::ffff:1.2.3.4")
+	plain := net.IPv4(1, 2, 3, 4).To4()
+	h.UpdateRecords("edge.example.com.", []Record{{IP: mapped}, {IP: plain}})
+
+	got := h.Snapshot()["edge.example.com."].Records
+	if len(got) != 1 {
+		t.Fatalf("records = %v, want a single record", got)
+	}
+	if len(got[0].IP) != net.IPv4len {
+		t.Fatalf("record IP length = %d, want %d", len(got[0].IP), net.IPv4len)
+	}
+}
+
+func TestUpdateRecordsKeepsDroppedIPsForGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{Domain: key, GracePeriod: time.Hour}
+	first, second := net.IPv4(192, 0, 2, 1).To4(), net.IPv4(192, 0, 2, 2).To4()
+
+	h.UpdateRecords(key, []Record{{IP: first}, {IP: second}})
+	h.UpdateRecords(key, []Record{{IP: second}})
+	if got, _ := h.store.Get(key); len(got) != 2 || got[1].DroppedAt.IsZero() {
+		t.Fatalf("records = %+v, want the dropped IP kept and marked", got)
+	}
+	if got := h.drainingIPs(key); len(got) != 1 || !got[0].Equal(first) {
+		t.Fatalf("drainingIPs() = %v, want the dropped IP re-checked", got)
+	}
+
+	h.UpdateRecords(key, []Record{{IP: second}, {IP: first}})
+	if got := h.drainingIPs(key); len(got) != 0 {
+		t.Fatalf("drainingIPs() = %v, want the re-accepted IP no longer draining", got)
+	}
+
+	h.UpdateRecords(key, []Record{{IP: second}})
+	records, _ := h.store.Get(key)
+	records[1].DroppedAt = time.Now().Add(-2 * time.Hour)
+	h.UpdateRecords(key, []Record{{IP: second}})
+	if got, _ := h.store.Get(key); len(got) != 1 || !got[0].IP.Equal(second) {
+		t.Fatalf("records = %+v, want the IP removed once the grace period elapsed", got)
+	}
+}
+
+func TestUpdateRecordsKeepsStaleRecordsBelowLimit(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{Domain: key, Limit: 2, StaleWindow: time.Hour}
+	first, second, third := net.IPv4(192, 0, 2, 1).To4(), net.IPv4(192, 0, 2, 2).To4(), net.IPv4(192, 0, 2, 3).To4()
+
+	h.UpdateRecords(key, []Record{{IP: first}, {IP: second}, {IP: third}})
+	h.UpdateRecords(key, nil)
+	got, _ := h.store.Get(key)
+	if len(got) != 2 || !got[0].IP.Equal(first) || !got[1].IP.Equal(second) {
+		t.Fatalf("records = %+v, want the previous records kept up to the limit", got)
+	}
+	if draining := h.drainingIPs(key); len(draining) != 2 {
+		t.Fatalf("drainingIPs() = %v, want the stale records re-checked", draining)
+	}
+
+	// A full cycle replaces them.
+	h.UpdateRecords(key, []Record{{IP: third}, {IP: first}})
+	if got, _ = h.store.Get(key); len(got) != 2 || !got[0].IP.Equal(third) {
+		t.Fatalf("records = %+v, want only the accepted IPs", got)
+	}
+
+	h.UpdateRecords(key, []Record{{IP: third}})
+	got, _ = h.store.Get(key)
+	got[1].ValidatedAt = time.Now().Add(-2 * time.Hour)
+	h.UpdateRecords(key, []Record{{IP: third}})
+	if got, _ := h.store.Get(key); len(got) != 1 {
+		t.Fatalf("records = %+v, want the record dropped once the window elapsed", got)
+	}
+}
+
+func TestAddRecordRejectsMappedDuplicate(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	if !h.AddRecord("edge.example.com.", net.IPv4(1, 2, 3, 4).To4()) {
+		t.Fatal("AddRecord() = false for a new IP")
+	}
+	if h.AddRecord("edge.example.com.", net.ParseIP("::ffff:1.2.3.4")) {
+		t.Fatal("AddRecord() = true for an IPv4-mapped duplicate")
+	}
+}
+
+func TestAcceptIPDedupesMixedRepresentations(t *testing.T) {
+	t.Parallel()
+
+	scan := &domainScan{
+		logger: zap.NewNop(),
+		limit:  4,
+		seen:   make(map[string]struct{}),
+		cancel: func() {},
+	}
+	scan.acceptIP(net.ParseIP("::ffff:1.2.3.4"), time.Millisecond)
+	scan.acceptIP(net.IPv4(1, 2, 3, 4).To4(), time.Millisecond)
+
+	if len(scan.okIPs) != 1 {
+		t.Fatalf("accepted = %v, want a single IP", scan.okIPs)
+	}
+}
+
+func TestResolveWildcardDomain(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.domains["*.cdn.example.com."] = &config.ScanConfig{Domain: "*.cdn.example.com."}
+	h.domains["static.cdn.example.com."] = &config.ScanConfig{Domain: "static.cdn.example.com."}
+	h.UpdateRecords("*.cdn.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	h.UpdateRecords("static.cdn.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 2).To4()}})
+
+	tests := map[string]string{
+		"img.cdn.example.com.":    "192.0.2.1",
+		"a.b.cdn.example.com.":    "192.0.2.1",
+		"static.cdn.example.com.": "192.0.2.2",
+		"cdn.example.com.":        "",
+	}
+	for name, want := range tests {
+		query := new(dns.Msg)
+		query.SetQuestion(name, dns.TypeA)
+		msg := h.resolve(query, queryClient{}, zap.NewNop())
+		if want == "" {
+			if len(msg.Answer) != 0 {
+				t.Errorf("resolve(%s) = %v, want no answer", name, msg.Answer)
+			}
+			continue
+		}
+		if len(msg.Answer) != 1 {
+			t.Fatalf("resolve(%s) = %v, want a single answer", name, msg.Answer)
+		}
+		a, ok := msg.Answer[0].(*dns.A)
+		if !ok || a.A.String() != want || a.Hdr.Name != name {
+			t.Errorf("resolve(%s) = %v, want %s owned by the question name", name, msg.Answer[0], want)
+		}
+	}
+}
+
+func TestResolveAliases(t *testing.T) {
+	t.Parallel()
+
+	cdn := "cdn.example.com."
+	h := newTestHandler(t)
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com.", AliasMode: config.AliasRecords}
+	h.domains[cdn] = &config.ScanConfig{Domain: cdn, AliasMode: config.AliasCNAME}
+	h.aliases["www.example.com."] = edgeDomain
+	h.aliases["static.example.com."] = cdn
+	h.UpdateRecords("edge.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	h.UpdateRecords(cdn, []Record{{IP: net.IPv4(192, 0, 2, 2).To4()}})
+
+	query := new(dns.Msg)
+	query.SetQuestion("www.example.com.", dns.TypeA)
+	msg := h.resolve(query, queryClient{}, zap.NewNop())
+	if len(msg.Answer) != 1 || msg.Answer[0].Header().Name != "www.example.com." {
+		t.Fatalf("resolve(www) = %v, want the edge record under the alias name", msg.Answer)
+	}
+
+	query.SetQuestion("static.example.com.", dns.TypeA)
+	msg = h.resolve(query, queryClient{}, zap.NewNop())
+	if len(msg.Answer) != 2 {
+		t.Fatalf("resolve(static) = %v, want a CNAME and an A record", msg.Answer)
+	}
+	cname, ok := msg.Answer[0].(*dns.CNAME)
+	if !ok || cname.Target != cdn {
+		t.Errorf("resolve(static) first answer = %v, want a CNAME to cdn.example.com.", msg.Answer[0])
+	}
+	if a, ok := msg.Answer[1].(*dns.A); !ok || a.Hdr.Name != cdn || a.A.String() != "192.0.2.2" {
+		t.Errorf("resolve(static) second answer = %v, want the cdn record", msg.Answer[1])
+	}
+}
+
+func TestResolveIgnoresNameCase(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com."}
+	h.aliases["www.example.com."] = edgeDomain
+	h.UpdateRecords("Edge.Example.COM", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+
+	for _, name := range []string{"eDgE.ExAmPlE.cOm.", "WWW.example.com."} {
+		query := new(dns.Msg)
+		query.SetQuestion(name, dns.TypeA)
+		msg := h.resolve(query, queryClient{}, zap.NewNop())
+		if len(msg.Answer) != 1 || msg.Answer[0].Header().Name != name {
+			t.Fatalf("resolve(%s) = %v, want the edge record owned by the question name as asked", name, msg.Answer)
+		}
+	}
+}
+
+func TestResolveProxyMode(t *testing.T) {
+	t.Parallel()
+
+	upstream := startTestDNS(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.RecursionAvailable = true
+		hdr := dns.RR_Header{Name: r.Question[0].Name, Rrtype: r.Question[0].Qtype, Class: dns.ClassINET, Ttl: 300}
+		if r.Question[0].Qtype == dns.TypeA {
+			msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: net.IPv4(203, 0, 113, 9)})
+		} else {
+			msg.Answer = append(msg.Answer, &dns.TXT{Hdr: hdr, Txt: []string{"upstream"}})
+		}
+		_ = w.WriteMsg(msg)
+	}))
+	forwarder, err := forward.New([]string{upstream}, time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler(t)
+	h.forwarder = forwarder
+	h.proxy, h.forwardUnknown = true, true
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com."}
+	h.domains["pending.example.com."] = &config.ScanConfig{Domain: "pending.example.com."}
+	h.UpdateRecords("edge.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  string
+	}{
+		{"edge.example.com.", dns.TypeA, "192.0.2.1"},
+		{"pending.example.com.", dns.TypeA, "203.0.113.9"},
+		{"www.example.org.", dns.TypeA, "203.0.113.9"},
+		{"edge.example.com.", dns.TypeTXT, "upstream"},
+	}
+	for _, tt := range tests {
+		query := new(dns.Msg)
+		query.SetQuestion(tt.name, tt.qtype)
+		msg := h.resolve(query, queryClient{}, zap.NewNop())
+		if !msg.RecursionAvailable || len(msg.Answer) != 1 {
+			t.Fatalf("resolve(%s %s) = %v, want a single recursive answer", tt.name, dns.TypeToString[tt.qtype], msg)
+		}
+		var got string
+		switch rr := msg.Answer[0].(type) {
+		case *dns.A:
+			got = rr.A.String()
+		case *dns.TXT:
+			got = rr.Txt[0]
+		}
+		if got != tt.want {
+			t.Errorf("resolve(%s %s) = %s, want %s", tt.name, dns.TypeToString[tt.qtype], got, tt.want)
+		}
+	}
+}
+
+func TestRecordConfidenceAt(t *testing.T) {
+	t.Parallel()
+
+	validated := time.Now()
+	r := Record{ValidatedAt: validated, Confidence: 0.8}
+	for _, tt := range []struct {
+		age      time.Duration
+		halfLife time.Duration
+		want     float64
+	}{
+		{age: time.Hour, halfLife: 0, want: 0.8},
+		{age: -time.Minute, halfLife: time.Hour, want: 0.8},
+		{age: 0, halfLife: time.Hour, want: 0.8},
+		{age: time.Hour, halfLife: time.Hour, want: 0.4},
+		{age: 2 * time.Hour, halfLife: time.Hour, want: 0.2},
+	} {
+		if got := r.confidenceAt(validated.Add(tt.age), tt.halfLife); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("confidenceAt(+%s, half-life %s) = %v, want %v", tt.age, tt.halfLife, got, tt.want)
+		}
+	}
+}
+
+func TestServableMinConfidence(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{Domain: key, MinConfidence: 0.5, ConfidenceHalfLife: time.Hour}
+	now := time.Now()
+	h.store.Update(key, []Record{
+		{IP: net.IPv4(192, 0, 2, 1).To4(), ValidatedAt: now, Confidence: 0.9},
+		{IP: net.IPv4(192, 0, 2, 2).To4(), ValidatedAt: now.Add(-time.Hour), Confidence: 0.9},
+		{IP: net.IPv4(192, 0, 2, 3).To4(), ValidatedAt: now, Confidence: 0.4},
+	}, now)
+
+	candidates := h.servable(key, now)
+	if len(candidates) != 1 || !candidates[0].IP.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("servable() = %v, want only the record whose decayed confidence reaches min_confidence", candidates)
+	}
+	query := new(dns.Msg)
+	query.SetQuestion(key, dns.TypeA)
+	if msg := h.resolve(query, queryClient{}, zap.NewNop()); len(msg.Answer) != 1 || !msg.Answer[0].(*dns.A).A.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("resolve() = %v, want only the confident record served", msg.Answer)
+	}
+
+	// Without min_confidence every record is served, with its decayed confidence.
+	h.domains[key].MinConfidence = 0
+	candidates = h.servable(key, now)
+	if len(candidates) != 3 || math.Abs(candidates[1].Confidence-0.45) > 1e-9 {
+		t.Fatalf("servable() = %v, want every record with the second one decayed to 0.45", candidates)
+	}
+}
+
+func TestResolvePaused(t *testing.T) {
+	t.Parallel()
+
+	upstream := startTestDNS(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.IPv4(203, 0, 113, 9),
+		})
+		_ = w.WriteMsg(msg)
+	}))
+	forwarder, err := forward.New([]string{upstream}, time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler(t)
+	h.forwarder = forwarder
+	disabled := false
+	for _, domainCfg := range []*config.ScanConfig{
+		{Domain: "stale.example.com.", Paused: true},
+		{Domain: "fallback.example.com.", Paused: true, PausedResponse: config.PausedFallback, FallbackIPs: []string{"198.51.100.1"}},
+		{Domain: "failing.example.com.", Paused: true, PausedResponse: config.PausedServFail},
+		{Domain: "forward.example.com.", Paused: true, PausedResponse: config.PausedForward},
+		{Domain: "disabled.example.com.", Enabled: &disabled, PausedResponse: config.PausedServFail},
+	} {
+		h.domains[domainCfg.Domain] = domainCfg
+		h.UpdateRecords(domainCfg.Domain, []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	}
+
+	tests := []struct {
+		name  string
+		rcode int
+		want  string
+		code  uint16
+	}{
+		{name: "stale.example.com.", rcode: dns.RcodeSuccess, want: "192.0.2.1", code: dns.ExtendedErrorCodeStaleAnswer},
+		{name: "fallback.example.com.", rcode: dns.RcodeSuccess, want: "198.51.100.1", code: dns.ExtendedErrorCodeOther},
+		{name: "failing.example.com.", rcode: dns.RcodeServerFailure, code: dns.ExtendedErrorCodeOther},
+		{name: "forward.example.com.", rcode: dns.RcodeSuccess, want: "203.0.113.9"},
+		{name: "disabled.example.com.", rcode: dns.RcodeServerFailure, code: dns.ExtendedErrorCodeOther},
+	}
+	for _, tt := range tests {
+		query := new(dns.Msg)
+		query.SetQuestion(tt.name, dns.TypeA)
+		query.SetEdns0(1232, false)
+		msg := h.resolve(query, queryClient{}, zap.NewNop())
+		if msg.Rcode != tt.rcode {
+			t.Errorf("resolve(%s) rcode = %s, want %s", tt.name, dns.RcodeToString[msg.Rcode], dns.RcodeToString[tt.rcode])
+			continue
+		}
+		var got string
+		if len(msg.Answer) == 1 {
+			got = msg.Answer[0].(*dns.A).A.String()
+		}
+		if got != tt.want || len(msg.Answer) > 1 {
+			t.Errorf("resolve(%s) = %v, want %q", tt.name, msg.Answer, tt.want)
+		}
+		if errs := extendedErrors(msg); tt.code != 0 && (len(errs) != 1 || errs[0].InfoCode != tt.code) {
+			t.Errorf("resolve(%s) extended errors = %v, want code %d", tt.name, errs, tt.code)
+		}
+	}
+}
+
+func TestResolveForwardForClientGroups(t *testing.T) {
+	t.Parallel()
+
+	upstream := startTestDNS(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.IPv4(203, 0, 113, 9),
+		})
+		_ = w.WriteMsg(msg)
+	}))
+	forwarder, err := forward.New([]string{upstream}, time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler(t)
+	h.forwarder = forwarder
+	h.forwardUnknown = true
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com."}
+	h.domains["api.example.com."] = &config.ScanConfig{Domain: "api.example.com."}
+	h.UpdateRecords("edge.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	h.UpdateRecords("api.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 2).To4()}})
+	groups, err := buildClientGroups([]config.ClientGroup{
+		{Name: "restricted", CIDRs: []string{"198.51.100.0/24"}, Domains: []string{"edge.example.com.", "listed.example.org."}},
+		{Name: "forwarding", CIDRs: []string{"203.0.113.0/24"}, Domains: []string{"edge.example.com."}, Forward: true},
+	}, h.ttl)
+	if err != nil {
+		t.Fatalf("buildClientGroups() returned error: %v", err)
+	}
+	h.clientGroups = groups
+
+	restricted, forwarding := net.IPv4(198, 51, 100, 7), net.IPv4(203, 0, 113, 7)
+	tests := []struct {
+		client net.IP
+		name   string
+		want   string
+	}{
+		{restricted, "edge.example.com.", "192.0.2.1"},
+		{restricted, "listed.example.org.", "203.0.113.9"},
+		{restricted, "www.example.org.", ""},
+		{restricted, "api.example.com.", ""},
+		{forwarding, "www.example.org.", "203.0.113.9"},
+		{forwarding, "api.example.com.", ""},
+	}
+	for _, tt := range tests {
+		query := new(dns.Msg)
+		query.SetQuestion(tt.name, dns.TypeA)
+		msg := h.resolve(query, queryClient{source: tt.client, subnet: tt.client}, zap.NewNop())
+		if tt.want == "" {
+			if msg.Rcode != dns.RcodeRefused {
+				t.Errorf("resolve(%s) from %s = %v, want REFUSED", tt.name, tt.client, msg)
+			}
+			continue
+		}
+		if len(msg.Answer) != 1 || !msg.Answer[0].(*dns.A).A.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("resolve(%s) from %s = %v, want %s", tt.name, tt.client, msg.Answer, tt.want)
+		}
+	}
+}
+
+func TestResolveCatchAll(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com.", CatchAll: true}
+	h.domains["api.example.com."] = &config.ScanConfig{Domain: "api.example.com."}
+	h.catchAll = edgeDomain
+	h.static = buildStaticRecords([]config.StaticRecord{{Name: "mail.example.com.", Type: "A", Value: "198.51.100.1", TTL: time.Minute}})
+	h.UpdateRecords("edge.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	h.UpdateRecords("api.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 2).To4()}})
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"www.example.org.", "192.0.2.1"},
+		{"api.example.com.", "192.0.2.2"},
+		{"mail.example.com.", "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		query := new(dns.Msg)
+		query.SetQuestion(tt.name, dns.TypeA)
+		msg := h.resolve(query, queryClient{}, zap.NewNop())
+		if len(msg.Answer) != 1 {
+			t.Fatalf("resolve(%s) = %v, want a single answer", tt.name, msg)
+		}
+		rr, ok := msg.Answer[0].(*dns.A)
+		if !ok || rr.Hdr.Name != tt.name || rr.A.String() != tt.want {
+			t.Errorf("resolve(%s) = %v, want %s", tt.name, msg.Answer[0], tt.want)
+		}
+	}
+
+	// Other lookups of unserved names are not mapped to the catch-all.
+	if key := h.domainKey("www.example.org."); key != "www.example.org." {
+		t.Fatalf("domainKey(www.example.org.) = %s, want the name itself", key)
+	}
+	query := new(dns.Msg)
+	query.SetQuestion("www.example.org.", dns.TypeMX)
+	if msg := h.resolve(query, queryClient{}, zap.NewNop()); msg.Rcode != dns.RcodeRefused || len(msg.Answer) != 0 {
+		t.Fatalf("resolve(www.example.org. MX) = %v, want the name outside the served zones", msg)
+	}
+
+	// Groups without the catch-all still get the apex records of their zone.
+	h.zones = buildZones([]config.ZoneConfig{{Name: "example.com.", NameServers: []string{"ns1.example.com."}}})
+	groups, err := buildClientGroups([]config.ClientGroup{{
+		Name:    "api",
+		CIDRs:   []string{"203.0.113.0/24"},
+		Domains: []string{"api.example.com."},
+	}}, h.ttl)
+	if err != nil {
+		t.Fatalf("buildClientGroups() returned error: %v", err)
+	}
+	h.clientGroups = groups
+	client := net.IPv4(203, 0, 113, 7)
+	from := queryClient{source: client, subnet: client}
+	query.SetQuestion("example.com.", dns.TypeSOA)
+	if msg := h.resolve(query, from, zap.NewNop()); msg.Rcode != dns.RcodeSuccess || len(msg.Answer) != 1 {
+		t.Fatalf("resolve(example.com. SOA) = %v, want the apex SOA for a group without the catch-all", msg)
+	}
+	query.SetQuestion("www.example.org.", dns.TypeA)
+	if msg := h.resolve(query, from, zap.NewNop()); msg.Rcode != dns.RcodeRefused {
+		t.Fatalf("resolve(www.example.org. A) = %v, want REFUSED for a group without the catch-all", msg)
+	}
+
+	// The ACL of the catch-all applies to the names it answers.
+	h.clientGroups = nil
+	_, allowed, _ := net.ParseCIDR("198.51.100.0/24")
+	h.domainACLs = map[string]*clientACL{"edge.example.com.": newClientACL([]*net.IPNet{allowed}, nil)}
+	if msg := h.resolve(query, from, zap.NewNop()); msg.Rcode != dns.RcodeRefused {
+		t.Fatalf("resolve(www.example.org. A) = %v, want REFUSED by the ACL of the catch-all", msg)
+	}
+}
+
+// edgeDomain is the domain most handler tests serve.
+const edgeDomain = "edge.example.com."
+
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	serials, err := newSerialManager(config.SerialConfig{}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("newSerialManager() returned error: %v", err)
+	}
+	return &Handler{
+		logger:   zap.NewNop(),
+		rwMux:    new(sync.RWMutex),
+		store:    NewMemoryStore(),
+		domains:  make(map[string]*config.ScanConfig),
+		policies: make(map[string]policy.AnswerPolicy),
+		aliases:  make(map[string]string),
+		static:   make(map[string][]dns.RR),
+		txt:      make(map[string][]string),
+		serials:  serials,
+		ttl:      60,
+
+		generations:  make(map[string]uint64),
+		pools:        make(map[string]poolState),
+		unknownRcode: dns.RcodeNameError,
+		outsideRcode: dns.RcodeRefused,
+	}
+}
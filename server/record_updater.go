@@ -2,10 +2,17 @@
 package server
 
 import (
+	"cmp"
 	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"iter"
 	"net"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
@@ -14,41 +21,103 @@ import (
 
 	"github.com/fmotalleb/mithra/vm"
 
+	"github.com/fmotalleb/helios-dns/check"
 	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/source"
 )
 
-func recordUpdater(ctx context.Context, cfg config.Config, h *dnsHandler) error {
+// cycleStats carries the outcome of an update cycle over to the next one.
+type cycleStats struct {
+	probes int64
+}
+
+func recordUpdater(ctx context.Context, cfg config.Config, h *Handler, stats *cycleStats) error {
 	logger := log.Of(ctx)
 
-	maxWorkers := normalizeMaxWorkers(cfg.MaxWorkers)
+	pace := cyclePacer(cfg, stats.probes)
 
 	logger.Info("record updater started",
 		zap.Int("domains_count", len(cfg.Domains)),
-		zap.Int("max_workers", maxWorkers),
+		zap.Int("max_workers", cap(h.limits.workerTokens)),
+		zap.String("scan_mode", cfg.ScanMode),
+		zap.String("shard", cfg.Shard),
 	)
+	if pace != nil {
+		logger.Debug("pacing probes", zap.Duration("spacing", pace.spacing))
+	}
 
-	workerTokens := make(chan struct{}, maxWorkers)
-
+	budget := h.limits.startCycle()
+	probes := newProbeLog(h.exporter != nil, h.probeWebhook)
+
+	// The cycle ID tags the logs of this cycle and the exemplars of its probe durations.
+	cycleID := rand.Text()
+	logger = logger.With(zap.String("cycle_id", cycleID))
+	cycle := cycleResources{
+		id:           cycleID,
+		workerTokens: h.limits.workerTokens,
+		budget:       budget,
+		pace:         pace,
+		probes:       probes,
+		shard:        newShard(cfg),
+		publish:      newPublishGroups(cfg.Domains, cfg.PublishMaxHold),
+	}
 	group, groupCtx := errgroup.WithContext(ctx)
 	for _, v := range cfg.Domains {
 		domainCfg := v
+		if !domainCfg.IsEnabled() {
+			logger.Debug("skipping disabled domain", zap.String("domain", domainCfg.Domain))
+			continue
+		}
+		if domainCfg.Paused {
+			logger.Info("skipping paused domain", zap.String("domain", domainCfg.Domain))
+			continue
+		}
+		delay := scanStartDelay(cfg.ScanJitter)
 		group.Go(func() error {
-			return processDomain(groupCtx, domainCfg, h, logger, workerTokens)
+			if delay > 0 {
+				logger.Debug("delaying domain scan", zap.String("domain", domainCfg.Domain), zap.Duration("delay", delay))
+			}
+			if !waitScanStart(groupCtx, delay) {
+				return nil
+			}
+			return processDomain(groupCtx, domainCfg, h, logger, cycle)
 		})
 	}
 
-	if err := group.Wait(); err != nil {
+	err := group.Wait()
+	if h.exporter != nil {
+		if exportErr := h.exporter.Write(probes.rows); exportErr != nil {
+			logger.Warn("failed to export probe results", zap.Error(exportErr))
+			h.reporter.Capture(exportErr, map[string]string{"component": "export"})
+		}
+	}
+	if err != nil {
 		return err
 	}
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
-	logger.Info("record updater finished")
+	stats.probes = budget.used()
+	logger.Info("record updater finished",
+		zap.Int64("probes", stats.probes),
+		zap.Int64("bytes", budget.usedBytes()),
+	)
 	return nil
 }
 
-func processDomain(ctx context.Context, cfg *config.ScanConfig, h *dnsHandler, logger *zap.Logger, workerTokens chan struct{}) error {
+// scanTags labels reported scan failures of a domain.
+func scanTags(cfg *config.ScanConfig) map[string]string {
+	return map[string]string{"component": "scan", "domain": cfg.Domain}
+}
+
+func processDomain(
+	ctx context.Context,
+	cfg *config.ScanConfig,
+	h *Handler,
+	logger *zap.Logger,
+	cycle cycleResources,
+) error {
 	domainLogger := logger.With(
 		zap.String("domain", cfg.Domain),
 		zap.String("sni", cfg.SNI),
@@ -57,38 +126,114 @@ func processDomain(ctx context.Context, cfg *config.ScanConfig, h *dnsHandler, l
 		zap.Int("limit", cfg.Limit),
 	)
 
-	vmRuntime, err := cfg.BuildVM()
+	src, err := newRecordSource(ctx, cfg, h, domainLogger, cycle)
 	if err != nil {
-		domainLogger.Error("failed to build VM", zap.Error(err))
+		domainLogger.Error("failed to build record source", zap.Error(err))
+		h.reporter.Capture(err, scanTags(cfg))
+		cycle.publish.skip(h, cfg, logger)
 		return err
 	}
-
+	accepted, err := src.Records(ctx)
+	if ctx.Err() != nil {
+		cycle.publish.skip(h, cfg, logger)
+		return nil
+	}
+	switch {
+	case errors.Is(err, errScanDeferred):
+		recordScanDeferred(cfg.Domain, cfg.SNI)
+		domainLogger.Warn("probe budget exhausted, domain deferred to next cycle",
+			zap.Int("accepted_ips", len(accepted)),
+		)
+		cycle.publish.skip(h, cfg, logger)
+		// Domains of a publish group only change along with their group.
+		if cfg.PublishGroup == "" {
+			h.keepPartial(cfg.Domain, accepted, normalizeLimit(cfg.Limit))
+		}
+		return nil
+	case err != nil:
+		domainLogger.Warn("failed to fetch records, keeping the current ones", zap.Error(err))
+		h.reporter.Capture(err, scanTags(cfg))
+		cycle.publish.skip(h, cfg, logger)
+		return nil
+	}
+	if cfg.CandidatePool > 0 {
+		slices.SortStableFunc(accepted, func(a, b source.Record) int { return cmp.Compare(a.Latency, b.Latency) })
+	}
+	// IPs accepted beyond result_limit are kept as standby for the re-validations.
 	limit := normalizeLimit(cfg.Limit)
-
-	sample, err := cfg.ReadCIDRsSamples()
-	if err != nil {
-		domainLogger.Error("failed to read CIDR samples", zap.Error(err))
-		return err
+	accepted = limitRecords(accepted, limit+h.revalidator.standbySize())
+	h.revalidator.setStandby(cfg.Domain, accepted[min(limit, len(accepted)):])
+	accepted = accepted[:min(limit, len(accepted))]
+
+	if f, ok := h.chaos.get(cfg.Domain); ok && f.EmptyResults {
+		domainLogger.Warn("chaos: dropping scan results", zap.Int("accepted_ips", len(accepted)))
+		recordFaultInjected(cfg.Domain, faultEmpty)
+		accepted = nil
 	}
 
-	domainLogger.Debug("CIDR samples loaded")
-
-	okIPs, err := collectIPs(ctx, vmRuntime, sample, domainLogger, limit, workerTokens, cfg.Domain, cfg.SNI)
-	if err != nil {
-		return err
-	}
-	if ctx.Err() != nil {
-		return nil
+	if cfg.LatencyFactor > 0 {
+		before := len(accepted)
+		accepted = gateLatency(accepted, cfg.LatencyFactor)
+		recordLatencyGated(cfg.Domain, cfg.SNI, before-len(accepted))
 	}
 
-	h.UpdateRecords(cfg.Domain, okIPs)
+	records := make([]Record, len(accepted))
+	for i, a := range accepted {
+		records[i] = Record{IP: a.IP, Latency: a.Latency}
+	}
+	cycle.publish.publish(h, cfg, records, logger)
 
 	domainLogger.Info("records updated",
-		zap.Int("accepted_ips", len(okIPs)),
+		zap.Int("accepted_ips", len(records)),
 	)
 	return nil
 }
 
+// keepPartial applies the IPs accepted by a scan of key deferred before it completed: the served
+// IPs among them are validated again, and the new ones are served while fewer than limit IPs are.
+// The other served IPs are kept as they are, as the scan did not get to probe them.
+func (d *Handler) keepPartial(key string, accepted []source.Record, limit int) {
+	if len(accepted) == 0 {
+		return
+	}
+	served := d.servedIPs(key)
+	var passed, added []source.Record
+	for _, a := range accepted {
+		switch {
+		case slices.ContainsFunc(served, a.IP.Equal):
+			passed = append(passed, a)
+		case len(served)+len(added) < limit:
+			added = append(added, a)
+		}
+	}
+	d.revalidated(key, passed, nil, added)
+}
+
+// minGatedPool is the smallest pool with a median to compare its IPs to.
+const minGatedPool = 2
+
+// gateLatency drops IPs whose latency exceeds the pool median by more than factor.
+func gateLatency(accepted []source.Record, factor float64) []source.Record {
+	if len(accepted) < minGatedPool {
+		return accepted
+	}
+	latencies := make([]time.Duration, len(accepted))
+	for i, a := range accepted {
+		latencies[i] = a.Latency
+	}
+	slices.Sort(latencies)
+	median := latencies[(len(latencies)-1)/2]
+	threshold := time.Duration(float64(median) * factor)
+
+	kept := make([]source.Record, 0, len(accepted))
+	for _, a := range accepted {
+		if a.Latency <= threshold {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
 func normalizeLimit(limit int) int {
 	if limit <= 0 {
 		return 1
@@ -103,35 +248,72 @@ func normalizeMaxWorkers(maxWorkers int) int {
 	return maxWorkers
 }
 
-func collectIPs(
-	ctx context.Context,
-	vmRuntime *vm.VM,
-	samples []iter.Seq[net.IP],
-	logger *zap.Logger,
-	limit int,
-	workerTokens chan struct{},
-	domain string,
-	sni string,
-) ([]net.IP, error) {
-	okIPs := make([]net.IP, 0, limit)
-	seen := make(map[string]struct{}, limit)
+// cycleResources are shared by every domain scanned during an update cycle.
+type cycleResources struct {
+	id           string
+	workerTokens chan struct{}
+	budget       *probeBudget
+	pace         *pacer
+	probes       *probeLog
+	shard        shard
+	publish      *publishGroups
+}
+
+// domainScan holds the state shared by the workers scanning one domain.
+type domainScan struct {
+	runner       *check.Runner
+	logger       *zap.Logger
+	limit        int
+	workerTokens chan struct{}
+	budget       *probeBudget
+	pace         *pacer
+	probes       *probeLog
+	chaos        *faultInjector
+	h            *Handler
+	domain       string
+	sni          string
+	cycleID      string
+	// cidrs counts probes and successes by index of the sampled CIDR.
+	cidrs []cidrCounters
+
+	cancel context.CancelFunc
+
+	// probeCount and traffic account the cost of the scan.
+	probeCount atomic.Int64
+	traffic    check.Traffic
+
+	okMu      sync.Mutex
+	seen      map[string]struct{}
+	okIPs     []source.Record
+	exhausted atomic.Bool
+	// canceled is set when the scan stopped before every sampled IP was probed, by the limit,
+	// the budget or ctx, so the CIDRs it did not finish are not known to be unproductive.
+	canceled bool
+}
+
+func collectIPs(ctx context.Context, scan *domainScan, samples []iter.Seq[net.IP]) ([]source.Record, error) {
+	scan.okIPs = make([]source.Record, 0, scan.limit)
+	scan.seen = make(map[string]struct{}, scan.limit)
 
 	domainCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	scan.cancel = cancel
 
-	ipCh := make(chan net.IP)
+	ipCh := make(chan probeTarget)
 
 	var producers sync.WaitGroup
 	producers.Add(len(samples))
-	for _, cidrIter := range samples {
-		iter := cidrIter
+	for i, cidrIter := range samples {
 		go func() {
 			defer producers.Done()
-			for ip := range iter {
-				if !sendIP(domainCtx, ipCh, ip) {
+			for ip := range cidrIter {
+				if !sendIP(domainCtx, ipCh, probeTarget{IP: ip, CIDR: i}) {
 					return
 				}
 			}
+			if i < len(scan.cidrs) {
+				scan.cidrs[i].exhausted.Store(true)
+			}
 		}()
 	}
 
@@ -140,77 +322,98 @@ func collectIPs(
 		close(ipCh)
 	}()
 
-	var okMu sync.Mutex
 	var workers sync.WaitGroup
 	workerCount := len(samples)
 	if workerCount == 0 {
-		return okIPs, nil
+		return scan.okIPs, nil
 	}
 	for i := 0; i < workerCount; i++ {
 		workers.Add(1)
 		go func() {
 			defer workers.Done()
-			runWorker(domainCtx, ipCh, workerTokens, vmRuntime, logger, domain, sni, limit, &okMu, seen, &okIPs, cancel)
+			scan.runWorker(domainCtx, ipCh)
 		}()
 	}
 	workers.Wait()
+	scan.canceled = domainCtx.Err() != nil
 
-	if ctx.Err() != nil {
-		return okIPs, nil
-	}
-	return okIPs, nil
+	return scan.okIPs, nil
 }
 
-func sendIP(ctx context.Context, out chan<- net.IP, ip net.IP) bool {
+// probeTarget is a sampled IP along with the index of the CIDR it was sampled from.
+type probeTarget struct {
+	IP   net.IP
+	CIDR int
+}
+
+func sendIP(ctx context.Context, out chan<- probeTarget, target probeTarget) bool {
 	select {
 	case <-ctx.Done():
 		return false
-	case out <- ip:
+	case out <- target:
 		return true
 	}
 }
 
-func runWorker(
-	ctx context.Context,
-	ipCh <-chan net.IP,
-	workerTokens chan struct{},
-	vmRuntime *vm.VM,
-	logger *zap.Logger,
-	domain string,
-	sni string,
-	limit int,
-	okMu *sync.Mutex,
-	seen map[string]struct{},
-	okIPs *[]net.IP,
-	cancel context.CancelFunc,
-) {
+func (s *domainScan) runWorker(ctx context.Context, ipCh <-chan probeTarget) {
 	for {
-		ip, ok := recvIP(ctx, ipCh)
+		target, ok := recvIP(ctx, ipCh)
 		if !ok {
 			return
 		}
-		if !acquireToken(ctx, workerTokens) {
+		ip := target.IP
+		if !s.pace.wait(ctx) {
+			return
+		}
+		if !acquireToken(ctx, s.workerTokens) {
 			return
 		}
-		success := runScan(ctx, vmRuntime, logger, ip)
-		releaseToken(workerTokens)
-		recordScanResult(domain, sni, success)
-		if !success {
+		// The budget is taken once a worker is free, so probes waiting for one never spend it.
+		if !s.budget.take() {
+			releaseToken(s.workerTokens)
+			s.exhausted.Store(true)
+			s.cancel()
+			return
+		}
+		res, latency := s.probe(ctx, ip)
+		releaseToken(s.workerTokens)
+		// Injected latency delays the outcome only, other probes keep the worker.
+		if f, ok := s.chaos.get(s.domain); ok {
+			duration := res.Duration
+			res = f.apply(ctx, s.domain, res)
+			latency += res.Duration - duration
+		}
+		recordScanResult(s.domain, s.sni, res.Success)
+		recordProbeDuration(s.domain, s.sni, res.Success, res.Duration, s.cycleID, ip)
+		s.probes.add(s.domain, ip, res)
+		s.countProbe(target.CIDR, res.Success)
+		if !res.Success {
 			continue
 		}
-		acceptIP(ip, limit, okMu, seen, okIPs, logger, cancel)
+		s.acceptIP(ip, latency)
 	}
 }
 
-func recvIP(ctx context.Context, ipCh <-chan net.IP) (net.IP, bool) {
+func recvIP(ctx context.Context, ipCh <-chan probeTarget) (probeTarget, bool) {
 	select {
 	case <-ctx.Done():
-		return nil, false
-	case ip, ok := <-ipCh:
+		return probeTarget{}, false
+	case target, ok := <-ipCh:
 		if !ok {
-			return nil, false
+			return probeTarget{}, false
 		}
-		return ip, true
+		return target, true
+	}
+}
+
+// countProbe records a probe outcome against the CIDR it was sampled from.
+func (s *domainScan) countProbe(cidr int, success bool) {
+	if cidr >= len(s.cidrs) {
+		return
+	}
+	s.cidrs[cidr].probes.Add(1)
+	if success {
+		s.cidrs[cidr].successes.Add(1)
 	}
 }
 
@@ -227,47 +430,66 @@ func releaseToken(workerTokens chan struct{}) {
 	<-workerTokens
 }
 
-func runScan(ctx context.Context, vmRuntime *vm.VM, logger *zap.Logger, ip net.IP) bool {
-	logger.Debug("testing IP",
-		zap.String("ip", ip.String()),
+// probe runs the checks of ip, a panic of the program fails the probe rather than the worker.
+// Its traffic is counted against the scan and the budget of the cycle. The latency of ip is the
+// connect and TLS handshake time of the native checks, or the duration of the probe when they
+// made no connection.
+func (s *domainScan) probe(ctx context.Context, ip net.IP) (res vm.Result, latency time.Duration) {
+	var (
+		traffic check.Traffic
+		timing  check.Timing
 	)
-	res := vmRuntime.ExecuteIP(ctx, ip)
+	s.probeCount.Add(1)
+	defer func() {
+		s.traffic.Add(&traffic)
+		s.budget.spend(traffic.Sent() + traffic.Received())
+	}()
+	defer func() {
+		if rec := recover(); rec != nil {
+			s.h.handlePanic("scan", rec, s.logger.With(zap.String("ip", ip.String())))
+			res = vm.Result{Error: fmt.Errorf("check panicked: %v", rec)}
+		}
+	}()
+	res = runScan(check.WithTiming(check.WithTraffic(ctx, &traffic), &timing), s.runner, s.logger, ip)
+	return res, cmp.Or(timing.Latency(), res.Duration)
+}
+
+// runScan probes ip with a logger scoped to it attached to ctx, so the program
+// and the native checks log with the domain and ip fields of the probe.
+func runScan(ctx context.Context, runner *check.Runner, logger *zap.Logger, ip net.IP) vm.Result {
+	ipLogger := logger.With(zap.String("ip", ip.String()))
+	ipLogger.Debug("testing IP")
+	res := runner.Run(log.WithLogger(ctx, ipLogger), ip)
 	if !res.Success {
-		logger.Debug("IP rejected",
-			zap.String("ip", ip.String()),
+		ipLogger.Debug("IP rejected",
+			zap.String("step", check.Step(res)),
+			zap.Duration("duration", res.Duration),
+			zap.Error(res.Error),
 		)
 	}
-	return res.Success
+	return res
 }
 
-func acceptIP(
-	ip net.IP,
-	limit int,
-	okMu *sync.Mutex,
-	seen map[string]struct{},
-	okIPs *[]net.IP,
-	logger *zap.Logger,
-	cancel context.CancelFunc,
-) {
-	ipCopy := make(net.IP, len(ip))
-	copy(ipCopy, ip)
-
-	okMu.Lock()
-	defer okMu.Unlock()
-	if len(*okIPs) >= limit {
+func (s *domainScan) acceptIP(ip net.IP, latency time.Duration) {
+	ipCopy := normalizeIP(ip)
+
+	s.okMu.Lock()
+	defer s.okMu.Unlock()
+	if len(s.okIPs) >= s.limit {
 		return
 	}
 	key := ipCopy.String()
-	if _, exists := seen[key]; exists {
+	if _, exists := s.seen[key]; exists {
 		return
 	}
-	seen[key] = struct{}{}
-	*okIPs = append(*okIPs, ipCopy)
-	logger.Debug("IP accepted",
+	s.seen[key] = struct{}{}
+	s.okIPs = append(s.okIPs, source.Record{IP: ipCopy, Latency: latency})
+	s.logger.Debug("IP accepted",
 		zap.String("ip", ipCopy.String()),
-		zap.Int("accepted_count", len(*okIPs)),
+		zap.Duration("latency", latency),
+		zap.Int("accepted_count", len(s.okIPs)),
 	)
-	if len(*okIPs) == limit {
-		cancel()
+	if len(s.okIPs) == s.limit {
+		s.cancel()
 	}
 }
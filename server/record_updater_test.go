@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/helios-dns/source"
+)
+
+// delayCheck passes every IP of 192.0.2.0/29, the lower its last byte the slower.
+type delayCheck struct{}
+
+func (delayCheck) Check(ctx context.Context, ip net.IP) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(8-ip.To4()[3]) * 10 * time.Millisecond):
+		return nil
+	}
+}
+
+func (delayCheck) String() string { return "delay" }
+
+func TestCandidatePoolServesFastest(t *testing.T) {
+	t.Parallel()
+
+	cfg := parseScanTestConfig(t, `
+interval: 1m
+max_workers: 1
+domains:
+  - domain: "edge.example.com."
+    cidr: ["192.0.2.1/32", "192.0.2.2/32", "192.0.2.3/32", "192.0.2.4/32"]
+    result_limit: 2
+    candidate_pool: 4
+`, 443)
+	h, err := NewHandler(cfg, zap.NewNop(), nil)
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+	if err := h.useChecks(delayCheck{}); err != nil {
+		t.Fatalf("useChecks() returned error: %v", err)
+	}
+	if err := h.Scan(context.Background(), cfg); err != nil {
+		t.Fatalf("Scan() returned error: %v", err)
+	}
+
+	records := h.Snapshot()["edge.example.com."].Records
+	if len(records) != 2 || !records[0].IP.Equal(net.IPv4(192, 0, 2, 4)) || !records[1].IP.Equal(net.IPv4(192, 0, 2, 3)) {
+		t.Fatalf("records = %v, want the 2 fastest of the pool", records)
+	}
+	if records[0].Latency <= 0 || records[0].Latency > records[1].Latency {
+		t.Fatalf("latencies = %v, %v, want the measured latencies in order", records[0].Latency, records[1].Latency)
+	}
+}
+
+func TestRunWorkerSpendsBudgetOnceAWorkerIsFree(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	scan := &domainScan{
+		logger:       zap.NewNop(),
+		workerTokens: make(chan struct{}, 1),
+		budget:       newProbeBudget(1, 0),
+		cancel:       cancel,
+	}
+	// Every worker is busy until the scan is canceled.
+	scan.workerTokens <- struct{}{}
+	ipCh := make(chan probeTarget, 1)
+	ipCh <- probeTarget{IP: net.IPv4(192, 0, 2, 1).To4()}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scan.runWorker(ctx, ipCh)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	<-done
+	if used := scan.budget.used(); used != 0 {
+		t.Fatalf("budget used = %d, want nothing spent by a probe that never got a worker", used)
+	}
+}
+
+func TestDeferredScanKeepsAcceptedIPs(t *testing.T) {
+	t.Parallel()
+
+	cfg := parseScanTestConfig(t, `
+interval: 1m
+max_workers: 1
+max_probes_per_interval: 2
+domains:
+  - domain: "edge.example.com."
+    cidr: ["192.0.2.1/32", "192.0.2.2/32", "192.0.2.3/32", "192.0.2.4/32"]
+    result_limit: 3
+`, 443)
+	h, err := NewHandler(cfg, zap.NewNop(), nil)
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+	if err := h.useChecks(new(failingCheck)); err != nil {
+		t.Fatalf("useChecks() returned error: %v", err)
+	}
+	served := net.IPv4(198, 51, 100, 1).To4()
+	h.UpdateRecords("edge.example.com.", []Record{{IP: served}})
+	if err := h.Scan(context.Background(), cfg); err != nil {
+		t.Fatalf("Scan() returned error: %v", err)
+	}
+
+	ips := recordIPs(h.Snapshot()["edge.example.com."].Records)
+	if len(ips) != 3 || !slices.ContainsFunc(ips, served.Equal) {
+		t.Fatalf("records = %v, want the served IP kept along with the 2 IPs probed before the budget ran out", ips)
+	}
+}
+
+func TestUseChecksRejectsNone(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	if err := h.useChecks(); !errors.Is(err, errNoChecks) {
+		t.Fatalf("useChecks() = %v, want %v", err, errNoChecks)
+	}
+	if h.checks != nil {
+		t.Fatalf("checks = %v, want the checks of the domains kept", h.checks)
+	}
+}
+
+func TestGateLatency(t *testing.T) {
+	t.Parallel()
+
+	record := func(last byte, latency time.Duration) source.Record {
+		return source.Record{IP: net.IPv4(192, 0, 2, last).To4(), Latency: latency}
+	}
+	accepted := []source.Record{
+		record(1, 10*time.Millisecond),
+		record(2, 30*time.Millisecond),
+		record(3, 20*time.Millisecond),
+		record(4, 61*time.Millisecond),
+		record(5, 60*time.Millisecond),
+	}
+	// The median is 30ms, twice it is the highest latency kept.
+	kept := gateLatency(accepted, 2)
+	if ips := ipsToStrings(sourceIPs(kept)); !slices.Equal(ips, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.5"}) {
+		t.Fatalf("gateLatency() kept %v, want every IP within twice the median latency in order", ips)
+	}
+	if kept := gateLatency(accepted[3:4], 1); len(kept) != 1 {
+		t.Fatalf("gateLatency() of a single IP = %v, want it kept", kept)
+	}
+}
+
+func sourceIPs(records []source.Record) []net.IP {
+	ips := make([]net.IP, len(records))
+	for i, r := range records {
+		ips[i] = r.IP
+	}
+	return ips
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type recordChangeResponse struct {
+	Domain  string   `json:"domain"`
+	Changed bool     `json:"changed"`
+	IPs     []string `json:"ips"`
+}
+
+// registerRecordsAPI adds the endpoints pinning and removing single records to mux, every request
+// must carry token as a bearer token.
+func registerRecordsAPI(mux *http.ServeMux, handler *Handler, token string) {
+	mux.Handle("POST /api/domains/{domain}/records", requireToken(token, func(w http.ResponseWriter, r *http.Request) {
+		handleRecordChange(w, r, handler, r.FormValue("ip"), handler.AddRecord)
+	}))
+	mux.Handle("DELETE /api/domains/{domain}/records/{ip}", requireToken(token, func(w http.ResponseWriter, r *http.Request) {
+		handleRecordChange(w, r, handler, r.PathValue("ip"), handler.RemoveRecord)
+	}))
+}
+
+// requireToken rejects the requests to next whose Authorization header is not token as a bearer
+// token.
+func requireToken(token string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "missing or invalid token"})
+			return
+		}
+		next(w, r)
+	})
+}
+
+// handleRecordChange applies a single record addition or removal to a configured domain.
+func handleRecordChange(
+	w http.ResponseWriter,
+	r *http.Request,
+	handler *Handler,
+	rawIP string,
+	apply func(string, net.IP) bool,
+) {
+	domain := r.PathValue("domain")
+	if _, ok := handler.domains[domain]; !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "unknown domain " + domain})
+		return
+	}
+	ip := net.ParseIP(rawIP)
+	if ip == nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid ip " + rawIP})
+		return
+	}
+	changed := apply(domain, ip)
+	writeJSON(w, http.StatusOK, recordChangeResponse{
+		Domain:  domain,
+		Changed: changed,
+		IPs:     ipsToStrings(recordIPs(handler.Snapshot()[domain].Records)),
+	})
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestRecordsAPI(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{Domain: key}
+	h.UpdateRecords(key, []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	mux := http.NewServeMux()
+	registerRecordsAPI(mux, h, "secret")
+
+	request := func(method, target, token string) (int, recordChangeResponse) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, http.NoBody)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		var resp recordChangeResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	for _, tt := range []struct {
+		target, token string
+		want          int
+	}{
+		{target: "/api/domains/" + key + "/records?ip=192.0.2.9", want: http.StatusUnauthorized},
+		{target: "/api/domains/" + key + "/records?ip=192.0.2.9", token: "wrong", want: http.StatusUnauthorized},
+		{target: "/api/domains/other.example.com./records?ip=192.0.2.9", token: "secret", want: http.StatusNotFound},
+		{target: "/api/domains/" + key + "/records?ip=bogus", token: "secret", want: http.StatusBadRequest},
+	} {
+		if code, _ := request(http.MethodPost, tt.target, tt.token); code != tt.want {
+			t.Fatalf("POST %s with token %q = %d, want %d", tt.target, tt.token, code, tt.want)
+		}
+	}
+	code, resp := request(http.MethodPost, "/api/domains/"+key+"/records?ip=192.0.2.9", "secret")
+	if code != http.StatusOK || !resp.Changed || !slices.Equal(resp.IPs, []string{"192.0.2.1", "192.0.2.9"}) {
+		t.Fatalf("POST = %d %+v, want 192.0.2.9 added", code, resp)
+	}
+
+	// A cycle publishing other IPs keeps the pinned one.
+	h.UpdateRecords(key, []Record{{IP: net.IPv4(192, 0, 2, 2).To4()}})
+	if ips := ipsToStrings(recordIPs(h.Snapshot()[key].Records)); !slices.Equal(ips, []string{"192.0.2.2", "192.0.2.9"}) {
+		t.Fatalf("records after a cycle = %v, want the pinned IP kept", ips)
+	}
+
+	code, resp = request(http.MethodDelete, "/api/domains/"+key+"/records/192.0.2.9", "secret")
+	if code != http.StatusOK || !resp.Changed || !slices.Equal(resp.IPs, []string{"192.0.2.2"}) {
+		t.Fatalf("DELETE = %d %+v, want 192.0.2.9 removed", code, resp)
+	}
+	h.UpdateRecords(key, []Record{{IP: net.IPv4(192, 0, 2, 2).To4()}})
+	if ips := ipsToStrings(recordIPs(h.Snapshot()[key].Records)); !slices.Equal(ips, []string{"192.0.2.2"}) {
+		t.Fatalf("records after a cycle = %v, want the removed pin gone", ips)
+	}
+	if code, resp = request(http.MethodDelete, "/api/domains/"+key+"/records/192.0.2.9", "secret"); code != http.StatusOK || resp.Changed {
+		t.Fatalf("DELETE of a missing IP = %d %+v, want 200 unchanged", code, resp)
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/go-tools/log"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+var rescanCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "helios_dns_rescans_total",
+		Help: "Total scans triggered by queries for a domain without records.",
+	},
+	[]string{"domain"},
+)
+
+func init() {
+	prometheus.MustRegister(rescanCounter)
+}
+
+// missScanner scans a domain as soon as it is queried while it has no records, instead of
+// waiting for the next update cycle. A nil scanner never rescans.
+type missScanner struct {
+	cfg     config.Config
+	domains map[string]*config.ScanConfig
+	trigger chan string
+
+	mu       sync.Mutex
+	last     map[string]time.Time
+	inFlight map[string]struct{}
+}
+
+// newMissScanner returns the scanner of the domains of cfg that may be rescanned, nil when
+// rescan_on_miss is disabled.
+func newMissScanner(cfg config.Config) *missScanner {
+	if !cfg.RescanOnMiss.Enabled {
+		return nil
+	}
+	domains := make(map[string]*config.ScanConfig)
+	for _, domainCfg := range cfg.Domains {
+		// Domains of a publish group are only published together with their group.
+		if domainCfg.IsEnabled() && !domainCfg.Paused && domainCfg.PublishGroup == "" {
+			domains[domainCfg.Domain] = domainCfg
+		}
+	}
+	return &missScanner{
+		cfg:      cfg,
+		domains:  domains,
+		trigger:  make(chan string, len(domains)),
+		last:     make(map[string]time.Time),
+		inFlight: make(map[string]struct{}),
+	}
+}
+
+// miss schedules a scan of key unless one ran within min_interval or is still running. It never
+// blocks, so it is safe to call while answering.
+func (s *missScanner) miss(key string) {
+	if s == nil {
+		return
+	}
+	if _, ok := s.domains[key]; !ok {
+		return
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, running := s.inFlight[key]; running || now.Sub(s.last[key]) < s.cfg.RescanOnMiss.MinInterval {
+		return
+	}
+	select {
+	case s.trigger <- key:
+		s.last[key] = now
+		s.inFlight[key] = struct{}{}
+	default:
+	}
+}
+
+// Run scans the domains scheduled by miss until ctx is done.
+func (s *missScanner) Run(ctx context.Context, h *Handler) error {
+	if s == nil {
+		return nil
+	}
+	var scans sync.WaitGroup
+	defer scans.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case key := <-s.trigger:
+			scans.Go(func() {
+				defer s.done(key)
+				s.scan(ctx, h, s.domains[key])
+			})
+		}
+	}
+}
+
+func (s *missScanner) done(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inFlight, key)
+}
+
+// scan runs a single domain scan right away, on the workers and the budget of the running cycle.
+func (s *missScanner) scan(ctx context.Context, h *Handler, domainCfg *config.ScanConfig) {
+	cycleID := rand.Text()
+	logger := log.Of(ctx).With(zap.String("cycle_id", cycleID))
+	logger.Info("rescanning domain queried without records", zap.String("domain", domainCfg.Domain))
+	rescanCounter.WithLabelValues(domainCfg.Domain).Inc()
+	cycle := cycleResources{
+		id:           cycleID,
+		workerTokens: h.limits.workerTokens,
+		budget:       h.limits.currentBudget(),
+		shard:        newShard(s.cfg),
+		probes:       newProbeLog(false, h.probeWebhook),
+	}
+	_ = processDomain(ctx, domainCfg, h, logger, cycle)
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestMissScannerRateLimits(t *testing.T) {
+	t.Parallel()
+
+	key := edgeDomain
+	cfg := config.Config{
+		RescanOnMiss: config.RescanConfig{Enabled: true, MinInterval: time.Hour},
+		Domains: []*config.ScanConfig{
+			{Domain: key},
+			{Domain: "grouped.example.com.", PublishGroup: "service"},
+		},
+	}
+	if newMissScanner(config.Config{Domains: cfg.Domains}) != nil {
+		t.Fatal("newMissScanner() without rescan_on_miss is not nil")
+	}
+	s := newMissScanner(cfg)
+	s.miss(key)
+	s.miss(key)
+	s.miss("grouped.example.com.")
+	s.miss("unknown.example.com.")
+	if n := len(s.trigger); n != 1 {
+		t.Fatalf("scheduled %d scans, want 1", n)
+	}
+	<-s.trigger
+	s.done(key)
+	s.miss(key)
+	if n := len(s.trigger); n != 0 {
+		t.Fatalf("scheduled %d scans within min_interval, want 0", n)
+	}
+}
+
+func TestResolveSchedulesRescanOnMiss(t *testing.T) {
+	t.Parallel()
+
+	key := edgeDomain
+	domainCfg := &config.ScanConfig{Domain: key}
+	h := newTestHandler(t)
+	h.domains[key] = domainCfg
+	h.rescans = newMissScanner(config.Config{
+		RescanOnMiss: config.RescanConfig{Enabled: true, MinInterval: time.Hour},
+		Domains:      []*config.ScanConfig{domainCfg},
+	})
+	query := new(dns.Msg)
+	query.SetQuestion(key, dns.TypeA)
+
+	h.resolve(query, queryClient{}, zap.NewNop())
+	if n := len(h.rescans.trigger); n != 0 {
+		t.Fatalf("scheduled %d scans before the first scan, want 0", n)
+	}
+	h.UpdateRecords(key, []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	h.resolve(query, queryClient{}, zap.NewNop())
+	if n := len(h.rescans.trigger); n != 0 {
+		t.Fatalf("scheduled %d scans with records, want 0", n)
+	}
+	h.UpdateRecords(key, nil)
+	h.resolve(query, queryClient{}, zap.NewNop())
+	if got := <-h.rescans.trigger; got != key {
+		t.Fatalf("scheduled scan of %q, want %q", got, key)
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+type resolveResponse struct {
+	Name           string     `json:"name"`
+	Type           string     `json:"type"`
+	Client         string     `json:"client,omitempty"`
+	Rcode          string     `json:"rcode"`
+	Policy         policyView `json:"policy"`
+	PausedResponse string     `json:"paused_response,omitempty"`
+	// ExtendedErrors explain answers that are not regular ones, as sent to EDNS0 clients.
+	ExtendedErrors []string     `json:"extended_errors,omitempty"`
+	Answers        []answerView `json:"answers"`
+}
+
+type policyView struct {
+	Group      string `json:"group,omitempty"`
+	TTL        uint32 `json:"ttl"`
+	MaxAnswers int    `json:"max_answers"`
+	Allowed    bool   `json:"allowed"`
+}
+
+type answerView struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	TTL  uint32 `json:"ttl"`
+	Data string `json:"data"`
+}
+
+// handleResolve answers ?name= through the same path as ServeDNS, as seen by ?client=
+// (the caller by default), without touching the DNS metrics.
+func handleResolve(w http.ResponseWriter, r *http.Request, handler *Handler) {
+	name := r.URL.Query().Get("name")
+	if _, ok := dns.IsDomainName(name); name == "" || !ok {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid name " + name})
+		return
+	}
+	qtypeStr := strings.ToUpper(r.URL.Query().Get("type"))
+	if qtypeStr == "" {
+		qtypeStr = dns.TypeToString[dns.TypeA]
+	}
+	qtype, ok := dns.StringToType[qtypeStr]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid type " + qtypeStr})
+		return
+	}
+	rawClient := r.URL.Query().Get("client")
+	if rawClient == "" {
+		rawClient, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	clientIP := net.ParseIP(rawClient)
+	if clientIP == nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid client " + rawClient})
+		return
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), qtype)
+	client := handler.policyFor(clientIP)
+	msg := handler.authorize(handler.resolve(query, queryClient{source: clientIP, subnet: clientIP}, handler.logger))
+
+	resp := resolveResponse{
+		Name:   query.Question[0].Name,
+		Type:   qtypeStr,
+		Client: clientIP.String(),
+		Rcode:  dns.RcodeToString[msg.Rcode],
+		Policy: policyView{
+			Group:      client.group,
+			TTL:        client.ttl,
+			MaxAnswers: client.maxAnswers,
+			Allowed:    client.allows(handler.domainKey(query.Question[0].Name)),
+		},
+		Answers: make([]answerView, 0, len(msg.Answer)),
+	}
+	if _, domainCfg, ok := handler.lookupDomain(resp.Name); ok && domainCfg.Suspended() {
+		resp.PausedResponse = domainCfg.PausedResponse
+	}
+	for _, ede := range extendedErrors(msg) {
+		resp.ExtendedErrors = append(resp.ExtendedErrors, ede.String())
+	}
+	for _, rr := range msg.Answer {
+		hdr := rr.Header()
+		resp.Answers = append(resp.Answers, answerView{
+			Name: hdr.Name,
+			Type: dns.TypeToString[hdr.Rrtype],
+			TTL:  hdr.Ttl,
+			Data: strings.TrimPrefix(rr.String(), hdr.String()),
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
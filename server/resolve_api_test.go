@@ -0,0 +1,40 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleResolve(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.UpdateRecords("edge.example.com.", []Record{{IP: net.IPv4(1, 2, 3, 4)}, {IP: net.ParseIP("2606:4700::1")}})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/resolve?name=edge.example.com&type=aaaa&client=10.0.0.1", http.NoBody)
+	rec := httptest.NewRecorder()
+	handleResolve(rec, req, h)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var resp resolveResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Rcode != "NOERROR" || !resp.Policy.Allowed || resp.Policy.TTL != 60 {
+		t.Fatalf("response = %+v", resp)
+	}
+	if len(resp.Answers) != 1 || resp.Answers[0].Type != "AAAA" || resp.Answers[0].Data != "2606:4700::1" {
+		t.Fatalf("answers = %+v, want the IPv6 record", resp.Answers)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/resolve?name=edge.example.com.&type=bogus", http.NoBody)
+	rec = httptest.NewRecorder()
+	handleResolve(rec, req, h)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status for invalid type = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
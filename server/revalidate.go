@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/go-tools/log"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/source"
+)
+
+var (
+	revalidationEvictedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helios_dns_revalidation_evicted_total",
+			Help: "Total served IPs evicted because they failed a re-validation between update cycles.",
+		},
+		[]string{"domain"},
+	)
+	revalidationPromotedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helios_dns_revalidation_promoted_total",
+			Help: "Total standby IPs served in place of evicted ones.",
+		},
+		[]string{"domain"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(revalidationEvictedCounter, revalidationPromotedCounter)
+}
+
+// revalidator re-probes the scanned IPs of every domain between update cycles, evicting the ones
+// failing and serving passing IPs of the standby pool of the domain in their place. The standby
+// pool holds the IPs the last scan accepted beyond result_limit. A nil revalidator does nothing.
+type revalidator struct {
+	cfg     config.Config
+	domains []*config.ScanConfig
+	// grouped holds the domains of a publish group, which are not re-validated.
+	grouped []string
+
+	mu      sync.Mutex
+	standby map[string][]source.Record
+	// failures counts the failed re-validations in a row of the served IPs of every domain.
+	failures map[string]map[string]int
+}
+
+// newRevalidator returns the revalidator of the domains of cfg, nil when revalidate is disabled.
+func newRevalidator(cfg config.Config) *revalidator {
+	if cfg.Revalidate.Interval <= 0 {
+		return nil
+	}
+	r := &revalidator{
+		cfg:      cfg,
+		standby:  make(map[string][]source.Record),
+		failures: make(map[string]map[string]int),
+	}
+	for _, domainCfg := range cfg.Domains {
+		// The records of an unchecked source are not probed at all.
+		if !domainCfg.IsEnabled() || (domainCfg.Source.Type != config.SourceScan && !domainCfg.Source.Check) {
+			continue
+		}
+		// Domains of a publish group are only published together with their group.
+		if domainCfg.PublishGroup != "" {
+			r.grouped = append(r.grouped, domainCfg.Domain)
+			continue
+		}
+		r.domains = append(r.domains, domainCfg)
+	}
+	return r
+}
+
+// standbySize returns how many IPs scans accept beyond result_limit for the standby pool.
+func (r *revalidator) standbySize() int {
+	if r == nil {
+		return 0
+	}
+	return r.cfg.Revalidate.Standby
+}
+
+// setStandby replaces the standby pool of domain.
+func (r *revalidator) setStandby(domain string, records []source.Record) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.standby[domain] = slices.Clone(records)
+}
+
+// takeStandby removes and returns the next IP of the standby pool of domain.
+func (r *revalidator) takeStandby(domain string) (source.Record, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pool := r.standby[domain]
+	if len(pool) == 0 {
+		return source.Record{}, false
+	}
+	r.standby[domain] = pool[1:]
+	return pool[0], true
+}
+
+// Run re-validates every domain each interval until ctx is done.
+func (r *revalidator) Run(ctx context.Context, h *Handler) error {
+	if r == nil {
+		return nil
+	}
+	if len(r.grouped) > 0 {
+		log.Of(ctx).Warn("domains of a publish group are not re-validated", zap.Strings("domains", r.grouped))
+	}
+	ticker := time.NewTicker(r.cfg.Revalidate.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.revalidateAll(ctx, h)
+		}
+	}
+}
+
+// revalidateAll re-validates every domain on the workers and the budget of the running cycle.
+func (r *revalidator) revalidateAll(ctx context.Context, h *Handler) {
+	cycleID := rand.Text()
+	logger := log.Of(ctx).With(zap.String("cycle_id", cycleID))
+	cycle := cycleResources{
+		id:           cycleID,
+		workerTokens: h.limits.workerTokens,
+		budget:       h.limits.currentBudget(),
+		probes:       newProbeLog(false, h.probeWebhook),
+	}
+	for _, domainCfg := range r.domains {
+		if ctx.Err() != nil {
+			return
+		}
+		if domainCfg.Suspended() {
+			continue
+		}
+		r.revalidate(ctx, h, domainCfg, logger.With(zap.String("domain", domainCfg.Domain)), cycle)
+	}
+}
+
+// revalidate probes the served IPs of domainCfg, evicts the ones failing too many times in a row
+// and promotes standby IPs passing their probe until as many IPs are served again.
+func (r *revalidator) revalidate(
+	ctx context.Context,
+	h *Handler,
+	domainCfg *config.ScanConfig,
+	logger *zap.Logger,
+	cycle cycleResources,
+) {
+	runner, err := h.newRunner(domainCfg)
+	if err != nil {
+		logger.Warn("failed to build VM for revalidation", zap.Error(err))
+		return
+	}
+	scan := &domainScan{
+		runner:       runner,
+		logger:       logger,
+		workerTokens: cycle.workerTokens,
+		budget:       cycle.budget,
+		probes:       cycle.probes,
+		h:            h,
+		domain:       domainCfg.Domain,
+		sni:          domainCfg.SNI,
+		cycleID:      cycle.id,
+	}
+	served := h.scannedIPs(domainCfg.Domain)
+	results := make([]revalidation, len(served))
+	var probes sync.WaitGroup
+	for i, ip := range served {
+		probes.Go(func() { results[i] = scan.revalidate(ctx, ip) })
+	}
+	probes.Wait()
+	for _, res := range results {
+		// Without a verdict for every served IP the round is skipped for the domain.
+		if !res.probed {
+			return
+		}
+	}
+	passed, failed := r.tally(domainCfg.Domain, served, results)
+	var promoted []source.Record
+	for len(promoted) < len(failed) {
+		candidate, ok := r.takeStandby(domainCfg.Domain)
+		if !ok {
+			break
+		}
+		res := scan.revalidate(ctx, candidate.IP)
+		if !res.probed {
+			break
+		}
+		if res.ok {
+			promoted = append(promoted, res.record)
+		}
+	}
+	h.revalidated(domainCfg.Domain, passed, failed, promoted)
+	if len(failed) == 0 {
+		return
+	}
+	revalidationEvictedCounter.WithLabelValues(domainCfg.Domain).Add(float64(len(failed)))
+	revalidationPromotedCounter.WithLabelValues(domainCfg.Domain).Add(float64(len(promoted)))
+	logger.Info("served IPs revalidated",
+		zap.Int("evicted", len(failed)),
+		zap.Int("promoted", len(promoted)),
+		zap.Int("served", len(passed)+len(promoted)),
+	)
+}
+
+// tally splits the served IPs of domain by the results of their probes into the passing ones and
+// the ones failing for the failures time in a row, which are evicted. The IPs failing fewer times
+// stay served without being validated again.
+func (r *revalidator) tally(domain string, served []net.IP, results []revalidation) ([]source.Record, []net.IP) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous := r.failures[domain]
+	counts := make(map[string]int)
+	passed := make([]source.Record, 0, len(served))
+	var failed []net.IP
+	for i, res := range results {
+		if res.ok {
+			passed = append(passed, res.record)
+			continue
+		}
+		key := served[i].String()
+		if n := previous[key] + 1; n < r.cfg.Revalidate.Failures {
+			counts[key] = n
+			continue
+		}
+		failed = append(failed, served[i])
+	}
+	r.failures[domain] = counts
+	return passed, failed
+}
+
+// revalidation is the outcome of the probe of an IP during a re-validation. probed is false when
+// the probe could not run, because ctx is done or the budget of the round is exhausted.
+type revalidation struct {
+	record source.Record
+	ok     bool
+	probed bool
+}
+
+// revalidate probes ip once.
+func (s *domainScan) revalidate(ctx context.Context, ip net.IP) revalidation {
+	if !acquireToken(ctx, s.workerTokens) {
+		return revalidation{}
+	}
+	if !s.budget.take() {
+		releaseToken(s.workerTokens)
+		return revalidation{}
+	}
+	res, latency := s.probe(ctx, ip)
+	releaseToken(s.workerTokens)
+	recordProbeDuration(s.domain, s.sni, res.Success, res.Duration, s.cycleID, ip)
+	s.probes.add(s.domain, ip, res)
+	if ctx.Err() != nil {
+		return revalidation{}
+	}
+	return revalidation{record: source.Record{IP: ip, Latency: latency}, ok: res.Success, probed: true}
+}
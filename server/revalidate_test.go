@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// failingCheck fails the IPs marked as failing and passes the others.
+type failingCheck struct {
+	mu      sync.Mutex
+	failing []net.IP
+}
+
+func (c *failingCheck) fail(ip net.IP) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failing = append(c.failing, ip)
+}
+
+func (c *failingCheck) Check(_ context.Context, ip net.IP) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if slices.ContainsFunc(c.failing, ip.Equal) {
+		return errors.New("marked as failing")
+	}
+	return nil
+}
+
+func (c *failingCheck) String() string { return "failing" }
+
+func TestRevalidateEvictsFailingIPs(t *testing.T) {
+	t.Parallel()
+
+	cfg := parseScanTestConfig(t, `
+interval: 1m
+revalidate:
+  interval: 1m
+  standby: 1
+domains:
+  - domain: "edge.example.com."
+    cidr: ["192.0.2.1/32", "192.0.2.2/32", "192.0.2.3/32"]
+    result_limit: 2
+`, 443)
+	h, err := NewHandler(cfg, zap.NewNop(), nil)
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+	check := new(failingCheck)
+	if err := h.useChecks(check); err != nil {
+		t.Fatalf("useChecks() returned error: %v", err)
+	}
+	if err := h.Scan(context.Background(), cfg); err != nil {
+		t.Fatalf("Scan() returned error: %v", err)
+	}
+	served := h.servedIPs("edge.example.com.")
+	if len(served) != 2 {
+		t.Fatalf("served = %v, want result_limit IPs", served)
+	}
+
+	check.fail(served[0])
+	h.revalidator.revalidateAll(context.Background(), h)
+	if kept := h.servedIPs("edge.example.com."); !slices.EqualFunc(kept, served, net.IP.Equal) {
+		t.Fatalf("served after a single failure = %v, want %v kept", kept, served)
+	}
+	h.revalidator.revalidateAll(context.Background(), h)
+
+	after := h.servedIPs("edge.example.com.")
+	if len(after) != 2 || slices.ContainsFunc(after, served[0].Equal) || !slices.ContainsFunc(after, served[1].Equal) {
+		t.Fatalf("served after two failures = %v, want %v evicted and replaced by the standby IP", after, served[0])
+	}
+	h.revalidator.revalidateAll(context.Background(), h)
+	if again := h.servedIPs("edge.example.com."); !slices.EqualFunc(again, after, net.IP.Equal) {
+		t.Fatalf("served after a passing revalidation = %v, want %v kept", again, after)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"math/rand/v2"
+	"slices"
+	"sync/atomic"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/policy"
+)
+
+// answerOrders maps the answer orders that sort responses to the policy sorting them.
+var answerOrders = map[string]string{
+	config.OrderLatency: policy.Latency,
+	config.OrderRandom:  policy.RandomN,
+}
+
+// randomSubset returns n candidates picked at random, in their original order. A zero n or
+// fewer candidates returns them all.
+func randomSubset(candidates []policy.Candidate, n int) []policy.Candidate {
+	if n <= 0 || len(candidates) <= n {
+		return candidates
+	}
+	picked := rand.Perm(len(candidates))[:n]
+	slices.Sort(picked)
+	subset := make([]policy.Candidate, n)
+	for i, idx := range picked {
+		subset[i] = candidates[idx]
+	}
+	return subset
+}
+
+// answerRotation reorders the answers of a domain on every response so clients do not all
+// connect to the first address. A nil rotation keeps the order of the answer policy.
+type answerRotation struct {
+	mode string
+	next atomic.Uint64
+}
+
+func newAnswerRotation(mode string) *answerRotation {
+	if mode == "" || mode == config.RotationNone {
+		return nil
+	}
+	return &answerRotation{mode: mode}
+}
+
+// apply returns candidates in the order of this response.
+func (r *answerRotation) apply(candidates []policy.Candidate) []policy.Candidate {
+	if r == nil || len(candidates) < 2 {
+		return candidates
+	}
+	if r.mode == config.RotationShuffle {
+		shuffled := slices.Clone(candidates)
+		rand.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		return shuffled
+	}
+	start := int((r.next.Add(1) - 1) % uint64(len(candidates))) //nolint:gosec // less than len(candidates)
+	return append(slices.Clone(candidates[start:]), candidates[:start]...)
+}
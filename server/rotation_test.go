@@ -0,0 +1,120 @@
+package server
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/policy"
+)
+
+func TestAnswerRotationShiftsFirstRecord(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{Domain: key}
+	h.rotations = map[string]*answerRotation{key: newAnswerRotation(config.RotationShift)}
+	h.UpdateRecords(key, []Record{
+		{IP: net.IPv4(192, 0, 2, 1).To4()},
+		{IP: net.IPv4(192, 0, 2, 2).To4()},
+		{IP: net.IPv4(192, 0, 2, 3).To4()},
+	})
+
+	var firsts []string
+	for range 4 {
+		query := new(dns.Msg)
+		query.SetQuestion(key, dns.TypeA)
+		msg := h.resolve(query, queryClient{}, zap.NewNop())
+		if len(msg.Answer) != 3 {
+			t.Fatalf("answer = %v, want every record", msg.Answer)
+		}
+		firsts = append(firsts, msg.Answer[0].(*dns.A).A.String())
+	}
+	want := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.1"}
+	for i := range want {
+		if firsts[i] != want[i] {
+			t.Fatalf("first answers = %v, want %v", firsts, want)
+		}
+	}
+}
+
+func TestAnswerRotationDisabled(t *testing.T) {
+	t.Parallel()
+
+	if r := newAnswerRotation(config.RotationNone); r != nil {
+		t.Fatalf("newAnswerRotation(none) = %v, want nil", r)
+	}
+	shuffle := newAnswerRotation(config.RotationShuffle)
+	candidates := fallbackCandidates(&config.ScanConfig{FallbackIPs: []string{"192.0.2.1", "192.0.2.2"}}, nil)
+	if got := shuffle.apply(candidates); len(got) != 2 {
+		t.Fatalf("apply() = %v, want every candidate kept", got)
+	}
+}
+
+func TestAnswerOrderLatency(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{Domain: key}
+	order, err := policy.New(answerOrders[config.OrderLatency], policy.Options{})
+	if err != nil {
+		t.Fatalf("policy.New() returned error: %v", err)
+	}
+	h.orders = map[string]policy.AnswerPolicy{key: order}
+	h.UpdateRecords(key, []Record{
+		{IP: net.IPv4(192, 0, 2, 1).To4()},
+		{IP: net.IPv4(192, 0, 2, 2).To4(), Latency: 30 * time.Millisecond},
+		{IP: net.IPv4(192, 0, 2, 3).To4(), Latency: 10 * time.Millisecond},
+	})
+
+	query := new(dns.Msg)
+	query.SetQuestion(key, dns.TypeA)
+	msg := h.resolve(query, queryClient{}, zap.NewNop())
+	var got []string
+	for _, rr := range msg.Answer {
+		got = append(got, rr.(*dns.A).A.String())
+	}
+	if want := []string{"192.0.2.3", "192.0.2.2", "192.0.2.1"}; !slices.Equal(got, want) {
+		t.Fatalf("answer order = %v, want %v", got, want)
+	}
+}
+
+func TestAnswersPerResponseServesRandomSubset(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{Domain: key, AnswersPerResponse: 2}
+	var records []Record
+	for i := range 10 {
+		records = append(records, Record{IP: net.IPv4(192, 0, 2, byte(i+1)).To4()})
+	}
+	h.UpdateRecords(key, records)
+
+	seen := make(map[string]struct{})
+	for range 50 {
+		query := new(dns.Msg)
+		query.SetQuestion(key, dns.TypeA)
+		msg := h.resolve(query, queryClient{}, zap.NewNop())
+		if len(msg.Answer) != 2 {
+			t.Fatalf("answer = %v, want 2 records", msg.Answer)
+		}
+		first, second := msg.Answer[0].(*dns.A).A, msg.Answer[1].(*dns.A).A
+		if first[3] >= second[3] {
+			t.Fatalf("answer = %v, want the subset in record order", msg.Answer)
+		}
+		seen[first.String()] = struct{}{}
+		seen[second.String()] = struct{}{}
+	}
+	if len(seen) < 3 {
+		t.Fatalf("served %d distinct records over 50 responses, want a random subset per response", len(seen))
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/fmotalleb/go-tools/log"
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+const (
+	selfTestDomain  = "selftest.helios-dns.invalid."
+	selfTestPath    = "/selftest"
+	selfTestTimeout = 2 * time.Second
+	selfTestPerm    = 0o600
+)
+
+// selfTestConfig scans the built-in test server listening on port and nothing else.
+const selfTestConfig = `
+listen: 127.0.0.1:0
+interval: 1m
+domains:
+  - domain: %q
+    cidr: ["127.0.0.1/32"]
+    port: %d
+    sni: selftest.helios-dns.invalid
+    path: %q
+    http_only: true
+    status_code: %d
+    sample_min: 1
+    result_limit: 1
+    timeout: %d
+`
+
+// SelfTestStep is the outcome of one step of the self-test.
+type SelfTestStep struct {
+	Name     string `json:"name" yaml:"name"`
+	Passed   bool   `json:"passed" yaml:"passed"`
+	Duration string `json:"duration" yaml:"duration"`
+	Detail   string `json:"detail,omitempty" yaml:"detail,omitempty"`
+}
+
+// SelfTestReport lists the steps of the self-test, it passed if every step passed.
+type SelfTestReport struct {
+	Passed bool           `json:"passed" yaml:"passed"`
+	Steps  []SelfTestStep `json:"steps" yaml:"steps"`
+}
+
+// Header implements the table output of the CLI.
+func (r SelfTestReport) Header() []string {
+	return []string{"STEP", "RESULT", "DURATION", "DETAIL"}
+}
+
+// Rows implements the table output of the CLI.
+func (r SelfTestReport) Rows() [][]string {
+	rows := make([][]string, len(r.Steps))
+	for i, s := range r.Steps {
+		result := "pass"
+		if !s.Passed {
+			result = "FAIL"
+		}
+		rows[i] = []string{s.Name, result, s.Duration, s.Detail}
+	}
+	return rows
+}
+
+// run executes step unless an earlier one failed, and records its outcome.
+func (r *SelfTestReport) run(name string, step func() (string, error)) {
+	if !r.Passed {
+		r.Steps = append(r.Steps, SelfTestStep{Name: name, Detail: "skipped"})
+		return
+	}
+	start := time.Now()
+	detail, err := step()
+	if err != nil {
+		detail = err.Error()
+		r.Passed = false
+	}
+	r.Steps = append(r.Steps, SelfTestStep{
+		Name:     name,
+		Passed:   err == nil,
+		Duration: time.Since(start).Round(time.Microsecond).String(),
+		Detail:   detail,
+	})
+}
+
+// SelfTest starts a local test server, scans it through the regular update pipeline, serves
+// the result on a temporary DNS listener and queries it. args are the CLI defaults of the config.
+func SelfTest(ctx context.Context, args map[string]any) SelfTestReport {
+	report := SelfTestReport{Passed: true}
+	logger := log.Of(ctx)
+	var (
+		target  net.Listener
+		cfg     config.Config
+		handler *Handler
+		dnsSrv  *dns.Server
+		dnsAddr string
+	)
+	defer func() {
+		if target != nil {
+			_ = target.Close()
+		}
+		if dnsSrv != nil {
+			_ = dnsSrv.Shutdown()
+		}
+	}()
+
+	report.run("test server", func() (string, error) {
+		var err error
+		if target, err = new(net.ListenConfig).Listen(ctx, "tcp", "127.0.0.1:0"); err != nil {
+			return "", err
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET "+selfTestPath, func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+		srv := &http.Server{Handler: mux, ReadHeaderTimeout: selfTestTimeout}
+		go func() { _ = srv.Serve(target) }()
+		return "listening on " + target.Addr().String(), nil
+	})
+	report.run("config", func() (string, error) {
+		port := target.Addr().(*net.TCPAddr).Port
+		body := fmt.Sprintf(selfTestConfig, selfTestDomain, port, selfTestPath, http.StatusNoContent, selfTestTimeout.Nanoseconds())
+		dir, err := os.MkdirTemp("", "helios-dns-selftest-")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "config.yaml")
+		if err = os.WriteFile(path, []byte(body), selfTestPerm); err != nil {
+			return "", err
+		}
+		if err = config.Parse(ctx, &cfg, path, args); err != nil {
+			return "", err
+		}
+		if handler, err = NewHandler(cfg, logger, nil); err != nil {
+			return "", err
+		}
+		return "parsed and validated", nil
+	})
+	report.run("scan", func() (string, error) {
+		if err := recordUpdater(ctx, cfg, handler, new(cycleStats)); err != nil {
+			return "", err
+		}
+		accepted := handler.Snapshot()[selfTestDomain].Records
+		if len(accepted) == 0 {
+			return "", errors.New("the test server was not accepted")
+		}
+		return "accepted " + accepted[0].IP.String() + " in " + accepted[0].Latency.String(), nil
+	})
+	report.run("dns listener", func() (string, error) {
+		pc, err := new(net.ListenConfig).ListenPacket(ctx, "udp", "127.0.0.1:0")
+		if err != nil {
+			return "", err
+		}
+		started := make(chan struct{})
+		dnsSrv = &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+		go func() { _ = dnsSrv.ActivateAndServe() }()
+		<-started
+		dnsAddr = pc.LocalAddr().String()
+		return "listening on " + dnsAddr, nil
+	})
+	report.run("query", func() (string, error) {
+		return selfTestQuery(ctx, dnsAddr)
+	})
+	return report
+}
+
+// selfTestQuery queries the test domain from the DNS listener at addr, which has to answer with
+// the address of the test server.
+func selfTestQuery(ctx context.Context, addr string) (string, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(selfTestDomain, dns.TypeA)
+	client := &dns.Client{Net: "udp", Timeout: selfTestTimeout}
+	resp, _, err := client.ExchangeContext(ctx, query, addr)
+	if err != nil {
+		return "", err
+	}
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) == 0 {
+		return "", fmt.Errorf("got %s with %d answers", dns.RcodeToString[resp.Rcode], len(resp.Answer))
+	}
+	a, ok := resp.Answer[0].(*dns.A)
+	if !ok || !a.A.Equal(net.ParseIP("127.0.0.1")) {
+		return "", fmt.Errorf("unexpected answer %s", resp.Answer[0])
+	}
+	return "answered " + a.A.String() + " ttl " + strconv.Itoa(int(a.Hdr.Ttl)), nil
+}
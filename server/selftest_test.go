@@ -0,0 +1,34 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSelfTestPasses(t *testing.T) {
+	t.Parallel()
+
+	args := map[string]any{
+		"args": map[string]any{
+			"listen":        "127.0.0.1:5353",
+			"http_listen":   "",
+			"max_workers":   4,
+			"interval":      time.Minute.Nanoseconds(),
+			"cidrs":         []string{"198.51.100.0/24"},
+			"sni":           "",
+			"path":          "/",
+			"timeout":       (200 * time.Millisecond).Nanoseconds(),
+			"port":          443,
+			"status_code":   0,
+			"sample_min":    0,
+			"sample_max":    8,
+			"sample_chance": 0.05,
+			"http_only":     false,
+		},
+	}
+	report := SelfTest(context.Background(), args)
+	if !report.Passed || len(report.Steps) != 5 {
+		t.Fatalf("SelfTest() = %+v, want every step passed", report)
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+const (
+	dateSerialDigits = 100
+	stateFileMode    = 0o600
+)
+
+// serialManager keeps monotonically increasing zone serials, optionally
+// persisted to a state file so secondaries never observe a serial going back.
+type serialManager struct {
+	mu       sync.Mutex
+	logger   *zap.Logger
+	strategy string
+	path     string
+	serials  map[string]uint32
+	// dirty is set while bumped serials are not persisted yet.
+	dirty bool
+	// writeMu orders the writes of the state file, so an older state never replaces a newer one.
+	writeMu sync.Mutex
+}
+
+func newSerialManager(cfg config.SerialConfig, logger *zap.Logger) (*serialManager, error) {
+	sm := &serialManager{
+		logger:   logger,
+		strategy: cfg.Strategy,
+		path:     cfg.StateFile,
+		serials:  make(map[string]uint32),
+	}
+	if sm.path == "" {
+		return sm, nil
+	}
+	data, err := os.ReadFile(sm.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return sm, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &sm.serials); err != nil {
+		return nil, err
+	}
+	for zone, serial := range sm.serials {
+		updateZoneSerialMetric(zone, serial)
+	}
+	return sm, nil
+}
+
+// Serial returns the current serial of zone, initializing it if needed. An initial serial is not
+// persisted, after a restart it is initialized again to the same or a later value.
+func (sm *serialManager) Serial(zone string) uint32 {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if serial, ok := sm.serials[zone]; ok {
+		return serial
+	}
+	return sm.bumpLocked(zone)
+}
+
+// Bump advances the serial of zone, the new value is written to the state file by Persist.
+func (sm *serialManager) Bump(zone string) uint32 {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.dirty = true
+	return sm.bumpLocked(zone)
+}
+
+func (sm *serialManager) bumpLocked(zone string) uint32 {
+	next := max(sm.serials[zone]+1, sm.base(time.Now()))
+	sm.serials[zone] = next
+	updateZoneSerialMetric(zone, next)
+	return next
+}
+
+// Persist writes the serials to the state file if any was bumped since the last write. A failed
+// write is retried by the next call.
+func (sm *serialManager) Persist() {
+	if sm.path == "" {
+		return
+	}
+	sm.writeMu.Lock()
+	defer sm.writeMu.Unlock()
+	sm.mu.Lock()
+	if !sm.dirty {
+		sm.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(sm.serials)
+	sm.dirty = false
+	sm.mu.Unlock()
+	if err == nil {
+		err = writeStateFile(sm.path, data)
+	}
+	if err != nil {
+		sm.logger.Warn("failed to persist zone serials", zap.Error(err))
+		sm.mu.Lock()
+		sm.dirty = true
+		sm.mu.Unlock()
+	}
+}
+
+// base returns the lowest serial the strategy allows at now.
+func (sm *serialManager) base(now time.Time) uint32 {
+	switch sm.strategy {
+	case config.SerialDate:
+		date, _ := strconv.ParseUint(now.UTC().Format("20060102"), 10, 32)
+		return uint32(date) * dateSerialDigits
+	case config.SerialUnixTime:
+		return uint32(now.Unix()) //nolint:gosec // unix time fits until 2106, serial arithmetic wraps anyway
+	default:
+		return 1
+	}
+}
+
+// writeStateFile replaces the file at path with data, through a rename so it is never partially
+// written.
+func writeStateFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(stateFileMode); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
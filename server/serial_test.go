@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestSerialManagerPersists(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "serials.json")
+	cfg := config.SerialConfig{Strategy: config.SerialCounter, StateFile: path}
+	sm, err := newSerialManager(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("newSerialManager() returned error: %v", err)
+	}
+	if got := sm.Serial("example.com."); got != 1 {
+		t.Fatalf("Serial() = %d, want 1", got)
+	}
+	if got := sm.Bump("example.com."); got != 2 {
+		t.Fatalf("Bump() = %d, want 2", got)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("state file stat error = %v, want it written by Persist only", err)
+	}
+	sm.Persist()
+
+	reloaded, err := newSerialManager(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("newSerialManager() returned error: %v", err)
+	}
+	if got := reloaded.Bump("example.com."); got != 3 {
+		t.Fatalf("Bump() after a restart = %d, want 3", got)
+	}
+}
+
+func TestSerialPersistDoesNotBlockQueries(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "serials.json")
+	h := newTestHandler(t)
+	serials, err := newSerialManager(config.SerialConfig{Strategy: config.SerialCounter, StateFile: path}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("newSerialManager() returned error: %v", err)
+	}
+	h.serials = serials
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{Domain: key}
+
+	// A slow write of the state file is simulated by holding its lock.
+	serials.writeMu.Lock()
+	published := make(chan struct{})
+	go func() {
+		h.UpdateRecords(key, []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+		close(published)
+	}()
+	readable := make(chan struct{})
+	go func() {
+		for len(h.Snapshot()[key].Records) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		close(readable)
+	}()
+	select {
+	case <-readable:
+	case <-time.After(time.Second):
+		t.Fatal("records are not readable while the serial is persisted")
+	}
+	serials.writeMu.Unlock()
+	<-published
+
+	data, err := os.ReadFile(path) //nolint:gosec // path is in the temp dir of the test
+	if err != nil {
+		t.Fatalf("read state file: %v", err)
+	}
+	var persisted map[string]uint32
+	if err := json.Unmarshal(data, &persisted); err != nil || persisted[key] == 0 {
+		t.Fatalf("state file = %s (%v), want the serial of %s", data, err, key)
+	}
+}
@@ -2,165 +2,1225 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"net"
+	"os"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
-	"golang.org/x/sync/errgroup"
 
 	"github.com/fmotalleb/go-tools/log"
 	"github.com/miekg/dns"
 
+	"github.com/fmotalleb/helios-dns/certs"
+	"github.com/fmotalleb/helios-dns/check"
 	"github.com/fmotalleb/helios-dns/config"
 	dnsServer "github.com/fmotalleb/helios-dns/dns"
+	"github.com/fmotalleb/helios-dns/dnstap"
+	"github.com/fmotalleb/helios-dns/export"
+	"github.com/fmotalleb/helios-dns/forward"
+	"github.com/fmotalleb/helios-dns/policy"
+	"github.com/fmotalleb/helios-dns/privacy"
+	"github.com/fmotalleb/helios-dns/querylog"
+	"github.com/fmotalleb/helios-dns/report"
+	"github.com/fmotalleb/helios-dns/source"
 )
 
+const componentDNS = "dns"
+
 // Serve starts the DNS server and periodic record updater loop.
 func Serve(ctx context.Context, cfg config.Config) error {
-	localCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
 	logger := log.Of(ctx)
-	handler := &dnsHandler{
-		logger:      logger,
-		rwMux:       new(sync.RWMutex),
-		memory:      make(map[string][]net.IP),
-		updatedAt:   make(map[string]time.Time),
-		sniByDomain: make(map[string]string),
-		ttl:         uint32(cfg.UpdateInterval.Seconds()),
+	handler, err := NewHandler(cfg, logger, nil)
+	if err != nil {
+		return err
 	}
-	for _, domainCfg := range cfg.Domains {
-		handler.sniByDomain[domainCfg.Domain] = domainCfg.SNI
+	if cfg.Export.Dir != "" {
+		if handler.exporter, err = export.New(cfg.Export); err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := handler.exporter.Close(); closeErr != nil {
+				logger.Warn("failed to close probe export", zap.Error(closeErr))
+			}
+		}()
 	}
-	group, groupCtx := errgroup.WithContext(localCtx)
-
-	group.Go(func() error {
-		if err := dnsServer.Serve(groupCtx, cfg.Listen, handler); err != nil {
+	if cfg.QueryLog.File != "" {
+		if handler.queryLog, err = querylog.New(cfg.QueryLog, handler.anonymizer); err != nil {
 			return err
 		}
-		return nil
-	})
-	if cfg.HTTPListen != "" {
-		group.Go(func() error {
-			if err := serveHTTP(groupCtx, cfg.HTTPListen, cfg, handler); err != nil {
-				return err
+		defer func() {
+			if closeErr := handler.queryLog.Close(); closeErr != nil {
+				logger.Warn("failed to close query log", zap.Error(closeErr))
 			}
-			return nil
+		}()
+	}
+	if cfg.ErrorReporting.DSN != "" {
+		if handler.reporter, err = report.New(cfg.ErrorReporting); err != nil {
+			return err
+		}
+		defer handler.reporter.Close()
+	}
+	if cfg.Chaos {
+		handler.chaos = newFaultInjector()
+		logger.Warn("chaos mode enabled, faults can be injected through the HTTP API")
+	}
+	ready := newReadiness(componentDNS)
+
+	// Stages start in order and stop in reverse order: the scanner stops before the listeners
+	// serving its records, and the listeners before the sinks and upstreams they use.
+	var components lifecycle
+	var certManager *certs.Manager
+	sinks := []component{
+		{name: "forwarder", run: func(ctx context.Context) error {
+			return handler.forwarder.Run(ctx, cfg.UpstreamCheck)
+		}},
+		{name: "dnstap", run: handler.dnstap.Run},
+		{name: "probe_webhook", run: handler.probeWebhook.Run},
+	}
+	if cfg.ACME.Enabled {
+		certManager = certs.New(cfg.ACME, handler)
+		sinks = append(sinks, component{name: "acme", run: certManager.Run})
+	}
+	components.stage(sinks...)
+
+	listeners := dnsListeners(cfg, handler, ready)
+	if cfg.HTTPListen != "" {
+		listeners = append(listeners, component{
+			name: "http",
+			run: func(ctx context.Context) error {
+				return handler.reporter.Go(func() error {
+					return serveHTTP(ctx, cfg.HTTPListen, cfg, handler, ready, certManager)
+				}, map[string]string{"component": "http"})()
+			},
 		})
 	}
+	components.stage(listeners...)
+
+	components.stage(component{name: "notifier", run: func(ctx context.Context) error {
+		return handler.notifier.Run(ctx, handler)
+	}})
+
+	components.stage(
+		component{name: "scanner", run: func(ctx context.Context) error {
+			defer handler.reporter.Recover()
+			return runUpdater(ctx, cfg, handler)
+		}, stopTimeout: scannerStopTimeout},
+		component{name: "bootstrap", run: func(ctx context.Context) error {
+			defer handler.reporter.Recover()
+			bootstrapRecords(ctx, cfg, handler)
+			return nil
+		}, stopTimeout: scannerStopTimeout},
+		component{name: "rescans", run: func(ctx context.Context) error {
+			return handler.rescans.Run(ctx, handler)
+		}, stopTimeout: scannerStopTimeout},
+		component{name: "revalidate", run: func(ctx context.Context) error {
+			defer handler.reporter.Recover()
+			return handler.revalidator.Run(ctx, handler)
+		}, stopTimeout: scannerStopTimeout},
+		component{name: "watchdog", run: func(ctx context.Context) error {
+			return newWatchdog(cfg, handler).Run(ctx, cfg.Watchdog.Interval)
+		}},
+		component{name: "ip_lists", run: func(ctx context.Context) error {
+			return handler.ipLists.Run(ctx, handler)
+		}},
+	)
+
+	return components.Run(ctx)
+}
+
+// scannerStopTimeout bounds how long the probes in flight may take to notice the shutdown.
+const scannerStopTimeout = 10 * time.Second
+
+// runUpdater runs an update cycle right away and then every interval of cfg until ctx is done.
+func runUpdater(ctx context.Context, cfg config.Config, handler *Handler) error {
 	timer := time.NewTimer(cfg.UpdateInterval)
 	defer timer.Stop()
-	group.Go(func() error {
-		if err := recordUpdater(groupCtx, cfg, handler); err != nil {
+	stats := new(cycleStats)
+	for {
+		if err := recordUpdater(ctx, cfg, handler, stats); err != nil {
 			return err
 		}
-		for range timer.C {
-			if err := recordUpdater(groupCtx, cfg, handler); err != nil {
-				return err
-			}
-			timer.Reset(cfg.UpdateInterval)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
 		}
-		return nil
-	})
+		timer.Reset(cfg.UpdateInterval)
+	}
+}
+
+// Scan runs one update cycle of cfg, the config the handler was built with, and publishes the
+// records it found.
+func (d *Handler) Scan(ctx context.Context, cfg config.Config) error {
+	return recordUpdater(ctx, cfg, d, new(cycleStats))
+}
+
+// dnsListeners returns a component per DNS listen address. The DNS component is ready once every
+// listener is bound.
+func dnsListeners(cfg config.Config, handler *Handler, ready *readiness) []component {
+	var pendingListeners atomic.Int32
+	pendingListeners.Store(int32(len(cfg.Listen))) //nolint:gosec // a handful of listen addresses
+	listeners := make([]component, 0, len(cfg.Listen)+1)
+	for _, addr := range cfg.Listen {
+		listeners = append(listeners, component{
+			name: "dns " + addr,
+			run: func(ctx context.Context) error {
+				return handler.reporter.Go(func() error {
+					opts := dnsServer.Options{
+						BindRetry: cfg.BindRetry,
+						TCP:       cfg.TCPEnabled(),
+						OnReady: func() {
+							if pendingListeners.Add(-1) == 0 {
+								ready.markReady(componentDNS)
+							}
+						},
+						WriteTimeout: cfg.WriteTimeout,
+						DrainTimeout: cfg.DrainTimeout,
+						MaxQuestions: cfg.MaxQuestions,
+					}
+					if err := dnsServer.Serve(ctx, addr, handler, opts); err != nil {
+						return fmt.Errorf("dns listener %s: %w", addr, err)
+					}
+					return nil
+				}, map[string]string{"component": "dns", "listen": addr})()
+			},
+			stopTimeout: cfg.DrainTimeout + defaultStopTimeout,
+		})
+	}
+	return listeners
+}
+
+// NewHandler builds the handler serving the domains and zones of cfg from the records of store, a
+// new [MemoryStore] if it is nil. Keys of store that are not domains of cfg are expired.
+func NewHandler(cfg config.Config, logger *zap.Logger, store RecordStore) (*Handler, error) {
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	anonymizer := privacy.New(cfg.Privacy)
+	handler := &Handler{
+		logger:      logger,
+		rwMux:       new(sync.RWMutex),
+		store:       store,
+		domains:     make(map[string]*config.ScanConfig),
+		policies:    make(map[string]policy.AnswerPolicy),
+		orders:      make(map[string]policy.AnswerPolicy),
+		rotations:   make(map[string]*answerRotation),
+		aliases:     make(map[string]string),
+		srv:         make(map[string]string),
+		static:      buildStaticRecords(cfg.StaticRecords),
+		zones:       buildZones(cfg.Zones),
+		cidrs:       newCIDRTracker(),
+		latencies:   newLatencyHistory(),
+		clients:     newClientStats(anonymizer),
+		outside:     newOutsideThrottle(cfg.OutsideLimit, anonymizer),
+		revalidator: newRevalidator(cfg),
+		limits:      newScanLimits(cfg),
+		txt:         make(map[string][]string),
+		ttl:         uint32(cfg.UpdateInterval.Seconds()),
+
+		generations:    make(map[string]uint64),
+		domainACLs:     make(map[string]*clientACL),
+		pools:          make(map[string]poolState),
+		forwardUnknown: cfg.ForwardUnknown || cfg.Proxy(),
+		proxy:          cfg.Proxy(),
+		udpSize:        cfg.EDNS.UDPSize,
+		unknownRcode:   rcodeNames[cfg.Rcodes.UnknownName],
+		outsideRcode:   rcodeNames[cfg.Rcodes.OutsideZones],
+		clientSubnet:   cfg.EDNS.ClientSubnet,
+		padding:        cfg.EDNS.Padding,
+		compress:       cfg.Compress,
+		maxQuestions:   cfg.MaxQuestions,
+		crashOnPanic:   cfg.CrashOnPanic,
+		anonymizer:     anonymizer,
+		selfTestToken:  rand.Text(),
+	}
+	forwarder, err := forward.New(cfg.UpstreamList(), cfg.UpstreamTimeout, cfg.UpstreamFailures)
+	if err != nil {
+		return nil, err
+	}
+	handler.forwarder = forwarder
+	for _, domainCfg := range cfg.Domains {
+		if !domainCfg.IsEnabled() && !domainCfg.ServeDisabled {
+			continue
+		}
+		if err = handler.addDomain(domainCfg); err != nil {
+			return nil, fmt.Errorf("domain %s: %w", domainCfg.Domain, err)
+		}
+	}
+	allow, deny, err := cfg.ClientACL()
+	if err != nil {
+		return nil, err
+	}
+	handler.acl = newClientACL(allow, deny)
+	if handler.ipLists, err = newIPLists(cfg.IPLists); err != nil {
+		return nil, err
+	}
+	clientGroups, err := buildClientGroups(cfg.ClientGroups, handler.ttl)
+	if err != nil {
+		return nil, err
+	}
+	handler.clientGroups = clientGroups
+	if err = setupDNSSEC(handler.zones, cfg.Zones, logger); err != nil {
+		return nil, err
+	}
+	if handler.transfers, err = buildTransfers(cfg.Zones); err != nil {
+		return nil, err
+	}
+	handler.notifier = newZoneNotifier(handler.transfers)
+	handler.rescans = newMissScanner(cfg)
+	handler.dnstap = dnstap.New(cfg.Dnstap, handler.anonymizer)
+	handler.probeWebhook = newProbeWebhook(cfg.ProbeWebhook)
+	if handler.serials, err = newSerialManager(cfg.Serial, logger); err != nil {
+		return nil, err
+	}
+	handler.dropStaleRecords()
+	return handler, nil
+}
+
+// addDomain serves domainCfg, with its aliases, answer policies and client lists.
+func (d *Handler) addDomain(domainCfg *config.ScanConfig) error {
+	if domainCfg.HTTP3.Enabled && !check.HTTP3Supported {
+		return check.ErrHTTP3Unsupported
+	}
+	d.domains[domainCfg.Domain] = domainCfg
+	if domainCfg.CatchAll {
+		d.catchAll = domainCfg.Domain
+	}
+	for _, alias := range domainCfg.ServedAliases() {
+		d.aliases[alias] = domainCfg.Domain
+	}
+	if name := domainCfg.SRVName(); name != "" {
+		d.srv[name] = domainCfg.Domain
+	}
+	selector, err := policy.New(domainCfg.AnswerPolicy.Name, policy.Options{Count: domainCfg.AnswerPolicy.Count})
+	if err != nil {
+		return err
+	}
+	d.policies[domainCfg.Domain] = selector
+	if order, ok := answerOrders[domainCfg.AnswerOrder]; ok {
+		if d.orders[domainCfg.Domain], err = policy.New(order, policy.Options{}); err != nil {
+			return err
+		}
+	}
+	if rotation := newAnswerRotation(domainCfg.Rotation); rotation != nil {
+		d.rotations[domainCfg.Domain] = rotation
+	}
+	allow, deny, err := domainCfg.ClientACL()
+	if err != nil {
+		return err
+	}
+	if acl := newClientACL(allow, deny); acl != nil {
+		d.domainACLs[domainCfg.Domain] = acl
+	}
+	return nil
+}
 
-	return group.Wait()
+// dropStaleRecords expires the keys of the store that are not served domains, and removes the
+// records the ip_lists files do not allow.
+func (d *Handler) dropStaleRecords() {
+	for key := range d.store.Snapshot() {
+		if _, ok := d.domains[key]; !ok {
+			d.store.Expire(key)
+		}
+	}
+	if d.ipLists != nil {
+		d.pruneRecords(d.ipLists.acl.Load())
+	}
 }
 
-type dnsHandler struct {
-	logger      *zap.Logger
-	rwMux       *sync.RWMutex
-	memory      map[string][]net.IP
-	updatedAt   map[string]time.Time
-	sniByDomain map[string]string
+// Handler answers DNS queries for the configured domains from the records published to it, and
+// implements [dns.Handler]. Its methods are safe for concurrent use.
+type Handler struct {
+	logger *zap.Logger
+	// rwMux guards the store along with the state derived from the records.
+	rwMux   *sync.RWMutex
+	store   RecordStore
+	domains map[string]*config.ScanConfig
+	// generation counts record publishes, generations holds the last one of every key.
+	generation  uint64
+	generations map[string]uint64
+	// aliases maps alias names to the domain whose records they serve.
+	aliases map[string]string
+	// catchAll is the domain serving the names no other entry serves, empty without one.
+	catchAll  string
+	forwarder *forward.Forwarder
+	// forwardUnknown proxies queries for names that are not configured domains to the forwarder.
+	forwardUnknown bool
+	// proxy forwards the queries of domains without servable IPs and of their other types too.
+	proxy bool
+	// zones are the zones served authoritatively, most specific first.
+	zones []*zone
+	// transfers holds the zones secondaries may transfer, by zone name.
+	transfers map[string]*zoneTransfer
+	// notifier is nil unless a zone notifies its secondaries.
+	notifier *zoneNotifier
+	// static holds the records of static_records keyed by canonical name.
+	static map[string][]dns.RR
+	// txt holds published TXT records, such as ACME challenges, keyed by canonical name.
+	txt map[string][]string
+	// srv maps the SRV names of the domains with srv.service to the domain.
+	srv map[string]string
+
+	clientGroups []clientGroup
+	serials      *serialManager
+	// policies select the answers of each domain.
+	policies map[string]policy.AnswerPolicy
+	// orders sort the answers of the domains with an answer order other than fixed.
+	orders map[string]policy.AnswerPolicy
+	// rotations reorder the answers of the domains with a rotation mode.
+	rotations map[string]*answerRotation
+	// acl is nil unless allow_clients or deny_clients is set, domainACLs holds the domains with
+	// their own lists.
+	acl        *clientACL
+	domainACLs map[string]*clientACL
+	// chaos is nil unless chaos mode is enabled.
+	chaos *faultInjector
+	// exporter is nil unless probe export is enabled.
+	exporter *export.Exporter
+	// probeWebhook is nil unless probe_webhook is set.
+	probeWebhook *probeWebhook
+	// cidrs tracks unproductive CIDRs of every domain for pruning.
+	cidrs *cidrTracker
+	// ipLists is nil unless ip_lists files are set.
+	ipLists *ipLists
+	// latencies keeps the recent latencies of the served IPs for /api/latency.
+	latencies *latencyHistory
+	// pools holds the smoothed healthy pool size of every key.
+	pools map[string]poolState
+	// clients counts the queries of every client subnet for /api/clients.
+	clients *clientStats
+	// outside is nil unless outside_zones_limit is set.
+	outside *outsideThrottle
+	// revalidator is nil unless revalidate is enabled.
+	revalidator *revalidator
+	// limits are shared by every scan.
+	limits *scanLimits
+	// publishHolds tracks the publish groups keeping their previous generation.
+	publishHolds publishHolds
+	// reporter is nil unless error reporting is enabled.
+	reporter *report.Reporter
+	// crashOnPanic lets panics of queries and probes propagate once reported.
+	crashOnPanic bool
+	// rescans is nil unless rescan_on_miss is enabled.
+	rescans *missScanner
+	// dnstap is nil unless dnstap logging is enabled.
+	dnstap *dnstap.Writer
+	// queryLog is nil unless query_log is set.
+	queryLog *querylog.Logger
+	// anonymizer is nil unless privacy is enabled, it anonymizes the client addresses logged.
+	anonymizer *privacy.Anonymizer
+	// selfTestToken authenticates the queries of the watchdog, see isSelfTest.
+	selfTestToken string
+	// checks replace the program and native checks of every domain once set by useChecks.
+	checksMu sync.Mutex
+	checks   []check.Checker
+
+	// udpSize is the UDP payload size advertised in EDNS0 answers.
+	udpSize uint16
+	// clientSubnet selects answers for the EDNS Client Subnet of a query instead of its source.
+	clientSubnet bool
+	// compress and padding shape answers, domains may override both.
+	compress bool
+	padding  int
+	// maxQuestions bounds the questions of a query, zero allows any number.
+	maxQuestions int
+	// unknownRcode answers served names without records, outsideRcode names outside the served zones.
+	unknownRcode int
+	outsideRcode int
 
 	ttl uint32
 }
 
-func (d *dnsHandler) UpdateRecords(key string, records []net.IP) {
+func (d *Handler) sniOf(name string) string {
+	if _, domainCfg, ok := d.lookupDomain(name); ok {
+		return domainCfg.SNI
+	}
+	return ""
+}
+
+// lookupDomain returns the configured domain serving name, an exact entry or alias wins over
+// the closest wildcard entry such as *.cdn.example.com., which matches names at any depth below it.
+// Names are matched case-insensitively, so 0x20 randomized queries find their domain.
+func (d *Handler) lookupDomain(name string) (string, *config.ScanConfig, bool) {
+	name = dns.CanonicalName(name)
+	if domainCfg, ok := d.domains[name]; ok {
+		return name, domainCfg, true
+	}
+	if key, ok := d.aliases[name]; ok {
+		return key, d.domains[key], true
+	}
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		key := "*." + name[off:]
+		if domainCfg, ok := d.domains[key]; ok {
+			return key, domainCfg, true
+		}
+	}
+	return "", nil, false
+}
+
+// catchAllFor returns the catch-all domain when it answers the addresses of name, a name no
+// domain serves. Only A and AAAA queries are answered by it, any other lookup treats name as
+// unknown.
+func (d *Handler) catchAllFor(name string) (string, *config.ScanConfig, bool) {
+	if d.catchAll == "" || d.servesOther(name) {
+		return "", nil, false
+	}
+	return d.catchAll, d.domains[d.catchAll], true
+}
+
+// servesOther reports whether a static record or an SRV name answers for name, the catch-all
+// domain does not shadow them.
+func (d *Handler) servesOther(name string) bool {
+	if _, ok := d.static[dns.CanonicalName(name)]; ok {
+		return true
+	}
+	_, ok := d.srvKey(name)
+	return ok
+}
+
+// domainKey returns the configured domain serving name, or name itself when there is none.
+func (d *Handler) domainKey(name string) string {
+	if key, _, ok := d.lookupDomain(name); ok {
+		return key
+	}
+	return dns.CanonicalName(name)
+}
+
+// UpdateRecords replaces the records of key with a freshly validated set, key is matched
+// case-insensitively.
+// IPs that were already served keep their decayed confidence and get boosted,
+// IPs missing from the set are kept until the grace period of the domain elapsed.
+func (d *Handler) UpdateRecords(key string, records []Record) {
+	d.publishRecords(map[string][]Record{key: records})
+}
+
+// publishRecords updates the records of every key of sets as one generation, under a single
+// lock so no query observes a mix of old and new sets. It returns the generation.
+func (d *Handler) publishRecords(sets map[string][]Record) uint64 {
 	now := time.Now()
+	defer d.serials.Persist()
 	d.rwMux.Lock()
 	defer d.rwMux.Unlock()
-	d.memory[key] = records
-	d.updatedAt[key] = now
-	updateRecordMetrics(key, records, now)
+	d.generation++
+	for key, records := range sets {
+		key = dns.CanonicalName(key)
+		d.updateLocked(key, records, now)
+		d.generations[key] = d.generation
+	}
+	return d.generation
 }
 
-type recordSnapshot struct {
-	IPs       []net.IP
-	UpdatedAt time.Time
+// updateLocked replaces the records of key, callers must hold the write lock.
+func (d *Handler) updateLocked(key string, records []Record, now time.Time) {
+	halfLife, boost := d.confidenceSettings(key)
+	var grace, stale time.Duration
+	var limit int
+	if domainCfg, ok := d.domains[key]; ok {
+		grace, stale, limit = domainCfg.GracePeriod, domainCfg.StaleWindow, domainCfg.Limit
+	}
+	previous, _ := d.store.Get(key)
+	records = slices.DeleteFunc(dedupeRecords(records), func(r Record) bool { return !d.ipLists.allows(r.IP) })
+	fresh := len(records)
+	for i := range records {
+		decayed := 0.0
+		if idx := indexOfIP(previous, records[i].IP); idx >= 0 {
+			decayed = previous[idx].confidenceAt(now, halfLife)
+		}
+		records[i].ValidatedAt = now
+		records[i].Confidence = min(1, decayed+boost)
+		records[i].DroppedAt = time.Time{}
+	}
+	for _, prev := range previous {
+		if grace <= 0 || indexOfIP(records[:fresh], prev.IP) >= 0 {
+			continue
+		}
+		if prev.DroppedAt.IsZero() {
+			prev.DroppedAt = now
+		}
+		if now.Sub(prev.DroppedAt) < grace {
+			records = append(records, prev)
+		}
+	}
+	if stale > 0 && len(records) < limit {
+		records = append(records, staleRecords(previous, records, limit-len(records), stale, now)...)
+	}
+	records = d.keepPinned(previous, records)
+	d.latencies.record(key, records[:fresh], now)
+	d.smoothPool(key, fresh)
+	hadServable := len(d.servable(key, now)) > 0
+	changed := !sameIPs(previous, records)
+	d.store.Update(key, records, now)
+	d.recordFallback(key, hadServable, len(d.servable(key, now)))
+	updateRecordMetrics(key, len(records), now)
+	if changed {
+		d.bumpSerial(key)
+	}
+}
+
+// keepPinned returns records with the pinned IPs of previous the ip_lists allow.
+func (d *Handler) keepPinned(previous, records []Record) []Record {
+	for _, prev := range previous {
+		if !prev.Pinned || !d.ipLists.allows(prev.IP) {
+			continue
+		}
+		if idx := indexOfIP(records, prev.IP); idx >= 0 {
+			records[idx].Pinned, records[idx].DroppedAt = true, time.Time{}
+			continue
+		}
+		records = append(records, prev)
+	}
+	return records
+}
+
+// bumpSerial advances the serial of the zone serving key and notifies its secondaries. Callers
+// hold the write lock, and defer d.serials.Persist before taking it so the state file is written
+// once queries are no longer blocked.
+func (d *Handler) bumpSerial(key string) {
+	zone := d.zoneName(key)
+	d.serials.Bump(zone)
+	d.notifier.changed(zone)
+}
+
+func (d *Handler) confidenceSettings(key string) (time.Duration, float64) {
+	if domainCfg, ok := d.domains[key]; ok {
+		return domainCfg.ConfidenceHalfLife, domainCfg.ConfidenceBoost
+	}
+	return 0, 1
+}
+
+// AddRecord pins ip in the records of key, where update cycles keep it until it is removed. It
+// reports false if ip was already pinned.
+func (d *Handler) AddRecord(key string, ip net.IP) bool {
+	key = dns.CanonicalName(key)
+	now := time.Now()
+	defer d.serials.Persist()
+	d.rwMux.Lock()
+	defer d.rwMux.Unlock()
+	records, _ := d.store.Get(key)
+	// Copy on write so snapshots taken before the update stay untouched.
+	updated := make([]Record, len(records), len(records)+1)
+	copy(updated, records)
+	if idx := indexOfIP(records, ip); idx >= 0 {
+		if records[idx].Pinned {
+			return false
+		}
+		updated[idx].Pinned = true
+		updated[idx].DroppedAt = time.Time{}
+		d.store.Update(key, updated, now)
+		return true
+	}
+	updated = append(updated, Record{
+		IP:          normalizeIP(ip),
+		ValidatedAt: now,
+		Confidence:  1,
+		Pinned:      true,
+	})
+	d.store.Update(key, updated, now)
+	updateRecordMetrics(key, len(updated), now)
+	d.bumpSerial(key)
+	return true
+}
+
+// RemoveRecord deletes ip from the records of key, pinned or not, it reports false if ip was not
+// present. An IP that was not pinned is served again once a cycle accepts it.
+func (d *Handler) RemoveRecord(key string, ip net.IP) bool {
+	key = dns.CanonicalName(key)
+	now := time.Now()
+	defer d.serials.Persist()
+	d.rwMux.Lock()
+	defer d.rwMux.Unlock()
+	records, _ := d.store.Get(key)
+	idx := indexOfIP(records, ip)
+	if idx < 0 {
+		return false
+	}
+	updated := slices.Delete(slices.Clone(records), idx, idx+1)
+	d.store.Update(key, updated, now)
+	updateRecordMetrics(key, len(updated), now)
+	d.bumpSerial(key)
+	return true
+}
+
+// drainingIPs returns the IPs of key served for their grace period only.
+func (d *Handler) drainingIPs(key string) []net.IP {
+	d.rwMux.RLock()
+	defer d.rwMux.RUnlock()
+	var out []net.IP
+	records, _ := d.store.Get(key)
+	for _, r := range records {
+		if !r.DroppedAt.IsZero() {
+			out = append(out, r.IP)
+		}
+	}
+	return out
 }
 
-func (d *dnsHandler) Snapshot() map[string]recordSnapshot {
+// servedIPs returns the IPs of key that are not draining.
+func (d *Handler) servedIPs(key string) []net.IP {
 	d.rwMux.RLock()
 	defer d.rwMux.RUnlock()
-	result := make(map[string]recordSnapshot, len(d.memory))
-	for key, records := range d.memory {
-		copyRecords := make([]net.IP, len(records))
-		for i, ip := range records {
-			ipCopy := make(net.IP, len(ip))
-			copy(ipCopy, ip)
-			copyRecords[i] = ipCopy
+	var out []net.IP
+	records, _ := d.store.Get(key)
+	for _, r := range records {
+		if r.DroppedAt.IsZero() {
+			out = append(out, r.IP)
+		}
+	}
+	return out
+}
+
+// scannedIPs returns the IPs of key that are neither draining nor pinned.
+func (d *Handler) scannedIPs(key string) []net.IP {
+	d.rwMux.RLock()
+	defer d.rwMux.RUnlock()
+	var out []net.IP
+	records, _ := d.store.Get(key)
+	for _, r := range records {
+		if r.DroppedAt.IsZero() && !r.Pinned {
+			out = append(out, r.IP)
+		}
+	}
+	return out
+}
+
+// revalidated applies a re-validation of the records of key between update cycles, or the
+// results of a deferred scan. The IPs of
+// passed are validated again, the ones of failed are evicted right away, without a grace period,
+// and promoted are served in their place.
+func (d *Handler) revalidated(key string, passed []source.Record, failed []net.IP, promoted []source.Record) {
+	key = dns.CanonicalName(key)
+	now := time.Now()
+	halfLife, boost := d.confidenceSettings(key)
+	defer d.serials.Persist()
+	d.rwMux.Lock()
+	defer d.rwMux.Unlock()
+	previous, _ := d.store.Get(key)
+	records := make([]Record, 0, len(previous)+len(promoted))
+	for _, r := range previous {
+		if slices.ContainsFunc(failed, r.IP.Equal) {
+			continue
 		}
-		result[key] = recordSnapshot{
-			IPs:       copyRecords,
-			UpdatedAt: d.updatedAt[key],
+		if idx := slices.IndexFunc(passed, func(p source.Record) bool { return p.IP.Equal(r.IP) }); idx >= 0 && r.DroppedAt.IsZero() {
+			r.Latency = passed[idx].Latency
+			r.Confidence = min(1, r.confidenceAt(now, halfLife)+boost)
+			r.ValidatedAt = now
 		}
+		records = append(records, r)
+	}
+	for _, p := range promoted {
+		if indexOfIP(records, p.IP) >= 0 || !d.ipLists.allows(p.IP) {
+			continue
+		}
+		records = append(records, Record{IP: normalizeIP(p.IP), Latency: p.Latency, ValidatedAt: now, Confidence: min(1, boost)})
+	}
+	d.store.Update(key, records, now)
+	updateRecordMetrics(key, len(records), now)
+	if !sameIPs(previous, records) {
+		d.bumpSerial(key)
+	}
+}
+
+// hasRecords reports whether records are stored or TXT records are published under key.
+func (d *Handler) hasRecords(key string) bool {
+	d.rwMux.RLock()
+	defer d.rwMux.RUnlock()
+	_, ok := d.store.Get(key)
+	return ok || len(d.txt[key]) > 0
+}
+
+// hasStored reports whether records are stored under key.
+func (d *Handler) hasStored(key string) bool {
+	d.rwMux.RLock()
+	defer d.rwMux.RUnlock()
+	_, ok := d.store.Get(key)
+	return ok
+}
+
+// servable returns the records of key that may be served at now.
+// Callers must hold the read lock.
+func (d *Handler) servable(key string, now time.Time) []policy.Candidate {
+	records, _ := d.store.Get(key)
+	var minConfidence float64
+	var halfLife time.Duration
+	if domainCfg, ok := d.domains[key]; ok {
+		minConfidence, halfLife = domainCfg.MinConfidence, domainCfg.ConfidenceHalfLife
+	}
+	candidates := make([]policy.Candidate, 0, len(records))
+	for _, r := range records {
+		confidence := r.confidenceAt(now, halfLife)
+		if minConfidence > 0 && confidence < minConfidence {
+			continue
+		}
+		candidates = append(candidates, policy.Candidate{IP: r.IP, Latency: r.Latency, Confidence: confidence})
+	}
+	return candidates
+}
+
+// RecordSnapshot is a copy of the records of a key.
+type RecordSnapshot struct {
+	Records   []Record
+	UpdatedAt time.Time
+	// PoolSize is the smoothed healthy pool size, see [config.PoolConfig].
+	PoolSize float64
+	// Generation is the publish that last replaced the records, zero for manual changes only.
+	Generation uint64
+}
+
+// Snapshot returns a copy of the records of every key.
+func (d *Handler) Snapshot() map[string]RecordSnapshot {
+	d.rwMux.RLock()
+	defer d.rwMux.RUnlock()
+	result := d.store.Snapshot()
+	for key, snapshot := range result {
+		snapshot.Generation = d.generations[key]
+		snapshot.PoolSize = d.pools[key].size
+		result[key] = snapshot
 	}
 	return result
 }
 
 // ServeDNS implements [dns.Handler].
-func (d *dnsHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
-	msg := new(dns.Msg)
-	msg.SetReply(r)
-
-	if len(r.Question) == 0 {
-		if err := w.WriteMsg(msg); err != nil {
-			d.logger.Info("failed to write answer to empty question", zap.Error(err))
+func (d *Handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			d.handlePanic("dns", rec, d.logger)
+			// The answer is written directly, the usual reply path may panic again.
+			msg := new(dns.Msg)
+			msg.SetRcode(r, dns.RcodeServerFailure)
+			_ = w.WriteMsg(msg)
 		}
+	}()
+	w = d.queryLog.Wrap(d.dnstap.Wrap(w, r))
+	if len(r.Question) == 0 {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		d.reply(w, msg, d.logger)
+		return
+	}
+	for _, q := range r.Question {
+		recordDNSRequest(d.metricDomain(q.Name), d.sniOf(q.Name), q.Qtype)
+	}
+	d.clients.record(addrIP(w.RemoteAddr()), time.Now())
+	// Every question is resolved, signed and possibly forwarded on its own.
+	if d.maxQuestions > 0 && len(r.Question) > d.maxQuestions {
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeFormatError)
+		d.reply(w, msg, d.logger)
 		return
 	}
 	q := r.Question[0]
-	sni := d.sniByDomain[q.Name]
-	recordDNSRequest(q.Name, sni)
+	if opt := r.IsEdns0(); opt != nil && opt.Version() != 0 {
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeBadVers)
+		d.reply(w, d.edns(r, msg, nil), d.logger)
+		return
+	}
+	clientIP, subnet := d.clientAddr(r, w.RemoteAddr())
+	source := addrIP(w.RemoteAddr())
+	from := queryClient{source: source, subnet: clientIP, selfTest: d.isSelfTest(r, source)}
 	logger := d.logger.WithLazy(
 		zap.String("name", q.Name),
 		zap.Uint16("class", q.Qclass),
 		zap.Uint16("type", q.Qtype),
-		zap.String("from", w.RemoteAddr().String()),
+		zap.Stringer("from", d.anonymizer.Addr(w.RemoteAddr())),
 	)
 	logger.Debug("handling dns request")
-	if q.Qtype != dns.TypeA {
-		if err := w.WriteMsg(msg); err != nil {
-			d.logger.Info("failed to write answer to non A record request", zap.Error(err))
-		}
+	// The source address is checked rather than the client subnet, which the client controls.
+	// Every question must be permitted.
+	if !from.selfTest && slices.ContainsFunc(r.Question, func(q dns.Question) bool { return !d.permits(source, q.Name) }) {
+		logger.Debug("client refused by acl")
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeRefused)
+		d.reply(w, d.edns(r, withEDE(msg, dns.ExtendedErrorCodeProhibited, "client not allowed"), nil), logger)
+		return
+	}
+	if d.dropsOutside(r, source, logger) {
+		return
+	}
+	if q.Qtype == dns.TypeAXFR || q.Qtype == dns.TypeIXFR {
+		d.transfer(w, r, logger)
 		return
 	}
+	msg := d.answerQuestions(r, from, logger)
+	d.reply(w, d.shape(w, r, d.truncate(w, r, d.edns(r, msg, subnet))), logger)
+}
+
+// dropsOutside reports whether r, whose questions are all outside the served zones, exceeds the
+// rate limit of the subnet of source.
+func (d *Handler) dropsOutside(r *dns.Msg, source net.IP, logger *zap.Logger) bool {
+	if d.outside == nil || slices.ContainsFunc(r.Question, func(q dns.Question) bool { return !d.outsideZones(q.Name) }) {
+		return false
+	}
+	allowed, subnet, throttled := d.outside.allow(source, time.Now())
+	if throttled {
+		logger.Warn("dropping queries outside the served zones", zap.String("subnet", subnet))
+	}
+	if !allowed {
+		recordOutsideDropped()
+	}
+	return !allowed
+}
+
+// resolve builds the reply to r, which must have a question, for the client from.
+func (d *Handler) resolve(r *dns.Msg, from queryClient, logger *zap.Logger) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.RecursionAvailable = d.proxy
+	q := r.Question[0]
+	if q.Qtype == dns.TypeTXT && d.answerTXT(msg, q.Name) {
+		return msg
+	}
+	key, domainCfg, local, allowed := d.servingDomain(q, from)
+	if !allowed {
+		msg.Rcode = dns.RcodeRefused
+		return withEDE(msg, dns.ExtendedErrorCodeProhibited, "client not allowed")
+	}
+	client := d.policyOf(from)
+	if srvKey, ok := d.srvOwner(q.Name, key, local); ok {
+		return d.resolveSRV(msg, srvKey, client)
+	}
+	z := d.zoneFor(q.Name)
+	if d.resolveApex(msg, z, q) {
+		return msg
+	}
+	_, static := d.static[dns.CanonicalName(q.Name)]
+	forwarded := d.forwardsName(local, static, z)
+	if !client.allowsQuery(key, forwarded) {
+		logger.Debug("domain not allowed for client group", zap.String("group", client.group))
+		msg.Rcode = dns.RcodeRefused
+		return withEDE(msg, dns.ExtendedErrorCodeProhibited, "domain not allowed for client group")
+	}
+	if d.resolveANY(msg, q, z, domainCfg, client, local || static) {
+		return msg
+	}
+	switch {
+	case static && !local:
+		return d.resolveStatic(r, msg, client, from, logger)
+	case forwarded:
+		logger.Debug("forwarding unknown name to upstream")
+		return d.forward(r, msg, logger)
+	case !local && !d.hasStored(key):
+		return d.resolveUnknown(msg, q.Name, z)
+	}
+	return d.resolveServed(r, msg, key, domainCfg, client, from, local, logger)
+}
+
+// srvOwner returns the domain whose SRV records answer name, served by the domain key. SRV names
+// are exact names, they win over wildcard domains only.
+func (d *Handler) srvOwner(name, key string, local bool) (string, bool) {
+	if local && !strings.HasPrefix(key, "*.") {
+		return "", false
+	}
+	return d.srvKey(name)
+}
+
+// servingDomain returns the key and the config of the domain serving the name of q, and whether it
+// is a configured domain. Addresses of names no domain serves are served by the catch_all domain,
+// allowed reports whether from passes its ACL, which ServeDNS checked for the name only.
+func (d *Handler) servingDomain(q dns.Question, from queryClient) (string, *config.ScanConfig, bool, bool) {
+	key, domainCfg, local := d.lookupDomain(q.Name)
+	if !local && (q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA) {
+		key, domainCfg, local = d.catchAllFor(q.Name)
+		if local && !from.selfTest && !d.domainACLs[key].allows(from.source) {
+			return key, domainCfg, local, false
+		}
+	}
+	if !local {
+		key = dns.CanonicalName(q.Name)
+	}
+	return key, domainCfg, local, true
+}
 
+// resolveApex answers the apex records of z, which are answered to every client group, it reports
+// whether q asked for them.
+func (d *Handler) resolveApex(msg *dns.Msg, z *zone, q dns.Question) bool {
+	if z == nil || !z.isApex(q.Name) {
+		return false
+	}
+	switch q.Qtype {
+	case dns.TypeSOA, dns.TypeNS, dns.TypeDNSKEY:
+		msg.Answer = d.apexRecords(z, q.Name, q.Qtype)
+		return true
+	default:
+		return false
+	}
+}
+
+// forwardsName reports whether a name that is neither a configured domain, nor a static record,
+// nor in the zone z is forwarded to the upstreams.
+func (d *Handler) forwardsName(local, static bool, z *zone) bool {
+	return !local && !static && z == nil && d.forwardUnknown
+}
+
+// resolveANY answers an ANY query for a served name minimally, it reports whether q was one. served
+// tells whether the name is a configured domain or a static record, the apex of z is served too.
+func (d *Handler) resolveANY(
+	msg *dns.Msg,
+	q dns.Question,
+	z *zone,
+	domainCfg *config.ScanConfig,
+	client clientPolicy,
+	served bool,
+) bool {
+	if q.Qtype != dns.TypeANY || d.isCNAME(q.Name, domainCfg) {
+		return false
+	}
+	if !served && (z == nil || !z.isApex(q.Name)) {
+		return false
+	}
+	msg.Answer = append(msg.Answer, minimalANY(q.Name, client.ttl))
+	return true
+}
+
+// resolveUnknown answers a query for a name without records, within the served zones or not.
+func (d *Handler) resolveUnknown(msg *dns.Msg, name string, z *zone) *dns.Msg {
+	if msg.Rcode = d.negativeRcode(name, z); msg.Rcode == dns.RcodeRefused {
+		return withEDE(msg, dns.ExtendedErrorCodeNotAuthoritative, "name outside the served zones")
+	}
+	return msg
+}
+
+// resolveServed answers a query for key, a configured domain or a name with stored records.
+func (d *Handler) resolveServed(
+	r *dns.Msg,
+	msg *dns.Msg,
+	key string,
+	domainCfg *config.ScanConfig,
+	client clientPolicy,
+	from queryClient,
+	local bool,
+	logger *zap.Logger,
+) *dns.Msg {
+	q := r.Question[0]
+	if _, alias := d.aliases[dns.CanonicalName(q.Name)]; alias && domainCfg.AliasMode == config.AliasCNAME {
+		return d.resolveCNAME(r, msg, key, client, from, logger)
+	}
+	if q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA && !servesHTTPS(domainCfg, q.Qtype) {
+		return d.resolveOtherType(r, msg, domainCfg, client, logger)
+	}
+	if local && domainCfg.Suspended() {
+		return d.resolvePaused(r, msg, domainCfg, client, from, logger)
+	}
+	return d.resolveAddress(r, msg, key, domainCfg, client, from, logger)
+}
+
+// resolveOtherType answers a query for a served name of a type other than the addresses with its
+// static records, forwarding it when there are none and the domain forwards other types.
+func (d *Handler) resolveOtherType(
+	r *dns.Msg,
+	msg *dns.Msg,
+	domainCfg *config.ScanConfig,
+	client clientPolicy,
+	logger *zap.Logger,
+) *dns.Msg {
+	q := r.Question[0]
+	msg.Answer = append(msg.Answer, d.staticRecords(q.Name, q.Qtype, client.ttl)...)
+	if len(msg.Answer) == 0 && domainCfg != nil && (d.proxy || domainCfg.OtherTypes == config.OtherTypesForward) {
+		logger.Debug("forwarding query type without records to upstream")
+		return d.forward(r, msg, logger)
+	}
+	return msg
+}
+
+// resolveAddress answers a query for the addresses of key with its servable records, falling back
+// to the fallback IPs of domainCfg, or to the upstreams in proxy mode.
+func (d *Handler) resolveAddress(
+	r *dns.Msg,
+	msg *dns.Msg,
+	key string,
+	domainCfg *config.ScanConfig,
+	client clientPolicy,
+	from queryClient,
+	logger *zap.Logger,
+) *dns.Msg {
 	d.rwMux.RLock()
+	candidates := d.servable(key, time.Now())
+	// Before the first scan of key completes, the running cycle is already scanning it.
+	_, stored := d.store.Get(key)
+	if len(candidates) == 0 && stored {
+		d.rescans.miss(key)
+	}
+	if domainCfg != nil && (d.pools[key].low || len(candidates) == 0) {
+		candidates = withFallback(candidates, domainCfg, d.ipLists)
+	}
+	if d.proxy && (!stored || len(candidates) == 0) {
+		d.rwMux.RUnlock()
+		logger.Debug("forwarding domain without servable IPs to upstream")
+		return d.forward(r, msg, logger)
+	}
 	defer d.rwMux.RUnlock()
-	res, ok := d.memory[q.Name]
-	if !ok {
-		if err := w.WriteMsg(msg); err != nil {
-			d.logger.Info("failed to write empty answer to unknown request", zap.Error(err))
-		}
-		return
+	if !stored {
+		return withEDE(msg, dns.ExtendedErrorCodeNotReady, "first scan of the domain in progress")
 	}
-	recordDNSAnswer(q.Name, sni, len(res))
-	for _, addr := range res {
-		rr := &dns.A{
-			Hdr: dns.RR_Header{
-				Name:   q.Name,
-				Rrtype: dns.TypeA,
-				Class:  dns.ClassINET,
-				// In seconds
-				Ttl: d.ttl,
-			},
-			A: addr,
-		}
-		msg.Answer = append(msg.Answer, rr)
+	if len(candidates) == 0 {
+		return withEDE(msg, dns.ExtendedErrorCodeOther, "no healthy IP for the domain")
 	}
-	if err := w.WriteMsg(msg); err != nil {
+	return d.answer(msg, key, candidates, client, from.subnet)
+}
+
+// reply writes msg to the client and records it in the answer metrics.
+func (d *Handler) reply(w dns.ResponseWriter, msg *dns.Msg, logger *zap.Logger) {
+	for _, q := range msg.Question {
+		recordDNSAnswer(d.metricDomain(q.Name), d.sniOf(q.Name), q.Qtype, msg.Rcode, answerCount(msg, q))
+	}
+	if len(msg.Question) > 0 {
+		recordDNSResponse(w.RemoteAddr().Network(), msg.Question[0].Qtype, msg.Rcode)
+	}
+	err := w.WriteMsg(msg)
+	switch {
+	case err == nil:
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		// The client stopped reading, drop the connection instead of waiting on it.
+		recordWriteTimeout(w.RemoteAddr().Network())
+		logger.Debug("answer write timed out", zap.Error(err))
+		_ = w.Close()
+	default:
 		logger.Warn("failed to write answer", zap.Error(err))
 	}
 }
+
+// metricDomain bounds the domain label of metrics to the configured domains.
+func (d *Handler) metricDomain(name string) string {
+	if key, _, ok := d.lookupDomain(name); ok {
+		return key
+	}
+	return otherLabel
+}
+
+// answer adds the candidates matching the question of msg as its answer records,
+// selected by the answer policy of the domain key and limited by the client policy.
+func (d *Handler) answer(
+	msg *dns.Msg,
+	key string,
+	candidates []policy.Candidate,
+	client clientPolicy,
+	clientIP net.IP,
+) *dns.Msg {
+	name, qtype := msg.Question[0].Name, msg.Question[0].Qtype
+	if qtype == dns.TypeHTTPS || qtype == dns.TypeSVCB {
+		return d.answerHTTPS(msg, key, candidates, client, clientIP)
+	}
+	for _, c := range d.selectAnswers(name, qtype, key, candidates, client, clientIP) {
+		hdr := dns.RR_Header{
+			Name:   name,
+			Rrtype: qtype,
+			Class:  dns.ClassINET,
+			// In seconds
+			Ttl: client.ttl,
+		}
+		if qtype == dns.TypeAAAA {
+			msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: hdr, AAAA: c.IP})
+			continue
+		}
+		msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: c.IP})
+	}
+	return msg
+}
+
+// selectAnswers returns the candidates served to the client in the order they are answered, of
+// the address family of qtype for A and AAAA and of both families otherwise.
+func (d *Handler) selectAnswers(
+	name string,
+	qtype uint16,
+	key string,
+	candidates []policy.Candidate,
+	client clientPolicy,
+	clientIP net.IP,
+) []policy.Candidate {
+	if qtype == dns.TypeA || qtype == dns.TypeAAAA {
+		candidates = candidatesOfFamily(candidates, qtype)
+	}
+	candidates = client.preferred(candidates)
+	query := policy.Query{Name: name, Qtype: qtype, Client: clientIP}
+	if selector, ok := d.policies[key]; ok {
+		candidates = selector.Select(query, candidates)
+	}
+	if cfg := d.domains[key]; cfg != nil {
+		candidates = randomSubset(candidates, cfg.AnswersPerResponse)
+	}
+	if order, ok := d.orders[key]; ok {
+		candidates = order.Select(query, candidates)
+	}
+	candidates = d.rotations[key].apply(candidates)
+	return client.limit(candidates)
+}
+
+// resolveCNAME answers a query for an alias with a CNAME to the domain key, followed by
+// the answer to the same query for key.
+func (d *Handler) resolveCNAME(
+	r *dns.Msg,
+	msg *dns.Msg,
+	key string,
+	client clientPolicy,
+	from queryClient,
+	logger *zap.Logger,
+) *dns.Msg {
+	q := r.Question[0]
+	msg.Answer = append(msg.Answer, &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   q.Name,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    client.ttl,
+		},
+		Target: key,
+	})
+	if q.Qtype == dns.TypeCNAME {
+		return msg
+	}
+	target := r.Copy()
+	target.Question[0].Name = key
+	resp := d.resolve(target, from, logger)
+	msg.Rcode = resp.Rcode
+	msg.Answer = append(msg.Answer, resp.Answer...)
+	for _, ede := range extendedErrors(resp) {
+		withEDE(msg, ede.InfoCode, ede.ExtraText)
+	}
+	return msg
+}
+
+// resolvePaused answers a query for a paused domain according to its paused_response.
+func (d *Handler) resolvePaused(
+	r *dns.Msg,
+	msg *dns.Msg,
+	domainCfg *config.ScanConfig,
+	client clientPolicy,
+	from queryClient,
+	logger *zap.Logger,
+) *dns.Msg {
+	logger = logger.With(zap.String("paused_response", domainCfg.PausedResponse))
+	logger.Debug("serving paused domain")
+	switch domainCfg.PausedResponse {
+	case config.PausedFallback:
+		withEDE(msg, dns.ExtendedErrorCodeOther, "domain paused, serving fallback IPs")
+		return d.answer(msg, domainCfg.Domain, fallbackCandidates(domainCfg, d.ipLists), client, from.subnet)
+	case config.PausedServFail:
+		msg.Rcode = dns.RcodeServerFailure
+		return withEDE(msg, dns.ExtendedErrorCodeOther, "domain paused")
+	case config.PausedForward:
+		return d.forward(r, msg, logger)
+	default:
+		d.rwMux.RLock()
+		defer d.rwMux.RUnlock()
+		withEDE(msg, dns.ExtendedErrorCodeStaleAnswer, "domain paused, serving last known good records")
+		return d.answer(msg, domainCfg.Domain, d.servable(domainCfg.Domain, time.Now()), client, from.subnet)
+	}
+}
+
+// forward relays r to the upstream resolvers, msg is answered with SERVFAIL if they all fail.
+func (d *Handler) forward(r *dns.Msg, msg *dns.Msg, logger *zap.Logger) *dns.Msg {
+	resp, err := d.forwarder.Exchange(context.Background(), r)
+	if err != nil {
+		logger.Warn("failed to forward query to upstream", zap.Error(err))
+		msg.Rcode = dns.RcodeServerFailure
+		return withEDE(msg, dns.ExtendedErrorCodeNoReachableAuthority, "upstream resolvers failed")
+	}
+	return resp
+}
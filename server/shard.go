@@ -0,0 +1,42 @@
+package server
+
+import (
+	"hash/fnv"
+	"net"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// shard selects the part of the candidate space scanned by this instance, so instances sharing
+// the same count split every CIDR between them without coordinating.
+type shard struct {
+	index, count uint32
+}
+
+func newShard(cfg config.Config) shard {
+	index, count := cfg.ShardOf()
+	return shard{index: uint32(index), count: uint32(count)} //nolint:gosec // validated, 0 <= index < count
+}
+
+// owns reports whether ip belongs to the shard, by the FNV-1a hash of ip modulo the shard count.
+func (s shard) owns(ip net.IP) bool {
+	if s.count <= 1 {
+		return true
+	}
+	key := ip.To4()
+	if key == nil {
+		key = ip.To16()
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return h.Sum32()%s.count == s.index
+}
+
+// keep returns the filter sampling the IPs of the shard only, nil when there is a single shard.
+// Sampling within the shard gives every instance the full sample_max of its part of the range.
+func (s shard) keep() func(net.IP) bool {
+	if s.count <= 1 {
+		return nil
+	}
+	return s.owns
+}
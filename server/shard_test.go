@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestShardsPartitionCandidates(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.ScanConfig{CIDRs: []string{"192.0.2.0/24"}, SamplesChance: 1}
+	owners := make(map[string]int, 256)
+	for index := range uint32(3) {
+		samples, err := cfg.ReadCIDRsSamples(context.Background(), shard{index: index, count: 3}.keep())
+		if err != nil {
+			t.Fatalf("ReadCIDRsSamples() returned error: %v", err)
+		}
+		for ip := range samples[0] {
+			owners[ip.String()]++
+		}
+	}
+	if len(owners) != 256 {
+		t.Fatalf("shards covered %d of 256 IPs", len(owners))
+	}
+	for ip, n := range owners {
+		if n != 1 {
+			t.Fatalf("IP %s is scanned by %d shards, want exactly one", ip, n)
+		}
+	}
+	// Mapped addresses belong to the same shard as their IPv4 form.
+	s := shard{index: 1, count: 3}
+	if s.owns(net.ParseIP("::ffff:192.0.2.7")) != s.owns(net.IPv4(192, 0, 2, 7).To4()) {
+		t.Fatal("owns() differs between the IPv4 and the mapped form of an address")
+	}
+}
+
+func TestShardSamplesWithinItsPart(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.ScanConfig{CIDRs: []string{"192.0.2.0/24"}, SamplesMinimum: 16, SamplesMaximum: 16}
+	s := shard{index: 2, count: 3}
+	samples, err := cfg.ReadCIDRsSamples(context.Background(), s.keep())
+	if err != nil {
+		t.Fatalf("ReadCIDRsSamples() returned error: %v", err)
+	}
+	count := 0
+	for ip := range samples[0] {
+		if !s.owns(ip) {
+			t.Fatalf("sampled %s outside the shard", ip)
+		}
+		count++
+	}
+	if count != 16 {
+		t.Fatalf("sampled %d IPs, want the full sample_max of 16 within the shard", count)
+	}
+}
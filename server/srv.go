@@ -0,0 +1,141 @@
+package server
+
+import (
+	"math"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/policy"
+)
+
+// maxWeight is the weight of a candidate with full confidence and the lowest latency.
+const maxWeight = 100
+
+// candidateWeights derives a relative weight for every candidate from its confidence and its
+// latency against the fastest candidate. Weights are kept in 1..maxWeight so every served IP
+// still receives some traffic.
+func candidateWeights(candidates []policy.Candidate) []uint16 {
+	var fastest time.Duration
+	for _, c := range candidates {
+		if c.Latency > 0 && (fastest == 0 || c.Latency < fastest) {
+			fastest = c.Latency
+		}
+	}
+	weights := make([]uint16, len(candidates))
+	for i, c := range candidates {
+		score := c.Confidence
+		if c.Latency > 0 && fastest > 0 {
+			score *= float64(fastest) / float64(c.Latency)
+		}
+		weights[i] = uint16(min(maxWeight, max(1, math.Round(score*maxWeight))))
+	}
+	return weights
+}
+
+// srvTarget returns the target name of ip in the SRV records of key, such as
+// 192-0-2-1.edge.example.com., which resolves to ip for as long as it is served.
+func srvTarget(ip net.IP, key string) string {
+	label := strings.NewReplacer(".", "-", ":", "-").Replace(ip.String())
+	return label + "." + key
+}
+
+// parseSRVTargetLabel is the reverse of the first label of [srvTarget].
+func parseSRVTargetLabel(label string) net.IP {
+	if ip := net.ParseIP(strings.ReplaceAll(label, "-", ".")).To4(); ip != nil {
+		return ip
+	}
+	return net.ParseIP(strings.ReplaceAll(label, "-", ":"))
+}
+
+// srvKey returns the domain whose SRV records own name, either as the SRV name itself or as the
+// target name of one of its IPs.
+func (d *Handler) srvKey(name string) (string, bool) {
+	if len(d.srv) == 0 {
+		return "", false
+	}
+	name = dns.CanonicalName(name)
+	if key, ok := d.srv[name]; ok {
+		return key, true
+	}
+	label, parent, _ := strings.Cut(name, ".")
+	if domainCfg, ok := d.domains[parent]; ok && domainCfg.SRVName() != "" && parseSRVTargetLabel(label) != nil {
+		return parent, true
+	}
+	return "", false
+}
+
+// resolveSRV answers a query for the SRV name of key with a weighted SRV record per servable IP,
+// and a query for one of their targets with its address.
+func (d *Handler) resolveSRV(msg *dns.Msg, key string, client clientPolicy) *dns.Msg {
+	q := msg.Question[0]
+	if !client.allows(key) {
+		msg.Rcode = dns.RcodeRefused
+		return msg
+	}
+	d.rwMux.RLock()
+	candidates := d.servable(key, time.Now())
+	d.rwMux.RUnlock()
+
+	domainCfg := d.domains[key]
+	name := dns.CanonicalName(q.Name)
+	if name == domainCfg.SRVName() {
+		if q.Qtype != dns.TypeSRV {
+			return msg
+		}
+		msg.Answer, msg.Extra = d.srvRecords(q.Name, key, client.limit(candidates), candidateWeights(candidates), client.ttl)
+		return msg
+	}
+
+	label, _, _ := strings.Cut(name, ".")
+	ip := parseSRVTargetLabel(label)
+	if !slices.ContainsFunc(candidates, func(c policy.Candidate) bool { return c.IP.Equal(ip) }) {
+		msg.Rcode = dns.RcodeNameError
+		return msg
+	}
+	rr := addressRecord(q.Name, ip, client.ttl)
+	if rr.Header().Rrtype == q.Qtype {
+		msg.Answer = append(msg.Answer, rr)
+	}
+	return msg
+}
+
+// srvRecords returns an SRV record under name for each of candidates of key, weighted by weights,
+// and the address records of their targets.
+func (d *Handler) srvRecords(
+	name string,
+	key string,
+	candidates []policy.Candidate,
+	weights []uint16,
+	ttl uint32,
+) ([]dns.RR, []dns.RR) {
+	domainCfg := d.domains[key]
+	srvs := make([]dns.RR, 0, len(candidates))
+	targets := make([]dns.RR, 0, len(candidates))
+	for i, c := range candidates {
+		target := srvTarget(c.IP, key)
+		srvs = append(srvs, &dns.SRV{
+			Hdr:      dns.RR_Header{Name: name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: ttl},
+			Priority: domainCfg.SRV.Priority,
+			Weight:   weights[i],
+			Port:     domainCfg.SRVPort(),
+			Target:   target,
+		})
+		targets = append(targets, addressRecord(target, c.IP, ttl))
+	}
+	return srvs, targets
+}
+
+// addressRecord returns the A or AAAA record of ip under name.
+func addressRecord(name string, ip net.IP, ttl uint32) dns.RR {
+	hdr := dns.RR_Header{Name: name, Class: dns.ClassINET, Ttl: ttl}
+	if ip4 := ip.To4(); ip4 != nil {
+		hdr.Rrtype = dns.TypeA
+		return &dns.A{Hdr: hdr, A: ip4}
+	}
+	hdr.Rrtype = dns.TypeAAAA
+	return &dns.AAAA{Hdr: hdr, AAAA: ip}
+}
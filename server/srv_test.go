@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/policy"
+)
+
+func TestCandidateWeights(t *testing.T) {
+	t.Parallel()
+
+	weights := candidateWeights([]policy.Candidate{
+		{Latency: 10 * time.Millisecond, Confidence: 1},
+		{Latency: 20 * time.Millisecond, Confidence: 1},
+		{Latency: 10 * time.Millisecond, Confidence: 0.5},
+		{Confidence: 1},
+		{Latency: time.Minute, Confidence: 0.01},
+	})
+	if want := []uint16{100, 50, 50, 100, 1}; !slices.Equal(weights, want) {
+		t.Fatalf("candidateWeights() = %v, want %v", weights, want)
+	}
+}
+
+func TestSRVTargetRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, raw := range []string{"192.0.2.1", "2001:db8::1"} {
+		ip := net.ParseIP(raw)
+		target := srvTarget(ip, "edge.example.com.")
+		label, _, _ := strings.Cut(target, ".")
+		if got := parseSRVTargetLabel(label); !got.Equal(ip) {
+			t.Fatalf("parseSRVTargetLabel(%q) = %v, want %v", label, got, ip)
+		}
+	}
+	if ip := parseSRVTargetLabel("www"); ip != nil {
+		t.Fatalf("parseSRVTargetLabel(www) = %v, want nil", ip)
+	}
+}
+
+func TestResolveSRV(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{
+		Domain: key,
+		Port:   443,
+		// Confidence of re-validated records, applied by config defaults.
+		ConfidenceBoost: 1,
+		SRV:             config.SRVConfig{Service: "_https._tcp", Priority: 10},
+	}
+	h.srv = map[string]string{"_https._tcp.edge.example.com.": key}
+	h.UpdateRecords(key, []Record{
+		{IP: net.IPv4(192, 0, 2, 1).To4(), Latency: 10 * time.Millisecond},
+		{IP: net.IPv4(192, 0, 2, 2).To4(), Latency: 40 * time.Millisecond},
+	})
+
+	query := new(dns.Msg)
+	query.SetQuestion("_https._tcp.edge.example.com.", dns.TypeSRV)
+	msg := h.resolve(query, queryClient{}, zap.NewNop())
+	if len(msg.Answer) != 2 || len(msg.Extra) != 2 {
+		t.Fatalf("answer = %v, extra = %v, want an SRV record and a target address per IP", msg.Answer, msg.Extra)
+	}
+	first := msg.Answer[0].(*dns.SRV)
+	if first.Port != 443 || first.Priority != 10 || first.Weight != 100 || first.Target != "192-0-2-1.edge.example.com." {
+		t.Fatalf("first SRV = %v", first)
+	}
+	if weight := msg.Answer[1].(*dns.SRV).Weight; weight != 25 {
+		t.Fatalf("second SRV weight = %d, want 25", weight)
+	}
+
+	query.SetQuestion("192-0-2-2.edge.example.com.", dns.TypeA)
+	msg = h.resolve(query, queryClient{}, zap.NewNop())
+	if len(msg.Answer) != 1 || !msg.Answer[0].(*dns.A).A.Equal(net.IPv4(192, 0, 2, 2)) {
+		t.Fatalf("target answer = %v, want 192.0.2.2", msg.Answer)
+	}
+
+	query.SetQuestion("192-0-2-9.edge.example.com.", dns.TypeA)
+	if msg = h.resolve(query, queryClient{}, zap.NewNop()); msg.Rcode != dns.RcodeNameError {
+		t.Fatalf("rcode of a target no longer served = %s, want NXDOMAIN", dns.RcodeToString[msg.Rcode])
+	}
+}
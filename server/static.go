@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net"
+
+	"go.uber.org/zap"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// maxCNAMEChain bounds how many static CNAME records are followed for a single query.
+const maxCNAMEChain = 8
+
+// buildStaticRecords returns the configured static records keyed by canonical name.
+// Records with a zero TTL are answered with the TTL of the client policy.
+func buildStaticRecords(records []config.StaticRecord) map[string][]dns.RR {
+	result := make(map[string][]dns.RR, len(records))
+	for _, rec := range records {
+		hdr := dns.RR_Header{
+			Name:   dns.CanonicalName(rec.Name),
+			Rrtype: dns.StringToType[rec.Type],
+			Class:  dns.ClassINET,
+			Ttl:    uint32(rec.TTL.Seconds()),
+		}
+		var rr dns.RR
+		switch hdr.Rrtype {
+		case dns.TypeA:
+			rr = &dns.A{Hdr: hdr, A: net.ParseIP(rec.Value).To4()}
+		case dns.TypeAAAA:
+			rr = &dns.AAAA{Hdr: hdr, AAAA: net.ParseIP(rec.Value)}
+		case dns.TypeCNAME:
+			rr = &dns.CNAME{Hdr: hdr, Target: dns.CanonicalName(rec.Value)}
+		case dns.TypeTXT:
+			rr = &dns.TXT{Hdr: hdr, Txt: []string{rec.Value}}
+		case dns.TypeMX:
+			pref, host, err := config.ParseMX(rec.Value)
+			if err != nil {
+				continue
+			}
+			rr = &dns.MX{Hdr: hdr, Preference: pref, Mx: host}
+		default:
+			continue
+		}
+		result[hdr.Name] = append(result[hdr.Name], rr)
+	}
+	return result
+}
+
+// staticRecords returns the static records of name with the given type, ttl fills in unset TTLs.
+// Addresses the ip_lists do not allow are left out.
+func (d *Handler) staticRecords(name string, qtype uint16, ttl uint32) []dns.RR {
+	var out []dns.RR
+	for _, rr := range d.static[dns.CanonicalName(name)] {
+		if rr.Header().Rrtype != qtype {
+			continue
+		}
+		switch rr := rr.(type) {
+		case *dns.A:
+			if !d.ipLists.allows(rr.A) {
+				continue
+			}
+		case *dns.AAAA:
+			if !d.ipLists.allows(rr.AAAA) {
+				continue
+			}
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Name = name
+		if rr.Header().Ttl == 0 {
+			rr.Header().Ttl = ttl
+		}
+		out = append(out, rr)
+	}
+	return out
+}
+
+// resolveStatic answers a query for a name with static records, following static CNAME
+// records and resolving targets outside of them like any other query.
+func (d *Handler) resolveStatic(
+	r *dns.Msg,
+	msg *dns.Msg,
+	client clientPolicy,
+	from queryClient,
+	logger *zap.Logger,
+) *dns.Msg {
+	q := r.Question[0]
+	name := q.Name
+	for range maxCNAMEChain {
+		_, static := d.static[dns.CanonicalName(name)]
+		if _, _, local := d.lookupDomain(name); local || !static {
+			target := r.Copy()
+			target.Question[0].Name = name
+			resp := d.resolve(target, from, logger)
+			msg.Rcode = resp.Rcode
+			msg.Answer = append(msg.Answer, resp.Answer...)
+			return msg
+		}
+		if answers := d.staticRecords(name, q.Qtype, client.ttl); len(answers) > 0 {
+			msg.Answer = append(msg.Answer, answers...)
+			return msg
+		}
+		cnames := d.staticRecords(name, dns.TypeCNAME, client.ttl)
+		if len(cnames) == 0 {
+			return msg
+		}
+		msg.Answer = append(msg.Answer, cnames[0])
+		name = cnames[0].(*dns.CNAME).Target
+	}
+	logger.Warn("static CNAME chain is too long", zap.Int("max", maxCNAMEChain))
+	msg.Rcode = dns.RcodeServerFailure
+	return msg
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/forward"
+)
+
+func TestResolveStaticRecords(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com."}
+	h.UpdateRecords("edge.example.com.", []Record{{IP: []byte{192, 0, 2, 1}}})
+	h.static = buildStaticRecords([]config.StaticRecord{
+		{Name: "mail.example.com.", Type: "A", Value: "198.51.100.7"},
+		{Name: "www.example.com.", Type: "CNAME", Value: "edge.example.com."},
+		{Name: "edge.example.com.", Type: "TXT", Value: "v=spf1 -all"},
+	})
+
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  []string
+	}{
+		{name: "Mail.example.com.", qtype: dns.TypeA, want: []string{"198.51.100.7"}},
+		{name: "www.example.com.", qtype: dns.TypeA, want: []string{"edge.example.com.", "192.0.2.1"}},
+		{name: "edge.example.com.", qtype: dns.TypeTXT, want: []string{"v=spf1 -all"}},
+		{name: "mail.example.com.", qtype: dns.TypeAAAA, want: nil},
+	}
+	for _, tt := range tests {
+		query := new(dns.Msg)
+		query.SetQuestion(tt.name, tt.qtype)
+		msg := h.resolve(query, queryClient{}, zap.NewNop())
+		if len(msg.Answer) != len(tt.want) {
+			t.Fatalf("resolve(%s %s) = %v, want %v", tt.name, dns.TypeToString[tt.qtype], msg.Answer, tt.want)
+		}
+		for i, rr := range msg.Answer {
+			var got string
+			switch v := rr.(type) {
+			case *dns.A:
+				got = v.A.String()
+			case *dns.CNAME:
+				got = v.Target
+			case *dns.TXT:
+				got = v.Txt[0]
+			}
+			if got != tt.want[i] {
+				t.Errorf("resolve(%s %s)[%d] = %s, want %s", tt.name, dns.TypeToString[tt.qtype], i, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestResolveOtherTypes(t *testing.T) {
+	t.Parallel()
+
+	upstream := startTestDNS(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.CAA{
+			Hdr:   dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeCAA, Class: dns.ClassINET, Ttl: 300},
+			Tag:   "issue",
+			Value: "letsencrypt.org",
+		})
+		_ = w.WriteMsg(msg)
+	}))
+	forwarder, err := forward.New([]string{upstream}, time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler(t)
+	h.forwarder = forwarder
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{Domain: key, OtherTypes: config.OtherTypesForward}
+	h.UpdateRecords(key, []Record{{IP: []byte{192, 0, 2, 1}}})
+	h.static = buildStaticRecords([]config.StaticRecord{
+		{Name: key, Type: "MX", Value: "10 mail.example.com."},
+	})
+
+	query := new(dns.Msg)
+	query.SetQuestion(key, dns.TypeMX)
+	msg := h.resolve(query, queryClient{}, zap.NewNop())
+	if len(msg.Answer) != 1 || msg.Answer[0].(*dns.MX).Mx != "mail.example.com." {
+		t.Fatalf("answer = %v, want the static MX record", msg.Answer)
+	}
+
+	query.SetQuestion(key, dns.TypeCAA)
+	msg = h.resolve(query, queryClient{}, zap.NewNop())
+	if len(msg.Answer) != 1 || msg.Answer[0].Header().Rrtype != dns.TypeCAA {
+		t.Fatalf("answer = %v, want the CAA record of the upstream", msg.Answer)
+	}
+
+	h.domains[key].OtherTypes = config.OtherTypesNoData
+	msg = h.resolve(query, queryClient{}, zap.NewNop())
+	if msg.Rcode != dns.RcodeSuccess || len(msg.Answer) != 0 {
+		t.Fatalf("rcode = %d, answer = %v, want NODATA", msg.Rcode, msg.Answer)
+	}
+}
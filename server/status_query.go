@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Selectable per-domain fields of /api/status.
+const (
+	fieldIPs        = "ips"
+	fieldRecords    = "records"
+	fieldLastUpdate = "last_update"
+	fieldConfig     = "config"
+)
+
+var statusFields = []string{fieldIPs, fieldRecords, fieldLastUpdate, fieldConfig}
+
+// statusQuery holds the filtering and pagination parameters of /api/status.
+type statusQuery struct {
+	domains []string
+	fields  []string
+	offset  int
+	limit   int
+}
+
+func parseStatusQuery(values url.Values) (statusQuery, error) {
+	q := statusQuery{
+		domains: splitList(values["domain"]),
+		fields:  splitList(values["fields"]),
+	}
+	for _, field := range q.fields {
+		if !slices.Contains(statusFields, field) {
+			return q, fmt.Errorf("unknown field %q, must be one of %s", field, strings.Join(statusFields, ", "))
+		}
+	}
+	if len(q.fields) == 0 {
+		q.fields = slices.Clone(statusFields)
+	}
+	if raw := values.Get("config"); raw != "" {
+		include, err := strconv.ParseBool(raw)
+		if err != nil {
+			return q, fmt.Errorf("invalid config %q: %w", raw, err)
+		}
+		if !include {
+			q.fields = slices.DeleteFunc(q.fields, func(f string) bool { return f == fieldConfig })
+		}
+	}
+	var err error
+	if q.offset, err = parseNonNegative(values, "offset"); err != nil {
+		return q, err
+	}
+	if q.limit, err = parseNonNegative(values, "limit"); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+func parseNonNegative(values url.Values, key string) (int, error) {
+	raw := values.Get(key)
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, errors.New(key + " must be a non-negative integer")
+	}
+	return n, nil
+}
+
+// splitList flattens repeated and comma separated query values.
+func splitList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for item := range strings.SplitSeq(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+	}
+	return out
+}
+
+func (q statusQuery) matches(domain string) bool {
+	return len(q.domains) == 0 || slices.Contains(q.domains, domain)
+}
+
+func (q statusQuery) has(field string) bool {
+	return slices.Contains(q.fields, field)
+}
+
+// paginate returns the window of items selected by offset and limit, a zero limit means no limit.
+func paginate[T any](items []T, offset, limit int) []T {
+	start := min(offset, len(items))
+	end := len(items)
+	if limit > 0 {
+		end = min(start+limit, end)
+	}
+	return items[start:end]
+}
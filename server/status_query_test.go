@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestParseStatusQuery(t *testing.T) {
+	t.Parallel()
+
+	q, err := parseStatusQuery(url.Values{
+		"domain": {"a.example.com.,b.example.com."},
+		"fields": {"ips,config"},
+		"config": {"false"},
+		"offset": {"1"},
+		"limit":  {"2"},
+	})
+	if err != nil {
+		t.Fatalf("parseStatusQuery() error = %v", err)
+	}
+	if len(q.domains) != 2 || !q.matches("b.example.com.") || q.matches("c.example.com.") {
+		t.Fatalf("domains = %v", q.domains)
+	}
+	if !q.has(fieldIPs) || q.has(fieldConfig) || q.has(fieldRecords) {
+		t.Fatalf("fields = %v, want only ips", q.fields)
+	}
+	if q.offset != 1 || q.limit != 2 {
+		t.Fatalf("offset, limit = %d, %d, want 1, 2", q.offset, q.limit)
+	}
+
+	for _, values := range []url.Values{
+		{"fields": {"secret"}},
+		{"limit": {"-1"}},
+		{"config": {"maybe"}},
+	} {
+		if _, err := parseStatusQuery(values); err == nil {
+			t.Errorf("parseStatusQuery(%v) error = nil", values)
+		}
+	}
+}
+
+func TestBuildStatusPaginates(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{Domains: []*config.ScanConfig{
+		{Domain: "a.example.com."},
+		{Domain: "b.example.com."},
+		{Domain: "c.example.com."},
+	}}
+	q, err := parseStatusQuery(url.Values{"offset": {"1"}, "limit": {"1"}, "config": {"false"}})
+	if err != nil {
+		t.Fatalf("parseStatusQuery() error = %v", err)
+	}
+	resp := buildStatus(cfg, nil, q)
+	if resp.Total != 3 || len(resp.Domains) != 1 {
+		t.Fatalf("total = %d, domains = %d, want 3, 1", resp.Total, len(resp.Domains))
+	}
+	got := resp.Domains[0]
+	if !strings.HasPrefix(got.Domain, "b.") || got.Config != nil || got.IPs == nil {
+		t.Fatalf("domain status = %+v", got)
+	}
+
+	q.offset = 10
+	if resp := buildStatus(cfg, nil, q); len(resp.Domains) != 0 {
+		t.Fatalf("domains past the end = %d, want 0", len(resp.Domains))
+	}
+}
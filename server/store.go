@@ -0,0 +1,65 @@
+package server
+
+import (
+	"time"
+)
+
+// RecordStore holds the records served for every key by a [Handler]. The handler guards its
+// calls with its own lock, Get and Snapshot may run concurrently with each other but never with
+// Update or Expire, so implementations need no locking of their own. Records passed to and
+// returned by a store are not modified afterwards.
+type RecordStore interface {
+	// Get returns the records of key, ok is false while nothing was stored under key.
+	Get(key string) (records []Record, ok bool)
+	// Update replaces the records of key, updated at updatedAt.
+	Update(key string, records []Record, updatedAt time.Time)
+	// Snapshot returns a copy of the records of every key along with their update time.
+	Snapshot() map[string]RecordSnapshot
+	// Expire forgets key and its records.
+	Expire(key string)
+}
+
+// MemoryStore is the default [RecordStore], it keeps the records in memory only.
+type MemoryStore struct {
+	records   map[string][]Record
+	updatedAt map[string]time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records:   make(map[string][]Record),
+		updatedAt: make(map[string]time.Time),
+	}
+}
+
+// Get implements [RecordStore].
+func (s *MemoryStore) Get(key string) ([]Record, bool) {
+	records, ok := s.records[key]
+	return records, ok
+}
+
+// Update implements [RecordStore].
+func (s *MemoryStore) Update(key string, records []Record, updatedAt time.Time) {
+	s.records[key] = records
+	s.updatedAt[key] = updatedAt
+}
+
+// Snapshot implements [RecordStore].
+func (s *MemoryStore) Snapshot() map[string]RecordSnapshot {
+	result := make(map[string]RecordSnapshot, len(s.records))
+	for key, records := range s.records {
+		copyRecords := make([]Record, len(records))
+		for i, r := range records {
+			copyRecords[i] = r.clone()
+		}
+		result[key] = RecordSnapshot{Records: copyRecords, UpdatedAt: s.updatedAt[key]}
+	}
+	return result
+}
+
+// Expire implements [RecordStore].
+func (s *MemoryStore) Expire(key string) {
+	delete(s.records, key)
+	delete(s.updatedAt, key)
+}
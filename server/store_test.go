@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/helios-dns/config"
+	"github.com/fmotalleb/helios-dns/policy"
+)
+
+func TestNewHandlerExpiresUnconfiguredKeys(t *testing.T) {
+	t.Parallel()
+
+	key := edgeDomain
+	store := NewMemoryStore()
+	store.Update(key, []Record{{IP: net.IPv4(192, 0, 2, 1).To4(), Confidence: 1}}, time.Now())
+	store.Update("removed.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 2).To4()}}, time.Now())
+
+	cfg := config.Config{Domains: []*config.ScanConfig{{
+		Domain:       key,
+		AnswerPolicy: config.AnswerPolicyConfig{Name: policy.All},
+	}}}
+	h, err := NewHandler(cfg, zap.NewNop(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Get("removed.example.com."); ok {
+		t.Fatal("records of a domain missing from the config were kept")
+	}
+	if got := h.Snapshot()[key].Records; len(got) != 1 {
+		t.Fatalf("records = %v, want the stored record served", got)
+	}
+}
+
+func TestMemoryStoreSnapshotIsACopy(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryStore()
+	store.Update("edge.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}}, time.Now())
+	snapshot := store.Snapshot()
+	snapshot["edge.example.com."].Records[0].IP[3] = 9
+	if got, _ := store.Get("edge.example.com."); !got[0].IP.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("stored IP = %v, want it untouched by snapshot changes", got[0].IP)
+	}
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+const (
+	// transferChunk is the number of records sent per message of a zone transfer.
+	transferChunk = 100
+	// notifyDelay coalesces the serial changes of a scan cycle into a single NOTIFY.
+	notifyDelay   = time.Second
+	notifyTimeout = 2 * time.Second
+)
+
+// zoneTransfer holds the secondaries allowed to transfer a zone and those notified of its changes.
+type zoneTransfer struct {
+	allow  []*net.IPNet
+	notify []string
+}
+
+// buildTransfers returns the transfer settings of the zones that allow transfers, by zone name.
+func buildTransfers(cfgs []config.ZoneConfig) (map[string]*zoneTransfer, error) {
+	transfers := make(map[string]*zoneTransfer)
+	for _, c := range cfgs {
+		if len(c.Transfer.Allow) == 0 {
+			continue
+		}
+		allow, err := c.Transfer.AllowedNetworks()
+		if err != nil {
+			return nil, fmt.Errorf("zone %s: %w", c.Name, err)
+		}
+		transfers[dns.CanonicalName(c.Name)] = &zoneTransfer{allow: allow, notify: c.Transfer.Notify}
+	}
+	return transfers, nil
+}
+
+func (t *zoneTransfer) allows(ip net.IP) bool {
+	if t == nil || ip == nil {
+		return false
+	}
+	return slices.ContainsFunc(t.allow, func(n *net.IPNet) bool { return n.Contains(ip) })
+}
+
+// transfer answers an AXFR or IXFR query. Without a change history, IXFR is answered with the
+// full zone unless the secondary is already up to date, which RFC 1995 allows.
+func (d *Handler) transfer(w dns.ResponseWriter, r *dns.Msg, logger *zap.Logger) {
+	q := r.Question[0]
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	z := d.zoneFor(q.Name)
+	if z == nil || !z.isApex(q.Name) || !d.transfers[z.name].allows(addrIP(w.RemoteAddr())) {
+		logger.Info("zone transfer refused")
+		msg.Rcode = dns.RcodeRefused
+		d.reply(w, msg, logger)
+		return
+	}
+	msg.Authoritative = true
+	soa := z.soa(d.serials.Serial(z.name))
+	tcp := w.RemoteAddr().Network() == "tcp"
+	if q.Qtype == dns.TypeIXFR && (!tcp || upToDate(r, soa.Serial)) {
+		// A single SOA tells the secondary it is current, or to retry over TCP.
+		msg.Answer = []dns.RR{soa}
+		d.reply(w, msg, logger)
+		return
+	}
+	if !tcp {
+		logger.Info("zone transfer refused over udp")
+		msg.Rcode = dns.RcodeRefused
+		d.reply(w, msg, logger)
+		return
+	}
+
+	records := d.zoneRecords(z, soa)
+	recordDNSAnswer(z.name, "", q.Qtype, dns.RcodeSuccess, len(records))
+	logger.Info("zone transfer", zap.Uint32("serial", soa.Serial), zap.Int("records", len(records)))
+	ch := make(chan *dns.Envelope)
+	done := make(chan error, 1)
+	go func() { done <- new(dns.Transfer).Out(w, r, ch) }()
+	for chunk := range slices.Chunk(records, transferChunk) {
+		select {
+		case ch <- &dns.Envelope{RR: chunk}:
+		case err := <-done:
+			logger.Warn("zone transfer failed", zap.Error(err))
+			return
+		}
+	}
+	close(ch)
+	if err := <-done; err != nil {
+		logger.Warn("zone transfer failed", zap.Error(err))
+	}
+}
+
+// upToDate reports whether the SOA sent in the authority section of an IXFR query has serial or
+// a later one.
+func upToDate(r *dns.Msg, serial uint32) bool {
+	for _, rr := range r.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			// Serial arithmetic of RFC 1982, the difference wraps around.
+			return int32(serial-soa.Serial) <= 0 //nolint:gosec // wraps by design
+		}
+	}
+	return false
+}
+
+// zoneRecords returns the records of z as a zone transfer, between two copies of soa: the apex
+// NS records, the records of every domain, alias and SRV name and the static records within z.
+func (d *Handler) zoneRecords(z *zone, soa *dns.SOA) []dns.RR {
+	inZone := func(name string) bool { return d.zoneFor(name) == z }
+	records := d.apexRecords(z, z.name, dns.TypeNS)
+	addresses := func(owner, key string) {
+		for _, c := range d.servable(key, time.Now()) {
+			records = append(records, addressRecord(owner, c.IP, d.ttl))
+		}
+	}
+
+	d.rwMux.RLock()
+	for key, domainCfg := range d.domains {
+		if inZone(key) {
+			addresses(key, key)
+		}
+		if name := domainCfg.SRVName(); name != "" && inZone(name) {
+			candidates := d.servable(key, time.Now())
+			srvs, targets := d.srvRecords(name, key, candidates, candidateWeights(candidates), d.ttl)
+			records = append(append(records, srvs...), targets...)
+		}
+	}
+	for alias, key := range d.aliases {
+		if !inZone(alias) {
+			continue
+		}
+		if d.domains[key].AliasMode == config.AliasCNAME {
+			records = append(records, &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: alias, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: d.ttl},
+				Target: key,
+			})
+			continue
+		}
+		addresses(alias, key)
+	}
+	d.rwMux.RUnlock()
+	for name, rrs := range d.static {
+		if inZone(name) {
+			for _, rr := range rrs {
+				records = append(records, dns.Copy(rr))
+			}
+		}
+	}
+
+	slices.SortStableFunc(records, func(a, b dns.RR) int {
+		if c := strings.Compare(dns.CanonicalName(a.Header().Name), dns.CanonicalName(b.Header().Name)); c != 0 {
+			return c
+		}
+		return int(a.Header().Rrtype) - int(b.Header().Rrtype)
+	})
+	return append(append([]dns.RR{soa}, records...), soa)
+}
+
+// zoneNotifier sends NOTIFY messages to the secondaries of zones whose serial changed. A nil
+// notifier notifies nobody.
+type zoneNotifier struct {
+	targets map[string][]string
+	client  *dns.Client
+
+	mu      sync.Mutex
+	pending map[string]struct{}
+	wake    chan struct{}
+}
+
+func newZoneNotifier(transfers map[string]*zoneTransfer) *zoneNotifier {
+	targets := make(map[string][]string)
+	for name, t := range transfers {
+		if len(t.notify) > 0 {
+			targets[name] = t.notify
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	return &zoneNotifier{
+		targets: targets,
+		client:  &dns.Client{Net: "udp", Timeout: notifyTimeout},
+		pending: make(map[string]struct{}),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// changed schedules a NOTIFY for zone.
+func (n *zoneNotifier) changed(zone string) {
+	if n == nil {
+		return
+	}
+	if _, ok := n.targets[zone]; !ok {
+		return
+	}
+	n.mu.Lock()
+	n.pending[zone] = struct{}{}
+	n.mu.Unlock()
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends the scheduled notifications until ctx is done.
+func (n *zoneNotifier) Run(ctx context.Context, h *Handler) error {
+	if n == nil {
+		return nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-n.wake:
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(notifyDelay):
+		}
+		n.mu.Lock()
+		zones := n.pending
+		n.pending = make(map[string]struct{})
+		n.mu.Unlock()
+		for _, z := range h.zones {
+			if _, ok := zones[z.name]; ok {
+				n.notify(ctx, z.soa(h.serials.Serial(z.name)), h.logger)
+			}
+		}
+	}
+}
+
+func (n *zoneNotifier) notify(ctx context.Context, soa *dns.SOA, logger *zap.Logger) {
+	msg := new(dns.Msg)
+	msg.SetNotify(soa.Hdr.Name)
+	msg.Authoritative = true
+	msg.Answer = []dns.RR{soa}
+	for _, target := range n.targets[soa.Hdr.Name] {
+		resp, _, err := n.client.ExchangeContext(ctx, msg, target)
+		if err == nil && resp.Rcode != dns.RcodeSuccess {
+			err = fmt.Errorf("unexpected rcode %s", dns.RcodeToString[resp.Rcode])
+		}
+		if err != nil {
+			logger.Warn("failed to notify secondary",
+				zap.String("zone", soa.Hdr.Name),
+				zap.String("target", target),
+				zap.Error(err),
+			)
+			continue
+		}
+		logger.Debug("notified secondary", zap.String("zone", soa.Hdr.Name), zap.String("target", target), zap.Uint32("serial", soa.Serial))
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func startTestDNSTCP(t *testing.T, handler dns.Handler) string {
+	t.Helper()
+
+	l, err := new(net.ListenConfig).Listen(t.Context(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &dns.Server{Listener: l, Handler: handler}
+	go func() { _ = server.ActivateAndServe() }()
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return l.Addr().String()
+}
+
+func TestZoneTransfer(t *testing.T) {
+	t.Parallel()
+
+	zoneCfg := config.ZoneConfig{
+		Name:        "example.com.",
+		NameServers: []string{"ns1.example.com."},
+		TTL:         time.Hour,
+		NegativeTTL: 5 * time.Minute,
+		Transfer:    config.TransferConfig{Allow: []string{"127.0.0.0/8"}},
+	}
+	h := newTestHandler(t)
+	h.zones = buildZones([]config.ZoneConfig{zoneCfg})
+	transfers, err := buildTransfers([]config.ZoneConfig{zoneCfg})
+	if err != nil {
+		t.Fatalf("buildTransfers() returned error: %v", err)
+	}
+	h.transfers = transfers
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com.", AliasMode: config.AliasCNAME}
+	h.aliases["www.example.com."] = edgeDomain
+	h.static = buildStaticRecords([]config.StaticRecord{{Name: "mail.example.com.", Type: "A", Value: "198.51.100.1", TTL: time.Minute}})
+	h.UpdateRecords("edge.example.com.", []Record{
+		{IP: net.IPv4(192, 0, 2, 1).To4()},
+		{IP: net.ParseIP("2001:db8::1")},
+	})
+	addr := startTestDNSTCP(t, h)
+
+	query := new(dns.Msg)
+	query.SetAxfr("example.com.")
+	records := transferIn(t, query, addr)
+	counts := make(map[uint16]int)
+	for _, rr := range records {
+		counts[rr.Header().Rrtype]++
+	}
+	if records[0].Header().Rrtype != dns.TypeSOA || records[len(records)-1].Header().Rrtype != dns.TypeSOA {
+		t.Fatalf("transfer = %v, want it framed by the SOA", records)
+	}
+	if counts[dns.TypeNS] != 1 || counts[dns.TypeA] != 2 || counts[dns.TypeAAAA] != 1 || counts[dns.TypeCNAME] != 1 {
+		t.Fatalf("transfer = %v, want NS, the domain records, the alias CNAME and the static record", records)
+	}
+
+	// A secondary already holding the current serial gets a single SOA.
+	serial := h.serials.Serial("example.com.")
+	ixfr := new(dns.Msg)
+	ixfr.SetIxfr("example.com.", serial, "ns1.example.com.", "hostmaster.example.com.")
+	resp, _, err := (&dns.Client{Net: "tcp", Timeout: time.Second}).Exchange(ixfr, addr)
+	if err != nil {
+		t.Fatalf("Exchange() returned error: %v", err)
+	}
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.SOA).Serial != serial {
+		t.Fatalf("IXFR answer = %v, want the current SOA only", resp.Answer)
+	}
+
+	h.transfers["example.com."].allow = []*net.IPNet{{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}
+	resp, _, err = (&dns.Client{Net: "tcp", Timeout: time.Second}).Exchange(query, addr)
+	if err != nil {
+		t.Fatalf("Exchange() returned error: %v", err)
+	}
+	if resp.Rcode != dns.RcodeRefused {
+		t.Fatalf("rcode = %s, want REFUSED for a client outside transfer.allow", dns.RcodeToString[resp.Rcode])
+	}
+}
+
+// transferIn returns the records of the zone transfer query from addr.
+func transferIn(t *testing.T, query *dns.Msg, addr string) []dns.RR {
+	t.Helper()
+	envelopes, err := new(dns.Transfer).In(query, addr)
+	if err != nil {
+		t.Fatalf("Transfer.In() returned error: %v", err)
+	}
+	var records []dns.RR
+	for env := range envelopes {
+		if env.Error != nil {
+			t.Fatalf("transfer envelope error: %v", env.Error)
+		}
+		records = append(records, env.RR...)
+	}
+	return records
+}
+
+func TestZoneNotifierNotifiesSecondaries(t *testing.T) {
+	t.Parallel()
+
+	notified := make(chan *dns.Msg, 1)
+	secondary := startTestDNS(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		_ = w.WriteMsg(msg)
+		select {
+		case notified <- r:
+		default:
+		}
+	}))
+
+	h := newTestHandler(t)
+	h.zones = buildZones([]config.ZoneConfig{{Name: "example.com.", NameServers: []string{"ns1.example.com."}}})
+	h.notifier = newZoneNotifier(map[string]*zoneTransfer{"example.com.": {notify: []string{secondary}}})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() { _ = h.notifier.Run(ctx, h) }()
+
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com."}
+	h.UpdateRecords("edge.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	select {
+	case r := <-notified:
+		if r.Opcode != dns.OpcodeNotify || r.Question[0].Name != "example.com." {
+			t.Fatalf("got %v, want a NOTIFY for example.com.", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("secondary was not notified of the record change")
+	}
+}
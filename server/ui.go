@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// uiTheme is served at /api/ui so the dashboard can apply the configured branding.
+type uiTheme struct {
+	Title  string            `json:"title"`
+	Logo   string            `json:"logo,omitempty"`
+	Colors map[string]string `json:"colors,omitempty"`
+}
+
+func buildUITheme(cfg config.UIConfig) uiTheme {
+	theme := uiTheme{Title: cfg.Title, Logo: cfg.Logo}
+	// Keys are the CSS variables of the dashboard.
+	vars := map[string]string{
+		"--bg":     cfg.Colors.Background,
+		"--ink":    cfg.Colors.Text,
+		"--accent": cfg.Colors.Accent,
+		"--card":   cfg.Colors.Card,
+		"--border": cfg.Colors.Border,
+	}
+	for name, value := range vars {
+		if value == "" {
+			continue
+		}
+		if theme.Colors == nil {
+			theme.Colors = make(map[string]string, len(vars))
+		}
+		theme.Colors[name] = value
+	}
+	return theme
+}
+
+// uiHandler serves the dashboard from cfg.Dir, or the embedded assets when it is empty.
+func uiHandler(cfg config.UIConfig) http.Handler {
+	if cfg.Dir != "" {
+		return http.FileServerFS(os.DirFS(cfg.Dir))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, staticFS, "static")
+	})
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestBuildUIThemeKeepsUnsetColors(t *testing.T) {
+	t.Parallel()
+
+	theme := buildUITheme(config.UIConfig{
+		Title:  "Edge",
+		Colors: config.UIColors{Accent: "#ff8800"},
+	})
+	if theme.Title != "Edge" {
+		t.Errorf("title = %q, want Edge", theme.Title)
+	}
+	if len(theme.Colors) != 1 || theme.Colors["--accent"] != "#ff8800" {
+		t.Errorf("colors = %v, want only the accent override", theme.Colors)
+	}
+}
+
+func TestUIHandlerServesExternalDir(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("custom dashboard"), 0o600); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	uiHandler(config.UIConfig{Dir: dir}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "custom dashboard") {
+		t.Fatalf("GET / = %d %q, want the external index", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/fmotalleb/go-tools/log"
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+var errEmptyAnswer = errors.New("empty answer while records are available")
+
+var (
+	watchdogHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helios_dns_watchdog_healthy",
+			Help: "Whether the last self-test query of a domain was answered as expected (1) or not (0).",
+		},
+		[]string{"domain"},
+	)
+	watchdogFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helios_dns_watchdog_failures_total",
+			Help: "Total self-test queries of a domain that were not answered as expected.",
+		},
+		[]string{"domain"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(watchdogHealthy, watchdogFailures)
+}
+
+// watchdogEvent is posted to the webhook when a domain starts or stops failing its self-test.
+type watchdogEvent struct {
+	Domain  string    `json:"domain"`
+	Healthy bool      `json:"healthy"`
+	Reason  string    `json:"reason,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// watchdog queries the running DNS listener for every served domain, catching breakage
+// between the records in memory and the answers clients actually get.
+type watchdog struct {
+	handler *Handler
+	addr    string
+	client  *dns.Client
+	webhook string
+	// failing holds the last observed state of each domain, only transitions are notified.
+	failing map[string]bool
+}
+
+func newWatchdog(cfg config.Config, handler *Handler) *watchdog {
+	return &watchdog{
+		handler: handler,
+		addr:    loopbackAddr(cfg.Listen[0]),
+		client:  &dns.Client{Net: "udp", Timeout: cfg.Watchdog.Timeout},
+		webhook: cfg.Watchdog.Webhook,
+		failing: make(map[string]bool),
+	}
+}
+
+// selfTestOption is the EDNS0 local option carrying the token of the watchdog queries.
+const selfTestOption = dns.EDNS0LOCALSTART
+
+// isSelfTest reports whether r is a query of the watchdog, sent from a loopback address with the
+// token of the handler. The watchdog tests the answers of the domains, so its queries are exempt
+// from allow_clients, deny_clients and client groups, which may leave out loopback.
+func (d *Handler) isSelfTest(r *dns.Msg, source net.IP) bool {
+	opt := r.IsEdns0()
+	if d.selfTestToken == "" || opt == nil || !source.IsLoopback() {
+		return false
+	}
+	for _, option := range opt.Option {
+		if local, ok := option.(*dns.EDNS0_LOCAL); ok && local.Code == selfTestOption {
+			return subtle.ConstantTimeCompare(local.Data, []byte(d.selfTestToken)) == 1
+		}
+	}
+	return false
+}
+
+// loopbackAddr returns the address to reach listen from this host.
+func loopbackAddr(listen string) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return listen
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		if ip != nil && ip.To4() == nil {
+			return net.JoinHostPort(net.IPv6loopback.String(), port)
+		}
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	return listen
+}
+
+// Run checks every domain each interval until ctx is done.
+func (w *watchdog) Run(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.checkAll(ctx)
+		}
+	}
+}
+
+func (w *watchdog) checkAll(ctx context.Context) {
+	logger := log.Of(ctx).Named("watchdog")
+	domains := make([]string, 0, len(w.handler.domains))
+	for domain, domainCfg := range w.handler.domains {
+		// Suspended domains answer by their paused_response, which may be an error on purpose.
+		if !domainCfg.Suspended() {
+			domains = append(domains, domain)
+		}
+	}
+	slices.Sort(domains)
+	for _, domain := range domains {
+		err := w.check(ctx, domain)
+		healthy := err == nil
+		if healthy {
+			watchdogHealthy.WithLabelValues(domain).Set(1)
+		} else {
+			watchdogHealthy.WithLabelValues(domain).Set(0)
+			watchdogFailures.WithLabelValues(domain).Inc()
+		}
+		wasFailing, seen := w.failing[domain]
+		w.failing[domain] = !healthy
+		if (!seen && healthy) || (seen && wasFailing == !healthy) {
+			continue
+		}
+		event := watchdogEvent{Domain: domain, Healthy: healthy, Time: time.Now()}
+		if healthy {
+			logger.Info("self-test recovered", zap.String("domain", domain))
+		} else {
+			event.Reason = err.Error()
+			logger.Warn("self-test failed", zap.String("domain", domain), zap.Error(err))
+		}
+		if err := w.notify(ctx, event); err != nil {
+			logger.Warn("failed to notify watchdog webhook", zap.Error(err))
+		}
+	}
+}
+
+// check queries domain and fails when it is not answered, or answered empty while records exist.
+func (w *watchdog) check(ctx context.Context, domain string) error {
+	qtype, wantAnswers := w.expectation(domain)
+	query := new(dns.Msg)
+	query.SetQuestion(domain, qtype)
+	query.SetEdns0(dns.DefaultMsgSize, false)
+	opt := query.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: selfTestOption, Data: []byte(w.handler.selfTestToken)})
+	resp, _, err := w.client.ExchangeContext(ctx, query, w.addr)
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("unexpected rcode %s", dns.RcodeToString[resp.Rcode])
+	}
+	if wantAnswers && len(resp.Answer) == 0 {
+		return errEmptyAnswer
+	}
+	return nil
+}
+
+// expectation picks the query type of domain and whether its answer should hold records. Like
+// resolve, the fallback IPs count while the smoothed pool is low or nothing is servable.
+func (w *watchdog) expectation(domain string) (uint16, bool) {
+	d := w.handler
+	d.rwMux.RLock()
+	candidates := d.servable(domain, time.Now())
+	if domainCfg := d.domains[domain]; domainCfg != nil && (d.pools[domain].low || len(candidates) == 0) {
+		candidates = withFallback(candidates, domainCfg, d.ipLists)
+	}
+	d.rwMux.RUnlock()
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		if len(candidatesOfFamily(candidates, qtype)) > 0 {
+			return qtype, true
+		}
+	}
+	return dns.TypeA, false
+}
+
+func (w *watchdog) notify(ctx context.Context, event watchdogEvent) error {
+	if w.webhook == "" {
+		return nil
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, w.client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestLoopbackAddr(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"0.0.0.0:53":  "127.0.0.1:53",
+		":5353":       "127.0.0.1:5353",
+		"[::]:53":     "[::1]:53",
+		"10.0.0.1:53": "10.0.0.1:53",
+		"[::1]:5353":  "[::1]:5353",
+	}
+	for in, want := range tests {
+		if got := loopbackAddr(in); got != want {
+			t.Errorf("loopbackAddr(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestWatchdogCheck(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com."}
+	h.UpdateRecords("edge.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+
+	w := &watchdog{
+		handler: h,
+		addr:    startTestDNS(t, h),
+		client:  &dns.Client{Net: "udp", Timeout: time.Second},
+		failing: make(map[string]bool),
+	}
+	if err := w.check(context.Background(), "edge.example.com."); err != nil {
+		t.Fatalf("check() returned error: %v", err)
+	}
+
+	// A listener that lost its records must be reported even though memory still holds them.
+	empty := newTestHandler(t)
+	empty.domains["edge.example.com."] = h.domains["edge.example.com."]
+	w.addr = startTestDNS(t, empty)
+	if err := w.check(context.Background(), "edge.example.com."); !errors.Is(err, errEmptyAnswer) {
+		t.Fatalf("check() error = %v, want %v", err, errEmptyAnswer)
+	}
+}
+
+func TestWatchdogExpectsFallback(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	key := edgeDomain
+	h.domains[key] = &config.ScanConfig{
+		Domain:      key,
+		FallbackIPs: []string{"2001:db8::1"},
+		Pool:        config.PoolConfig{MinSize: 2},
+	}
+	w := &watchdog{handler: h}
+	// An empty scan leaves only the fallback IPs, which clients are still answered with.
+	h.UpdateRecords(key, nil)
+	if qtype, want := w.expectation(key); qtype != dns.TypeAAAA || !want {
+		t.Fatalf("expectation() = %s, %v, want AAAA with answers", dns.TypeToString[qtype], want)
+	}
+}
+
+func TestWatchdogIsExemptFromACLs(t *testing.T) {
+	t.Parallel()
+
+	_, office, _ := net.ParseCIDR("10.0.0.0/8")
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	h := newTestHandler(t)
+	h.selfTestToken = "token"
+	h.acl = newClientACL([]*net.IPNet{office}, nil)
+	h.domainACLs = map[string]*clientACL{"edge.example.com.": newClientACL(nil, []*net.IPNet{loopback})}
+	groups, err := buildClientGroups([]config.ClientGroup{
+		{Name: "local", CIDRs: []string{"127.0.0.0/8"}, Domains: []string{"other.example.com."}},
+	}, h.ttl)
+	if err != nil {
+		t.Fatalf("buildClientGroups() returned error: %v", err)
+	}
+	h.clientGroups = groups
+	h.domains["edge.example.com."] = &config.ScanConfig{Domain: "edge.example.com."}
+	h.UpdateRecords("edge.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	addr := startTestDNS(t, h)
+
+	w := &watchdog{
+		handler: h,
+		addr:    addr,
+		client:  &dns.Client{Net: "udp", Timeout: time.Second},
+		failing: make(map[string]bool),
+	}
+	if err := w.check(context.Background(), "edge.example.com."); err != nil {
+		t.Fatalf("check() returned error: %v, want the watchdog answered despite the ACLs", err)
+	}
+
+	// Other loopback clients, and the ones guessing the token wrong, are still refused.
+	for _, token := range []string{"", "guess"} {
+		query := new(dns.Msg)
+		query.SetQuestion("edge.example.com.", dns.TypeA)
+		if token != "" {
+			query.SetEdns0(dns.DefaultMsgSize, false)
+			opt := query.IsEdns0()
+			opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: selfTestOption, Data: []byte(token)})
+		}
+		resp, err := dns.Exchange(query, addr)
+		if err != nil {
+			t.Fatalf("Exchange() returned error: %v", err)
+		}
+		if resp.Rcode != dns.RcodeRefused {
+			t.Fatalf("rcode with token %q = %s, want REFUSED", token, dns.RcodeToString[resp.Rcode])
+		}
+	}
+}
+
+func startTestDNS(t *testing.T, handler dns.Handler) string {
+	t.Helper()
+
+	pc, err := new(net.ListenConfig).ListenPacket(t.Context(), "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: handler}
+	go func() { _ = server.ActivateAndServe() }()
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return pc.LocalAddr().String()
+}
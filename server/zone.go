@@ -0,0 +1,204 @@
+package server
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// zone is a zone this server is authoritative for.
+type zone struct {
+	name        string
+	nameServers []string
+	hostmaster  string
+	ttl         uint32
+	refresh     uint32
+	retry       uint32
+	expire      uint32
+	negativeTTL uint32
+	// signer is nil unless the zone is signed with DNSSEC.
+	signer *zoneSigner
+}
+
+// buildZones converts the configured zones, most specific first so lookups find the closest one.
+func buildZones(cfgs []config.ZoneConfig) []*zone {
+	zones := make([]*zone, 0, len(cfgs))
+	for _, c := range cfgs {
+		z := &zone{
+			name:        dns.CanonicalName(c.Name),
+			hostmaster:  dns.CanonicalName(c.Hostmaster),
+			ttl:         uint32(c.TTL.Seconds()),
+			refresh:     uint32(c.Refresh.Seconds()),
+			retry:       uint32(c.Retry.Seconds()),
+			expire:      uint32(c.Expire.Seconds()),
+			negativeTTL: uint32(c.NegativeTTL.Seconds()),
+		}
+		if c.Hostmaster == "" {
+			z.hostmaster = "hostmaster." + z.name
+		}
+		for _, ns := range c.NameServers {
+			z.nameServers = append(z.nameServers, dns.CanonicalName(ns))
+		}
+		zones = append(zones, z)
+	}
+	slices.SortStableFunc(zones, func(a, b *zone) int {
+		return dns.CountLabel(b.name) - dns.CountLabel(a.name)
+	})
+	return zones
+}
+
+// zoneFor returns the closest zone containing name, or nil if name is outside every zone.
+func (d *Handler) zoneFor(name string) *zone {
+	name = dns.CanonicalName(name)
+	for _, z := range d.zones {
+		if dns.IsSubDomain(z.name, name) {
+			return z
+		}
+	}
+	return nil
+}
+
+// zoneName returns the zone whose serial tracks the records of key, key itself outside of zones.
+func (d *Handler) zoneName(key string) string {
+	if z := d.zoneFor(key); z != nil {
+		return z.name
+	}
+	return key
+}
+
+func (z *zone) isApex(name string) bool {
+	return dns.CanonicalName(name) == z.name
+}
+
+// soa returns the SOA record of z with the given serial.
+func (z *zone) soa(serial uint32) *dns.SOA {
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   z.name,
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    z.ttl,
+		},
+		Ns:      z.nameServers[0],
+		Mbox:    z.hostmaster,
+		Serial:  serial,
+		Refresh: z.refresh,
+		Retry:   z.retry,
+		Expire:  z.expire,
+		Minttl:  z.negativeTTL,
+	}
+}
+
+// apexRecords returns the SOA, NS or DNSKEY records of z for qtype, owned by name as queried.
+func (d *Handler) apexRecords(z *zone, name string, qtype uint16) []dns.RR {
+	switch qtype {
+	case dns.TypeSOA:
+		soa := z.soa(d.serials.Serial(z.name))
+		soa.Hdr.Name = name
+		return []dns.RR{soa}
+	case dns.TypeNS:
+		out := make([]dns.RR, 0, len(z.nameServers))
+		for _, ns := range z.nameServers {
+			out = append(out, &dns.NS{
+				Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: z.ttl},
+				Ns:  ns,
+			})
+		}
+		return out
+	case dns.TypeDNSKEY:
+		if z.signer != nil {
+			return z.signer.keys(name)
+		}
+	}
+	return nil
+}
+
+// hasDescendant reports whether a served name or a published TXT record lies below name, which
+// makes name an empty non-terminal that exists without records of its own.
+func (d *Handler) hasDescendant(name string) bool {
+	suffix := "." + dns.CanonicalName(name)
+	below := func(n string) bool { return strings.HasSuffix(dns.CanonicalName(n), suffix) }
+	for n := range d.domains {
+		if below(n) {
+			return true
+		}
+	}
+	for n := range d.aliases {
+		if below(n) {
+			return true
+		}
+	}
+	for n := range d.static {
+		if below(n) {
+			return true
+		}
+	}
+	d.rwMux.RLock()
+	defer d.rwMux.RUnlock()
+	for n := range d.txt {
+		if below(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// rcodeNames maps the response codes of the rcodes config to their values.
+var rcodeNames = map[string]int{
+	config.RcodeNoError:  dns.RcodeSuccess,
+	config.RcodeNXDomain: dns.RcodeNameError,
+	config.RcodeRefused:  dns.RcodeRefused,
+}
+
+// negativeRcode returns the rcode of a query for name, which has no records of the queried type.
+// Zone apexes, names with TXT records and names with records below them exist and get NODATA,
+// other names within a zone or below a served name get the unknown name rcode and anything else
+// the outside zones rcode.
+func (d *Handler) negativeRcode(name string, z *zone) int {
+	if (z != nil && z.isApex(name)) || d.hasRecords(dns.CanonicalName(name)) || d.hasDescendant(name) {
+		return dns.RcodeSuccess
+	}
+	if z != nil || d.hasServedAncestor(name) {
+		return d.unknownRcode
+	}
+	return d.outsideRcode
+}
+
+// hasServedAncestor reports whether a domain, alias or static record is configured above name.
+func (d *Handler) hasServedAncestor(name string) bool {
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		parent := name[off:]
+		if _, ok := d.domains[parent]; ok {
+			return true
+		}
+		if _, ok := d.aliases[parent]; ok {
+			return true
+		}
+		if _, ok := d.static[dns.CanonicalName(parent)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize marks msg as authoritative when its question is within a zone, and adds the zone SOA
+// to the authority section of negative answers so resolvers can cache them.
+func (d *Handler) authorize(msg *dns.Msg) *dns.Msg {
+	if len(msg.Question) == 0 {
+		return msg
+	}
+	z := d.zoneFor(msg.Question[0].Name)
+	if z == nil {
+		return msg
+	}
+	msg.Authoritative = true
+	if len(msg.Answer) == 0 && (msg.Rcode == dns.RcodeSuccess || msg.Rcode == dns.RcodeNameError) {
+		soa := z.soa(d.serials.Serial(z.name))
+		soa.Hdr.Ttl = min(soa.Hdr.Ttl, z.negativeTTL)
+		msg.Ns = append(msg.Ns, soa)
+	}
+	return msg
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/miekg/dns"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestResolveAuthoritativeZone(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.zones = buildZones([]config.ZoneConfig{{
+		Name:        "example.com.",
+		NameServers: []string{"ns1.example.com.", "ns2.example.com."},
+		TTL:         time.Hour,
+		NegativeTTL: 5 * time.Minute,
+	}})
+	h.domains["edge.cdn.example.com."] = &config.ScanConfig{Domain: "edge.cdn.example.com."}
+	h.UpdateRecords("edge.cdn.example.com.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+
+	resolve := func(name string, qtype uint16) *dns.Msg {
+		query := new(dns.Msg)
+		query.SetQuestion(name, qtype)
+		return h.authorize(h.resolve(query, queryClient{}, zap.NewNop()))
+	}
+
+	msg := resolve("example.com.", dns.TypeSOA)
+	soa, ok := msg.Answer[0].(*dns.SOA)
+	if !ok || !msg.Authoritative || soa.Mbox != "hostmaster.example.com." || soa.Serial == 0 {
+		t.Fatalf("SOA answer = %v (aa=%t), want the zone SOA", msg.Answer, msg.Authoritative)
+	}
+	if msg = resolve("example.com.", dns.TypeNS); len(msg.Answer) != 2 {
+		t.Fatalf("NS answer = %v, want both name servers", msg.Answer)
+	}
+	if msg = resolve("edge.cdn.example.com.", dns.TypeA); len(msg.Answer) != 1 || !msg.Authoritative {
+		t.Fatalf("A answer = %v (aa=%t), want an authoritative record", msg.Answer, msg.Authoritative)
+	}
+
+	msg = resolve("missing.example.com.", dns.TypeA)
+	if msg.Rcode != dns.RcodeNameError || len(msg.Ns) != 1 || msg.Ns[0].Header().Ttl != 300 {
+		t.Fatalf("missing name = %s %v, want NXDOMAIN with the SOA capped at the negative TTL", dns.RcodeToString[msg.Rcode], msg.Ns)
+	}
+	if msg = resolve("cdn.example.com.", dns.TypeA); msg.Rcode != dns.RcodeSuccess || len(msg.Ns) != 1 {
+		t.Fatalf("empty non-terminal = %s %v, want NOERROR with the SOA", dns.RcodeToString[msg.Rcode], msg.Ns)
+	}
+	if msg = resolve("other.org.", dns.TypeA); msg.Authoritative || len(msg.Ns) != 0 {
+		t.Fatalf("name outside zones = %+v, want a plain answer", msg)
+	}
+}
+
+func TestNegativeRcodes(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t)
+	h.domains["edge.cdn.example.net."] = &config.ScanConfig{Domain: "edge.cdn.example.net."}
+	h.UpdateRecords("edge.cdn.example.net.", []Record{{IP: net.IPv4(192, 0, 2, 1).To4()}})
+	h.PresentTXT("_acme-challenge.sub.example.org.", "token")
+
+	rcode := func(name string, qtype uint16) int {
+		query := new(dns.Msg)
+		query.SetQuestion(name, qtype)
+		return h.resolve(query, queryClient{}, zap.NewNop()).Rcode
+	}
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  int
+	}{
+		{"edge.cdn.example.net.", dns.TypeMX, dns.RcodeSuccess},
+		{"cdn.example.net.", dns.TypeA, dns.RcodeSuccess},
+		{"www.edge.cdn.example.net.", dns.TypeA, dns.RcodeNameError},
+		{"other.org.", dns.TypeA, dns.RcodeRefused},
+		// Names with TXT records only exist too.
+		{"_acme-challenge.sub.example.org.", dns.TypeA, dns.RcodeSuccess},
+		{"sub.example.org.", dns.TypeA, dns.RcodeSuccess},
+	}
+	for _, tt := range tests {
+		if got := rcode(tt.name, tt.qtype); got != tt.want {
+			t.Errorf("%s %s rcode = %s, want %s", tt.name, dns.TypeToString[tt.qtype], dns.RcodeToString[got], dns.RcodeToString[tt.want])
+		}
+	}
+
+	h.unknownRcode, h.outsideRcode = rcodeNames[config.RcodeNoError], rcodeNames[config.RcodeNXDomain]
+	if got := rcode("www.edge.cdn.example.net.", dns.TypeA); got != dns.RcodeSuccess {
+		t.Errorf("unknown name rcode = %s, want the configured NOERROR", dns.RcodeToString[got])
+	}
+	if got := rcode("other.org.", dns.TypeA); got != dns.RcodeNameError {
+		t.Errorf("outside zones rcode = %s, want the configured NXDOMAIN", dns.RcodeToString[got])
+	}
+}
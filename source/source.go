@@ -0,0 +1,134 @@
+// Package source provides the origins of the IPs served for a domain besides scanning.
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+// maxFeedSize bounds the body read from an HTTP feed.
+const maxFeedSize = 1 << 20
+
+// Record is an IP produced by a source, with the latency it was validated with if known.
+type Record struct {
+	IP      net.IP
+	Latency time.Duration
+}
+
+// RecordSource produces the records of a domain for one update cycle.
+type RecordSource interface {
+	Records(ctx context.Context) ([]Record, error)
+}
+
+// New returns the built-in source selected by cfg, which must not be a scan source.
+func New(cfg config.SourceConfig) (RecordSource, error) {
+	switch cfg.Type {
+	case config.SourceStatic:
+		return NewStatic(cfg.IPs)
+	case config.SourceResolve:
+		return &Resolve{Name: cfg.Name, Resolver: net.DefaultResolver, Timeout: cfg.Timeout}, nil
+	case config.SourceHTTP:
+		return &HTTPFeed{URL: cfg.URL, Client: &http.Client{Timeout: cfg.Timeout}}, nil
+	}
+	return nil, fmt.Errorf("unsupported record source %q", cfg.Type)
+}
+
+// Static serves a fixed list of IPs.
+type Static []Record
+
+// NewStatic parses ips into a static source.
+func NewStatic(ips []string) (Static, error) {
+	records, err := parseIPs(ips)
+	if err != nil {
+		return nil, err
+	}
+	return Static(records), nil
+}
+
+// Records implements [RecordSource].
+func (s Static) Records(context.Context) ([]Record, error) {
+	return append([]Record(nil), s...), nil
+}
+
+// Resolve serves the addresses a name currently resolves to.
+type Resolve struct {
+	Name     string
+	Resolver *net.Resolver
+	Timeout  time.Duration
+}
+
+// Records implements [RecordSource].
+func (r *Resolve) Records(ctx context.Context) ([]Record, error) {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	ips, err := r.Resolver.LookupIP(ctx, "ip", r.Name)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", r.Name, err)
+	}
+	records := make([]Record, len(ips))
+	for i, ip := range ips {
+		records[i] = Record{IP: ip}
+	}
+	return records, nil
+}
+
+// HTTPFeed serves the IPs listed by a JSON document, either an array of addresses or an object
+// holding them in its "ips" field.
+type HTTPFeed struct {
+	URL    string
+	Client *http.Client
+}
+
+// Records implements [RecordSource].
+func (f *HTTPFeed) Records(ctx context.Context) ([]Record, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch feed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch feed: unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return nil, fmt.Errorf("read feed: %w", err)
+	}
+	var ips []string
+	if err := json.Unmarshal(body, &ips); err != nil {
+		var doc struct {
+			IPs []string `json:"ips"`
+		}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return nil, fmt.Errorf("decode feed: %w", err)
+		}
+		ips = doc.IPs
+	}
+	return parseIPs(ips)
+}
+
+func parseIPs(ips []string) ([]Record, error) {
+	records := make([]Record, 0, len(ips))
+	for _, s := range ips {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q", s)
+		}
+		records = append(records, Record{IP: ip})
+	}
+	return records, nil
+}
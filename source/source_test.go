@@ -0,0 +1,67 @@
+package source
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fmotalleb/helios-dns/config"
+)
+
+func TestHTTPFeedDecodesBothLayouts(t *testing.T) {
+	t.Parallel()
+
+	bodies := map[string]string{
+		"/list":   `["192.0.2.1", "2001:db8::1"]`,
+		"/object": `{"ips": ["192.0.2.1", "2001:db8::1"]}`,
+		"/broken": `{"ips": ["not-an-ip"]}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := bodies[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	for _, path := range []string{"/list", "/object"} {
+		src, err := New(config.SourceConfig{Type: config.SourceHTTP, URL: srv.URL + path})
+		if err != nil {
+			t.Fatalf("New() returned error: %v", err)
+		}
+		records, err := src.Records(context.Background())
+		if err != nil {
+			t.Fatalf("Records(%s) returned error: %v", path, err)
+		}
+		if len(records) != 2 || !records[1].IP.Equal(net.ParseIP("2001:db8::1")) {
+			t.Fatalf("Records(%s) = %v, want both feed addresses", path, records)
+		}
+	}
+	for _, path := range []string{"/broken", "/missing"} {
+		src := &HTTPFeed{URL: srv.URL + path, Client: srv.Client()}
+		if _, err := src.Records(context.Background()); err == nil {
+			t.Fatalf("Records(%s) expected error, got nil", path)
+		}
+	}
+}
+
+func TestStaticReturnsCopies(t *testing.T) {
+	t.Parallel()
+
+	src, err := NewStatic([]string{"192.0.2.1"})
+	if err != nil {
+		t.Fatalf("NewStatic() returned error: %v", err)
+	}
+	records, _ := src.Records(context.Background())
+	records[0] = Record{}
+	if again, _ := src.Records(context.Background()); !again[0].IP.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("Records() = %v, want the static list unchanged", again)
+	}
+	if _, err := NewStatic([]string{"bogus"}); err == nil {
+		t.Fatal("NewStatic() expected error, got nil")
+	}
+}